@@ -45,6 +45,10 @@ type Config struct {
 	// TLSConfig when listening for encrypted connections (gRPC, DNS-over-TLS).
 	TLSConfig *tls.Config
 
+	// TsigSecret holds the TSIG secrets (base64 encoded), keyed on key name. When set, the server
+	// verifies TSIG signed requests, see dns.ResponseWriter's TsigStatus, and signs the responses.
+	TsigSecret map[string]string
+
 	// Plugin stack.
 	Plugin []plugin.Plugin
 
@@ -76,3 +80,11 @@ func GetConfig(c *caddy.Controller) *Config {
 	ctx.saveConfig(key, &Config{ListenHosts: []string{""}})
 	return GetConfig(c)
 }
+
+// AddTsigSecret adds the TSIG secret of key name to c, so the server can verify and sign messages with it.
+func (c *Config) AddTsigSecret(name, secret string) {
+	if c.TsigSecret == nil {
+		c.TsigSecret = make(map[string]string)
+	}
+	c.TsigSecret[name] = secret
+}
@@ -14,6 +14,8 @@ type DoHWriter struct {
 	raddr net.Addr
 	// laddr is our address. This can be optionally set.
 	laddr net.Addr
+	// tsigStatus is returned by TsigStatus, TSIG isn't verified over DoH.
+	tsigStatus error
 }
 
 // RemoteAddr returns the remote address.
@@ -21,3 +23,15 @@ func (d *DoHWriter) RemoteAddr() net.Addr { return d.raddr }
 
 // LocalAddr returns the local address.
 func (d *DoHWriter) LocalAddr() net.Addr { return d.laddr }
+
+// TsigStatus returns dns.ErrSig when the request has a TSIG record, as it isn't verified.
+func (d *DoHWriter) TsigStatus() error { return d.tsigStatus }
+
+// TsigTimersOnly implements dns.ResponseWriter, it does nothing.
+func (d *DoHWriter) TsigTimersOnly(bool) {}
+
+// Hijack implements dns.ResponseWriter, it does nothing.
+func (d *DoHWriter) Hijack() {}
+
+// Close implements dns.ResponseWriter, it does nothing.
+func (d *DoHWriter) Close() error { return nil }
@@ -132,6 +132,10 @@ func (s *ServergRPC) Query(ctx context.Context, in *pb.DnsPacket) (*pb.DnsPacket
 	}
 
 	w := &gRPCresponse{localAddr: s.listenAddr, remoteAddr: a, Msg: msg}
+	// TSIG isn't verified over gRPC, a signed request must not be taken as authenticated.
+	if msg.IsTsig() != nil {
+		w.tsigStatus = dns.ErrSig
+	}
 
 	dnsCtx := context.WithValue(ctx, Key{}, s.Server)
 	s.ServeDNS(dnsCtx, w, msg)
@@ -156,6 +160,7 @@ type gRPCresponse struct {
 	localAddr  net.Addr
 	remoteAddr net.Addr
 	Msg        *dns.Msg
+	tsigStatus error
 }
 
 // Write is the hack that makes this work. It does not actually write the message
@@ -168,7 +173,7 @@ func (r *gRPCresponse) Write(b []byte) (int, error) {
 
 // These methods implement the dns.ResponseWriter interface from Go DNS.
 func (r *gRPCresponse) Close() error              { return nil }
-func (r *gRPCresponse) TsigStatus() error         { return nil }
+func (r *gRPCresponse) TsigStatus() error         { return r.tsigStatus }
 func (r *gRPCresponse) TsigTimersOnly(b bool)     {}
 func (r *gRPCresponse) Hijack()                   {}
 func (r *gRPCresponse) LocalAddr() net.Addr       { return r.localAddr }
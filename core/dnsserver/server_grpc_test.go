@@ -0,0 +1,32 @@
+package dnsserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/pb"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/peer"
+)
+
+func TestGRPCForgedTsig(t *testing.T) {
+	s, err := NewServergRPC("127.0.0.1:53", []*Config{testConfig("grpc", tsigPlugin{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4053}})
+	p, err := s.Query(ctx, &pb.DnsPacket{Msg: forgedTsig(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(p.Msg); err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected a forged TSIG to be refused, got %s", dns.RcodeToString[m.Rcode])
+	}
+}
@@ -3,6 +3,7 @@ package dnsserver
 import (
 	"bytes"
 	"crypto/tls"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -64,3 +65,32 @@ func TestCustomHTTPRequestValidator(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPSForgedTsig(t *testing.T) {
+	c := testConfig("https", tsigPlugin{})
+	c.TLSConfig = &tls.Config{}
+	s, err := NewServerHTTPS("127.0.0.1:443", []*Config{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(forgedTsig(t)))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected HTTP status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected a forged TSIG to be refused, got %s", dns.RcodeToString[m.Rcode])
+	}
+}
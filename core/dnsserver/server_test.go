@@ -2,9 +2,13 @@ package dnsserver
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/test"
 
@@ -76,6 +80,42 @@ func TestDebug(t *testing.T) {
 	}
 }
 
+type errorPlugin struct{}
+
+func (ep errorPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	return dns.RcodeServerFailure, edns.NewError(edns.ExtendedErrorNetworkError, errors.New("timeout"))
+}
+
+func (ep errorPlugin) Name() string { return "errorplugin" }
+
+func TestServeDNSExtendedError(t *testing.T) {
+	s, err := NewServer("127.0.0.1:53", []*Config{testConfig("dns", errorPlugin{})})
+	if err != nil {
+		t.Fatalf("Expected no error for NewServer, got %s", err)
+	}
+
+	for _, useEdns := range []bool{true, false} {
+		m := new(dns.Msg)
+		m.SetQuestion("aaa.example.com.", dns.TypeA)
+		if useEdns {
+			m.SetEdns0(4096, false)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		s.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Rcode != dns.RcodeServerFailure {
+			t.Errorf("Expected SERVFAIL, got %s", dns.RcodeToString[rec.Msg.Rcode])
+		}
+		code, _, ok := edns.ExtendedError(rec.Msg)
+		if ok != useEdns {
+			t.Errorf("Expected extended error %t for EDNS0 %t, got %t", useEdns, useEdns, ok)
+		}
+		if ok && code != edns.ExtendedErrorNetworkError {
+			t.Errorf("Expected info-code %d, got %d", edns.ExtendedErrorNetworkError, code)
+		}
+	}
+}
+
 func BenchmarkCoreServeDNS(b *testing.B) {
 	s, err := NewServer("127.0.0.1:53", []*Config{testConfig("dns", testPlugin{})})
 	if err != nil {
@@ -93,3 +133,33 @@ func BenchmarkCoreServeDNS(b *testing.B) {
 		s.ServeDNS(ctx, w, m)
 	}
 }
+
+// tsigPlugin answers with NOERROR when the request's TSIG is valid, and with REFUSED otherwise.
+type tsigPlugin struct{}
+
+func (tp tsigPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeRefused
+	}
+	w.WriteMsg(m)
+	return m.Rcode, nil
+}
+
+func (tp tsigPlugin) Name() string { return "tsigplugin" }
+
+// forgedTsig returns a packed query with a TSIG record that has a made up MAC.
+func forgedTsig(t *testing.T) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeAXFR)
+	m.SetTsig("xfr.example.com.", dns.HmacSHA256, 300, time.Now().Unix())
+	t1 := m.Extra[0].(*dns.TSIG)
+	t1.MAC = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+	t1.MACSize = 32
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
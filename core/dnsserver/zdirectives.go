@@ -33,9 +33,11 @@ var Directives = []string{
 	"any",
 	"chaos",
 	"loadbalance",
+	"tsig",
 	"cache",
 	"rewrite",
 	"dnssec",
+	"validate",
 	"autopath",
 	"template",
 	"transfer",
@@ -48,10 +50,13 @@ var Directives = []string{
 	"file",
 	"auto",
 	"secondary",
+	"catalog",
+	"sql",
 	"etcd",
 	"loop",
 	"forward",
 	"grpc",
+	"recursive",
 	"erratic",
 	"whoami",
 	"on",
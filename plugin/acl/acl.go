@@ -6,6 +6,7 @@ import (
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/request"
 
 	"github.com/infobloxopen/go-trees/iptree"
@@ -36,6 +37,7 @@ type policy struct {
 	action action
 	qtypes map[uint16]struct{}
 	filter *iptree.Tree
+	ede    uint16 // Extended DNS Error info-code for blocked queries.
 }
 
 const (
@@ -59,12 +61,16 @@ RulesCheckLoop:
 			continue
 		}
 
-		action := matchWithPolicies(rule.policies, w, r)
+		action, ede := matchWithPolicies(rule.policies, w, r)
 		switch action {
 		case actionBlock:
 			{
 				m := new(dns.Msg)
 				m.SetRcode(r, dns.RcodeRefused)
+				if r.IsEdns0() != nil {
+					edns.SetExtendedError(m, ede, "")
+					state.SizeAndDo(m)
+				}
 				w.WriteMsg(m)
 				RequestBlockCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
 				return dns.RcodeSuccess, nil
@@ -81,8 +87,8 @@ RulesCheckLoop:
 }
 
 // matchWithPolicies matches the DNS query with a list of ACL polices and returns suitable
-// action against the query.
-func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) action {
+// action against the query, together with the Extended DNS Error info-code of the matching policy.
+func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) (action, uint16) {
 	state := request.Request{W: w, Req: r}
 
 	ip := net.ParseIP(state.IP())
@@ -101,9 +107,9 @@ func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) acti
 		}
 
 		// matched.
-		return policy.action
+		return policy.action, policy.ede
 	}
-	return actionNone
+	return actionNone, 0
 }
 
 // Name implements the plugin.Handler interface.
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/miekg/dns"
@@ -13,6 +14,7 @@ import (
 type testResponseWriter struct {
 	test.ResponseWriter
 	Rcode int
+	Msg   *dns.Msg
 }
 
 func (t *testResponseWriter) setRemoteIP(ip string) {
@@ -22,6 +24,7 @@ func (t *testResponseWriter) setRemoteIP(ip string) {
 // WriteMsg implement dns.ResponseWriter interface.
 func (t *testResponseWriter) WriteMsg(m *dns.Msg) error {
 	t.Rcode = m.Rcode
+	t.Msg = m
 	return nil
 }
 
@@ -392,3 +395,55 @@ func TestACLServeDNS(t *testing.T) {
 		})
 	}
 }
+
+func TestACLExtendedError(t *testing.T) {
+	tests := []struct {
+		config      string
+		edns        bool
+		expectedOpt bool
+		expectedEDE uint16
+	}{
+		{`acl example.org {
+			block net 192.168.0.0/16
+		}`, true, true, edns.ExtendedErrorProhibited},
+		{`acl example.org {
+			block type A
+		}`, true, true, edns.ExtendedErrorBlocked},
+		{`acl example.org {
+			block type A net *
+		}`, true, true, edns.ExtendedErrorBlocked},
+		{`acl example.org {
+			block net 192.168.0.0/16
+		}`, false, false, 0},
+	}
+
+	for i, tc := range tests {
+		a, err := parse(NewTestControllerWithZones(tc.config, nil))
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		a.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+		w := &testResponseWriter{}
+		w.setRemoteIP("192.168.0.2")
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		a.ServeDNS(context.TODO(), w, m)
+
+		if w.Rcode != dns.RcodeRefused {
+			t.Errorf("Test %d: expected REFUSED, got %s", i, dns.RcodeToString[w.Rcode])
+		}
+		if (w.Msg.IsEdns0() != nil) != tc.expectedOpt {
+			t.Errorf("Test %d: expected OPT %t", i, tc.expectedOpt)
+		}
+		if !tc.expectedOpt {
+			continue
+		}
+		if code, _, ok := edns.ExtendedError(w.Msg); !ok || code != tc.expectedEDE {
+			t.Errorf("Test %d: expected extended error %d, got %d", i, tc.expectedEDE, code)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 
 	"github.com/infobloxopen/go-trees/iptree"
 	"github.com/miekg/dns"
@@ -70,6 +71,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 
 			hasTypeSection := false
 			hasNetSection := false
+			allNets := false
 
 			remainingTokens := c.RemainingArgs()
 			for len(remainingTokens) > 0 {
@@ -108,6 +110,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 					for _, token := range tokens {
 						if token == "*" {
 							p.filter = newDefaultFilter()
+							allNets = true
 							break
 						}
 						token = normalize(token)
@@ -132,6 +135,13 @@ func parse(c *caddy.Controller) (ACL, error) {
 				p.filter = newDefaultFilter()
 			}
 
+			// A query blocked for all clients is for a blocked name or type, otherwise this client is
+			// prohibited from asking it.
+			p.ede = edns.ExtendedErrorProhibited
+			if allNets || !hasNetSection {
+				p.ede = edns.ExtendedErrorBlocked
+			}
+
 			r.policies = append(r.policies, p)
 		}
 		a.Rules = append(a.Rules, r)
@@ -31,6 +31,8 @@ type (
 		directory string
 		template  string
 		re        *regexp.Regexp
+		nested    bool // match re against the path relative to directory, instead of the file name.
+		poll      bool // walk directory every ReloadInterval, instead of watching it for changes.
 
 		ReloadInterval time.Duration
 		upstream       *upstream.Upstream // Upstream for looking up names during the resolution process.
@@ -45,17 +45,9 @@ func setup(c *caddy.Controller) error {
 			return err
 		}
 
-		go func() {
-			ticker := time.NewTicker(a.loader.ReloadInterval)
-			for {
-				select {
-				case <-walkChan:
-					return
-				case <-ticker.C:
-					a.Walk()
-				}
-			}
-		}()
+		if a.loader.ReloadInterval > 0 {
+			go a.watch(walkChan)
+		}
 		return nil
 	})
 
@@ -105,7 +97,7 @@ func autoParse(c *caddy.Controller) (Auto, error) {
 				if !c.NextArg() {
 					return a, c.ArgErr()
 				}
-				a.loader.directory = c.Val()
+				a.loader.directory = filepath.Clean(c.Val())
 				if !filepath.IsAbs(a.loader.directory) && config.Root != "" {
 					a.loader.directory = filepath.Join(config.Root, a.loader.directory)
 				}
@@ -145,6 +137,18 @@ func autoParse(c *caddy.Controller) (Auto, error) {
 				}
 				a.loader.ReloadInterval = d
 
+			case "nested":
+				if c.NextArg() {
+					return Auto{}, c.ArgErr()
+				}
+				a.loader.nested = true
+
+			case "poll":
+				if c.NextArg() {
+					return Auto{}, c.ArgErr()
+				}
+				a.loader.poll = true
+
 			case "upstream":
 				// remove soon
 				c.RemainingArgs() // eat remaining args
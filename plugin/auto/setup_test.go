@@ -126,3 +126,50 @@ func TestAutoParse(t *testing.T) {
 		}
 	}
 }
+
+func TestAutoParseLayout(t *testing.T) {
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedNested bool
+		expectedPoll   bool
+	}{
+		{`auto {
+			directory /tmp
+		}`, false, false, false},
+		{`auto {
+			directory /tmp ^([^/]+)/([^/]+)/db$ {2}.{1}
+			nested
+		}`, false, true, false},
+		{`auto {
+			directory /tmp
+			poll
+		}`, false, false, true},
+		{`auto {
+			directory /tmp
+			nested yes
+		}`, true, false, false},
+		{`auto {
+			directory /tmp
+			poll 10s
+		}`, true, false, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		a, err := autoParse(c)
+
+		if (err != nil) != test.shouldErr {
+			t.Fatalf("Test %d expected error %t, got '%v'", i, test.shouldErr, err)
+		}
+		if test.shouldErr {
+			continue
+		}
+		if a.loader.nested != test.expectedNested {
+			t.Errorf("Test %d expected nested %t, got %t", i, test.expectedNested, a.loader.nested)
+		}
+		if a.loader.poll != test.expectedPoll {
+			t.Errorf("Test %d expected poll %t, got %t", i, test.expectedPoll, a.loader.poll)
+		}
+	}
+}
@@ -4,16 +4,29 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/coredns/coredns/plugin/file"
 
 	"github.com/miekg/dns"
 )
 
-// Walk will recursively walk of the file under l.directory and adds the one that match l.re.
+// fileStamp holds the path, modification time and size of a zone's file, these are used to detect changes.
+type fileStamp struct {
+	path  string
+	mtime time.Time
+	size  int64
+}
+
+// Walk will recursively walk of the file under l.directory and adds the one that match l.re. Zones we
+// already have are only reloaded when their file has changed since the last walk.
 func (a Auto) Walk() error {
+	a.Zones.walkMu.Lock()
+	defer a.Zones.walkMu.Unlock()
 
-	// TODO(miek): should add something so that we don't stomp on each other.
+	if a.Zones.stamps == nil {
+		a.Zones.stamps = make(map[string]fileStamp)
+	}
 
 	toDelete := make(map[string]bool)
 	for _, n := range a.Zones.Names() {
@@ -25,15 +38,30 @@ func (a Auto) Walk() error {
 			return nil
 		}
 
-		match, origin := matches(a.loader.re, info.Name(), a.loader.template)
+		match, origin := a.loader.matches(path)
 		if !match {
 			return nil
 		}
 
-		if z, ok := a.Zones.Z[origin]; ok {
+		// Stat follows symlinks, so we notice when the target of a symlink changes.
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		stamp := fileStamp{path: path, mtime: fi.ModTime(), size: fi.Size()}
+
+		if z := a.Zones.Zones(origin); z != nil {
 			// we already have this zone
 			toDelete[origin] = false
+			if a.Zones.stamps[origin] == stamp {
+				return nil
+			}
+			// Only remember the file when it's loaded, so a file that is still being written, or a zone that's
+			// refused, is tried again on the next walk.
 			z.SetFile(path)
+			if z.ReloadFile(a.transfer) {
+				a.Zones.stamps[origin] = stamp
+			}
 			return nil
 		}
 
@@ -50,11 +78,18 @@ func (a Auto) Walk() error {
 			log.Warningf("Parse zone `%s': %v", origin, err)
 			return nil
 		}
+		if err := zo.CheckLoad(); err != nil {
+			log.Warningf("Not loading zone `%s': %v", origin, err)
+			return nil
+		}
 
-		zo.ReloadInterval = a.loader.ReloadInterval
+		// Changes to the zone's file are picked up by the walks, so the zone doesn't need to poll it itself.
+		zo.ReloadInterval = 0
 		zo.Upstream = a.loader.upstream
+		zo.IXFR = a.transfer != nil
 
 		a.Zones.Add(zo, origin, a.transfer)
+		a.Zones.stamps[origin] = stamp
 
 		if a.metrics != nil {
 			a.metrics.AddZone(origin)
@@ -86,17 +121,33 @@ func (a Auto) Walk() error {
 	return nil
 }
 
+// matches returns the origin for the file at path. For a nested layout the regular expression is matched
+// against the path relative to the directory, otherwise against the file name.
+func (l loader) matches(path string) (match bool, origin string) {
+	if !l.nested {
+		return matches(l.re, path, l.template)
+	}
+	rel, err := filepath.Rel(l.directory, path)
+	if err != nil {
+		return false, ""
+	}
+	return matchesPath(l.re, filepath.ToSlash(rel), l.template)
+}
+
 // matches re to filename, if it is a match, the subexpression will be used to expand
 // template to an origin. When match is true that origin is returned. Origin is fully qualified.
 func matches(re *regexp.Regexp, filename, template string) (match bool, origin string) {
-	base := filepath.Base(filename)
+	return matchesPath(re, filepath.Base(filename), template)
+}
 
-	matches := re.FindStringSubmatchIndex(base)
+// matchesPath is like matches, but re is matched against all of path.
+func matchesPath(re *regexp.Regexp, path, template string) (match bool, origin string) {
+	matches := re.FindStringSubmatchIndex(path)
 	if matches == nil {
 		return false, ""
 	}
 
-	by := re.ExpandString(nil, template, base, matches)
+	by := re.ExpandString(nil, template, path, matches)
 	if by == nil {
 		return false, ""
 	}
@@ -1,10 +1,13 @@
 package auto
 
 import (
+	"bytes"
 	"io/ioutil"
+	golog "log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -66,6 +69,162 @@ func TestWalkNonExistent(t *testing.T) {
 	a.Walk()
 }
 
+func TestWalkNested(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "coredns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	for _, dir := range []string{"org/example", "net/example", "net/other"} {
+		if err := os.MkdirAll(filepath.Join(tempdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"org/example/db", "net/example/db", "net/other/notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(tempdir, name), []byte(zoneContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := Auto{
+		loader: loader{
+			directory: tempdir,
+			re:        regexp.MustCompile(`^([^/]+)/([^/]+)/db$`),
+			template:  `${2}.${1}`,
+			nested:    true,
+		},
+		Zones: &Zones{},
+	}
+
+	a.Walk()
+
+	if x := len(a.Zones.Names()); x != 2 {
+		t.Errorf("Expected 2 zones, got %d", x)
+	}
+	for _, name := range []string{"example.org.", "example.net."} {
+		if _, ok := a.Zones.Z[name]; !ok {
+			t.Errorf("%s should have been added", name)
+		}
+	}
+}
+
+func TestWalkChanged(t *testing.T) {
+	tempdir, err := createFiles()
+	if err != nil {
+		if tempdir != "" {
+			os.RemoveAll(tempdir)
+		}
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	a := Auto{
+		loader: loader{
+			directory: tempdir,
+			re:        regexp.MustCompile(`db\.(.*)`),
+			template:  `${1}`,
+		},
+		Zones: &Zones{},
+	}
+
+	a.Walk()
+	org := a.Zones.Z["example.org."]
+
+	// Write a new version of db.example.org, with a higher serial and an extra record; db.example.com
+	// is a symlink to it, so that changes as well.
+	changed := strings.Replace(zoneContent, "2016082534", "2016082535", 1) + "mail IN A 127.0.0.2\n"
+	if err := ioutil.WriteFile(filepath.Join(tempdir, "db.example.org"), []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Walk()
+
+	if a.Zones.Z["example.org."] != org {
+		t.Errorf("Expected the zone to be reloaded in place")
+	}
+	for _, name := range []string{"example.org.", "example.com."} {
+		if x := len(a.Zones.Z[name].All()); x != 2 {
+			t.Errorf("Expected 2 RRs in %s, got %d", name, x)
+		}
+	}
+}
+
+func TestWalkFailedReload(t *testing.T) {
+	tempdir, err := createFiles()
+	if err != nil {
+		if tempdir != "" {
+			os.RemoveAll(tempdir)
+		}
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	a := Auto{
+		loader: loader{
+			directory: tempdir,
+			re:        regexp.MustCompile(`db\.(.*)`),
+			template:  `${1}`,
+		},
+		Zones: &Zones{},
+	}
+
+	a.Walk()
+	stamp := a.Zones.stamps["example.org."]
+
+	// A file that can't be loaded (yet), e.g. because it's still being written, isn't remembered, so it's
+	// tried again on the next walk.
+	if err := ioutil.WriteFile(filepath.Join(tempdir, "db.example.org"), []byte("; testzone\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a.Walk()
+	if a.Zones.stamps["example.org."] != stamp {
+		t.Errorf("Expected the file of example.org. not to be remembered after a failed reload")
+	}
+	if x := len(a.Zones.Z["example.org."].All()); x != 1 {
+		t.Errorf("Expected 1 RR in example.org., got %d", x)
+	}
+}
+
+func TestWalkCheck(t *testing.T) {
+	tempdir, err := createFiles()
+	if err != nil {
+		if tempdir != "" {
+			os.RemoveAll(tempdir)
+		}
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	// A zone whose NS record has no glue.
+	lame := zoneContent + "sub IN NS ns.sub\n"
+	if err := ioutil.WriteFile(filepath.Join(tempdir, "db.example.net"), []byte(lame), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(ioutil.Discard)
+
+	a := Auto{
+		loader: loader{
+			directory: tempdir,
+			re:        regexp.MustCompile(`db\.(.*)`),
+			template:  `${1}`,
+		},
+		Zones: &Zones{},
+	}
+	a.Walk()
+
+	// The problems are logged, and the zone is loaded.
+	if !strings.Contains(buf.String(), "missing glue") {
+		t.Errorf("Expected the missing glue in example.net. to be logged, got %q", buf.String())
+	}
+	if a.Zones.Zones("example.net.") == nil {
+		t.Errorf("Expected example.net. to be loaded")
+	}
+}
+
 func createFiles() (string, error) {
 	dir, err := ioutil.TempDir(os.TempDir(), "coredns")
 	if err != nil {
@@ -0,0 +1,137 @@
+package auto
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watch keeps the zones in sync with the directory until stop is closed. Changes are picked up through
+// filesystem notifications; when the directory can't be watched, or a.loader.poll is set, the directory
+// is walked every a.loader.ReloadInterval instead. When the directory went away, it's watched again once it's
+// back.
+func (a Auto) watch(stop chan bool) {
+	for {
+		rewatch := false
+		if !a.loader.poll {
+			err := a.watchDirectory(stop)
+			if err == nil {
+				return
+			}
+			rewatch = err == errDirectoryGone || os.IsNotExist(err)
+			log.Warningf("Failed to watch %s, scanning it every %s instead: %s", a.loader.directory, a.loader.ReloadInterval, err)
+		}
+		if !a.poll(stop, rewatch) {
+			return
+		}
+		log.Infof("Watching %s again", a.loader.directory)
+	}
+}
+
+// watchDirectory watches the directory with filesystem notifications, see notify. It returns nil when stop
+// is closed.
+func (a Auto) watchDirectory(stop chan bool) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := addWatches(w, a.loader.directory); err != nil {
+		return err
+	}
+	// Changes made before the watches were added aren't notified.
+	a.Walk()
+	if a.notify(w, stop) {
+		return errDirectoryGone
+	}
+	return nil
+}
+
+// poll walks the directory every a.loader.ReloadInterval until stop is closed, it then returns false. When
+// rewatch is true, it returns true as soon as the directory is there again, so it can be watched.
+func (a Auto) poll(stop chan bool, rewatch bool) bool {
+	ticker := time.NewTicker(a.loader.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+			a.Walk()
+			if !rewatch {
+				continue
+			}
+			if fi, err := os.Stat(a.loader.directory); err == nil && fi.IsDir() {
+				return true
+			}
+		}
+	}
+}
+
+// notify walks the directory when w reports changes in it. Changes that come in quick succession, e.g. when
+// a tree of zones is copied in place, are handled with a single walk. It returns when stop is closed, or
+// returns true when the directory itself went away and we should poll until it's back.
+func (a Auto) notify(w *fsnotify.Watcher, stop chan bool) bool {
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return false
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return false
+			}
+			if ev.Name == a.loader.directory && ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				a.Walk()
+				return true
+			}
+			// New directories need to be watched as well.
+			if ev.Op&fsnotify.Create != 0 {
+				if fi, err := os.Lstat(ev.Name); err == nil && fi.IsDir() {
+					if err := addWatches(w, ev.Name); err != nil {
+						log.Warningf("Failed to watch %s: %s", ev.Name, err)
+					}
+				}
+			}
+			if settled == nil {
+				settled = time.After(settleTime)
+			}
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return false
+			}
+			// Events may have been lost, a walk gets us back in sync.
+			log.Warningf("Error watching %s: %s", a.loader.directory, err)
+			if settled == nil {
+				settled = time.After(settleTime)
+			}
+
+		case <-settled:
+			settled = nil
+			a.Walk()
+		}
+	}
+}
+
+// addWatches adds dir and all directories below it to w.
+func addWatches(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return w.Add(path)
+	})
+}
+
+var errDirectoryGone = errors.New("directory was removed")
+
+// settleTime is how long we wait for more changes after a notification before walking the directory.
+const settleTime = 200 * time.Millisecond
@@ -1,10 +1,12 @@
 package auto
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func TestWatcher(t *testing.T) {
@@ -98,3 +100,124 @@ func TestSymlinks(t *testing.T) {
 		}
 	}
 }
+
+func TestWatch(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		// When watching, the interval is too long for the changes to be found by polling.
+		interval := time.Hour
+		if poll {
+			interval = 50 * time.Millisecond
+		}
+
+		tempdir, err := createFiles()
+		if err != nil {
+			if tempdir != "" {
+				os.RemoveAll(tempdir)
+			}
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempdir)
+
+		a := Auto{
+			loader: loader{
+				directory:      tempdir,
+				re:             regexp.MustCompile(`db\.(.*)`),
+				template:       `${1}`,
+				poll:           poll,
+				ReloadInterval: interval,
+			},
+			Zones: &Zones{},
+		}
+
+		a.Walk()
+		stop := make(chan bool)
+		go a.watch(stop)
+		time.Sleep(100 * time.Millisecond) // give the watcher time to start.
+
+		// A zone in a new subdirectory is picked up, one that is removed is dropped.
+		sub := filepath.Join(tempdir, "sub")
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(sub, "db.example.net"), []byte(zoneContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(tempdir, "db.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		if !eventually(func() bool { return a.Zones.Zones("example.net.") != nil && a.Zones.Zones("example.com.") == nil }) {
+			t.Errorf("Expected example.net. to be added and example.com. to be removed (poll %t), got %v", poll, a.Zones.Names())
+		}
+		close(stop)
+	}
+}
+
+func TestWatchDirectoryRecreated(t *testing.T) {
+	tempdir, err := createFiles()
+	if err != nil {
+		if tempdir != "" {
+			os.RemoveAll(tempdir)
+		}
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	a := Auto{
+		loader: loader{
+			directory:      tempdir,
+			re:             regexp.MustCompile(`db\.(.*)`),
+			template:       `${1}`,
+			ReloadInterval: time.Second,
+		},
+		Zones: &Zones{},
+	}
+
+	a.Walk()
+	stop := make(chan bool)
+	defer close(stop)
+	go a.watch(stop)
+	time.Sleep(100 * time.Millisecond) // give the watcher time to start.
+
+	// The directory goes away, and comes back; this is found by polling.
+	if err := os.RemoveAll(tempdir); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return len(a.Zones.Names()) == 0 }) {
+		t.Fatalf("Expected the zones to be removed, got %v", a.Zones.Names())
+	}
+	if err := os.Mkdir(tempdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempdir, "db.example.org"), []byte(zoneContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return a.Zones.Zones("example.org.") != nil }) {
+		t.Fatalf("Expected example.org. to be added, got %v", a.Zones.Names())
+	}
+
+	// The directory is watched again: a new zone is found well before the next poll.
+	time.Sleep(100 * time.Millisecond)
+	if err := ioutil.WriteFile(filepath.Join(tempdir, "db.example.net"), []byte(zoneContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i := 0; i < 25 && !found; i++ {
+		time.Sleep(20 * time.Millisecond)
+		found = a.Zones.Zones("example.net.") != nil
+	}
+	if !found {
+		t.Errorf("Expected example.net. to be added through a notification, got %v", a.Zones.Names())
+	}
+}
+
+// eventually returns true if f returns true within a few seconds.
+func eventually(f func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
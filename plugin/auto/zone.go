@@ -16,6 +16,9 @@ type Zones struct {
 
 	origins []string // Any origins from the server block.
 
+	stamps map[string]fileStamp // The stamps of the zones' files, keyed by origin, only used by Walk.
+	walkMu sync.Mutex           // Serializes the walks of the directory.
+
 	sync.RWMutex
 }
 
@@ -66,6 +69,7 @@ func (z *Zones) Remove(name string) {
 	}
 
 	delete(z.Z, name)
+	delete(z.stamps, name)
 
 	// TODO(miek): just regenerate Names (might be bad if you have a lot of zones...)
 	z.names = []string{}
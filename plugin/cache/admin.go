@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// admin is the HTTP admin API of the cache. It lists, flushes and pre-populates entries.
+type admin struct {
+	Addr string
+	c    *Cache
+
+	ln  net.Listener
+	mux *http.ServeMux
+}
+
+// entry is an item in the cache, as returned by the admin API.
+type entry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Cache string `json:"cache"`
+	Rcode string `json:"rcode"`
+	TTL   int    `json:"ttl"`
+}
+
+func (a *admin) OnStartup() error {
+	ln, err := reuseport.Listen("tcp", a.Addr)
+	if err != nil {
+		return err
+	}
+
+	a.ln = ln
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc("/cache/entries", a.entries)
+	a.mux.HandleFunc("/cache/flush", a.flush)
+	a.mux.HandleFunc("/cache/prefetch", a.prefetch)
+
+	go func() { http.Serve(a.ln, a.mux) }()
+	return nil
+}
+
+func (a *admin) OnShutdown() error {
+	if a.ln != nil {
+		a.ln.Close()
+		a.ln = nil
+	}
+	return nil
+}
+
+// entries lists the entries in the cache, optionally only those at or below the zone given in the zone parameter.
+func (a *admin) entries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	zone := "."
+	if z := r.URL.Query().Get("zone"); z != "" {
+		zone = plugin.Host(z).Normalize()
+	}
+
+	now := a.c.now().UTC()
+	entries := []entry{}
+	walk := func(cache string) func(uint64, interface{}) bool {
+		return func(_ uint64, el interface{}) bool {
+			i := el.(*item)
+			if dns.IsSubDomain(zone, i.Name) {
+				entries = append(entries, entry{Name: i.Name, Type: dns.Type(i.Type).String(), Cache: cache, Rcode: dns.RcodeToString[i.Rcode], TTL: i.ttl(now)})
+			}
+			return true
+		}
+	}
+	a.c.pcache.Walk(walk(Success))
+	a.c.ncache.Walk(walk(Denial))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// flush removes entries from the cache. With the name parameter the entries for that name are removed, restricted
+// to a single type with the type parameter. With the zone parameter all entries at or below the zone are removed.
+// Without any parameters the cache is emptied.
+func (a *admin) flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	name, zone := q.Get("name"), q.Get("zone")
+	if name != "" && zone != "" {
+		http.Error(w, "only one of name and zone can be given", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		match func(*item) bool
+		nsec  func(string) bool
+	)
+	switch {
+	case name != "":
+		name = plugin.Host(name).Normalize()
+		qtype, err := parseType(q.Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match = func(i *item) bool { return i.Name == name && (qtype == dns.TypeNone || i.Type == qtype) }
+		nsec = func(z string) bool { return dns.IsSubDomain(z, name) }
+	case zone != "":
+		zone = plugin.Host(zone).Normalize()
+		match = func(i *item) bool { return dns.IsSubDomain(zone, i.Name) }
+		nsec = overlaps(zone)
+	default:
+		match = func(*item) bool { return true }
+		nsec = func(string) bool { return true }
+	}
+
+	n := a.c.flush(match, nsec)
+	log.Infof("Flushed %d entries from the cache", n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Flushed int `json:"flushed"`
+	}{n})
+}
+
+// prefetch looks up the name and type parameters, and caches the reply.
+func (a *admin) prefetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	qtype, err := parseType(q.Get("type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if qtype == dns.TypeNone {
+		qtype = dns.TypeA
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(plugin.Host(name).Normalize(), qtype)
+	setDo(m)
+	state := request.Request{W: &adminWriter{}, Req: m}
+	if plugin.Zones(a.c.Zones).Matches(state.Name()) == "" {
+		http.Error(w, "name is not in the zones of the cache", http.StatusBadRequest)
+		return
+	}
+
+	cw := newPrefetchResponseWriter("", state, a.c)
+	if _, err := a.c.next(r.Context(), cw, m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// flush removes all entries for which match returns true from the cache. It returns the number of entries removed.
+// With a store, these entries are also skipped in the store until they expire there. The NSEC and NSEC3 records of
+// the zones for which nsec returns true are removed as well, as these could otherwise still deny the flushed names.
+func (c *Cache) flush(match func(*item) bool, nsec func(zone string) bool) int {
+	if c.store != nil {
+		c.addFlushed(match, c.now().UTC())
+	}
+	if c.aggressive != nil {
+		c.aggressive.flush(nsec)
+	}
+	n := 0
+	for _, ca := range []*cache.Cache{c.pcache, c.ncache} {
+		ca.Walk(func(k uint64, el interface{}) bool {
+			if match(el.(*item)) {
+				ca.Remove(k)
+				n++
+			}
+			return true
+		})
+	}
+	return n
+}
+
+// serveNotify handles a NOTIFY for a zone: all entries at or below the zone are flushed. It returns false if the
+// NOTIFY isn't allowed by the ACL, and should be handled by the next plugin.
+func (c *Cache) serveNotify(state request.Request) bool {
+	ip := net.ParseIP(state.IP())
+	allowed := false
+	for _, n := range c.notifyFrom {
+		if n.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	zone := state.Name()
+	n := c.flush(func(i *item) bool { return dns.IsSubDomain(zone, i.Name) }, overlaps(zone))
+	log.Infof("Flushed %d entries for %s after NOTIFY from %s", n, zone, state.IP())
+
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+	state.W.WriteMsg(m)
+	return true
+}
+
+// overlaps returns a function that reports whether the denials of a zone can cover names in zone: the zones at or
+// below zone, and the ones above it.
+func overlaps(zone string) func(string) bool {
+	return func(z string) bool { return dns.IsSubDomain(zone, z) || dns.IsSubDomain(z, zone) }
+}
+
+func parseType(s string) (uint16, error) {
+	if s == "" {
+		return dns.TypeNone, nil
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown type: %s", s)
+	}
+	return qtype, nil
+}
+
+// adminWriter is the dns.ResponseWriter for queries made by the admin API. It looks like a client on the
+// loopback interface, and never writes anything.
+type adminWriter struct{}
+
+func (w *adminWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *adminWriter) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *adminWriter) WriteMsg(*dns.Msg) error     { return nil }
+func (w *adminWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *adminWriter) Close() error                { return nil }
+func (w *adminWriter) TsigStatus() error           { return nil }
+func (w *adminWriter) TsigTimersOnly(bool)         {}
+func (w *adminWriter) Hijack()                     {}
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func newAdminCache(t *testing.T) (*Cache, *admin) {
+	c := New()
+	c.Next = snapshotHandler()
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"example.org.", dns.TypeA},
+		{"example.org.", dns.TypeAAAA},
+		{"a.example.org.", dns.TypeA},
+		{"missing.org.", dns.TypeA},
+		{"example.net.", dns.TypeA},
+	} {
+		req := new(dns.Msg)
+		req.SetQuestion(q.name, q.qtype)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	}
+	if n := c.pcache.Len() + c.ncache.Len(); n != 5 {
+		t.Fatalf("Expected %d items in the cache, got %d", 5, n)
+	}
+	return c, &admin{c: c}
+}
+
+func TestAdminEntries(t *testing.T) {
+	_, a := newAdminCache(t)
+
+	tests := []struct {
+		url      string
+		expected int
+	}{
+		{"/cache/entries", 5},
+		{"/cache/entries?zone=example.org", 3},
+		{"/cache/entries?zone=a.example.org.", 1},
+		{"/cache/entries?zone=example.com.", 0},
+	}
+	for i, tc := range tests {
+		rec := httptest.NewRecorder()
+		a.entries(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Test %d: expected status %d, got %d", i, http.StatusOK, rec.Code)
+		}
+		entries := []entry{}
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("Test %d: failed to decode entries: %s", i, err)
+		}
+		if len(entries) != tc.expected {
+			t.Errorf("Test %d: expected %d entries, got %d: %v", i, tc.expected, len(entries), entries)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	a.entries(rec, httptest.NewRequest(http.MethodPost, "/cache/entries", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	tests := []struct {
+		url      string
+		code     int
+		expected int // entries left in the cache.
+	}{
+		{"/cache/flush", http.StatusOK, 0},
+		{"/cache/flush?name=example.org", http.StatusOK, 3},
+		{"/cache/flush?name=example.org.&type=aaaa", http.StatusOK, 4},
+		{"/cache/flush?zone=example.org.", http.StatusOK, 2},
+		{"/cache/flush?zone=org.", http.StatusOK, 1},
+		{"/cache/flush?name=example.org.&type=bogus", http.StatusBadRequest, 5},
+		{"/cache/flush?name=example.org.&zone=org.", http.StatusBadRequest, 5},
+	}
+	for i, tc := range tests {
+		c, a := newAdminCache(t)
+		rec := httptest.NewRecorder()
+		a.flush(rec, httptest.NewRequest(http.MethodPost, tc.url, nil))
+		if rec.Code != tc.code {
+			t.Errorf("Test %d: expected status %d, got %d", i, tc.code, rec.Code)
+		}
+		if n := c.pcache.Len() + c.ncache.Len(); n != tc.expected {
+			t.Errorf("Test %d: expected %d items left in the cache, got %d", i, tc.expected, n)
+		}
+	}
+}
+
+func TestAdminPrefetch(t *testing.T) {
+	c := New()
+	c.Zones = []string{"org."}
+	c.Next = snapshotHandler()
+	a := &admin{c: c}
+
+	rec := httptest.NewRecorder()
+	a.prefetch(rec, httptest.NewRequest(http.MethodPost, "/cache/prefetch?name=example.org", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if c.pcache.Len() != 1 {
+		t.Errorf("Expected %d item in the cache, got %d", 1, c.pcache.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	a.prefetch(rec, httptest.NewRequest(http.MethodPost, "/cache/prefetch?name=example.net", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// writerHandler calls all methods of the ResponseWriter, before answering like snapshotHandler.
+func writerHandler() plugin.Handler {
+	next := snapshotHandler()
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		w.TsigStatus()
+		w.TsigTimersOnly(false)
+		w.Hijack()
+		w.LocalAddr()
+		w.RemoteAddr()
+		defer w.Close()
+		return next.ServeDNS(ctx, w, r)
+	})
+}
+
+func TestAdminPrefetchWriter(t *testing.T) {
+	c := New()
+	c.Next = writerHandler()
+	a := &admin{c: c}
+
+	rec := httptest.NewRecorder()
+	a.prefetch(rec, httptest.NewRequest(http.MethodPost, "/cache/prefetch?name=example.org", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestFlushNotify(t *testing.T) {
+	c, _ := newAdminCache(t)
+	_, local, _ := net.ParseCIDR("10.0.0.0/8") // test.ResponseWriter's remote address is 10.240.0.1.
+	_, other, _ := net.ParseCIDR("192.0.2.0/24")
+
+	notify := new(dns.Msg)
+	notify.SetNotify("example.org.")
+
+	// Not allowed, passed on to the next plugin.
+	c.notifyFrom = []*net.IPNet{other}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, notify)
+	if n := c.pcache.Len() + c.ncache.Len(); n != 5 {
+		t.Errorf("Expected %d items in the cache, got %d", 5, n)
+	}
+
+	c.notifyFrom = []*net.IPNet{local}
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, notify)
+	if rec.Msg == nil || rec.Msg.Opcode != dns.OpcodeNotify || !rec.Msg.Response {
+		t.Fatalf("Expected NOTIFY response, got %v", rec.Msg)
+	}
+	if n := c.pcache.Len() + c.ncache.Len(); n != 2 {
+		t.Errorf("Expected %d items in the cache, got %d", 2, n)
+	}
+}
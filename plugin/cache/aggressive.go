@@ -0,0 +1,332 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/validator"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// aggressive holds the validated NSEC and NSEC3 records from the negative answers we've seen, per zone. These
+// are used to synthesize NXDOMAIN and NODATA answers for other names in the spans they cover (RFC 8198).
+type aggressive struct {
+	zones map[string]*nsecZone
+	size  int // maximum number of NSEC and NSEC3 records per zone.
+
+	sync.RWMutex
+}
+
+// nsecZone holds the denials of existence of a zone.
+type nsecZone struct {
+	soa    []dns.RR // SOA and its RRSIGs, for the authority section.
+	expire time.Time
+	nsec   []*denial // sorted in canonical order.
+	nsec3  []*denial // sorted on hashed owner name.
+}
+
+// denial is an NSEC or NSEC3 record together with its RRSIGs.
+type denial struct {
+	key    string // owner name for NSEC, the hashed owner name for NSEC3.
+	rrs    []dns.RR
+	expire time.Time
+}
+
+func newAggressive(size int) *aggressive {
+	return &aggressive{zones: make(map[string]*nsecZone), size: size}
+}
+
+// add adds the NSEC and NSEC3 records in the authority section of m, a validated NXDOMAIN or NODATA reply. They
+// can be used for d. The records are not validated here: m must be the reply to a query with the CD bit clear,
+// and have the AD bit set by an upstream we trust.
+func (a *aggressive) add(m *dns.Msg, now time.Time, d time.Duration) {
+	var soa *dns.SOA
+	for _, rr := range m.Ns {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+			break
+		}
+	}
+	if soa == nil {
+		return
+	}
+	zone := strings.ToLower(soa.Hdr.Name)
+	expire := now.Add(d)
+
+	sigs := signatures(m.Ns, zone)
+	soaRRs := sigs[sigKey(zone, dns.TypeSOA)]
+	if len(soaRRs) == 0 {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	z, ok := a.zones[zone]
+	if !ok {
+		if len(a.zones) >= maxAggressiveZones {
+			a.prune(now)
+		}
+		z = &nsecZone{}
+		a.zones[zone] = z
+	}
+	z.soa = append([]dns.RR{soa}, soaRRs...)
+	z.expire = expire
+
+	for _, rr := range m.Ns {
+		owner := strings.ToLower(rr.Header().Name)
+		switch n := rr.(type) {
+		case *dns.NSEC:
+			s := sigs[sigKey(owner, dns.TypeNSEC)]
+			if len(s) == 0 || !dns.IsSubDomain(zone, owner) {
+				continue
+			}
+			z.nsec = insert(z.nsec, &denial{key: owner, rrs: append([]dns.RR{n}, s...), expire: expire}, validator.Compare, a.size)
+		case *dns.NSEC3:
+			s := sigs[sigKey(owner, dns.TypeNSEC3)]
+			// Opt-out spans may hold unsigned delegations, they can't be used to deny anything (RFC 8198, section 4).
+			if len(s) == 0 || n.Flags&optOut != 0 || n.Iterations > maxNSEC3Iterations {
+				continue
+			}
+			if dns.CountLabel(owner) != dns.CountLabel(zone)+1 || !dns.IsSubDomain(zone, owner) {
+				continue
+			}
+			key := strings.ToUpper(dns.SplitDomainName(owner)[0])
+			z.nsec3 = insert(z.nsec3, &denial{key: key, rrs: append([]dns.RR{n}, s...), expire: expire}, strings.Compare, a.size)
+		}
+	}
+}
+
+// synthesize returns an NXDOMAIN or NODATA reply for state, if the cached NSEC or NSEC3 records prove that name
+// or type doesn't exist. Otherwise nil is returned.
+func (a *aggressive) synthesize(state request.Request, now time.Time, do bool) *dns.Msg {
+	qname, qtype := state.Name(), state.QType()
+
+	a.RLock()
+	z := a.zone(qname)
+	if z == nil || !z.expire.After(now) {
+		a.RUnlock()
+		return nil
+	}
+	zone := strings.ToLower(z.soa[0].Header().Name)
+	cands := z.candidates(qname, zone, now)
+	soa := z.soa
+	expire := z.expire
+	a.RUnlock()
+
+	if len(cands) == 0 {
+		return nil
+	}
+
+	nsec := []dns.RR{}
+	for _, c := range cands {
+		nsec = append(nsec, c.rrs[0])
+		if c.expire.Before(expire) {
+			expire = c.expire
+		}
+	}
+	if belowCut(nsec, qname, qtype) {
+		return nil
+	}
+
+	rcode := dns.RcodeSuccess
+	switch {
+	case validator.ProvesNameError(nsec, qname):
+		rcode = dns.RcodeNameError
+	case validator.ProvesNoData(nsec, qname, qtype):
+	default:
+		return nil
+	}
+
+	ns := append([]dns.RR{}, soa...)
+	for _, c := range cands {
+		ns = append(ns, c.rrs...)
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true // See toMsg.
+	m.RecursionAvailable = true
+	// RFC 6840, section 5.8: AD is set when the client asked for it with either DO or AD.
+	m.AuthenticatedData = do || state.Req.AuthenticatedData
+	m.Rcode = rcode
+	m.Ns = filterRRSlice(ns, uint32(expire.Sub(now).Seconds()), do, true)
+	return m
+}
+
+// flush removes the denials of the zones for which match returns true.
+func (a *aggressive) flush(match func(zone string) bool) {
+	a.Lock()
+	defer a.Unlock()
+	for zone := range a.zones {
+		if match(zone) {
+			delete(a.zones, zone)
+		}
+	}
+}
+
+// zone returns the closest zone we have denials for that encloses qname. The lock must be held.
+func (a *aggressive) zone(qname string) *nsecZone {
+	for _, off := range dns.Split(qname) {
+		if z, ok := a.zones[qname[off:]]; ok {
+			return z
+		}
+	}
+	return a.zones["."]
+}
+
+// prune removes expired zones, and a random one if none have expired. The lock must be held.
+func (a *aggressive) prune(now time.Time) {
+	for zone, z := range a.zones {
+		if !z.expire.After(now) {
+			delete(a.zones, zone)
+		}
+	}
+	if len(a.zones) < maxAggressiveZones {
+		return
+	}
+	for zone := range a.zones {
+		delete(a.zones, zone)
+		return
+	}
+}
+
+// candidates returns the (unexpired) denials that could take part in a proof that qname doesn't exist, or that
+// it doesn't have a type: the ones that match or cover qname, its ancestors in the zone and the wildcards below
+// those.
+func (z *nsecZone) candidates(qname, zone string, now time.Time) []*denial {
+	names := []string{}
+	for _, off := range dns.Split(qname) {
+		name := qname[off:]
+		if !dns.IsSubDomain(zone, name) {
+			break
+		}
+		names = append(names, name, "*."+name)
+	}
+	if zone == "." {
+		names = append(names, ".", "*.")
+	}
+
+	seen := make(map[*denial]bool)
+	cands := []*denial{}
+	add := func(d *denial) {
+		if d != nil && !seen[d] && d.expire.After(now) {
+			seen[d] = true
+			cands = append(cands, d)
+		}
+	}
+
+	if len(z.nsec) > 0 {
+		for _, name := range names {
+			add(floor(z.nsec, name, validator.Compare))
+		}
+	}
+	if len(z.nsec3) > 0 {
+		n := z.nsec3[0].rrs[0].(*dns.NSEC3)
+		for _, name := range names {
+			add(floor(z.nsec3, dns.HashName(name, n.Hash, n.Iterations, n.Salt), strings.Compare))
+		}
+	}
+	return cands
+}
+
+// belowCut returns true if the NSEC or NSEC3 records show qname is at or below a zone cut or DNAME, in which case
+// they can't be used to prove anything about qname. A DS query at the zone cut is fine.
+func belowCut(nsec []dns.RR, qname string, qtype uint16) bool {
+	cut := func(owner string, types []uint16) bool {
+		ns, soa, dname := false, false, false
+		for _, t := range types {
+			switch t {
+			case dns.TypeNS:
+				ns = true
+			case dns.TypeSOA:
+				soa = true
+			case dns.TypeDNAME:
+				dname = true
+			}
+		}
+		if owner == qname {
+			return ns && !soa && qtype != dns.TypeDS
+		}
+		return (ns && !soa) || dname
+	}
+
+	for _, rr := range nsec {
+		switch n := rr.(type) {
+		case *dns.NSEC:
+			owner := strings.ToLower(n.Hdr.Name)
+			if dns.IsSubDomain(owner, qname) && cut(owner, n.TypeBitMap) {
+				return true
+			}
+		case *dns.NSEC3:
+			for _, off := range dns.Split(qname) {
+				if n.Match(qname[off:]) && cut(qname[off:], n.TypeBitMap) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// insert inserts d into the sorted slice ds, replacing a denial with the same key. If ds grows beyond size, the
+// denial that expires first is removed.
+func insert(ds []*denial, d *denial, compare func(a, b string) int, size int) []*denial {
+	i := sort.Search(len(ds), func(i int) bool { return compare(ds[i].key, d.key) >= 0 })
+	if i < len(ds) && compare(ds[i].key, d.key) == 0 {
+		ds[i] = d
+		return ds
+	}
+	ds = append(ds, nil)
+	copy(ds[i+1:], ds[i:])
+	ds[i] = d
+
+	if len(ds) <= size {
+		return ds
+	}
+	first := 0
+	for j := range ds {
+		if ds[j].expire.Before(ds[first].expire) {
+			first = j
+		}
+	}
+	return append(ds[:first], ds[first+1:]...)
+}
+
+// floor returns the denial with the largest key that is smaller than or equal to key. If there is none, the
+// last one is returned, as that one wraps around to the start of the zone.
+func floor(ds []*denial, key string, compare func(a, b string) int) *denial {
+	i := sort.Search(len(ds), func(i int) bool { return compare(ds[i].key, key) > 0 })
+	if i == 0 {
+		return ds[len(ds)-1]
+	}
+	return ds[i-1]
+}
+
+// signatures returns the RRSIGs in rrs made by the zone signer, keyed on sigKey.
+func signatures(rrs []dns.RR, signer string) map[string][]dns.RR {
+	sigs := make(map[string][]dns.RR)
+	for _, rr := range rrs {
+		s, ok := rr.(*dns.RRSIG)
+		if !ok || !strings.EqualFold(s.SignerName, signer) {
+			continue
+		}
+		k := sigKey(strings.ToLower(s.Hdr.Name), s.TypeCovered)
+		sigs[k] = append(sigs[k], s)
+	}
+	return sigs
+}
+
+func sigKey(name string, t uint16) string { return name + "/" + dns.Type(t).String() }
+
+const (
+	maxAggressiveZones = 1024
+	maxNSEC3Iterations = 150
+	optOut             = 1 // the opt-out flag in the NSEC3 flags field.
+
+	defaultAggressiveSize = 1024 // default number of NSEC and NSEC3 records we keep per zone.
+)
@@ -0,0 +1,337 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// The signatures are not checked, cache relies on the AD bit; these only need the right signer.
+func sig(covered, owner, signer string) dns.RR {
+	return test.RRSIG(fmt.Sprintf("%s 300 IN RRSIG %s 13 2 300 20300101000000 20200101000000 12345 %s aGVsbG8=", owner, covered, signer))
+}
+
+var nsecRecords = map[string][]dns.RR{
+	"example.org.":       {test.NSEC("example.org. 300 IN NSEC b.example.org. SOA NS RRSIG NSEC DNSKEY"), sig("NSEC", "example.org.", "example.org.")},
+	"b.example.org.":     {test.NSEC("b.example.org. 300 IN NSEC d.example.org. A RRSIG NSEC"), sig("NSEC", "b.example.org.", "example.org.")},
+	"d.example.org.":     {test.NSEC("d.example.org. 300 IN NSEC deleg.example.org. A RRSIG NSEC"), sig("NSEC", "d.example.org.", "example.org.")},
+	"deleg.example.org.": {test.NSEC("deleg.example.org. 300 IN NSEC www.example.org. NS RRSIG NSEC"), sig("NSEC", "deleg.example.org.", "example.org.")},
+	"www.example.org.":   {test.NSEC("www.example.org. 300 IN NSEC example.org. A RRSIG NSEC"), sig("NSEC", "www.example.org.", "example.org.")},
+}
+
+func soa(zone string) []dns.RR {
+	return []dns.RR{
+		test.SOA(zone + " 300 IN SOA ns." + zone + " hostmaster." + zone + " 1 7200 3600 1209600 300"),
+		sig("SOA", zone, zone),
+	}
+}
+
+// denialBackend answers with NXDOMAIN or NODATA, with the NSEC(3) records in the map under the qname, and counts
+// the queries it gets.
+func denialBackend(denials map[string][]string, zone string, rrs map[string][]dns.RR, ad bool, count *int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*count++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable, m.AuthenticatedData = true, true, ad
+		m.Rcode = dns.RcodeNameError
+		names, ok := denials[r.Question[0].Name]
+		if !ok {
+			m.Rcode = dns.RcodeServerFailure
+			w.WriteMsg(m)
+			return m.Rcode, nil
+		}
+		if names[0] == "nodata" {
+			m.Rcode = dns.RcodeSuccess
+			names = names[1:]
+		}
+		m.Ns = soa(zone)
+		for _, n := range names {
+			m.Ns = append(m.Ns, rrs[n]...)
+		}
+		w.WriteMsg(m)
+		return m.Rcode, nil
+	})
+}
+
+var nsecDenials = map[string][]string{
+	"c.example.org.":       {"b.example.org.", "example.org."},
+	"b.example.org.":       {"nodata", "b.example.org."},
+	"deleg.example.org.":   {"nodata", "deleg.example.org."},
+	"x.deleg.example.org.": {"deleg.example.org.", "example.org."},
+}
+
+func TestAggressiveNSEC(t *testing.T) {
+	tests := []struct {
+		qname    string
+		qtype    uint16
+		cd       bool
+		rcode    int
+		upstream bool // whether the query goes upstream.
+	}{
+		// Fills the cache.
+		{"c.example.org.", dns.TypeA, false, dns.RcodeNameError, true},
+		// Already proven by the NSEC of b.
+		{"b.example.org.", dns.TypeAAAA, false, dns.RcodeSuccess, false},
+		{"deleg.example.org.", dns.TypeDS, false, dns.RcodeSuccess, true},
+		// Covered by b -> d, and the wildcard by the apex NSEC.
+		{"c1.example.org.", dns.TypeA, false, dns.RcodeNameError, false},
+		{"c.c.example.org.", dns.TypeA, false, dns.RcodeNameError, false},
+		// Covered by the apex NSEC.
+		{"a.example.org.", dns.TypeMX, false, dns.RcodeNameError, false},
+		// Type not in the bitmap of b.
+		{"b.example.org.", dns.TypeTXT, false, dns.RcodeSuccess, false},
+		// But A is.
+		{"b.example.org.", dns.TypeA, false, dns.RcodeSuccess, true},
+		// With CD we don't synthesize anything.
+		{"c2.example.org.", dns.TypeA, true, dns.RcodeServerFailure, true},
+		// Below the delegation, or at it for something else than DS: the NSEC can't be used.
+		{"x.deleg.example.org.", dns.TypeA, false, dns.RcodeNameError, true},
+		{"deleg.example.org.", dns.TypeA, false, dns.RcodeSuccess, true},
+		// Covered by deleg -> www.
+		{"e.example.org.", dns.TypeA, false, dns.RcodeNameError, false},
+		// Not covered by anything we've seen.
+		{"da.example.org.", dns.TypeA, false, dns.RcodeServerFailure, true},
+	}
+
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, true, &count)
+
+	for i, tc := range tests {
+		before := count
+		req := new(dns.Msg)
+		req.SetQuestion(tc.qname, tc.qtype)
+		req.CheckingDisabled = tc.cd
+		req.SetEdns0(4096, true)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+		if upstream := count > before; upstream != tc.upstream {
+			t.Errorf("Test %d: expected upstream query to be %t, got %t", i, tc.upstream, upstream)
+		}
+		if tc.upstream {
+			continue
+		}
+		if !rec.Msg.AuthenticatedData {
+			t.Errorf("Test %d: expected AD bit to be set", i)
+		}
+		types := []string{}
+		for _, rr := range rec.Msg.Ns {
+			types = append(types, dns.TypeToString[rr.Header().Rrtype])
+		}
+		if s := strings.Join(types, " "); !strings.Contains(s, "SOA") || !strings.Contains(s, "NSEC") || !strings.Contains(s, "RRSIG") {
+			t.Errorf("Test %d: expected SOA, NSEC and RRSIG in authority section, got %s", i, s)
+		}
+	}
+}
+
+func TestAggressiveNSECNoDO(t *testing.T) {
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, true, &count)
+
+	req := new(dns.Msg)
+	req.SetQuestion("c.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+	req.SetQuestion("c1.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+	if rec.Msg.AuthenticatedData {
+		t.Errorf("Expected no AD bit")
+	}
+	if len(rec.Msg.Ns) != 1 {
+		t.Errorf("Expected only the SOA record, got %v", rec.Msg.Ns)
+	}
+	if count != 1 {
+		t.Errorf("Expected %d upstream query, got %d", 1, count)
+	}
+}
+
+func TestAggressiveNSECAuthenticatedData(t *testing.T) {
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, true, &count)
+
+	req := new(dns.Msg)
+	req.SetQuestion("c.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+	// Without DO, but with AD, the client wants to know if the answer is authenticated (RFC 6840, section 5.8).
+	req.SetQuestion("c1.example.org.", dns.TypeA)
+	req.AuthenticatedData = true
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if count != 1 {
+		t.Fatalf("Expected %d upstream query, got %d", 1, count)
+	}
+	if !rec.Msg.AuthenticatedData {
+		t.Errorf("Expected AD bit")
+	}
+}
+
+func TestAggressiveNSECFlush(t *testing.T) {
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, true, &count)
+
+	req := new(dns.Msg)
+	req.SetQuestion("c.example.org.", dns.TypeA)
+	req.SetEdns0(4096, true)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+	// A flush of a name in another zone leaves the denials alone.
+	c.flush(func(*item) bool { return false }, func(z string) bool { return dns.IsSubDomain(z, "example.net.") })
+	req.SetQuestion("c1.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	if count != 1 {
+		t.Fatalf("Expected %d upstream query, got %d", 1, count)
+	}
+
+	c.flush(func(i *item) bool { return dns.IsSubDomain("example.org.", i.Name) }, overlaps("example.org."))
+	req.SetQuestion("c2.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	if count != 2 {
+		t.Errorf("Expected the denials to be flushed with the zone, got %d upstream queries", count)
+	}
+}
+
+func TestAggressiveNSECNotValidated(t *testing.T) {
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, false, &count)
+
+	for _, name := range []string{"c.example.org.", "c1.example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	}
+	if count != 2 {
+		t.Errorf("Expected %d upstream queries, got %d", 2, count)
+	}
+}
+
+func TestAggressiveNSECCheckingDisabled(t *testing.T) {
+	c := New()
+	c.aggressive = newAggressive(defaultAggressiveSize)
+	count := 0
+	c.Next = denialBackend(nsecDenials, "example.org.", nsecRecords, true, &count)
+
+	// The reply to a query with CD set wasn't validated, even if the AD bit is set.
+	req := new(dns.Msg)
+	req.SetQuestion("c.example.org.", dns.TypeA)
+	req.CheckingDisabled = true
+	req.SetEdns0(4096, true)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+	req.SetQuestion("c1.example.org.", dns.TypeA)
+	req.CheckingDisabled = false
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	if count != 2 {
+		t.Errorf("Expected %d upstream queries, got %d", 2, count)
+	}
+}
+
+// nsec3Zone returns the NSEC3 records for example.net., which only has the apex and www. It returns the records
+// keyed on their owner names.
+func nsec3Zone(flags uint8) map[string][]dns.RR {
+	names := []string{"example.net.", "www.example.net."}
+	hashes := []string{}
+	for _, n := range names {
+		hashes = append(hashes, dns.HashName(n, dns.SHA1, 1, "AABB"))
+	}
+	types := map[string]string{hashes[0]: "SOA NS RRSIG DNSKEY NSEC3PARAM", hashes[1]: "A RRSIG"}
+	sort.Strings(hashes)
+
+	rrs := make(map[string][]dns.RR)
+	for i, h := range hashes {
+		next := hashes[(i+1)%len(hashes)]
+		owner := strings.ToLower(h) + ".example.net."
+		rr, err := dns.NewRR(fmt.Sprintf("%s 300 IN NSEC3 1 %d 1 AABB %s %s", owner, flags, next, types[h]))
+		if err != nil {
+			panic(err)
+		}
+		rrs[owner] = []dns.RR{rr, sig("NSEC3", owner, "example.net.")}
+	}
+	return rrs
+}
+
+func TestAggressiveNSEC3(t *testing.T) {
+	for _, tc := range []struct {
+		flags    uint8
+		upstream int
+	}{
+		{0, 1},
+		{1, 2}, // opt-out.
+	} {
+		zone := nsec3Zone(tc.flags)
+		owners := []string{}
+		for o := range zone {
+			owners = append(owners, o)
+		}
+		denials := map[string][]string{"nx.example.net.": owners, "other.example.net.": owners}
+
+		c := New()
+		c.aggressive = newAggressive(defaultAggressiveSize)
+		count := 0
+		c.Next = denialBackend(denials, "example.net.", zone, true, &count)
+
+		for _, name := range []string{"nx.example.net.", "other.example.net."} {
+			req := new(dns.Msg)
+			req.SetQuestion(name, dns.TypeA)
+			req.SetEdns0(4096, true)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			c.ServeDNS(context.TODO(), rec, req)
+			if rec.Msg.Rcode != dns.RcodeNameError {
+				t.Errorf("Flags %d: expected NXDOMAIN for %s, got %s", tc.flags, name, dns.RcodeToString[rec.Msg.Rcode])
+			}
+		}
+		if count != tc.upstream {
+			t.Errorf("Flags %d: expected %d upstream queries, got %d", tc.flags, tc.upstream, count)
+		}
+	}
+}
+
+func TestInsertFloor(t *testing.T) {
+	ds := []*denial{}
+	for _, k := range []string{"d", "b", "f", "b"} {
+		ds = insert(ds, &denial{key: k}, strings.Compare, 10)
+	}
+	if len(ds) != 3 {
+		t.Fatalf("Expected %d denials, got %d", 3, len(ds))
+	}
+	tests := []struct {
+		key, expected string
+	}{
+		{"a", "f"}, // wraps around.
+		{"b", "b"},
+		{"c", "b"},
+		{"e", "d"},
+		{"g", "f"},
+	}
+	for i, tc := range tests {
+		if d := floor(ds, tc.key, strings.Compare); d.key != tc.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, tc.expected, d.key)
+		}
+	}
+}
@@ -4,6 +4,7 @@ package cache
 import (
 	"hash/fnv"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -18,6 +19,11 @@ import (
 // Cache is a plugin that looks up responses in a cache and caches replies.
 // It has a success and a denial of existence cache.
 type Cache struct {
+	// Lookups and hits, for the hit ratio. These are accessed atomically and need to be 64 bit aligned,
+	// so keep them first.
+	lookups uint64
+	hits    uint64
+
 	Next  plugin.Handler
 	Zones []string
 
@@ -31,12 +37,39 @@ type Cache struct {
 	pttl    time.Duration
 	minpttl time.Duration
 
+	scopes   *cache.Cache // ECS scopes of the answers we've cached, see storeKey.
+	scopesMu sync.Mutex   // protects the read-modify-write of an entry in scopes.
+	policy   cache.Policy
+
 	// Prefetch.
 	prefetch   int
 	duration   time.Duration
 	percentage int
 
-	staleUpTo time.Duration
+	staleUpTo    time.Duration
+	verifyStale  bool          // RFC 8767 serve-stale: try to get a fresh answer first, see serveStale.
+	staleTimeout time.Duration // how long to wait for the fresh answer.
+
+	aggressive *aggressive // validated NSEC(3) records, for aggressive negative caching.
+
+	// Shared store, used as a second level cache.
+	store        Store
+	storePrefix  string
+	storeTimeout time.Duration
+	storeQueue   chan storeWrite // items to write to the store, see queue
+	storeOnce    sync.Once
+	storeDone    chan struct{}
+	flushes      []flushed // flushes that items read from the store must respect, see fetch.
+	flushesMu    sync.Mutex
+
+	// Admin.
+	admin      *admin
+	notifyFrom []*net.IPNet // networks we accept NOTIFYs from, to flush a zone.
+
+	// Snapshot.
+	snapshot         string
+	snapshotInterval time.Duration
+	stop             chan struct{}
 
 	// Testing.
 	now func() time.Time
@@ -46,26 +79,30 @@ type Cache struct {
 // caller to set the Next handler.
 func New() *Cache {
 	return &Cache{
-		Zones:      []string{"."},
-		pcap:       defaultCap,
-		pcache:     cache.New(defaultCap),
-		pttl:       maxTTL,
-		minpttl:    minTTL,
-		ncap:       defaultCap,
-		ncache:     cache.New(defaultCap),
-		nttl:       maxNTTL,
-		minnttl:    minNTTL,
-		prefetch:   0,
-		duration:   1 * time.Minute,
-		percentage: 10,
-		now:        time.Now,
+		Zones:        []string{"."},
+		pcap:         defaultCap,
+		pcache:       cache.New(defaultCap),
+		pttl:         maxTTL,
+		minpttl:      minTTL,
+		ncap:         defaultCap,
+		ncache:       cache.New(defaultCap),
+		scopes:       cache.New(defaultCap),
+		nttl:         maxNTTL,
+		minnttl:      minNTTL,
+		prefetch:     0,
+		duration:     1 * time.Minute,
+		percentage:   10,
+		storeTimeout: defaultStoreTimeout,
+		now:          time.Now,
 	}
 }
 
 // key returns key under which we store the item, -1 will be returned if we don't store the message.
 // Currently we do not cache Truncated, errors zone transfers or dynamic update messages.
-// qname holds the already lowercased qname.
-func key(qname string, m *dns.Msg, t response.Type) (bool, uint64) {
+// qname holds the already lowercased qname, cd is the CD bit of the request: answers to queries that
+// have it set may not be validated, so they are kept apart. Answers that are tailored to a client's
+// subnet are stored under a key derived from this one, see storeKey.
+func key(qname string, m *dns.Msg, t response.Type, cd bool) (bool, uint64) {
 	// We don't store truncated responses.
 	if m.Truncated {
 		return false, 0
@@ -75,13 +112,16 @@ func key(qname string, m *dns.Msg, t response.Type) (bool, uint64) {
 		return false, 0
 	}
 
-	return true, hash(qname, m.Question[0].Qtype)
+	return true, hash(qname, m.Question[0].Qtype, cd)
 }
 
-func hash(qname string, qtype uint16) uint64 {
+func hash(qname string, qtype uint16, cd bool) uint64 {
 	h := fnv.New64()
 	h.Write([]byte{byte(qtype >> 8)})
 	h.Write([]byte{byte(qtype)})
+	if cd {
+		h.Write([]byte{1})
+	}
 	h.Write([]byte(qname))
 	return h.Sum64()
 }
@@ -106,7 +146,11 @@ type ResponseWriter struct {
 
 	do         bool // When true the original request had the DO bit set.
 	prefetch   bool // When true write nothing back to the client.
+	stale      bool // When true we're refreshing a stale item: server failures are not cached, so it can still be served.
 	remoteAddr net.Addr
+
+	scope  uint8 // ECS scope of the reply, set with SetScope.
+	scoped bool  // When true scope has been set.
 }
 
 // newPrefetchResponseWriter returns a Cache ResponseWriter to be used in
@@ -141,12 +185,31 @@ func (w *ResponseWriter) RemoteAddr() net.Addr {
 	return w.ResponseWriter.RemoteAddr()
 }
 
+// SetScope implements the edns.Scoper interface. Lookups done by the plugins in between for other questions report
+// their scope too, the largest one is used: caching per subnet when that isn't needed is only less efficient.
+func (w *ResponseWriter) SetScope(scope uint8) {
+	if !w.scoped || scope > w.scope {
+		w.scope, w.scoped = scope, true
+	}
+}
+
+// ecsScope returns the ECS scope of res, the reply we're writing, or the one set with SetScope if that's larger.
+func (w *ResponseWriter) ecsScope(res *dns.Msg) uint8 {
+	if scope := ecsScope(res); !w.scoped || scope > w.scope {
+		return scope
+	}
+	return w.scope
+}
+
 // WriteMsg implements the dns.ResponseWriter interface.
 func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	mt, _ := response.Typify(res, w.now().UTC())
 
 	// key returns empty string for anything we don't want to cache.
-	hasKey, key := key(w.state.Name(), res, mt)
+	hasKey, key := key(w.state.Name(), res, mt, w.state.Req.CheckingDisabled)
+	if hasKey {
+		key = w.storeKey(w.state, w.ecsScope(res), key)
+	}
 
 	msgTTL := dnsutil.MinimalTTL(res, mt)
 	var duration time.Duration
@@ -159,6 +222,10 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 		duration = computeTTL(msgTTL, w.minpttl, w.pttl)
 	}
 
+	if w.stale && mt == response.ServerError {
+		hasKey = false
+	}
+
 	if hasKey && duration > 0 {
 		if w.state.Match(res) {
 			w.set(res, key, mt, duration)
@@ -176,10 +243,12 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 
 	// Apply capped TTL to this reply to avoid jarring TTL experience 1799 -> 8 (e.g.)
 	// We also may need to filter out DNSSEC records, see toMsg() for similar code.
+	// The RRs are copied when cached, as the cached item holds the original ones.
 	ttl := uint32(duration.Seconds())
-	res.Answer = filterRRSlice(res.Answer, ttl, w.do, false)
-	res.Ns = filterRRSlice(res.Ns, ttl, w.do, false)
-	res.Extra = filterRRSlice(res.Extra, ttl, w.do, false)
+	cached := hasKey && duration > 0
+	res.Answer = filterRRSlice(res.Answer, ttl, w.do, cached)
+	res.Ns = filterRRSlice(res.Ns, ttl, w.do, cached)
+	res.Extra = filterRRSlice(res.Extra, ttl, w.do, cached)
 
 	return w.ResponseWriter.WriteMsg(res)
 }
@@ -190,15 +259,27 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 	switch mt {
 	case response.NoError, response.Delegation:
 		i := newItem(m, w.now(), duration)
-		w.pcache.Add(key, i)
+		w.admit(w.pcache, key, i, Success)
 		// when pre-fetching, remove the negative cache entry if it exists
 		if w.prefetch {
 			w.ncache.Remove(key)
 		}
+		if w.store != nil && w.ecsScope(m) == 0 {
+			w.queue(key, kindSuccess, i, w.server)
+		}
 
 	case response.NameError, response.NoData, response.ServerError:
+		// Only denials the upstream validated for us are used, with CD set it didn't validate anything. The AD bit
+		// is taken at face value, so the upstream (and the path to it) must be trusted.
+		if w.aggressive != nil && !w.state.Req.CheckingDisabled && m.AuthenticatedData && mt != response.ServerError {
+			w.aggressive.add(m, w.now().UTC(), duration)
+		}
 		i := newItem(m, w.now(), duration)
-		w.ncache.Add(key, i)
+		w.admit(w.ncache, key, i, Denial)
+		// Server failures are often transient, don't spread them to the other servers.
+		if w.store != nil && w.ecsScope(m) == 0 && mt != response.ServerError {
+			w.queue(key, kindDenial, i, w.server)
+		}
 
 	case response.OtherError:
 		// don't cache these
@@ -207,6 +288,17 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 	}
 }
 
+// admit adds i to c, and counts the items evicted for it, or i itself when it isn't admitted.
+func (w *ResponseWriter) admit(c *cache.Cache, key uint64, i *item, typ string) {
+	admitted, evicted := c.Admit(key, i)
+	if evicted {
+		cacheEvictions.WithLabelValues(w.server, typ).Inc()
+	}
+	if !admitted {
+		cacheRejections.WithLabelValues(w.server, typ).Inc()
+	}
+}
+
 // Write implements the dns.ResponseWriter interface.
 func (w *ResponseWriter) Write(buf []byte) (int, error) {
 	log.Warning("Caching called with Write: not caching reply")
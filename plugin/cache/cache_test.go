@@ -198,7 +198,7 @@ func TestCache(t *testing.T) {
 		state := request.Request{W: &test.ResponseWriter{}, Req: m}
 
 		mt, _ := response.Typify(m, utc)
-		valid, k := key(state.Name(), m, mt)
+		valid, k := key(state.Name(), m, mt, false)
 
 		if valid {
 			crr.set(m, k, mt, c.pttl)
@@ -254,6 +254,22 @@ func TestCacheZeroTTL(t *testing.T) {
 	}
 }
 
+func TestCacheHitRatio(t *testing.T) {
+	c := New()
+	c.Next = ttlBackend(60)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	ctx := context.TODO()
+
+	for i := 0; i < 4; i++ {
+		c.ServeDNS(ctx, &test.ResponseWriter{}, req)
+	}
+	if c.lookups != 4 || c.hits != 3 {
+		t.Errorf("Expected 4 lookups and 3 hits, got %d and %d", c.lookups, c.hits)
+	}
+}
+
 func TestServeFromStaleCache(t *testing.T) {
 	c := New()
 	c.Next = ttlBackend(60)
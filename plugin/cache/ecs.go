@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// ecsScope returns the scope prefix length of the EDNS0 Client Subnet (RFC 7871) option in m. If there
+// is no such option 0 is returned. A non zero scope means the answer is only valid for clients in
+// that subnet, and it can't be shared with other clients.
+func ecsScope(m *dns.Msg) uint8 {
+	o := m.IsEdns0()
+	if o == nil {
+		return 0
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e.SourceScope
+		}
+	}
+	return 0
+}
+
+// scopes holds the scope prefix lengths of the last answers we cached for a name, type and CD bit, per
+// address family. A non zero scope means the answers are stored per subnet, see subnetKey.
+type scopes struct {
+	v4, v6 uint8
+}
+
+func (s *scopes) get(family uint16) uint8 {
+	if family == 1 {
+		return s.v4
+	}
+	return s.v6
+}
+
+func (s *scopes) set(family uint16, scope uint8) {
+	if family == 1 {
+		s.v4 = scope
+		return
+	}
+	s.v6 = scope
+}
+
+// clientSubnet returns the subnet an answer for state is tailored to: the one in the request's ECS option, or
+// when there isn't one, the address of the client. It returns the address family (1 for IPv4, 2 for IPv6),
+// the address and the source prefix length.
+func clientSubnet(state request.Request) (uint16, net.IP, uint8) {
+	if o := state.Req.IsEdns0(); o != nil {
+		for _, s := range o.Option {
+			if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+				return e.Family, e.Address, e.SourceNetmask
+			}
+		}
+	}
+	ip := net.ParseIP(state.IP())
+	if ip4 := ip.To4(); ip4 != nil {
+		return 1, ip4, net.IPv4len * 8
+	}
+	return 2, ip, net.IPv6len * 8
+}
+
+// subnetKey returns the key for the answer stored under key k that is valid for the subnet of ip with prefix
+// length scope.
+func subnetKey(k uint64, family uint16, ip net.IP, scope uint8) uint64 {
+	bits := net.IPv6len * 8
+	if family == 1 {
+		bits = net.IPv4len * 8
+		ip = ip.To4()
+	}
+	if int(scope) > bits {
+		scope = uint8(bits)
+	}
+	subnet := ip.Mask(net.CIDRMask(int(scope), bits))
+
+	b := make([]byte, 11)
+	binary.BigEndian.PutUint64(b, k)
+	binary.BigEndian.PutUint16(b[8:], family)
+	b[10] = scope
+
+	h := fnv.New64()
+	h.Write(b)
+	h.Write(subnet)
+	return h.Sum64()
+}
+
+// lookupKey returns the key under which we find the answer for state. If the answers for the name are tailored
+// to a subnet, the key for the client's subnet is returned.
+func (c *Cache) lookupKey(state request.Request) uint64 {
+	k := hash(state.Name(), state.QType(), state.Req.CheckingDisabled)
+	s, ok := c.scopes.Get(k)
+	if !ok {
+		return k
+	}
+	family, ip, source := clientSubnet(state)
+	scope := s.(*scopes).get(family)
+	if scope == 0 {
+		return k
+	}
+	// A client that sent a shorter prefix than the scope can't use answers that are more specific.
+	if source < scope {
+		scope = source
+	}
+	return subnetKey(k, family, ip, scope)
+}
+
+// storeKey returns the key to store the reply for state, with ECS scope scope, under. k is the key returned by
+// key. The scope of the answer is recorded, so lookupKey can find it.
+func (c *Cache) storeKey(state request.Request, scope uint8, k uint64) uint64 {
+	family, ip, source := clientSubnet(state)
+
+	// The scopes of both families share an entry, without the lock a concurrent store for the other family is lost.
+	c.scopesMu.Lock()
+	s, ok := c.scopes.Get(k)
+	if scope == 0 && !ok {
+		c.scopesMu.Unlock()
+		return k
+	}
+	ns := scopes{}
+	if ok {
+		ns = *(s.(*scopes))
+	}
+	ns.set(family, scope)
+	c.scopes.Add(k, &ns)
+	c.scopesMu.Unlock()
+
+	if scope == 0 {
+		return k
+	}
+	if source < scope {
+		scope = source
+	}
+	return subnetKey(k, family, ip, scope)
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// ecsBackend answers with the client's subnet, from the ECS option, in the answer and returns the given scope.
+func ecsBackend(scope uint8, count *int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*count++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+
+		ip := net.ParseIP("192.0.2.1")
+		if o := r.IsEdns0(); o != nil {
+			for _, s := range o.Option {
+				if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+					ip = e.Address
+					m.SetEdns0(4096, false)
+					m.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_SUBNET{
+						Code: dns.EDNS0SUBNET, Family: e.Family, SourceNetmask: e.SourceNetmask, SourceScope: scope, Address: e.Address,
+					}}
+				}
+			}
+		}
+		m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}, A: ip}}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func ecsRequest(subnet string, cd bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.CheckingDisabled = cd
+	if subnet == "" {
+		return m
+	}
+	_, n, _ := net.ParseCIDR(subnet)
+	prefix, _ := n.Mask.Size()
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(prefix), Address: n.IP.To4()}}
+	return m
+}
+
+func TestCacheECS(t *testing.T) {
+	tests := []struct {
+		scope    uint8
+		subnet   string
+		cd       bool
+		expected string // address in the answer.
+		upstream int    // expected number of upstream queries so far.
+	}{
+		{24, "10.0.0.0/24", false, "10.0.0.0", 1},
+		{24, "10.0.1.0/24", false, "10.0.1.0", 2},
+		{24, "10.0.0.0/24", false, "10.0.0.0", 2},
+		{24, "10.0.1.0/24", false, "10.0.1.0", 2},
+		// Same subnet, but with CD set.
+		{24, "10.0.0.0/24", true, "10.0.0.0", 3},
+		{24, "10.0.0.0/24", true, "10.0.0.0", 3},
+	}
+
+	c := New()
+	count := 0
+	for i, tc := range tests {
+		c.Next = ecsBackend(tc.scope, &count)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, ecsRequest(tc.subnet, tc.cd))
+
+		if count != tc.upstream {
+			t.Errorf("Test %d: expected %d upstream queries, got %d", i, tc.upstream, count)
+		}
+		if len(rec.Msg.Answer) != 1 {
+			t.Errorf("Test %d: expected 1 answer, got %d", i, len(rec.Msg.Answer))
+			continue
+		}
+		if x := rec.Msg.Answer[0].(*dns.A).A.String(); x != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expected, x)
+		}
+	}
+}
+
+func TestCacheECSScopeZero(t *testing.T) {
+	c := New()
+	count := 0
+	c.Next = ecsBackend(0, &count)
+
+	// A scope of zero means the answer is valid for everybody.
+	for _, subnet := range []string{"10.0.0.0/24", "10.0.1.0/24", ""} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, ecsRequest(subnet, false))
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 upstream query, got %d", count)
+	}
+}
+
+func TestCacheECSClientAddress(t *testing.T) {
+	c := New()
+	count := 0
+	c.Next = ecsBackend(24, &count)
+
+	// Without ECS in the request the client's address selects the subnet; the first answer is tailored to
+	// 10.0.0.0/24, test.ResponseWriter has 10.240.0.1 as remote address, so that's a miss.
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, ecsRequest("10.0.0.0/24", false))
+	c.ServeDNS(context.TODO(), rec, ecsRequest("", false))
+	if count != 2 {
+		t.Errorf("Expected 2 upstream queries, got %d", count)
+	}
+	c.ServeDNS(context.TODO(), rec, ecsRequest("10.240.0.0/24", false))
+	if count != 2 {
+		t.Errorf("Expected the answer for the client's subnet to be cached, got %d upstream queries", count)
+	}
+}
+
+func TestCacheECSSetScope(t *testing.T) {
+	c := New()
+	count := 0
+	// The backend removed the ECS option from the reply, as the client didn't send one, and sets the scope instead.
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		count++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A("example.org. 3600 IN A 127.0.0.1")}
+		edns.ScoperFrom(ctx).SetScope(24)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, ecsRequest("", false))
+	c.ServeDNS(context.TODO(), rec, ecsRequest("10.0.0.0/24", false))
+	if count != 2 {
+		t.Errorf("Expected the answer to only be cached for the client's subnet, got %d upstream queries", count)
+	}
+	c.ServeDNS(context.TODO(), rec, ecsRequest("10.240.0.0/24", false))
+	if count != 2 {
+		t.Errorf("Expected the answer for the client's subnet to be cached, got %d upstream queries", count)
+	}
+}
+
+func TestStoreKeyConcurrent(t *testing.T) {
+	c := New()
+	v4 := request.Request{W: &test.ResponseWriter{}, Req: ecsRequest("", false)}
+	v6 := request.Request{W: &test.ResponseWriter6{}, Req: ecsRequest("", false)}
+
+	// Stores for both families at the same time must both be recorded.
+	for i := 0; i < 1000; i++ {
+		k := uint64(i)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); <-start; c.storeKey(v4, 24, k) }()
+		go func() { defer wg.Done(); <-start; c.storeKey(v6, 56, k) }()
+		close(start)
+		wg.Wait()
+
+		s, ok := c.scopes.Get(k)
+		if !ok {
+			t.Fatalf("Expected scopes for key %d", k)
+		}
+		if sc := s.(*scopes); sc.v4 != 24 || sc.v6 != 56 {
+			t.Fatalf("Expected scopes 24 and 56 for key %d, got %d and %d", k, sc.v4, sc.v6)
+		}
+	}
+}
+
+func TestSubnetKey(t *testing.T) {
+	k := hash("example.org.", dns.TypeA, false)
+	a := subnetKey(k, 1, net.ParseIP("10.0.0.1"), 24)
+	if b := subnetKey(k, 1, net.ParseIP("10.0.0.200"), 24); a != b {
+		t.Errorf("Expected addresses in the same subnet to have the same key")
+	}
+	if b := subnetKey(k, 1, net.ParseIP("10.0.1.1"), 24); a == b {
+		t.Errorf("Expected addresses in different subnets to have different keys")
+	}
+	if b := subnetKey(k, 1, net.ParseIP("10.0.0.1"), 16); a == b {
+		t.Errorf("Expected different scopes to have different keys")
+	}
+	if hash("example.org.", dns.TypeA, true) == k {
+		t.Errorf("Expected the CD bit to change the key")
+	}
+}
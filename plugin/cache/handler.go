@@ -3,10 +3,12 @@ package cache
 import (
 	"context"
 	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -23,6 +25,13 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, rc)
 	}
 
+	if r.Opcode == dns.OpcodeNotify && c.notifyFrom != nil {
+		if c.serveNotify(state) {
+			return dns.RcodeSuccess, nil
+		}
+		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, rc)
+	}
+
 	now := c.now().UTC()
 	server := metrics.WithServer(ctx)
 
@@ -35,15 +44,31 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 
 	ttl := 0
 	i := c.getIgnoreTTL(now, state, server)
+	if i == nil && c.store != nil {
+		i = c.fetch(ctx, state, now, server)
+	}
 	if i != nil {
 		ttl = i.ttl(now)
 	}
+	if i == nil && c.aggressive != nil && !rc.CheckingDisabled {
+		if m := c.aggressive.synthesize(state, now, do); m != nil {
+			aggressiveAnswers.WithLabelValues(server, dns.RcodeToString[m.Rcode]).Inc()
+			w.WriteMsg(m)
+			return m.Rcode, nil
+		}
+	}
 	if i == nil {
 		if !do {
 			setDo(rc)
 		}
 		crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do}
-		return plugin.NextOrFailure(c.Name(), c.Next, ctx, crr, rc)
+		return c.next(ctx, crr, rc)
+	}
+	if ttl < 0 && c.verifyStale {
+		if !do {
+			setDo(rc)
+		}
+		return c.serveStale(ctx, state, r, server, i, ttl, now, do)
 	}
 	if ttl < 0 {
 		servedStale.WithLabelValues(server).Inc()
@@ -55,10 +80,13 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 				setDo(rc)
 			}
 			crr := &ResponseWriter{Cache: c, state: state, server: server, prefetch: true, remoteAddr: addr, do: do}
-			plugin.NextOrFailure(c.Name(), c.Next, ctx, crr, rc)
+			c.next(ctx, crr, rc)
 		}()
 	}
 	resp := i.toMsg(r, now, do)
+	if ttl < 0 {
+		setStale(resp, r)
+	}
 	w.WriteMsg(resp)
 
 	if c.shouldPrefetch(i, now) {
@@ -67,11 +95,17 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	return dns.RcodeSuccess, nil
 }
 
+// next passes r on to the next plugin, with crr as the writer. Crr is also put in the context, so a plugin that
+// reports the ECS scope of the reply (see edns.Scoper) finds it, whatever writers wrap it in between.
+func (c *Cache) next(ctx context.Context, crr *ResponseWriter, r *dns.Msg) (int, error) {
+	return plugin.NextOrFailure(c.Name(), c.Next, edns.WithScoper(ctx, crr), crr, r)
+}
+
 func (c *Cache) doPrefetch(ctx context.Context, state request.Request, server string, i *item, now time.Time) {
 	cw := newPrefetchResponseWriter(server, state, c)
 
 	cachePrefetches.WithLabelValues(server).Inc()
-	plugin.NextOrFailure(c.Name(), c.Next, ctx, cw, state.Req)
+	c.next(ctx, cw, state.Req)
 
 	// When prefetching we loose the item i, and with it the frequency
 	// that we've gathered sofar. See we copy the frequencies info back
@@ -94,29 +128,33 @@ func (c *Cache) shouldPrefetch(i *item, now time.Time) bool {
 func (c *Cache) Name() string { return "cache" }
 
 func (c *Cache) get(now time.Time, state request.Request, server string) (*item, bool) {
-	k := hash(state.Name(), state.QType())
+	k := c.lookupKey(state)
 
 	if i, ok := c.ncache.Get(k); ok && i.(*item).ttl(now) > 0 {
 		cacheHits.WithLabelValues(server, Denial).Inc()
+		c.hitRatio(server, true)
 		return i.(*item), true
 	}
 
 	if i, ok := c.pcache.Get(k); ok && i.(*item).ttl(now) > 0 {
 		cacheHits.WithLabelValues(server, Success).Inc()
+		c.hitRatio(server, true)
 		return i.(*item), true
 	}
 	cacheMisses.WithLabelValues(server).Inc()
+	c.hitRatio(server, false)
 	return nil, false
 }
 
 // getIgnoreTTL unconditionally returns an item if it exists in the cache.
 func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string) *item {
-	k := hash(state.Name(), state.QType())
+	k := c.lookupKey(state)
 
 	if i, ok := c.ncache.Get(k); ok {
 		ttl := i.(*item).ttl(now)
 		if ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds())) {
 			cacheHits.WithLabelValues(server, Denial).Inc()
+			c.hitRatio(server, true)
 			return i.(*item)
 		}
 	}
@@ -124,15 +162,27 @@ func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string
 		ttl := i.(*item).ttl(now)
 		if ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds())) {
 			cacheHits.WithLabelValues(server, Success).Inc()
+			c.hitRatio(server, true)
 			return i.(*item)
 		}
 	}
 	cacheMisses.WithLabelValues(server).Inc()
+	c.hitRatio(server, false)
 	return nil
 }
 
+// hitRatio records a lookup, and updates the hit ratio metric.
+func (c *Cache) hitRatio(server string, hit bool) {
+	lookups := atomic.AddUint64(&c.lookups, 1)
+	hits := atomic.LoadUint64(&c.hits)
+	if hit {
+		hits = atomic.AddUint64(&c.hits, 1)
+	}
+	cacheHitRatio.WithLabelValues(server).Set(float64(hits) / float64(lookups))
+}
+
 func (c *Cache) exists(state request.Request) *item {
-	k := hash(state.Name(), state.QType())
+	k := c.lookupKey(state)
 	if i, ok := c.ncache.Get(k); ok {
 		return i.(*item)
 	}
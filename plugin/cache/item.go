@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"strings"
 	"time"
 
 	"github.com/coredns/coredns/plugin/cache/freq"
@@ -8,6 +9,8 @@ import (
 )
 
 type item struct {
+	Name               string // lowercased qname
+	Type               uint16
 	Rcode              int
 	AuthenticatedData  bool
 	RecursionAvailable bool
@@ -17,12 +20,17 @@ type item struct {
 
 	origTTL uint32
 	stored  time.Time
+	failed  int64 // Unix time in nanoseconds of the last failed refresh of the stale item, accessed atomically.
 
 	*freq.Freq
 }
 
 func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 	i := new(item)
+	if len(m.Question) > 0 {
+		i.Name = strings.ToLower(m.Question[0].Name)
+		i.Type = m.Question[0].Qtype
+	}
 	i.Rcode = m.Rcode
 	i.AuthenticatedData = m.AuthenticatedData
 	i.RecursionAvailable = m.RecursionAvailable
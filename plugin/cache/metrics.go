@@ -50,4 +50,47 @@ var (
 		Name:      "served_stale_total",
 		Help:      "The number of requests served from stale cache entries.",
 	}, []string{"server"})
+	// staleAnswers is the number of stale answers served with serve_stale verify, by the reason the fresh answer wasn't used.
+	staleAnswers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "stale_answers_total",
+		Help:      "The number of stale answers served after the lookup of a fresh one timed out or failed.",
+	}, []string{"server", "reason"})
+	// aggressiveAnswers is the number of negative answers synthesized from cached NSEC and NSEC3 records.
+	aggressiveAnswers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "aggressive_nsec_answers_total",
+		Help:      "The number of negative answers synthesized from cached NSEC and NSEC3 records.",
+	}, []string{"server", "rcode"})
+	// cacheEvictions is the number of items evicted from the cache to make room for new ones.
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "The count of cache evictions.",
+	}, []string{"server", "type"})
+	// cacheRejections is the number of items not admitted to the cache, because they're used less often than
+	// the ones they would replace.
+	cacheRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "rejections_total",
+		Help:      "The count of items not admitted to the cache.",
+	}, []string{"server", "type"})
+	// cacheHitRatio is the ratio of cache hits to lookups.
+	cacheHitRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "hit_ratio",
+		Help:      "The ratio of cache hits to lookups since the cache was started.",
+	}, []string{"server"})
+	// storeRequests is the number of requests to the shared store, by operation and result.
+	storeRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "store_requests_total",
+		Help:      "The count of requests to the shared store, by operation and result.",
+	}, []string{"server", "op", "result"})
 )
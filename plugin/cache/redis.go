@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redis is a Store that talks the Redis protocol (RESP), so it can be used with Redis and compatible servers.
+// It keeps a small pool of connections, these are dialed when needed. At most maxRedisConns connections
+// are in use at any time, callers wait for one to become available.
+type redis struct {
+	addr string
+	pool chan *redisConn
+	sem  chan struct{} // holds a value for every connection in use
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newRedis(addr string) *redis {
+	return &redis{addr: addr, pool: make(chan *redisConn, maxRedisConns), sem: make(chan struct{}, maxRedisConns)}
+}
+
+// Get implements the Store interface.
+func (r *redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.do(ctx, "GET", []byte(key))
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	buf, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected reply to GET: %v", reply)
+	}
+	return buf, true, nil
+}
+
+// Set implements the Store interface.
+func (r *redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := int64(ttl / time.Millisecond)
+	if ms <= 0 {
+		return nil
+	}
+	_, err := r.do(ctx, "SET", []byte(key), value, []byte("PX"), []byte(strconv.FormatInt(ms, 10)))
+	return err
+}
+
+// Close implements the Store interface.
+func (r *redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for {
+		select {
+		case c := <-r.pool:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends the command with args and returns the reply. A connection is only returned to the pool if the
+// command went through without errors, as it may otherwise hold a partial reply.
+func (r *redis) do(ctx context.Context, cmd string, args ...[]byte) (interface{}, error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	} else {
+		c.SetDeadline(time.Time{})
+	}
+
+	reply, err := c.do(cmd, args...)
+	if err != nil {
+		c.Close()
+		<-r.sem
+		return nil, err
+	}
+	r.release(c)
+
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// conn returns a connection from the pool, or dials a new one. It waits until fewer than maxRedisConns
+// connections are in use. The connection must be given back with release, or closed and its slot freed.
+func (r *redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		<-r.sem
+		return nil, err
+	}
+	return &redisConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+func (r *redis) release(c *redisConn) {
+	defer func() { <-r.sem }()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		c.Close()
+		return
+	}
+	select {
+	case r.pool <- c:
+	default:
+		c.Close()
+	}
+}
+
+// do writes cmd and args as an array of bulk strings and reads the reply.
+func (c *redisConn) do(cmd string, args ...[]byte) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(a))
+		c.w.Write(a)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply reads a single reply from r. Simple strings are returned as strings, errors as redisError,
+// integers as int64, bulk strings as []byte and arrays as []interface{}. A null bulk string or array is
+// returned as nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed reply")
+	}
+	t, line := line[0], line[1:len(line)-2]
+
+	switch t {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxRedisBulk {
+			return nil, fmt.Errorf("bulk string of %d bytes is too large", n)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxRedisBulk {
+			return nil, fmt.Errorf("array of %d elements is too large", n)
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown reply type: %q", t)
+}
+
+const (
+	maxRedisConns = 16      // maximum number of connections in use
+	maxRedisBulk  = 1 << 20 // a cached message is much smaller than this.
+)
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server that speaks enough of the Redis protocol for the redis store.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	data map[string][]byte
+	ttls map[string]time.Duration
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	f := &fakeRedis{ln: ln, data: make(map[string][]byte), ttls: make(map[string]time.Duration)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Addr() string { return f.ln.Addr().String() }
+func (f *fakeRedis) Close()       { f.ln.Close() }
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args, ok := req.([]interface{})
+		if !ok || len(args) == 0 {
+			io.WriteString(conn, "-ERR protocol error\r\n")
+			continue
+		}
+		cmd := make([]string, len(args))
+		for i := range args {
+			b, _ := args[i].([]byte)
+			cmd[i] = string(b)
+		}
+		io.WriteString(conn, f.do(cmd))
+	}
+}
+
+func (f *fakeRedis) do(cmd []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(cmd[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		v, ok := f.data[cmd[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		if len(cmd) == 5 && strings.ToUpper(cmd[3]) == "PX" {
+			ms, err := strconv.Atoi(cmd[4])
+			if err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+			f.ttls[cmd[1]] = time.Duration(ms) * time.Millisecond
+		}
+		f.data[cmd[1]] = []byte(cmd[2])
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, k := range cmd[1:] {
+			if _, ok := f.data[k]; ok {
+				delete(f.data, k)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd[0])
+}
+
+func (f *fakeRedis) ttl(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ttls[key]
+}
+
+func (f *fakeRedis) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.data)
+}
+
+func TestRedis(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	r := newRedis(f.Addr())
+	defer r.Close()
+	ctx := context.TODO()
+
+	if _, ok, err := r.Get(ctx, "a"); err != nil || ok {
+		t.Fatalf("Expected a miss, got %t, %v", ok, err)
+	}
+	value := []byte("binary\r\n\x00value")
+	if err := r.Set(ctx, "a", value, 90*time.Second); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	buf, ok, err := r.Get(ctx, "a")
+	if err != nil || !ok {
+		t.Fatalf("Expected a hit, got %t, %v", ok, err)
+	}
+	if string(buf) != string(value) {
+		t.Errorf("Expected value %q, got %q", value, buf)
+	}
+	if ttl := f.ttl("a"); ttl != 90*time.Second {
+		t.Errorf("Expected TTL %s, got %s", 90*time.Second, ttl)
+	}
+	if _, err := r.do(ctx, "FLUSHALL"); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+	// The connection is still usable after an error reply.
+	if reply, err := r.do(ctx, "PING"); err != nil || reply != "PONG" {
+		t.Errorf("Expected PONG, got %v, %v", reply, err)
+	}
+}
+
+func TestRedisUnreachable(t *testing.T) {
+	f := newFakeRedis(t)
+	addr := f.Addr()
+	f.Close()
+
+	r := newRedis(addr)
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := r.Get(ctx, "a"); err == nil {
+		t.Errorf("Expected error for unreachable store")
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+		err      bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{"-ERR wrong\r\n", "redis: ERR wrong", false},
+		{":42\r\n", "42", false},
+		{"$5\r\nhello\r\n", "[104 101 108 108 111]", false},
+		{"$-1\r\n", "<nil>", false},
+		{"*2\r\n$1\r\na\r\n:1\r\n", "[[97] 1]", false},
+		{"*-1\r\n", "<nil>", false},
+		{"$5\r\nhel", "", true},
+		{"+OK\n", "", true},
+		{"!3\r\n", "", true},
+		{"$2000000\r\n", "", true},
+	}
+	for i, tc := range tests {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(tc.in)))
+		if tc.err {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if got := fmt.Sprintf("%v", reply); got != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expected, got)
+		}
+	}
+}
+
+func TestRedisMaxConns(t *testing.T) {
+	// A store that accepts connections, but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	r := newRedis(ln.Addr().String())
+	defer r.Close()
+	// One deadline for all: a connection that times out is closed, and a Get with time left dials a new one.
+	ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4*maxRedisConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Get(ctx, "a")
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) > maxRedisConns {
+		t.Errorf("Expected at most %d connections, got %d", maxRedisConns, len(conns))
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -27,6 +29,35 @@ func setup(c *caddy.Controller) error {
 		return ca
 	})
 
+	if ca.admin != nil {
+		c.OnStartup(ca.admin.OnStartup)
+		c.OnRestart(ca.admin.OnShutdown)
+		c.OnFinalShutdown(ca.admin.OnShutdown)
+		c.OnRestartFailed(ca.admin.OnStartup)
+	}
+
+	if ca.snapshot != "" {
+		c.OnStartup(func() error {
+			if err := ca.loadSnapshot(); err != nil {
+				log.Warningf("Starting with an empty cache: %s", err)
+			}
+			go ca.snapshotLoop()
+			return nil
+		})
+		// On a reload the new cache is started after this, so it can load our snapshot.
+		c.OnRestart(ca.saveSnapshot)
+		c.OnShutdown(func() error {
+			close(ca.stop)
+			return nil
+		})
+		c.OnFinalShutdown(ca.saveSnapshot)
+	}
+
+	if ca.store != nil {
+		c.OnShutdown(ca.stopStore)
+		c.OnShutdown(ca.store.Close)
+	}
+
 	return nil
 }
 
@@ -170,11 +201,11 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 
 			case "serve_stale":
 				args := c.RemainingArgs()
-				if len(args) > 1 {
+				if len(args) > 3 {
 					return nil, c.ArgErr()
 				}
 				ca.staleUpTo = 1 * time.Hour
-				if len(args) == 1 {
+				if len(args) > 0 && args[0] != "immediate" && args[0] != "verify" {
 					d, err := time.ParseDuration(args[0])
 					if err != nil {
 						return nil, err
@@ -183,7 +214,138 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 						return nil, errors.New("invalid negative duration for serve_stale")
 					}
 					ca.staleUpTo = d
+					args = args[1:]
+				}
+				if len(args) == 0 {
+					break
+				}
+				switch args[0] {
+				case "immediate":
+					if len(args) > 1 {
+						return nil, c.ArgErr()
+					}
+				case "verify":
+					ca.verifyStale = true
+					ca.staleTimeout = defaultStaleTimeout
+					if len(args) > 1 {
+						d, err := time.ParseDuration(args[1])
+						if err != nil {
+							return nil, err
+						}
+						if d <= 0 {
+							return nil, errors.New("invalid timeout for serve_stale, must be positive")
+						}
+						ca.staleTimeout = d
+					}
+				default:
+					return nil, fmt.Errorf("invalid refresh mode for serve_stale: %s", args[0])
+				}
+			case "eviction":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "random":
+					ca.policy = cache.Random
+				case "lru":
+					ca.policy = cache.LRU
+				case "lfu":
+					ca.policy = cache.LFU
+				default:
+					return nil, fmt.Errorf("unknown eviction policy: %s", args[0])
+				}
+			case "aggressive_nsec":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				size := defaultAggressiveSize
+				if len(args) == 1 {
+					n, err := strconv.Atoi(args[0])
+					if err != nil {
+						return nil, err
+					}
+					if n <= 0 {
+						return nil, fmt.Errorf("aggressive_nsec size must be positive: %d", n)
+					}
+					size = n
+				}
+				ca.aggressive = newAggressive(size)
+			case "admin":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if _, _, err := net.SplitHostPort(args[0]); err != nil {
+					return nil, err
+				}
+				ca.admin = &admin{Addr: args[0], c: ca}
+			case "flush_notify":
+				nets := c.RemainingArgs()
+				if len(nets) == 0 {
+					nets = []string{"127.0.0.0/8", "::1/128"}
+				}
+				ca.notifyFrom = nil
+				for _, n := range nets {
+					_, ipnet, err := net.ParseCIDR(n)
+					if err != nil {
+						return nil, err
+					}
+					ca.notifyFrom = append(ca.notifyFrom, ipnet)
 				}
+			case "snapshot":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				file := args[0]
+				config := dnsserver.GetConfig(c)
+				if !filepath.IsAbs(file) && config.Root != "" {
+					file = filepath.Join(config.Root, file)
+				}
+				ca.snapshot = file
+				ca.snapshotInterval = defaultSnapshotInterval
+				if len(args) == 2 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if d <= 0 {
+						return nil, fmt.Errorf("snapshot interval must be positive: %s", d)
+					}
+					ca.snapshotInterval = d
+				}
+				ca.stop = make(chan struct{})
+			case "store":
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				if args[0] != "redis" {
+					return nil, fmt.Errorf("unknown store: %s", args[0])
+				}
+				if _, _, err := net.SplitHostPort(args[1]); err != nil {
+					return nil, err
+				}
+				ca.store = newRedis(args[1])
+				ca.storePrefix = defaultStorePrefix
+				if len(args) == 3 {
+					ca.storePrefix = args[2]
+				}
+			case "store_timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d <= 0 {
+					return nil, fmt.Errorf("store timeout must be positive: %s", d)
+				}
+				ca.storeTimeout = d
 			default:
 				return nil, c.ArgErr()
 			}
@@ -194,8 +356,14 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 		}
 		ca.Zones = origins
 
-		ca.pcache = cache.New(ca.pcap)
-		ca.ncache = cache.New(ca.ncap)
+		ca.pcache = cache.NewWithPolicy(ca.pcap, ca.policy)
+		ca.ncache = cache.NewWithPolicy(ca.ncap, ca.policy)
+		// A scope must not be refused while the item it's for is cached: LFU doesn't admit everything.
+		scopes := ca.policy
+		if scopes == cache.LFU {
+			scopes = cache.LRU
+		}
+		ca.scopes = cache.NewWithPolicy(ca.pcap+ca.ncap, scopes)
 	}
 
 	return ca, nil
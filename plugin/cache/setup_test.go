@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/cache"
 )
 
 func TestSetup(t *testing.T) {
@@ -126,11 +127,17 @@ func TestServeStale(t *testing.T) {
 		{"serve_stale 1h20m", false, 80 * time.Minute},
 		{"serve_stale 0m", false, 0},
 		{"serve_stale 0", false, 0},
+		{"serve_stale 20m immediate", false, 20 * time.Minute},
+		{"serve_stale 20m verify", false, 20 * time.Minute},
+		{"serve_stale verify 500ms", false, 1 * time.Hour},
 		// fails
 		{"serve_stale 20", true, 0},
 		{"serve_stale -20m", true, 0},
 		{"serve_stale aa", true, 0},
 		{"serve_stale 1m nono", true, 0},
+		{"serve_stale 1m immediate 1s", true, 0},
+		{"serve_stale 1m verify 0s", true, 0},
+		{"serve_stale 1m verify 1s 2s", true, 0},
 	}
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
@@ -150,3 +157,232 @@ func TestServeStale(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupSnapshot(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedFile     string
+		expectedInterval time.Duration
+	}{
+		{"snapshot /tmp/cache", false, "/tmp/cache", defaultSnapshotInterval},
+		{"snapshot /tmp/cache 30s", false, "/tmp/cache", 30 * time.Second},
+		// fails
+		{"snapshot", true, "", 0},
+		{"snapshot /tmp/cache 0s", true, "", 0},
+		{"snapshot /tmp/cache aa", true, "", 0},
+		{"snapshot /tmp/cache 30s 40s", true, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.snapshot != test.expectedFile {
+			t.Errorf("Test %v: Expected snapshot %q but found: %q", i, test.expectedFile, ca.snapshot)
+		}
+		if ca.snapshotInterval != test.expectedInterval {
+			t.Errorf("Test %v: Expected snapshot interval %v but found: %v", i, test.expectedInterval, ca.snapshotInterval)
+		}
+	}
+}
+
+func TestSetupEviction(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		policy    cache.Policy
+	}{
+		{"", false, cache.Random},
+		{"eviction random", false, cache.Random},
+		{"eviction lru", false, cache.LRU},
+		{"eviction lfu", false, cache.LFU},
+		// fails
+		{"eviction", true, cache.Random},
+		{"eviction fifo", true, cache.Random},
+		{"eviction lru lfu", true, cache.Random},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.policy != test.policy {
+			t.Errorf("Test %v: Expected policy %v but found: %v", i, test.policy, ca.policy)
+		}
+		// The scopes of the cached items are always admitted, whatever the policy.
+		for k := uint64(0); k < 4*defaultCap; k++ {
+			if admitted, _ := ca.scopes.Admit(k, &scopes{}); !admitted {
+				t.Errorf("Test %v: Expected scope %d to be admitted", i, k)
+				break
+			}
+		}
+	}
+}
+
+func TestSetupAdmin(t *testing.T) {
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedAdmin  string
+		expectedNotify int
+	}{
+		{"admin localhost:8054", false, "localhost:8054", 0},
+		{"flush_notify", false, "", 2},
+		{"flush_notify 10.0.0.0/8 2001:db8::/32 192.0.2.1/32", false, "", 3},
+		// fails
+		{"admin", true, "", 0},
+		{"admin localhost", true, "", 0},
+		{"admin localhost:8054 localhost:8055", true, "", 0},
+		{"flush_notify 10.0.0.1", true, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		addr := ""
+		if ca.admin != nil {
+			addr = ca.admin.Addr
+		}
+		if addr != test.expectedAdmin {
+			t.Errorf("Test %v: Expected admin %q but found: %q", i, test.expectedAdmin, addr)
+		}
+		if len(ca.notifyFrom) != test.expectedNotify {
+			t.Errorf("Test %v: Expected %d notify networks but found: %d", i, test.expectedNotify, len(ca.notifyFrom))
+		}
+	}
+}
+
+func TestServeStaleVerify(t *testing.T) {
+	tests := []struct {
+		input        string
+		verify       bool
+		staleTimeout time.Duration
+	}{
+		{"serve_stale", false, 0},
+		{"serve_stale 20m immediate", false, 0},
+		{"serve_stale 20m verify", true, defaultStaleTimeout},
+		{"serve_stale verify 500ms", true, 500 * time.Millisecond},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if err != nil {
+			t.Fatalf("Test %v: Expected no error but found error: %v", i, err)
+		}
+		if ca.verifyStale != test.verify {
+			t.Errorf("Test %v: Expected verify %t but found: %t", i, test.verify, ca.verifyStale)
+		}
+		if ca.staleTimeout != test.staleTimeout {
+			t.Errorf("Test %v: Expected stale timeout %v but found: %v", i, test.staleTimeout, ca.staleTimeout)
+		}
+	}
+}
+
+func TestSetupAggressiveNSEC(t *testing.T) {
+	tests := []struct {
+		input        string
+		shouldErr    bool
+		expectedSize int
+	}{
+		{"", false, 0},
+		{"aggressive_nsec", false, defaultAggressiveSize},
+		{"aggressive_nsec 100", false, 100},
+		// fails
+		{"aggressive_nsec 0", true, 0},
+		{"aggressive_nsec aa", true, 0},
+		{"aggressive_nsec 10 20", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		size := 0
+		if ca.aggressive != nil {
+			size = ca.aggressive.size
+		}
+		if size != test.expectedSize {
+			t.Errorf("Test %v: Expected size %d but found: %d", i, test.expectedSize, size)
+		}
+	}
+}
+
+func TestSetupStore(t *testing.T) {
+	tests := []struct {
+		input           string
+		shouldErr       bool
+		expectedStore   bool
+		expectedPrefix  string
+		expectedTimeout time.Duration
+	}{
+		{"", false, false, "", defaultStoreTimeout},
+		{"store redis 127.0.0.1:6379", false, true, defaultStorePrefix, defaultStoreTimeout},
+		{"store redis 127.0.0.1:6379 dns:", false, true, "dns:", defaultStoreTimeout},
+		{"store redis 127.0.0.1:6379\nstore_timeout 200ms", false, true, defaultStorePrefix, 200 * time.Millisecond},
+		// fails
+		{"store redis", true, false, "", 0},
+		{"store memcached 127.0.0.1:11211", true, false, "", 0},
+		{"store redis 127.0.0.1", true, false, "", 0},
+		{"store redis 127.0.0.1:6379 a b", true, false, "", 0},
+		{"store_timeout", true, false, "", 0},
+		{"store_timeout 0s", true, false, "", 0},
+		{"store_timeout aa", true, false, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if (ca.store != nil) != test.expectedStore {
+			t.Errorf("Test %v: Expected store %t but found: %t", i, test.expectedStore, ca.store != nil)
+		}
+		if ca.storePrefix != test.expectedPrefix {
+			t.Errorf("Test %v: Expected prefix %q but found: %q", i, test.expectedPrefix, ca.storePrefix)
+		}
+		if ca.storeTimeout != test.expectedTimeout {
+			t.Errorf("Test %v: Expected timeout %s but found: %s", i, test.expectedTimeout, ca.storeTimeout)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A snapshot file starts with snapshotMagic and a version byte, followed by entries. Each entry is a
+// snapshotHeader and Len bytes of payload. For items the payload is the message in wire format, for
+// scopes it's the v4 and v6 scope.
+const (
+	snapshotMagic   = "CDNSCACHE"
+	snapshotVersion = 1
+)
+
+const (
+	kindSuccess = iota
+	kindDenial
+	kindScope
+)
+
+type snapshotHeader struct {
+	Kind   uint8
+	Key    uint64
+	Stored int64  // Unix time in nanoseconds the item was stored.
+	TTL    uint32 // Original TTL of the item.
+	Len    uint32
+}
+
+// saveSnapshot writes the contents of the cache to c.snapshot. The file is written to a temporary file
+// first, and then renamed, so a crash while writing leaves the previous snapshot intact.
+func (c *Cache) saveSnapshot() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(c.snapshot), filepath.Base(c.snapshot)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails with not exist when the rename succeeded.
+
+	n, err := c.writeSnapshot(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.snapshot); err != nil {
+		return err
+	}
+	log.Debugf("Wrote %d items to snapshot %s", n, c.snapshot)
+	return nil
+}
+
+// writeSnapshot writes the items in the cache that haven't expired to w. It returns the number of items
+// written.
+func (c *Cache) writeSnapshot(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+
+	now := c.now().UTC()
+	n := 0
+	var err error
+	items := func(kind uint8) func(uint64, interface{}) bool {
+		return func(k uint64, el interface{}) bool {
+			i := el.(*item)
+			if c.expired(i, now) {
+				return true
+			}
+			buf, err1 := i.pack()
+			if err1 != nil {
+				return true // Not something we can store, skip it.
+			}
+			h := snapshotHeader{Kind: kind, Key: k, Stored: i.stored.UnixNano(), TTL: i.origTTL, Len: uint32(len(buf))}
+			if err = writeEntry(bw, h, buf); err != nil {
+				return false
+			}
+			n++
+			return true
+		}
+	}
+	c.pcache.Walk(items(kindSuccess))
+	if err == nil {
+		c.ncache.Walk(items(kindDenial))
+	}
+	if err == nil {
+		c.scopes.Walk(func(k uint64, el interface{}) bool {
+			s := el.(*scopes)
+			h := snapshotHeader{Kind: kindScope, Key: k, Len: 2}
+			err = writeEntry(bw, h, []byte{s.v4, s.v6})
+			return err == nil
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, bw.Flush()
+}
+
+func writeEntry(w io.Writer, h snapshotHeader, buf []byte) error {
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// loadSnapshot reads the snapshot in c.snapshot into the cache. A missing snapshot isn't an error, the cache
+// then starts empty.
+func (c *Cache) loadSnapshot() error {
+	f, err := os.Open(c.snapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := c.readSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %s", c.snapshot, err)
+	}
+	log.Infof("Loaded %d items from snapshot %s", n, c.snapshot)
+	return nil
+}
+
+// readSnapshot reads a snapshot from r and adds the items that haven't expired to the cache. The items keep
+// the time they were stored, so their TTLs are decreased with the time passed since. It returns the number
+// of items added.
+func (c *Cache) readSnapshot(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return 0, err
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, errors.New("not a cache snapshot")
+	}
+	if v := magic[len(snapshotMagic)]; v != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version: %d", v)
+	}
+
+	now := c.now().UTC()
+	n := 0
+	for {
+		h := snapshotHeader{}
+		if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		buf := make([]byte, h.Len)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return n, err
+		}
+
+		if h.Kind == kindScope {
+			if len(buf) != 2 {
+				return n, fmt.Errorf("malformed scope entry of length %d", len(buf))
+			}
+			c.scopes.Add(h.Key, &scopes{v4: buf[0], v6: buf[1]})
+			continue
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(buf); err != nil {
+			return n, err
+		}
+		i := newItem(m, time.Unix(0, h.Stored), time.Duration(h.TTL)*time.Second)
+		if c.expired(i, now) {
+			continue
+		}
+		switch h.Kind {
+		case kindSuccess:
+			c.pcache.Add(h.Key, i)
+		case kindDenial:
+			c.ncache.Add(h.Key, i)
+		default:
+			return n, fmt.Errorf("unknown snapshot entry kind: %d", h.Kind)
+		}
+		n++
+	}
+}
+
+// expired returns true when i can't be served anymore, not even as a stale item.
+func (c *Cache) expired(i *item, now time.Time) bool {
+	ttl := i.ttl(now)
+	return ttl <= 0 && -ttl >= int(c.staleUpTo.Seconds())
+}
+
+// pack returns the message held in i in wire format.
+func (i *item) pack() ([]byte, error) {
+	m := new(dns.Msg)
+	if i.Name != "" {
+		m.Question = []dns.Question{{Name: i.Name, Qtype: i.Type, Qclass: dns.ClassINET}}
+	}
+	m.Response = true
+	m.Rcode = i.Rcode
+	m.AuthenticatedData = i.AuthenticatedData
+	m.RecursionAvailable = i.RecursionAvailable
+	m.Answer = i.Answer
+	m.Ns = i.Ns
+	m.Extra = i.Extra
+	m.Compress = true
+	return m.Pack()
+}
+
+// snapshotLoop writes a snapshot every c.snapshotInterval, until c.stop is closed.
+func (c *Cache) snapshotLoop() {
+	tick := time.NewTicker(c.snapshotInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := c.saveSnapshot(); err != nil {
+				log.Warningf("Failed to write snapshot %s: %s", c.snapshot, err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// defaultSnapshotInterval is the default interval between snapshots.
+const defaultSnapshotInterval = 5 * time.Minute
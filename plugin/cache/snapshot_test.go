@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// snapshotHandler answers example.org. with an A record with a TTL of 100s, and everything else with NXDOMAIN.
+func snapshotHandler() plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+		if r.Question[0].Name == "example.org." {
+			m.Answer = []dns.RR{test.A("example.org. 100 IN A 127.0.0.53")}
+		} else {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = []dns.RR{test.SOA("org. 100 IN SOA ns.org. hostmaster.org. 1 7200 3600 1209600 100")}
+		}
+		w.WriteMsg(m)
+		return m.Rcode, nil
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	t0 := time.Now().UTC()
+	c := New()
+	c.now = func() time.Time { return t0 }
+	c.Next = snapshotHandler()
+
+	for _, name := range []string{"example.org.", "missing.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := c.writeSnapshot(buf)
+	if err != nil {
+		t.Fatalf("Expected no error writing snapshot, got %s", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected %d items in snapshot, got %d", 2, n)
+	}
+
+	tests := []struct {
+		after    time.Duration
+		stale    time.Duration
+		expected int
+	}{
+		{30 * time.Second, 0, 2},
+		{200 * time.Second, 0, 0},
+		{200 * time.Second, 1 * time.Hour, 2},
+	}
+	for i, tc := range tests {
+		c1 := New()
+		c1.staleUpTo = tc.stale
+		c1.now = func() time.Time { return t0.Add(tc.after) }
+		c1.Next = test.ErrorHandler()
+
+		n, err := c1.readSnapshot(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Test %d: expected no error reading snapshot, got %s", i, err)
+		}
+		if n != tc.expected {
+			t.Errorf("Test %d: expected %d items, got %d", i, tc.expected, n)
+		}
+		if n == 0 || tc.stale > 0 {
+			continue
+		}
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c1.ServeDNS(context.TODO(), rec, req)
+		if len(rec.Msg.Answer) != 1 {
+			t.Fatalf("Test %d: expected an answer from the cache, got %v", i, rec.Msg)
+		}
+		if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != 70 {
+			t.Errorf("Test %d: expected TTL %d, got %d", i, 70, ttl)
+		}
+
+		req.SetQuestion("missing.org.", dns.TypeA)
+		c1.ServeDNS(context.TODO(), rec, req)
+		if rec.Msg.Rcode != dns.RcodeNameError {
+			t.Errorf("Test %d: expected NXDOMAIN from the cache, got %s", i, dns.RcodeToString[rec.Msg.Rcode])
+		}
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New()
+	c.snapshot = filepath.Join(dir, "snapshot")
+	// A missing snapshot is fine.
+	if err := c.loadSnapshot(); err != nil {
+		t.Fatalf("Expected no error for missing snapshot, got %s", err)
+	}
+
+	c.Next = snapshotHandler()
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	if err := c.saveSnapshot(); err != nil {
+		t.Fatalf("Expected no error saving snapshot, got %s", err)
+	}
+
+	c1 := New()
+	c1.snapshot = c.snapshot
+	if err := c1.loadSnapshot(); err != nil {
+		t.Fatalf("Expected no error loading snapshot, got %s", err)
+	}
+	if c1.pcache.Len() != 1 {
+		t.Errorf("Expected %d item in cache, got %d", 1, c1.pcache.Len())
+	}
+
+	if err := ioutil.WriteFile(c.snapshot, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.loadSnapshot(); err == nil {
+		t.Errorf("Expected error loading a corrupt snapshot, got none")
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// serveStale implements serve-stale as described in RFC 8767: the stale item i is only used when the next plugin
+// doesn't reply within c.staleTimeout, or replies with an error. The lookup continues in the background after the
+// timeout, so the cache gets refreshed. When a lookup fails, i is served right away, without a new lookup, for the
+// next staleRecheck. ttl is the (negative) TTL of i, r is the original request and state holds the request as sent
+// to the next plugin, with the DO bit set.
+func (c *Cache) serveStale(ctx context.Context, state request.Request, r *dns.Msg, server string, i *item, ttl int, now time.Time, do bool) (int, error) {
+	w := state.W
+	stale := func() (int, error) {
+		servedStale.WithLabelValues(server).Inc()
+		// Adjust the time to get a staleAnswerTTL TTL in the reply built from the stale item.
+		resp := i.toMsg(r, now.Add(time.Duration(ttl)*time.Second-staleAnswerTTL), do)
+		setStale(resp, r)
+		w.WriteMsg(resp)
+		return dns.RcodeSuccess, nil
+	}
+
+	if i.recheck(now) {
+		staleAnswers.WithLabelValues(server, "recheck").Inc()
+		return stale()
+	}
+
+	type result struct {
+		m     *dns.Msg
+		rcode int
+		err   error
+	}
+	done := make(chan result, 1)
+
+	// The lookup may outlive the client's connection, so it gets a writer and request of its own.
+	sw := &staleWriter{local: w.LocalAddr(), remote: w.RemoteAddr()}
+	bg := request.Request{W: sw, Req: state.Req}
+	go func() {
+		crr := &ResponseWriter{ResponseWriter: sw, Cache: c, state: bg, server: server, do: do, stale: true}
+		rcode, err := c.next(ctx, crr, bg.Req)
+		if !usable(sw.msg, err) {
+			i.refreshFailed(c.now())
+		}
+		done <- result{sw.msg, rcode, err}
+	}()
+
+	timer := time.NewTimer(c.staleTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		if usable(res.m, res.err) {
+			w.WriteMsg(res.m)
+			return res.rcode, nil
+		}
+		staleAnswers.WithLabelValues(server, "error").Inc()
+	case <-timer.C:
+		staleAnswers.WithLabelValues(server, "timeout").Inc()
+	}
+	return stale()
+}
+
+// usable returns true when m, the reply to the lookup of a fresh answer, can be used instead of the stale item.
+func usable(m *dns.Msg, err error) bool {
+	return err == nil && m != nil && m.Rcode != dns.RcodeServerFailure && m.Rcode != dns.RcodeRefused
+}
+
+// refreshFailed records that the lookup of a fresh answer for the stale item i failed at now.
+func (i *item) refreshFailed(now time.Time) { atomic.StoreInt64(&i.failed, now.UnixNano()) }
+
+// recheck returns true when the last refresh of i failed less than staleRecheck before now. This is the failure
+// recheck timer of RFC 8767 section 4.
+func (i *item) recheck(now time.Time) bool {
+	failed := atomic.LoadInt64(&i.failed)
+	return failed != 0 && now.Sub(time.Unix(0, failed)) < staleRecheck
+}
+
+// staleWriter is the dns.ResponseWriter for the lookup of a fresh answer in serveStale. It keeps the reply, and
+// has the addresses of the client's connection, which may be closed by the time the lookup finishes.
+type staleWriter struct {
+	local, remote net.Addr
+	msg           *dns.Msg
+}
+
+func (w *staleWriter) LocalAddr() net.Addr         { return w.local }
+func (w *staleWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *staleWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *staleWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *staleWriter) Close() error                { return nil }
+func (w *staleWriter) TsigStatus() error           { return nil }
+func (w *staleWriter) TsigTimersOnly(bool)         {}
+func (w *staleWriter) Hijack()                     {}
+
+// setStale adds an Extended DNS Error to m, the reply to r built from a stale item, to tell the client the answer is
+// stale. This is only done when the client used EDNS0.
+func setStale(m, r *dns.Msg) {
+	if r.IsEdns0() == nil {
+		return
+	}
+	code := edns.ExtendedErrorStaleAnswer
+	if m.Rcode == dns.RcodeNameError {
+		code = edns.ExtendedErrorStaleNXDOMAINAnswer
+	}
+	edns.SetExtendedError(m, code, "")
+}
+
+const (
+	// staleAnswerTTL is the TTL of stale answers, RFC 8767 section 4 recommends 30 seconds.
+	staleAnswerTTL = 30 * time.Second
+	// defaultStaleTimeout is the default time to wait for a fresh answer, before answering with a stale one. This is the
+	// client response timer of RFC 8767 section 5.
+	defaultStaleTimeout = 1800 * time.Millisecond
+	// staleRecheck is how long a stale item is served without trying to refresh it, after a refresh failed. This is the
+	// failure recheck timer of RFC 8767 section 4, which recommends 30 seconds.
+	staleRecheck = 30 * time.Second
+)
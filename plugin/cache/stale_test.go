@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// staleBackend answers with an A record with address ip after delay, or with rcode if it isn't NOERROR. After
+// writing the reply it signals on done.
+func staleBackend(ip string, rcode int, delay time.Duration, done chan<- struct{}) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		defer func() { done <- struct{}{} }()
+		time.Sleep(delay)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+		m.Rcode = rcode
+		if rcode == dns.RcodeSuccess {
+			m.Answer = []dns.RR{test.A("example.org. 60 IN A " + ip)}
+		}
+		w.WriteMsg(m)
+		return rcode, nil
+	})
+}
+
+func TestServeStaleVerifyMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          string // backend reply.
+		rcode       int
+		delay       time.Duration
+		expectedIP  string
+		expectedTTL uint32
+		refreshed   bool // whether the cache holds the new answer afterwards.
+	}{
+		{"fresh", "127.0.0.2", dns.RcodeSuccess, 0, "127.0.0.2", 60, true},
+		{"timeout", "127.0.0.2", dns.RcodeSuccess, 200 * time.Millisecond, "127.0.0.1", 30, true},
+		{"servfail", "", dns.RcodeServerFailure, 0, "127.0.0.1", 30, false},
+		{"refused", "", dns.RcodeRefused, 0, "127.0.0.1", 30, false},
+	}
+
+	for _, tc := range tests {
+		done := make(chan struct{}, 3)
+		now := time.Now().UTC()
+		c := New()
+		c.staleUpTo = time.Hour
+		c.verifyStale = true
+		c.staleTimeout = 50 * time.Millisecond
+		c.now = func() time.Time { return now }
+		c.Next = staleBackend("127.0.0.1", dns.RcodeSuccess, 0, done)
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+		<-done
+
+		// The item is stale now.
+		now = now.Add(2 * time.Minute)
+		c.Next = staleBackend(tc.ip, tc.rcode, tc.delay, done)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+		if len(rec.Msg.Answer) != 1 {
+			t.Fatalf("Test %s: expected 1 answer, got %v", tc.name, rec.Msg)
+		}
+		a := rec.Msg.Answer[0].(*dns.A)
+		if a.A.String() != tc.expectedIP {
+			t.Errorf("Test %s: expected %s, got %s", tc.name, tc.expectedIP, a.A)
+		}
+		if a.Hdr.Ttl != tc.expectedTTL {
+			t.Errorf("Test %s: expected TTL %d, got %d", tc.name, tc.expectedTTL, a.Hdr.Ttl)
+		}
+
+		// Wait for the background lookup to finish.
+		<-done
+		if c.ncache.Len() != 0 {
+			t.Errorf("Test %s: expected nothing in the negative cache, got %d items", tc.name, c.ncache.Len())
+		}
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+		c.Next = staleBackend("", dns.RcodeServerFailure, 0, done)
+		c.ServeDNS(context.TODO(), rec, req)
+		refreshed := len(rec.Msg.Answer) == 1 && rec.Msg.Answer[0].(*dns.A).A.String() == "127.0.0.2"
+		if refreshed != tc.refreshed {
+			t.Errorf("Test %s: expected refreshed to be %t, got %t", tc.name, tc.refreshed, refreshed)
+		}
+	}
+}
+
+func TestServeStaleExtendedError(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		done := make(chan struct{}, 3)
+		now := time.Now().UTC()
+		c := New()
+		c.staleUpTo = time.Hour
+		c.verifyStale = verify
+		c.staleTimeout = 50 * time.Millisecond
+		c.now = func() time.Time { return now }
+		c.Next = staleBackend("127.0.0.1", dns.RcodeSuccess, 0, done)
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		req.SetEdns0(4096, false)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+		<-done
+		if _, _, ok := edns.ExtendedError(rec.Msg); ok {
+			t.Errorf("Verify %t: expected no extended error for a fresh answer", verify)
+		}
+
+		now = now.Add(2 * time.Minute)
+		c.Next = staleBackend("", dns.RcodeServerFailure, 0, done)
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+		<-done
+		if code, _, ok := edns.ExtendedError(rec.Msg); !ok || code != edns.ExtendedErrorStaleAnswer {
+			t.Errorf("Verify %t: expected extended error %d, got %d", verify, edns.ExtendedErrorStaleAnswer, code)
+		}
+	}
+}
+
+func TestServeStaleRecheck(t *testing.T) {
+	done := make(chan struct{}, 3)
+	now := time.Now().UTC()
+	c := New()
+	c.staleUpTo = time.Hour
+	c.verifyStale = true
+	c.staleTimeout = 50 * time.Millisecond
+	c.now = func() time.Time { return now }
+	c.Next = staleBackend("127.0.0.1", dns.RcodeSuccess, 0, done)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	<-done
+
+	// The refresh of the stale item fails.
+	now = now.Add(2 * time.Minute)
+	c.Next = staleBackend("", dns.RcodeServerFailure, 0, done)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	<-done
+
+	// Within the recheck interval the stale item is served without a lookup.
+	now = now.Add(staleRecheck - time.Second)
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		t.Errorf("Expected no lookup within the recheck interval")
+		return dns.RcodeServerFailure, nil
+	})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "127.0.0.1" {
+		t.Fatalf("Expected the stale answer, got %v", rec.Msg)
+	}
+
+	// After it the cache tries to refresh the item again.
+	now = now.Add(2 * time.Second)
+	c.Next = staleBackend("127.0.0.2", dns.RcodeSuccess, 0, done)
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	<-done
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "127.0.0.2" {
+		t.Errorf("Expected the fresh answer, got %v", rec.Msg)
+	}
+}
+
+// closingWriter is a test.ResponseWriter that fails the test when its addresses are used after it's closed.
+type closingWriter struct {
+	test.ResponseWriter
+	t      *testing.T
+	closed int32
+}
+
+func (w *closingWriter) RemoteAddr() net.Addr {
+	if atomic.LoadInt32(&w.closed) != 0 {
+		w.t.Errorf("Expected the remote address not to be used after the reply was written")
+	}
+	return w.ResponseWriter.RemoteAddr()
+}
+
+func (w *closingWriter) LocalAddr() net.Addr {
+	if atomic.LoadInt32(&w.closed) != 0 {
+		w.t.Errorf("Expected the local address not to be used after the reply was written")
+	}
+	return w.ResponseWriter.LocalAddr()
+}
+
+func TestServeStaleClientGone(t *testing.T) {
+	done := make(chan struct{}, 3)
+	now := time.Now().UTC()
+	c := New()
+	c.staleUpTo = time.Hour
+	c.verifyStale = true
+	c.staleTimeout = 50 * time.Millisecond
+	c.now = func() time.Time { return now }
+	c.Next = staleBackend("127.0.0.1", dns.RcodeSuccess, 0, done)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	<-done
+
+	// The lookup times out, its answer is tailored to the client's subnet, so the cache needs the client's
+	// address when it's written.
+	now = now.Add(2 * time.Minute)
+	ips := make(chan string, 1)
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		defer func() { done <- struct{}{} }()
+		time.Sleep(200 * time.Millisecond)
+		state := request.Request{W: w, Req: r}
+		ips <- state.IP()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A("example.org. 60 IN A 127.0.0.2")}
+		m.SetEdns0(4096, false)
+		o := m.IsEdns0()
+		o.Option = append(o.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, SourceScope: 24, Address: net.ParseIP("10.240.0.0").To4()})
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+	w := &closingWriter{t: t}
+	c.ServeDNS(context.TODO(), w, req)
+	atomic.StoreInt32(&w.closed, 1)
+	<-done
+	if ip := <-ips; ip != "10.240.0.1" {
+		t.Errorf("Expected the client's address 10.240.0.1, got %s", ip)
+	}
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "127.0.0.2" {
+		t.Errorf("Expected the refreshed answer for the client's subnet, got %v", rec.Msg)
+	}
+}
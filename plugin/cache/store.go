@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Store is a shared store for cached replies. It's used as a second level cache behind the in-memory one, so
+// multiple servers can share what they've cached. Values are opaque to the store and should expire after ttl.
+type Store interface {
+	// Get returns the value stored under key. If there is none, false is returned.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Close closes the connections to the store.
+	Close() error
+}
+
+// fetch looks up the reply for state in the store. If it's there it's also added to the in-memory cache. The
+// answer data in the store is trusted as is; the AD bit is not, it's cleared.
+func (c *Cache) fetch(ctx context.Context, state request.Request, now time.Time, server string) *item {
+	k := c.lookupKey(state)
+
+	ctx, cancel := context.WithTimeout(ctx, c.storeTimeout)
+	defer cancel()
+	buf, ok, err := c.store.Get(ctx, c.storePrefix+strconv.FormatUint(k, 16))
+	if err != nil {
+		storeRequests.WithLabelValues(server, "get", "error").Inc()
+		log.Debugf("Failed to get from store: %s", err)
+		return nil
+	}
+	if !ok {
+		storeRequests.WithLabelValues(server, "get", "miss").Inc()
+		return nil
+	}
+
+	kind, i, err := decodeItem(buf)
+	if err != nil {
+		storeRequests.WithLabelValues(server, "get", "error").Inc()
+		log.Debugf("Failed to decode item from store: %s", err)
+		return nil
+	}
+	// Keys are hashes, and anyone with access to the store can write to it; never answer with a reply
+	// for another question. Items are stored for class IN only.
+	if i.Name != state.Name() || i.Type != state.QType() || state.QClass() != dns.ClassINET {
+		storeRequests.WithLabelValues(server, "get", "error").Inc()
+		log.Debugf("Item from store is for %s %s, not for %s %s", i.Name, dns.Type(i.Type), state.Name(), dns.Type(state.QType()))
+		return nil
+	}
+	// A client with another view on time may have stored this, or the store might not expire things in time.
+	// What we flushed from the cache must not come back from the store either.
+	if c.expired(i, now) || c.isFlushed(i, now) {
+		storeRequests.WithLabelValues(server, "get", "miss").Inc()
+		return nil
+	}
+	storeRequests.WithLabelValues(server, "get", "hit").Inc()
+	// We can't tell who validated this, so we don't vouch for it.
+	i.AuthenticatedData = false
+
+	switch kind {
+	case kindSuccess:
+		c.pcache.Add(k, i)
+	case kindDenial:
+		c.ncache.Add(k, i)
+	}
+	return i
+}
+
+// flushed is a flush of the cache: items stored before at for which match returns true are flushed. Items in the
+// store are gone by until.
+type flushed struct {
+	match func(*item) bool
+	at    time.Time
+	until time.Time
+}
+
+// addFlushed records a flush of the items for which match returns true, so fetch skips these items in the store.
+func (c *Cache) addFlushed(match func(*item) bool, now time.Time) {
+	ttl := c.pttl
+	if c.nttl > ttl {
+		ttl = c.nttl
+	}
+	c.flushesMu.Lock()
+	defer c.flushesMu.Unlock()
+	c.pruneFlushed(now)
+	c.flushes = append(c.flushes, flushed{match: match, at: now, until: now.Add(ttl + c.staleUpTo)})
+}
+
+// isFlushed returns true if i, an item from the store, was stored before a flush that matches it.
+func (c *Cache) isFlushed(i *item, now time.Time) bool {
+	c.flushesMu.Lock()
+	defer c.flushesMu.Unlock()
+	c.pruneFlushed(now)
+	for _, f := range c.flushes {
+		if i.stored.Before(f.at) && f.match(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneFlushed removes the flushes that no item in the store can predate anymore. The caller must hold flushesMu.
+func (c *Cache) pruneFlushed(now time.Time) {
+	j := 0
+	for _, f := range c.flushes {
+		if now.Before(f.until) {
+			c.flushes[j] = f
+			j++
+		}
+	}
+	c.flushes = c.flushes[:j]
+}
+
+// storeWrite is an item waiting in the queue to be written to the store.
+type storeWrite struct {
+	k      uint64
+	kind   uint8
+	i      *item
+	server string
+}
+
+// queue queues i, the reply cached under k, to be written to the store. The queue is bounded, when it is
+// full, because the store is slow or unreachable, i is dropped.
+func (c *Cache) queue(k uint64, kind uint8, i *item, server string) {
+	c.storeOnce.Do(c.startStore)
+	select {
+	case c.storeQueue <- storeWrite{k: k, kind: kind, i: i, server: server}:
+	default:
+		storeRequests.WithLabelValues(server, "set", "dropped").Inc()
+	}
+}
+
+// startStore starts the goroutines that write the queued items to the store.
+func (c *Cache) startStore() {
+	c.storeQueue = make(chan storeWrite, storeQueueSize)
+	c.storeDone = make(chan struct{})
+	for j := 0; j < storeWriters; j++ {
+		go func() {
+			for {
+				select {
+				case <-c.storeDone:
+					return
+				case w := <-c.storeQueue:
+					c.put(w.k, w.kind, w.i, w.server)
+				}
+			}
+		}()
+	}
+}
+
+// stopStore stops writing to the store, queued items are dropped.
+func (c *Cache) stopStore() error {
+	c.storeOnce.Do(c.startStore)
+	close(c.storeDone)
+	return nil
+}
+
+// put stores i, the reply cached under k, in the store.
+func (c *Cache) put(k uint64, kind uint8, i *item, server string) {
+	buf, err := encodeItem(kind, k, i)
+	if err != nil {
+		return // Not something we can store.
+	}
+	// Keep it as long as we would serve it.
+	ttl := time.Duration(i.ttl(c.now().UTC()))*time.Second + c.staleUpTo
+	if ttl <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.storeTimeout)
+	defer cancel()
+	if err := c.store.Set(ctx, c.storePrefix+strconv.FormatUint(k, 16), buf, ttl); err != nil {
+		storeRequests.WithLabelValues(server, "set", "error").Inc()
+		log.Debugf("Failed to set in store: %s", err)
+		return
+	}
+	storeRequests.WithLabelValues(server, "set", "ok").Inc()
+}
+
+// encodeItem encodes i in the same format as used for snapshots.
+func encodeItem(kind uint8, k uint64, i *item) ([]byte, error) {
+	msg, err := i.pack()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	h := snapshotHeader{Kind: kind, Key: k, Stored: i.stored.UnixNano(), TTL: i.origTTL, Len: uint32(len(msg))}
+	if err := writeEntry(buf, h, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeItem decodes an item encoded with encodeItem.
+func decodeItem(buf []byte) (uint8, *item, error) {
+	r := bytes.NewReader(buf)
+	h := snapshotHeader{}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return 0, nil, err
+	}
+	if h.Kind != kindSuccess && h.Kind != kindDenial {
+		return 0, nil, fmt.Errorf("unknown item kind: %d", h.Kind)
+	}
+	if int(h.Len) != r.Len() {
+		return 0, nil, fmt.Errorf("item length %d, expected %d", r.Len(), h.Len)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[len(buf)-r.Len():]); err != nil {
+		return 0, nil, err
+	}
+	if len(m.Question) != 1 || m.Question[0].Qclass != dns.ClassINET {
+		return 0, nil, fmt.Errorf("item has no question for class IN")
+	}
+	return h.Kind, newItem(m, time.Unix(0, h.Stored), time.Duration(h.TTL)*time.Second), nil
+}
+
+const (
+	defaultStorePrefix  = "coredns:cache:"
+	defaultStoreTimeout = 50 * time.Millisecond
+
+	storeQueueSize = 1024 // items waiting to be written to the store
+	storeWriters   = 4    // goroutines writing to the store
+)
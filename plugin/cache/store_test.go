@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// waitStore waits until the store holds n values, as they're written asynchronously.
+func waitStore(t *testing.T, f *fakeRedis, n int) {
+	for i := 0; i < 100; i++ {
+		if f.len() == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d values in the store, got %d", n, f.len())
+}
+
+func TestStore(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	t0 := time.Now().UTC()
+	c := New()
+	c.now = func() time.Time { return t0 }
+	c.Next = snapshotHandler()
+	c.store, c.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c.store.Close()
+
+	for _, name := range []string{"example.org.", "missing.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	}
+	waitStore(t, f, 2)
+
+	// Another server, that can't reach its upstream, shares the store.
+	c1 := New()
+	c1.now = func() time.Time { return t0.Add(30 * time.Second) }
+	c1.Next = test.ErrorHandler()
+	c1.store, c1.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c1.store.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c1.ServeDNS(context.TODO(), rec, req)
+	if len(rec.Msg.Answer) != 1 {
+		t.Fatalf("Expected an answer from the store, got %v", rec.Msg)
+	}
+	if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != 70 {
+		t.Errorf("Expected TTL %d, got %d", 70, ttl)
+	}
+	if c1.pcache.Len() != 1 {
+		t.Errorf("Expected the answer to be added to the local cache, got %d items", c1.pcache.Len())
+	}
+
+	req.SetQuestion("missing.org.", dns.TypeA)
+	c1.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN from the store, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+
+	// Expired values aren't used, even when the store still has them.
+	c2 := New()
+	c2.now = func() time.Time { return t0.Add(200 * time.Second) }
+	c2.Next = test.ErrorHandler()
+	c2.store, c2.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c2.store.Close()
+
+	req.SetQuestion("example.org.", dns.TypeA)
+	c2.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for an expired value, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	c := New()
+	c.staleUpTo = 1 * time.Hour
+	c.Next = snapshotHandler()
+	c.store, c.storePrefix = newRedis(f.Addr()), "test:"
+	defer c.store.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	waitStore(t, f, 1)
+
+	k := "test:" + strconv.FormatUint(hash("example.org.", dns.TypeA, false), 16)
+	// The value is kept as long as it can be served stale.
+	if ttl := f.ttl(k); ttl < 1*time.Hour+99*time.Second || ttl > 1*time.Hour+100*time.Second {
+		t.Errorf("Expected TTL of about %s, got %s", 1*time.Hour+100*time.Second, ttl)
+	}
+}
+
+func TestStoreDecode(t *testing.T) {
+	i := newItem(new(dns.Msg).SetQuestion("example.org.", dns.TypeA), time.Now(), 10*time.Second)
+	buf, err := encodeItem(kindSuccess, 1, i)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	kind, i1, err := decodeItem(buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if kind != kindSuccess || i1.Name != "example.org." || i1.origTTL != 10 {
+		t.Errorf("Expected item for example.org. with TTL 10, got %s with %d", i1.Name, i1.origTTL)
+	}
+
+	for _, b := range [][]byte{nil, buf[:len(buf)-1], append([]byte{kindScope}, buf[1:]...)} {
+		if _, _, err := decodeItem(b); err == nil {
+			t.Errorf("Expected error decoding %v", b)
+		}
+	}
+}
+
+func TestStoreMismatch(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	c := New()
+	c.Next = test.ErrorHandler()
+	c.store, c.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c.store.Close()
+
+	// The store has the reply for another question under the key of example.org. A.
+	m := new(dns.Msg).SetQuestion("example.net.", dns.TypeA)
+	m.Answer = []dns.RR{test.A("example.net. 300 IN A 192.0.2.66")}
+	buf, err := encodeItem(kindSuccess, 1, newItem(m, time.Now(), 300*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := defaultStorePrefix + strconv.FormatUint(hash("example.org.", dns.TypeA, false), 16)
+	if err := c.store.Set(context.TODO(), k, buf, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeServerFailure || len(rec.Msg.Answer) != 0 {
+		t.Errorf("Expected the reply for another question not to be used, got %v", rec.Msg)
+	}
+}
+
+func TestStoreFlush(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	c := New()
+	c.Next = snapshotHandler()
+	c.store, c.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c.store.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	waitStore(t, f, 1)
+
+	// After a flush the entry, still in the store, must not come back.
+	c.Next = test.ErrorHandler()
+	if n := c.flush(func(i *item) bool { return i.Name == "example.org." }, func(string) bool { return true }); n != 1 {
+		t.Fatalf("Expected 1 entry to be flushed, got %d", n)
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for a flushed entry, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+}
+
+func TestStoreAuthenticatedData(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+
+	c := New()
+	c.Next = test.ErrorHandler()
+	c.store, c.storePrefix = newRedis(f.Addr()), defaultStorePrefix
+	defer c.store.Close()
+
+	m := new(dns.Msg).SetQuestion("example.org.", dns.TypeA)
+	m.AuthenticatedData = true
+	m.Answer = []dns.RR{test.A("example.org. 300 IN A 192.0.2.1")}
+	buf, err := encodeItem(kindSuccess, 1, newItem(m, time.Now(), 300*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := defaultStorePrefix + strconv.FormatUint(hash("example.org.", dns.TypeA, false), 16)
+	if err := c.store.Set(context.TODO(), k, buf, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	req.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if len(rec.Msg.Answer) != 1 {
+		t.Fatalf("Expected an answer from the store, got %v", rec.Msg)
+	}
+	if rec.Msg.AuthenticatedData {
+		t.Errorf("Expected no AD bit on a reply from the store")
+	}
+}
+
+// slowStore is a Store whose Set blocks until unblock is closed.
+type slowStore struct {
+	sets    int64
+	unblock chan struct{}
+}
+
+func (s *slowStore) Get(context.Context, string) ([]byte, bool, error) { return nil, false, nil }
+func (s *slowStore) Close() error                                      { return nil }
+func (s *slowStore) Set(context.Context, string, []byte, time.Duration) error {
+	atomic.AddInt64(&s.sets, 1)
+	<-s.unblock
+	return nil
+}
+
+func TestStoreQueueFull(t *testing.T) {
+	s := &slowStore{unblock: make(chan struct{})}
+	c := New()
+	c.store, c.storePrefix, c.storeTimeout = s, defaultStorePrefix, time.Hour
+
+	i := newItem(new(dns.Msg).SetQuestion("example.org.", dns.TypeA), time.Now(), 300*time.Second)
+	for j := 0; j < 2*storeQueueSize; j++ {
+		c.queue(uint64(j), kindSuccess, i, "dns://:53")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if x := atomic.LoadInt64(&s.sets); x != storeWriters {
+		t.Errorf("Expected %d writes in progress, got %d", storeWriters, x)
+	}
+	// Everything that doesn't fit in the queue is dropped.
+	if x := len(c.storeQueue); x < storeQueueSize-storeWriters || x > storeQueueSize {
+		t.Errorf("Expected about %d queued writes, got %d", storeQueueSize, x)
+	}
+	c.stopStore()
+	close(s.unblock)
+}
@@ -0,0 +1,64 @@
+// Package catalog implements catalog zones (RFC 9432): the member zones listed in a catalog zone are served as
+// secondary zones, which are added and removed as the catalog zone changes.
+package catalog
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+type (
+	// Catalog serves the catalog zone and its member zones.
+	Catalog struct {
+		Next plugin.Handler
+		*Zones
+
+		catalog *file.Zone // the catalog zone itself
+		loader
+	}
+
+	loader struct {
+		name      string   // name of the catalog zone
+		dbfile    string   // file the catalog zone is read from, if it isn't transferred
+		primaries []string // primaries of the catalog zone and the member zones
+
+		tsigKey       string
+		tsigAlgorithm string
+		tsigSecret    string
+
+		ReloadInterval time.Duration
+		upstream       *upstream.Upstream // Upstream for looking up names during the resolution process.
+	}
+)
+
+// ServeDNS implements the plugin.Handler interface.
+func (c Catalog) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+
+	// Precheck with the origins, i.e. are we allowed to look here?
+	if plugin.Zones(c.Zones.Origins()).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, r)
+	}
+
+	return file.File{Next: c.Next, Zones: c.Zones.Zones()}.ServeDNS(ctx, w, r)
+}
+
+// Transfer implements the transfer.Transferer interface.
+func (c Catalog) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	z, ok := c.Zones.Zones().Z[zone]
+	if !ok || z == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	return z.Transfer(serial)
+}
+
+// Name implements the Handler interface.
+func (c Catalog) Name() string { return "catalog" }
@@ -0,0 +1,136 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// Sync updates the member zones from the catalog zone, if it changed since the last time. New member zones
+// are transferred from the primaries, removed ones are no longer served. A member zone whose unique ID
+// changed is reset, i.e. transferred again from scratch. After Stop it does nothing.
+func (c Catalog) Sync() {
+	serial := c.catalog.SOASerialIfDefined()
+	c.Zones.RLock()
+	same := serial == c.Zones.serial || c.Zones.stopped
+	c.Zones.RUnlock()
+	if serial < 0 || same {
+		return
+	}
+
+	m, err := members(c.catalog, c.name)
+
+	c.Zones.Lock()
+	defer c.Zones.Unlock()
+	if c.Zones.stopped {
+		return
+	}
+	c.Zones.serial = serial
+	if err != nil {
+		log.Errorf("Catalog zone %s with %d SOA serial: %s", c.name, serial, err)
+		return
+	}
+
+	zones := file.Zones{Z: map[string]*file.Zone{c.name: c.catalog}, Names: []string{c.name}}
+	added, removed := 0, 0
+	for name, id := range m {
+		if name == c.name {
+			continue
+		}
+		if plugin.Zones(c.Zones.origins).Matches(name) == "" {
+			log.Warningf("Catalog zone %s: member zone %s is not in the zones of the server block, ignoring", c.name, name)
+			continue
+		}
+		z, ok := c.Zones.zones.Z[name]
+		if !ok || c.Zones.members[name] != id {
+			z = c.newZone(name)
+			added++
+		}
+		zones.Z[name] = z
+		zones.Names = append(zones.Names, name)
+	}
+	for name, z := range c.Zones.zones.Z {
+		if name == c.name || zones.Z[name] == z {
+			continue
+		}
+		z.StopUpdate()
+		removed++
+	}
+	c.Zones.zones, c.Zones.members = zones, m
+
+	log.Infof("Catalog zone %s with %d SOA serial: %d member zones, %d added and %d removed", c.name, serial, len(zones.Names)-1, added, removed)
+}
+
+// newZone returns a new member zone, which is transferred and kept up to date in the background.
+func (c Catalog) newZone(name string) *file.Zone {
+	z := file.NewZone(name, "stdin")
+	z.TransferFrom = c.primaries
+	z.TsigKey, z.TsigAlgorithm, z.TsigSecret = c.tsigKey, c.tsigAlgorithm, c.tsigSecret
+	z.Upstream = c.upstream
+	go func() {
+		z.TransferIn()
+		z.Update()
+	}()
+	return z
+}
+
+// Stop stops keeping the member zones up to date.
+func (c Catalog) Stop() {
+	c.Zones.Lock()
+	defer c.Zones.Unlock()
+	c.Zones.stopped = true
+	for name, z := range c.Zones.zones.Z {
+		if name != c.name {
+			z.StopUpdate()
+		}
+	}
+	c.Zones.zones = file.Zones{Z: map[string]*file.Zone{c.name: c.catalog}, Names: []string{c.name}}
+	c.Zones.members = nil
+}
+
+// members returns the member zones of catalog zone z, with origin, mapped to their unique IDs. Only version
+// 2 of the schema is supported. The properties of the members, such as the group, are ignored.
+func members(z *file.Zone, origin string) (map[string]string, error) {
+	var (
+		zones   = "zones." + origin
+		version = "version." + origin
+		labels  = dns.CountLabel(zones) + 1
+		ver     []string
+		m       = make(map[string]string)
+	)
+
+	z.RLock()
+	defer z.RUnlock()
+	z.Walk(func(e *tree.Elem, rrs map[uint16][]dns.RR) error {
+		name := strings.ToLower(e.Name())
+		switch {
+		case name == version:
+			for _, rr := range rrs[dns.TypeTXT] {
+				ver = append(ver, strings.Join(rr.(*dns.TXT).Txt, ""))
+			}
+		case dns.CountLabel(name) == labels && dns.IsSubDomain(zones, name):
+			ptrs := rrs[dns.TypePTR]
+			if len(ptrs) != 1 {
+				log.Warningf("Catalog zone %s: member %s has %d PTR records instead of one, ignoring", origin, name, len(ptrs))
+				return nil
+			}
+			member := strings.ToLower(dns.Fqdn(ptrs[0].(*dns.PTR).Ptr))
+			if _, ok := m[member]; ok {
+				log.Warningf("Catalog zone %s: member zone %s is listed more than once, ignoring %s", origin, member, name)
+				return nil
+			}
+			m[member] = dns.SplitDomainName(name)[0]
+		}
+		return nil
+	})
+
+	if len(ver) != 1 || ver[0] != "2" {
+		return nil, fmt.Errorf("unsupported schema version %q", ver)
+	}
+	return m, nil
+}
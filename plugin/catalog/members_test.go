@@ -0,0 +1,223 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+const dbCatalog = `
+$ORIGIN catalog.example.
+@           3600 IN SOA invalid. hostmaster.invalid. 1 3600 600 2147483646 0
+@           3600 IN NS  invalid.
+version     3600 IN TXT "2"
+a.zones     3600 IN PTR example.org.
+b.zones     3600 IN PTR example.net.
+group.b.zones 3600 IN TXT "primary"
+c.zones     3600 IN PTR example.com.
+c.zones     3600 IN PTR example.edu.
+d.zones     3600 IN PTR EXAMPLE.ORG.
+`
+
+func catalog(t *testing.T, db string) *file.Zone {
+	z, err := file.Parse(strings.NewReader(db), "catalog.example.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func TestMembers(t *testing.T) {
+	tests := []struct {
+		db        string
+		expected  map[string]string
+		shouldErr bool
+	}{
+		// c has two PTRs, d lists a zone a already does.
+		{dbCatalog, map[string]string{"example.org.": "a", "example.net.": "b"}, false},
+		{strings.Replace(dbCatalog, `"2"`, `"1"`, 1), nil, true},
+		{strings.Replace(dbCatalog, `version     3600 IN TXT "2"`, "", 1), nil, true},
+	}
+	for i, tc := range tests {
+		m, err := members(catalog(t, tc.db), "catalog.example.")
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if len(m) != len(tc.expected) {
+			t.Errorf("Test %d: expected %d members, got %d", i, len(tc.expected), len(m))
+		}
+		for zone, id := range tc.expected {
+			if m[zone] != id {
+				t.Errorf("Test %d: expected member %s with ID %s, got %q", i, zone, id, m[zone])
+			}
+		}
+	}
+}
+
+// primary transfers any zone, with a single A record for its apex.
+func primary(w dns.ResponseWriter, r *dns.Msg) {
+	zone := r.Question[0].Name
+	soa := test.SOA(fmt.Sprintf("%s 3600 IN SOA ns.%s hostmaster.%s 1 3600 600 86400 300", zone, zone, zone))
+	m := new(dns.Msg)
+	m.SetReply(r)
+	switch r.Question[0].Qtype {
+	case dns.TypeSOA:
+		m.Answer = []dns.RR{soa}
+	case dns.TypeAXFR:
+		m.Answer = []dns.RR{soa, test.A(fmt.Sprintf("%s 3600 IN A 127.0.0.1", zone)), soa}
+	default:
+		m.Rcode = dns.RcodeRefused
+	}
+	w.WriteMsg(m)
+}
+
+func TestSync(t *testing.T) {
+	s := dnstest.NewServer(primary)
+	defer s.Close()
+
+	cat := catalog(t, dbCatalog)
+	c := Catalog{
+		Zones:   &Zones{origins: []string{"example.org.", "example.net.", "catalog.example."}},
+		catalog: cat,
+		loader:  loader{name: "catalog.example.", primaries: []string{s.Addr}},
+		Next:    test.NextHandler(dns.RcodeRefused, nil),
+	}
+	defer c.Stop()
+
+	c.Sync()
+	if n := len(c.Zones.Zones().Names); n != 3 {
+		t.Fatalf("Expected %d zones, got %d", 3, n)
+	}
+	lookup(t, c, "example.net.", dns.RcodeSuccess)
+	lookup(t, c, "example.org.", dns.RcodeSuccess)
+	lookup(t, c, "example.com.", dns.RcodeRefused)
+
+	// Remove example.net, reset example.org and add example.com, which isn't in the origins.
+	org := c.Zones.Zones().Z["example.org."]
+	db := strings.Replace(dbCatalog, "1 3600 600", "2 3600 600", 1)
+	db = strings.Replace(db, "b.zones     3600 IN PTR example.net.", "", 1)
+	db = strings.Replace(db, "a.zones     3600 IN PTR example.org.", "e.zones 3600 IN PTR example.org.", 1)
+	db = strings.Replace(db, "c.zones     3600 IN PTR example.edu.", "", 1)
+	updated := catalog(t, db)
+	cat.Lock()
+	cat.Apex, cat.Tree = updated.Apex, updated.Tree
+	cat.Unlock()
+
+	c.Sync()
+	zones := c.Zones.Zones()
+	if n := len(zones.Names); n != 2 {
+		t.Fatalf("Expected %d zones, got %d", 2, n)
+	}
+	if zones.Z["example.org."] == org {
+		t.Errorf("Expected example.org. to be reset")
+	}
+	lookup(t, c, "example.org.", dns.RcodeSuccess)
+	lookup(t, c, "example.net.", dns.RcodeRefused)
+	lookup(t, c, "example.com.", dns.RcodeRefused)
+
+	// The catalog zone itself is served too.
+	lookup(t, c, "version.catalog.example.", dns.RcodeSuccess)
+}
+
+func TestSyncStopped(t *testing.T) {
+	s := dnstest.NewServer(primary)
+	defer s.Close()
+
+	c := Catalog{
+		Zones:   &Zones{origins: []string{"example.org.", "example.net.", "catalog.example."}},
+		catalog: catalog(t, dbCatalog),
+		loader:  loader{name: "catalog.example.", primaries: []string{s.Addr}},
+	}
+	c.Stop()
+
+	// A Sync after Stop, e.g. when the catalog zone is transferred late, must not start member zones.
+	c.Sync()
+	if n := len(c.Zones.Zones().Names); n != 1 {
+		t.Errorf("Expected %d zones, got %d", 1, n)
+	}
+}
+
+// catalogPrimary transfers the catalog zone, with example.org as its only member, and any other zone like
+// primary does.
+func catalogPrimary(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Question[0].Name != "catalog.example." {
+		primary(w, r)
+		return
+	}
+	soa := test.SOA("catalog.example. 3600 IN SOA invalid. hostmaster.invalid. 1 3600 600 2147483646 0")
+	m := new(dns.Msg)
+	m.SetReply(r)
+	switch r.Question[0].Qtype {
+	case dns.TypeSOA:
+		m.Answer = []dns.RR{soa}
+	case dns.TypeAXFR:
+		m.Answer = []dns.RR{soa,
+			test.NS("catalog.example. 3600 IN NS invalid."),
+			test.TXT(`version.catalog.example. 3600 IN TXT "2"`),
+			test.PTR("a.zones.catalog.example. 3600 IN PTR example.org."),
+			soa}
+	default:
+		m.Rcode = dns.RcodeRefused
+	}
+	w.WriteMsg(m)
+}
+
+func TestSyncTransfer(t *testing.T) {
+	s := dnstest.NewServer(catalogPrimary)
+	defer s.Close()
+
+	cat := file.NewZone("catalog.example.", "stdin")
+	cat.TransferFrom = []string{s.Addr}
+	c := Catalog{
+		Zones:   &Zones{origins: []string{"example.org.", "catalog.example."}},
+		catalog: cat,
+		loader:  loader{name: "catalog.example.", primaries: []string{s.Addr}},
+		Next:    test.NextHandler(dns.RcodeRefused, nil),
+	}
+	c.Zones.zones = file.Zones{Z: map[string]*file.Zone{c.name: cat}, Names: []string{c.name}}
+	cat.OnTransfer = c.Sync
+	defer c.Stop()
+
+	// The members are synced as soon as the catalog zone is transferred, not on the next reload.
+	if err := cat.TransferIn(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Zones.Zones().Names); n != 2 {
+		t.Fatalf("Expected %d zones, got %d", 2, n)
+	}
+	lookup(t, c, "example.org.", dns.RcodeSuccess)
+}
+
+// lookup looks up the A record of name, and waits for the zone to be transferred when rcode is success.
+func lookup(t *testing.T, c Catalog, name string, rcode int) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	for i := 0; i < 50; i++ {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, _ := c.ServeDNS(context.TODO(), rec, m)
+		if rec.Msg != nil {
+			code = rec.Msg.Rcode
+		}
+		if code == rcode {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Expected %s for %s, got something else", dns.RcodeToString[rcode], name)
+}
@@ -0,0 +1,174 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+)
+
+var log = clog.NewWithPlugin("catalog")
+
+func init() { plugin.Register("catalog", setup) }
+
+func setup(c *caddy.Controller) error {
+	ca, err := catalogParse(c)
+	if err != nil {
+		return plugin.Error("catalog", err)
+	}
+
+	syncChan := make(chan bool)
+
+	c.OnStartup(func() error {
+		if ca.dbfile != "" {
+			ca.catalog.Reload(nil)
+			ca.Sync()
+		} else {
+			go func() {
+				ca.catalog.TransferIn() // syncs the members, see OnTransfer
+				ca.catalog.Update()
+			}()
+		}
+
+		go func() {
+			ticker := time.NewTicker(ca.ReloadInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-syncChan:
+					return
+				case <-ticker.C:
+					ca.Sync()
+				}
+			}
+		}()
+		return nil
+	})
+
+	c.OnShutdown(func() error {
+		close(syncChan)
+		if ca.dbfile != "" {
+			ca.catalog.OnShutdown()
+		} else {
+			ca.catalog.StopUpdate()
+		}
+		ca.Stop()
+		return nil
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		ca.Next = next
+		return ca
+	})
+
+	return nil
+}
+
+func catalogParse(c *caddy.Controller) (Catalog, error) {
+	var ca = Catalog{
+		loader: loader{
+			ReloadInterval: 10 * time.Second,
+		},
+		Zones: &Zones{},
+	}
+
+	config := dnsserver.GetConfig(c)
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return ca, plugin.ErrOnce
+		}
+		i++
+
+		// catalog ZONE
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return ca, c.ArgErr()
+		}
+		ca.name = plugin.Host(args[0]).Normalize()
+		ca.Zones.origins = make([]string, len(c.ServerBlockKeys))
+		for j := range c.ServerBlockKeys {
+			ca.Zones.origins[j] = plugin.Host(c.ServerBlockKeys[j]).Normalize()
+		}
+		ca.loader.upstream = upstream.New()
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "transfer":
+				froms, err := parse.TransferIn(c)
+				if err != nil {
+					return ca, err
+				}
+				ca.primaries = append(ca.primaries, froms...)
+			case "file":
+				if !c.NextArg() {
+					return ca, c.ArgErr()
+				}
+				ca.dbfile = c.Val()
+				if !filepath.IsAbs(ca.dbfile) && config.Root != "" {
+					ca.dbfile = filepath.Join(config.Root, ca.dbfile)
+				}
+				if c.NextArg() {
+					return ca, c.ArgErr()
+				}
+			case "tsig":
+				name, alg, secret, err := parse.TSIGKey(c)
+				if err != nil {
+					return ca, err
+				}
+				ca.tsigKey, ca.tsigAlgorithm, ca.tsigSecret = name, alg, secret
+				// The NOTIFYs of the primaries are signed with the key as well.
+				config.AddTsigSecret(name, secret)
+			case "reload":
+				if !c.NextArg() {
+					return ca, c.ArgErr()
+				}
+				d, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return ca, plugin.Error("catalog", err)
+				}
+				if d <= 0 {
+					return ca, c.Errf("reload must be positive: %s", c.Val())
+				}
+				ca.ReloadInterval = d
+			default:
+				return ca, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	if len(ca.primaries) == 0 {
+		return ca, c.Err("'transfer from' is required")
+	}
+
+	if ca.dbfile == "" {
+		ca.catalog = file.NewZone(ca.name, "stdin")
+		ca.catalog.TransferFrom = ca.primaries
+		ca.catalog.TsigKey, ca.catalog.TsigAlgorithm, ca.catalog.TsigSecret = ca.tsigKey, ca.tsigAlgorithm, ca.tsigSecret
+		// Pick up the changes to the members as soon as the catalog zone is transferred, after a NOTIFY too.
+		ca.catalog.OnTransfer = ca.Sync
+	} else {
+		reader, err := os.Open(ca.dbfile)
+		if err != nil {
+			return ca, err
+		}
+		defer reader.Close()
+		ca.catalog, err = file.Parse(reader, ca.name, ca.dbfile, 0)
+		if err != nil {
+			return ca, err
+		}
+		ca.catalog.ReloadInterval = ca.ReloadInterval
+	}
+	ca.catalog.Upstream = ca.upstream
+	ca.Zones.zones = file.Zones{Z: map[string]*file.Zone{ca.name: ca.catalog}, Names: []string{ca.name}}
+
+	return ca, nil
+}
@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+)
+
+func TestCatalogParse(t *testing.T) {
+	name, rm, err := test.TempFile(".", dbCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		primaries int
+		reload    time.Duration
+		tsig      string
+	}{
+		{`catalog catalog.example {
+			transfer from 10.0.0.1 10.0.0.2
+		}`, false, 2, 10 * time.Second, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			file ` + name + `
+			reload 1m
+		}`, false, 1, time.Minute, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			tsig xfr hmac-sha256 c2VjcmV0
+		}`, false, 1, 10 * time.Second, "xfr."},
+		// fails
+		{`catalog`, true, 0, 0, ""},
+		{`catalog catalog.example example.org`, true, 0, 0, ""},
+		{`catalog catalog.example`, true, 0, 0, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			reload 0s
+		}`, true, 0, 0, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			file /does/not/exist
+		}`, true, 0, 0, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			tsig xfr hmac-foo c2VjcmV0
+		}`, true, 0, 0, ""},
+		{`catalog catalog.example {
+			transfer to 10.0.0.1
+		}`, true, 0, 0, ""},
+		{`catalog catalog.example {
+			transfer from 10.0.0.1
+			members
+		}`, true, 0, 0, ""},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		ca, err := catalogParse(c)
+		if err == nil && tc.shouldErr {
+			t.Errorf("Test %d expected errors, but got no error", i)
+			continue
+		} else if err != nil && !tc.shouldErr {
+			t.Errorf("Test %d expected no errors, but got '%v'", i, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(ca.primaries) != tc.primaries {
+			t.Errorf("Test %d expected %d primaries, got %d", i, tc.primaries, len(ca.primaries))
+		}
+		if ca.ReloadInterval != tc.reload {
+			t.Errorf("Test %d expected reload %s, got %s", i, tc.reload, ca.ReloadInterval)
+		}
+		if ca.tsigKey != tc.tsig {
+			t.Errorf("Test %d expected TSIG key %q, got %q", i, tc.tsig, ca.tsigKey)
+		}
+		if ca.catalog == nil || ca.catalog.TsigKey != tc.tsig {
+			t.Errorf("Test %d expected the catalog zone to be set up", i)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package catalog
+
+import (
+	"sync"
+
+	"github.com/coredns/coredns/plugin/file"
+)
+
+// Zones holds the catalog zone and its member zones. When the members change the zones are replaced, not
+// changed, so they can be used after the lock has been released.
+type Zones struct {
+	zones   file.Zones
+	members map[string]string // member zone to its unique ID in the catalog zone
+	serial  int64             // SOA serial of the catalog zone the members are taken from
+	stopped bool              // set by Stop, the members are no longer synced
+
+	origins []string // Any origins from the server block.
+
+	sync.RWMutex
+}
+
+// Zones returns the catalog zone and its member zones.
+func (z *Zones) Zones() file.Zones {
+	z.RLock()
+	zs := z.zones
+	z.RUnlock()
+	return zs
+}
+
+// Origins returns the origins from z.
+func (z *Zones) Origins() []string {
+	// doesn't need locking, because there aren't multiple Go routines accessing it.
+	return z.origins
+}
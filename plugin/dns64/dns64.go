@@ -72,6 +72,7 @@ func (d *DNS64) Name() string { return "dns64" }
 // 1. The request came in over IPv6 (not in RFC)
 // 2. The request is of type AAAA
 // 3. The request is of class INET
+// 4. The request does not have both the DO and CD bit set (RFC 6147 5.5)
 func requestShouldIntercept(req *request.Request) bool {
 	// Only intercept with this when the request came in over IPv6. This is not mentioned in the RFC.
 	// File an issue if you think we should translate even requests made using IPv4, or have a configuration flag
@@ -79,6 +80,11 @@ func requestShouldIntercept(req *request.Request) bool {
 		return false
 	}
 
+	// A validating stub resolver would fail to validate synthesized records, so leave those alone. See RFC 6147 5.5
+	if req.Req.CheckingDisabled && req.Do() {
+		return false
+	}
+
 	// Do not modify if question is not AAAA or not of class IN. See RFC 6147 5.1
 	return req.QType() == dns.TypeAAAA && req.QClass() == dns.ClassINET
 }
@@ -147,6 +153,10 @@ func (d *DNS64) Synthesize(origReq, origResponse, resp *dns.Msg) *dns.Msg {
 	// convert A records to AAAA records
 	for _, rr := range resp.Answer {
 		header := rr.Header()
+		// 5.5: the signatures of the A records don't cover the synthesized records
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == dns.TypeA {
+			continue
+		}
 		// 5.3.3: All other RR's MUST be returned unchanged
 		if header.Rrtype != dns.TypeA {
 			ret.Answer = append(ret.Answer, rr)
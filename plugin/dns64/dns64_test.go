@@ -448,3 +448,30 @@ func (fu *fakeUpstream) Lookup(_ context.Context, _ request.Request, name string
 
 	return fu.resp, nil
 }
+
+func TestRequestShouldIntercept(t *testing.T) {
+	tests := []struct {
+		qtype    uint16
+		do, cd   bool
+		expected bool
+	}{
+		{dns.TypeAAAA, false, false, true},
+		{dns.TypeAAAA, true, false, true},
+		{dns.TypeAAAA, false, true, true},
+		{dns.TypeAAAA, true, true, false},
+		{dns.TypeA, false, false, false},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", tc.qtype)
+		m.CheckingDisabled = tc.cd
+		if tc.do {
+			m.SetEdns0(4096, true)
+		}
+		req := &request.Request{W: &test.ResponseWriter6{}, Req: m}
+		if x := requestShouldIntercept(req); x != tc.expected {
+			t.Errorf("Test %d: expected %t, got %t", i, tc.expected, x)
+		}
+	}
+}
@@ -9,8 +9,11 @@ import (
 // hash serializes the RRset and returns a signature cache key.
 func hash(rrs []dns.RR) uint64 {
 	h := fnv.New64()
-	buf := make([]byte, 256)
+	buf := make([]byte, 512)
 	for _, r := range rrs {
+		if l := dns.Len(r); l > len(buf) {
+			buf = make([]byte, l)
+		}
 		off, err := dns.PackRR(r, buf, 0, nil, false)
 		if err == nil {
 			h.Write(buf[:off])
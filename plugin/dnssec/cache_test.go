@@ -1,12 +1,15 @@
 package dnssec
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/cache"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
 )
 
 func TestCacheSet(t *testing.T) {
@@ -80,3 +83,58 @@ func TestCacheNotValidYet(t *testing.T) {
 		t.Errorf("Signature was added to the cache even though not valid yet")
 	}
 }
+
+func TestCachePresign(t *testing.T) {
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+
+	m := testMsg()
+	k := hash(m.Answer)
+	// Signed 3 days ago, the signature is still valid, but stale.
+	d.Sign(request.Request{Req: m, Zone: "miek.nl."}, time.Now().UTC().AddDate(0, 0, -3), server)
+	sigs, ok := d.get(k, server)
+	if !ok {
+		t.Fatalf("Signature was not added to the cache")
+	}
+	if !d.stale(sigs) {
+		t.Fatalf("Expected signature to be stale")
+	}
+
+	// The stale signature is returned and replaced in the background.
+	m = d.Sign(request.Request{Req: testMsg(), Zone: "miek.nl."}, time.Now().UTC(), server)
+	if !section(m.Answer, 1) {
+		t.Errorf("Answer section should have 1 RRSIG")
+	}
+	for i := 0; i < 100; i++ {
+		if sigs, ok := d.get(k, server); ok && !d.stale(sigs) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected stale signature to be replaced")
+}
+
+func TestCacheValidity(t *testing.T) {
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	d.validity = 24 * time.Hour
+
+	m := testMsg()
+	k := hash(m.Answer)
+	// Signed 14 hours ago, valid for less than half the validity period.
+	d.Sign(request.Request{Req: m, Zone: "miek.nl."}, time.Now().UTC().Add(-14*time.Hour), server)
+	if _, ok := d.get(k, server); ok {
+		t.Errorf("Expected signature not to be used")
+	}
+}
+
+func TestHashLarge(t *testing.T) {
+	// Records that don't fit in the initial buffer must still be part of the hash.
+	a := []dns.RR{test.TXT(`miek.nl. 1800 IN TXT "` + strings.Repeat("a", 250) + `" "` + strings.Repeat("a", 250) + `" "a"`)}
+	b := []dns.RR{test.TXT(`miek.nl. 1800 IN TXT "` + strings.Repeat("a", 250) + `" "` + strings.Repeat("a", 250) + `" "b"`)}
+	if hash(a) == hash(b) {
+		t.Errorf("Expected different hashes for different RRsets")
+	}
+}
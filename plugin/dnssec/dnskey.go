@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/coredns/coredns/plugin/pkg/keypair"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -62,6 +63,11 @@ func ParseKeyFile(pubFile, privFile string) (*DNSKEY, error) {
 	return &DNSKEY{K: dk, D: dk.ToDS(dns.SHA256), s: nil, tag: 0}, errors.New("no private key found")
 }
 
+// newDNSKEY returns the DNSKEY for key pair p, the private key may be on a PKCS#11 token.
+func newDNSKEY(p keypair.Pair) *DNSKEY {
+	return &DNSKEY{K: p.Public, D: p.Public.ToDS(dns.SHA256), s: p.Private, tag: p.KeyTag}
+}
+
 // getDNSKEY returns the correct DNSKEY to the client. Signatures are added when do is true.
 func (d Dnssec) getDNSKEY(state request.Request, zone string, do bool, server string) *dns.Msg {
 	keys := make([]dns.RR, len(d.keys))
@@ -76,7 +82,7 @@ func (d Dnssec) getDNSKEY(state request.Request, zone string, do bool, server st
 		return m
 	}
 
-	incep, expir := incepExpir(time.Now().UTC())
+	incep, expir := d.incepExpir(time.Now().UTC())
 	if sigs, err := d.sign(keys, zone, 3600, incep, expir, server); err == nil {
 		m.Answer = append(m.Answer, sigs...)
 	}
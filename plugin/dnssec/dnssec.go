@@ -1,8 +1,9 @@
 // Package dnssec implements a plugin that signs responses on-the-fly using
-// NSEC black lies.
+// NSEC black lies or NSEC3 white lies.
 package dnssec
 
 import (
+	"context"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -21,8 +22,12 @@ type Dnssec struct {
 	zones     []string
 	keys      []*DNSKEY
 	splitkeys bool
+	nsec3     map[string]bool // zones that use NSEC3 white lies instead of NSEC black lies
+	validity  time.Duration   // validity of the signatures
 	inflight  *singleflight.Group
 	cache     *cache.Cache
+	enclosers *cache.Cache // closest enclosers for NSEC3 white lies, see closestEncloser
+	ceTypes   *cache.Cache // types that exist at those closest enclosers
 }
 
 // New returns a new Dnssec.
@@ -31,20 +36,28 @@ func New(zones []string, keys []*DNSKEY, splitkeys bool, next plugin.Handler, c
 		zones:     zones,
 		keys:      keys,
 		splitkeys: splitkeys,
+		validity:  defaultValidity,
 		cache:     c,
+		enclosers: cache.New(defaultCap),
+		ceTypes:   cache.New(defaultCap),
 		inflight:  new(singleflight.Group),
 	}
 }
 
 // Sign signs the message in state. it takes care of negative or nodata responses. It
-// uses NSEC black lies for authenticated denial of existence. For delegations it
-// will insert DS records and sign those.
+// uses NSEC black lies, or NSEC3 white lies, for authenticated denial of existence. For
+// delegations it will insert DS records and sign those.
 // Signatures will be cached for a short while. By default we sign for 8 days,
 // starting 3 hours ago.
 func (d Dnssec) Sign(state request.Request, now time.Time, server string) *dns.Msg {
+	return d.signMsg(context.Background(), state, now, server)
+}
+
+// signMsg is Sign, ctx is used for the lookups NSEC3 white lies need, see closestEncloser.
+func (d Dnssec) signMsg(ctx context.Context, state request.Request, now time.Time, server string) *dns.Msg {
 	req := state.Req
 
-	incep, expir := incepExpir(now)
+	incep, expir := d.incepExpir(now)
 
 	mt, _ := response.Typify(req, time.Now().UTC()) // TODO(miek): need opt record here?
 	if mt == response.Delegation {
@@ -61,6 +74,12 @@ func (d Dnssec) Sign(state request.Request, now time.Time, server string) *dns.M
 		if sigs, err := d.sign(req.Ns, state.Zone, ttl, incep, expir, server); err == nil {
 			req.Ns = append(req.Ns, sigs...)
 		}
+		if d.nsec3[state.Zone] {
+			if sigs, err := d.whiteLies(ctx, state, mt, ttl, incep, expir, server); err == nil {
+				req.Ns = append(req.Ns, sigs...)
+			}
+			return req
+		}
 		if sigs, err := d.nsec(state, mt, ttl, incep, expir, server); err == nil {
 			req.Ns = append(req.Ns, sigs...)
 		}
@@ -95,45 +114,70 @@ func (d Dnssec) sign(rrs []dns.RR, signerName string, ttl, incep, expir uint32,
 	k := hash(rrs)
 	sgs, ok := d.get(k, server)
 	if ok {
+		if d.stale(sgs) {
+			d.presign(k, rrs, signerName, ttl)
+		}
 		return sgs, nil
 	}
 
 	sigs, err := d.inflight.Do(k, func() (interface{}, error) {
-		var sigs []dns.RR
-		for _, k := range d.keys {
-			if d.splitkeys {
-				if len(rrs) > 0 && rrs[0].Header().Rrtype == dns.TypeDNSKEY {
-					// We are signing a DNSKEY RRSet. With split keys, we need to use a KSK here.
-					if !k.isKSK() {
-						continue
-					}
-				} else {
-					// For non-DNSKEY RRSets, we want to use a ZSK.
-					if !k.isZSK() {
-						continue
-					}
+		return d.signRRs(k, rrs, signerName, ttl, incep, expir)
+	})
+	return sigs.([]dns.RR), err
+}
+
+// presign signs rrs in the background, to replace the stale signatures in the cache before they must be
+// replaced while answering a query. As only RRsets that are asked for are signed again, this keeps the
+// signatures of the frequently queried RRsets in the cache.
+func (d Dnssec) presign(k uint64, rrs []dns.RR, signerName string, ttl uint32) {
+	cp := make([]dns.RR, len(rrs))
+	for i := range rrs {
+		cp[i] = dns.Copy(rrs[i])
+	}
+	go d.inflight.Do(k, func() (interface{}, error) {
+		if s, ok := d.cache.Get(k); ok && !d.stale(s.([]dns.RR)) {
+			return s, nil // signed again while we were waiting
+		}
+		incep, expir := d.incepExpir(time.Now().UTC())
+		return d.signRRs(k, cp, signerName, ttl, incep, expir)
+	})
+}
+
+// signRRs signs rrs with the keys and adds the signatures to the cache under key k.
+func (d Dnssec) signRRs(k uint64, rrs []dns.RR, signerName string, ttl, incep, expir uint32) ([]dns.RR, error) {
+	var sigs []dns.RR
+	for _, key := range d.keys {
+		if d.splitkeys {
+			if len(rrs) > 0 && rrs[0].Header().Rrtype == dns.TypeDNSKEY {
+				// We are signing a DNSKEY RRSet. With split keys, we need to use a KSK here.
+				if !key.isKSK() {
+					continue
+				}
+			} else {
+				// For non-DNSKEY RRSets, we want to use a ZSK.
+				if !key.isZSK() {
+					continue
 				}
 			}
-			sig := k.newRRSIG(signerName, ttl, incep, expir)
-			if e := sig.Sign(k.s, rrs); e != nil {
-				return sigs, e
-			}
-			sigs = append(sigs, sig)
 		}
-		d.set(k, sigs)
-		return sigs, nil
-	})
-	return sigs.([]dns.RR), err
+		sig := key.newRRSIG(signerName, ttl, incep, expir)
+		if e := sig.Sign(key.s, rrs); e != nil {
+			return sigs, e
+		}
+		sigs = append(sigs, sig)
+	}
+	d.set(k, sigs)
+	return sigs, nil
 }
 
 func (d Dnssec) set(key uint64, sigs []dns.RR) { d.cache.Add(key, sigs) }
 
+// get returns the signatures stored under key, if they are still valid for at least half of the validity period.
 func (d Dnssec) get(key uint64, server string) ([]dns.RR, bool) {
 	if s, ok := d.cache.Get(key); ok {
-		// we sign for 8 days, check if a signature in the cache reached 3/4 of that
-		is75 := time.Now().UTC().Add(sixDays)
+		is50 := time.Now().UTC().Add(d.validity / 2)
 		for _, rr := range s.([]dns.RR) {
-			if !rr.(*dns.RRSIG).ValidityPeriod(is75) {
+			if !rr.(*dns.RRSIG).ValidityPeriod(is50) {
 				cacheMisses.WithLabelValues(server).Inc()
 				return nil, false
 			}
@@ -146,14 +190,24 @@ func (d Dnssec) get(key uint64, server string) ([]dns.RR, bool) {
 	return nil, false
 }
 
-func incepExpir(now time.Time) (uint32, uint32) {
+// stale returns true if one of sigs is valid for less than 3/4 of the validity period, it should be replaced.
+func (d Dnssec) stale(sigs []dns.RR) bool {
+	is75 := time.Now().UTC().Add(d.validity * 3 / 4)
+	for _, rr := range sigs {
+		if !rr.(*dns.RRSIG).ValidityPeriod(is75) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Dnssec) incepExpir(now time.Time) (uint32, uint32) {
 	incep := uint32(now.Add(-3 * time.Hour).Unix()) // -(2+1) hours, be sure to catch daylight saving time and such
-	expir := uint32(now.Add(eightDays).Unix())      // sign for 8 days
+	expir := uint32(now.Add(d.validity).Unix())
 	return incep, expir
 }
 
 const (
-	eightDays  = 8 * 24 * time.Hour
-	sixDays    = 6 * 24 * time.Hour
-	defaultCap = 10000 // default capacity of the cache.
+	defaultValidity = 8 * 24 * time.Hour // sign for 8 days
+	defaultCap      = 10000              // default capacity of the cache.
 )
@@ -1,6 +1,7 @@
 package dnssec
 
 import (
+	"crypto"
 	"testing"
 	"time"
 
@@ -206,3 +207,54 @@ Publish: 20160423211746
 Activate: 20160423211746
 `
 )
+
+// BenchmarkSign reports the number of signatures per second for each algorithm.
+func BenchmarkSign(b *testing.B) {
+	rrs := []dns.RR{test.A("miek.nl.	1800	IN	A	127.0.0.1"), test.A("miek.nl.	1800	IN	A	127.0.0.2")}
+	incep, expir := uint32(time.Now().Unix()), uint32(time.Now().Add(defaultValidity).Unix())
+
+	for _, alg := range []struct {
+		alg  uint8
+		bits int
+	}{{dns.RSASHA256, 2048}, {dns.ECDSAP256SHA256, 256}, {dns.ECDSAP384SHA384, 384}, {dns.ED25519, 256}} {
+		k := benchKey(b, alg.alg, alg.bits)
+		b.Run(dns.AlgorithmToString[alg.alg], func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				sig := k.newRRSIG("miek.nl.", 1800, incep, expir)
+				if err := sig.Sign(k.s, rrs); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "sigs/s")
+		})
+	}
+}
+
+// BenchmarkSignCached reports the number of responses signed per second from the signature cache.
+func BenchmarkSignCached(b *testing.B) {
+	k := benchKey(b, dns.ECDSAP256SHA256, 256)
+	d := New([]string{"miek.nl."}, []*DNSKEY{k}, false, nil, cache.New(defaultCap))
+	now := time.Now().UTC()
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		d.Sign(request.Request{Req: testMsg(), Zone: "miek.nl."}, now, server)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+func benchKey(b *testing.B, alg uint8, bits int) *DNSKEY {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "miek.nl.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE,
+		Protocol:  3,
+		Algorithm: alg,
+	}
+	priv, err := dnskey.Generate(bits)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &DNSKEY{K: dnskey, D: dnskey.ToDS(dns.SHA256), s: priv.(crypto.Signer), tag: dnskey.KeyTag()}
+}
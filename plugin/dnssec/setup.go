@@ -2,14 +2,14 @@ package dnssec
 
 import (
 	"fmt"
-	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/keypair"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 )
 
@@ -18,30 +18,35 @@ var log = clog.NewWithPlugin("dnssec")
 func init() { plugin.Register("dnssec", setup) }
 
 func setup(c *caddy.Controller) error {
-	zones, keys, capacity, splitkeys, err := dnssecParse(c)
+	d, capacity, err := dnssecParse(c)
 	if err != nil {
 		return plugin.Error("dnssec", err)
 	}
 
 	ca := cache.New(capacity)
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-		return New(zones, keys, splitkeys, next, ca)
+		d.Next = next
+		d.cache = ca
+		return d
 	})
 
 	return nil
 }
 
-func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
+func dnssecParse(c *caddy.Controller) (Dnssec, int, error) {
 	zones := []string{}
 
 	keys := []*DNSKEY{}
 
 	capacity := defaultCap
 
+	validity := defaultValidity
+	var nsec3 []string
+
 	i := 0
 	for c.Next() {
 		if i > 0 {
-			return nil, nil, 0, false, plugin.ErrOnce
+			return Dnssec{}, 0, plugin.ErrOnce
 		}
 		i++
 
@@ -59,21 +64,38 @@ func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
 			case "key":
 				k, e := keyParse(c)
 				if e != nil {
-					return nil, nil, 0, false, e
+					return Dnssec{}, 0, e
 				}
 				keys = append(keys, k...)
 			case "cache_capacity":
 				if !c.NextArg() {
-					return nil, nil, 0, false, c.ArgErr()
+					return Dnssec{}, 0, c.ArgErr()
 				}
 				value := c.Val()
 				cacheCap, err := strconv.Atoi(value)
 				if err != nil {
-					return nil, nil, 0, false, err
+					return Dnssec{}, 0, err
 				}
 				capacity = cacheCap
+			case "nsec3":
+				nsec3 = c.RemainingArgs()
+				if len(nsec3) == 0 {
+					nsec3 = zones
+				}
+			case "validity":
+				if !c.NextArg() {
+					return Dnssec{}, 0, c.ArgErr()
+				}
+				v, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return Dnssec{}, 0, err
+				}
+				if v < time.Hour {
+					return Dnssec{}, 0, c.Errf("validity must be at least 1h: %s", c.Val())
+				}
+				validity = v
 			default:
-				return nil, nil, 0, false, c.Errf("unknown property '%s'", x)
+				return Dnssec{}, 0, c.Errf("unknown property '%s'", x)
 			}
 
 		}
@@ -81,6 +103,14 @@ func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
 	for i := range zones {
 		zones[i] = plugin.Host(zones[i]).Normalize()
 	}
+	whiteLies := map[string]bool{}
+	for _, z := range nsec3 {
+		z = plugin.Host(z).Normalize()
+		if plugin.Zones(zones).Matches(z) != z {
+			return Dnssec{}, 0, fmt.Errorf("nsec3 zone %s is not signed", z)
+		}
+		whiteLies[z] = true
+	}
 
 	// Check if we have both KSKs and ZSKs.
 	zsk, ksk := 0, 0
@@ -93,6 +123,10 @@ func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
 	}
 	splitkeys := zsk > 0 && ksk > 0
 
+	d := New(zones, keys, splitkeys, nil, nil)
+	d.nsec3 = whiteLies
+	d.validity = validity
+
 	// Check if each keys owner name can actually sign the zones we want them to sign.
 	for _, k := range keys {
 		kname := plugin.Name(k.K.Header().Name)
@@ -104,45 +138,24 @@ func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
 			}
 		}
 		if !ok {
-			return zones, keys, capacity, splitkeys, fmt.Errorf("key %s (keyid: %d) can not sign any of the zones", string(kname), k.tag)
+			return d, capacity, fmt.Errorf("key %s (keyid: %d) can not sign any of the zones", string(kname), k.tag)
 		}
 	}
 
-	return zones, keys, capacity, splitkeys, nil
+	return d, capacity, nil
 }
 
 func keyParse(c *caddy.Controller) ([]*DNSKEY, error) {
-	keys := []*DNSKEY{}
-	config := dnsserver.GetConfig(c)
-
 	if !c.NextArg() {
 		return nil, c.ArgErr()
 	}
-	value := c.Val()
-	if value == "file" {
-		ks := c.RemainingArgs()
-		if len(ks) == 0 {
-			return nil, c.ArgErr()
-		}
-
-		for _, k := range ks {
-			base := k
-			// Kmiek.nl.+013+26205.key, handle .private or without extension: Kmiek.nl.+013+26205
-			if strings.HasSuffix(k, ".key") {
-				base = k[:len(k)-4]
-			}
-			if strings.HasSuffix(k, ".private") {
-				base = k[:len(k)-8]
-			}
-			if !filepath.IsAbs(base) && config.Root != "" {
-				base = filepath.Join(config.Root, base)
-			}
-			k, err := ParseKeyFile(base+".key", base+".private")
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, k)
-		}
+	pairs, err := keypair.Parse(c)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*DNSKEY, len(pairs))
+	for i := range pairs {
+		keys[i] = newDNSKEY(pairs[i])
 	}
 	return keys, nil
 }
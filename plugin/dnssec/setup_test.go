@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 )
@@ -82,7 +83,8 @@ func TestSetupDnssec(t *testing.T) {
 
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", test.input)
-		zones, keys, capacity, splitkeys, err := dnssecParse(c)
+		d, capacity, err := dnssecParse(c)
+		zones, keys, splitkeys := d.zones, d.keys, d.splitkeys
 
 		if test.shouldErr && err == nil {
 			t.Errorf("Test %d: Expected error but found %s for input %s", i, err, test.input)
@@ -118,6 +120,68 @@ func TestSetupDnssec(t *testing.T) {
 	}
 }
 
+func TestSetupDnssecOptions(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedNSEC3    []string
+		expectedValidity time.Duration
+	}{
+		{`dnssec example.org`, false, nil, defaultValidity},
+		{`dnssec example.org example.net {
+				nsec3
+			}`, false, []string{"example.org.", "example.net."}, defaultValidity},
+		{`dnssec example.org example.net {
+				nsec3 example.net
+			}`, false, []string{"example.net."}, defaultValidity},
+		{`dnssec example.org {
+				validity 48h
+			}`, false, nil, 48 * time.Hour},
+		// fails
+		{`dnssec example.org {
+				nsec3 example.net
+			}`, true, nil, 0},
+		{`dnssec example.org {
+				nsec3 a.example.org
+			}`, true, nil, 0},
+		{`dnssec example.org {
+				validity
+			}`, true, nil, 0},
+		{`dnssec example.org {
+				validity 1d
+			}`, true, nil, 0},
+		{`dnssec example.org {
+				validity 30m
+			}`, true, nil, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		d, _, err := dnssecParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: Expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: Expected no error but found one for input %s: %s", i, test.input, err)
+			continue
+		}
+		if len(d.nsec3) != len(test.expectedNSEC3) {
+			t.Errorf("Test %d: Expected %d NSEC3 zones, got %d", i, len(test.expectedNSEC3), len(d.nsec3))
+		}
+		for _, z := range test.expectedNSEC3 {
+			if !d.nsec3[z] {
+				t.Errorf("Test %d: Expected NSEC3 for zone %s", i, z)
+			}
+		}
+		if d.validity != test.expectedValidity {
+			t.Errorf("Test %d: Expected validity %s, got %s", i, test.expectedValidity, d.validity)
+		}
+	}
+}
+
 const keypub = `; This is a zone-signing key, keyid 45330, for cluster.local.
 ; Created: 20170901060531 (Fri Sep  1 08:05:31 2017)
 ; Publish: 20170901060531 (Fri Sep  1 08:05:31 2017)
@@ -0,0 +1,212 @@
+package dnssec
+
+import (
+	"context"
+	"encoding/base32"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// whiteLies returns the NSEC3 records, and their signatures, for NXDOMAIN and NODATA responses. These are NSEC3
+// white lies (RFC 7129, Appendix B): every NSEC3 record covers or matches just a single hash, so they can't be
+// used to deny the existence of other names, nor to walk the zone.
+//
+// For NODATA this is a NSEC3 record matching the qname, with the black lies' type bit map. For NXDOMAIN it's
+// the closest encloser proof: a NSEC3 record matching the closest encloser, with the types that exist there,
+// and a NSEC3 record covering the next closer name; a third one covers the wildcard below the closest encloser.
+// The NSEC3 records use SHA1, without salt and without additional iterations (RFC 9276).
+func (d Dnssec) whiteLies(ctx context.Context, state request.Request, mt response.Type, ttl, incep, expir uint32, server string) ([]dns.RR, error) {
+	qname, zone := state.Name(), state.Zone
+
+	var nsec3s []*dns.NSEC3
+	switch mt {
+	case response.NoData:
+		bitmap := filter14(state.QType(), zoneBitmap, mt)
+		if qname == zone {
+			bitmap = filter18(state.QType(), apexBitmap, mt)
+		}
+		nsec3s = append(nsec3s, matching(qname, zone, ttl, bitmap))
+	case response.NameError:
+		ce, types := d.closestEncloser(ctx, state, ttl)
+		nsec3s = append(nsec3s, matching(ce, zone, ttl, types), covering(nextCloser(qname, ce), zone, ttl), covering("*."+ce, zone, ttl))
+	default:
+		return nil, nil
+	}
+
+	var rrs []dns.RR
+	for _, n := range nsec3s {
+		sigs, err := d.sign([]dns.RR{n}, zone, ttl, incep, expir, server)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, n)
+		rrs = append(rrs, sigs...)
+	}
+	return rrs, nil
+}
+
+// closestEncloser returns the closest encloser of the qname of state, a NXDOMAIN reply, and the types that
+// exist there. These are looked up with the next plugin: the ancestors of the qname until one exists, and
+// then each of the probeTypes. A lookup that fails counts as existing, so nothing is denied that may exist.
+// Types that aren't in probeTypes can't be found; they are denied, as in the black lies' type bit maps.
+// Both results are cached for ttl: the closest encloser for each ancestor that doesn't exist, and the types
+// for the closest encloser itself. A closest encloser is a name that exists, so NXDOMAINs under random names
+// only probe the types of the few names in the zone, not of every name queried.
+func (d Dnssec) closestEncloser(ctx context.Context, state request.Request, ttl uint32) (string, []uint16) {
+	qname, zone := state.Name(), state.Zone
+	parent := zone
+	if off, end := dns.NextLabel(qname, 0); !end && dns.IsSubDomain(zone, qname[off:]) {
+		parent = qname[off:]
+	}
+	expires := time.Now().Add(time.Duration(ttl) * time.Second)
+
+	var ce string
+	if e, ok := d.enclosers.Get(cache.Hash([]byte(zone + " " + parent))); ok && time.Now().Before(e.(*encloser).expires) {
+		ce = e.(*encloser).name
+	} else {
+		var missing []string
+		ce = parent
+		for ce != zone {
+			if m := d.lookup(ctx, state, ce, dns.TypeA); m == nil || m.Rcode != dns.RcodeNameError {
+				break
+			}
+			missing = append(missing, ce)
+			off, _ := dns.NextLabel(ce, 0)
+			ce = ce[off:]
+		}
+		for _, name := range append(missing, ce) {
+			d.enclosers.Add(cache.Hash([]byte(zone+" "+name)), &encloser{name: ce, expires: expires})
+		}
+	}
+
+	k := cache.Hash([]byte(zone + " " + ce))
+	if e, ok := d.ceTypes.Get(k); ok && time.Now().Before(e.(*encloser).expires) {
+		return ce, e.(*encloser).types
+	}
+
+	types := []uint16{dns.TypeRRSIG}
+	if ce == zone {
+		types = append(types, dns.TypeSOA, dns.TypeDNSKEY)
+	}
+	for _, t := range probeTypes {
+		if m := d.lookup(ctx, state, ce, t); m == nil || exists(m.Answer, ce, t) {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	d.ceTypes.Add(k, &encloser{name: ce, types: types, expires: expires})
+	return ce, types
+}
+
+// encloser is a closest encloser, and its types, as cached by closestEncloser.
+type encloser struct {
+	name    string
+	types   []uint16
+	expires time.Time
+}
+
+// lookup asks the next plugin for name and qtype. It returns nil when the lookup fails.
+func (d Dnssec) lookup(ctx context.Context, state request.Request, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	nw := nonwriter.New(state.W)
+	if _, err := plugin.NextOrFailure(d.Name(), d.Next, ctx, nw, m); err != nil || nw.Msg == nil {
+		return nil
+	}
+	if nw.Msg.Rcode != dns.RcodeSuccess && nw.Msg.Rcode != dns.RcodeNameError {
+		return nil
+	}
+	return nw.Msg
+}
+
+// exists returns true if rrs holds a record of type qtype for name.
+func exists(rrs []dns.RR, name string, qtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextCloser returns the next closer name of qname: the name one label longer than the closest encloser ce.
+func nextCloser(qname, ce string) string {
+	labels := dns.Split(qname)
+	return qname[labels[len(labels)-dns.CountLabel(ce)-1]:]
+}
+
+// probeTypes are the types closestEncloser looks up, the ones of the black lies' type bit maps and a few more.
+var probeTypes = []uint16{dns.TypeA, dns.TypeNS, dns.TypeCNAME, dns.TypePTR, dns.TypeHINFO, dns.TypeMX, dns.TypeTXT, dns.TypeAAAA,
+	dns.TypeLOC, dns.TypeSRV, dns.TypeNAPTR, dns.TypeCERT, dns.TypeDNAME, dns.TypeDS, dns.TypeSSHFP, dns.TypeTLSA, dns.TypeHIP,
+	dns.TypeOPENPGPKEY, dns.TypeSVCB, dns.TypeHTTPS, dns.TypeSPF, dns.TypeCAA}
+
+// matching returns a NSEC3 record matching name, with the types from bitmap.
+func matching(name, zone string, ttl uint32, bitmap []uint16) *dns.NSEC3 {
+	h := hashName(name)
+	types := make([]uint16, 0, len(bitmap))
+	for _, t := range bitmap {
+		if t != dns.TypeNSEC { // NSEC3 records live at the hashed owner names
+			types = append(types, t)
+		}
+	}
+	return newNSEC3(h, increment(h), zone, ttl, types)
+}
+
+// covering returns a NSEC3 record covering name, i.e. one that runs from the hash of name minus one, to the hash
+// of name plus one.
+func covering(name, zone string, ttl uint32) *dns.NSEC3 {
+	h := hashName(name)
+	return newNSEC3(decrement(h), increment(h), zone, ttl, nil)
+}
+
+func newNSEC3(owner, next []byte, zone string, ttl uint32, types []uint16) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: strings.ToLower(b32.EncodeToString(owner)) + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+		Hash:       dns.SHA1,
+		HashLength: uint8(len(next)),
+		NextDomain: b32.EncodeToString(next),
+		TypeBitMap: types,
+	}
+}
+
+var b32 = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// hashName returns the SHA1 hash of name, without salt and without additional iterations.
+func hashName(name string) []byte {
+	h, _ := b32.DecodeString(dns.HashName(name, dns.SHA1, 0, ""))
+	return h
+}
+
+// increment returns h plus one, it wraps around.
+func increment(h []byte) []byte {
+	n := append([]byte(nil), h...)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// decrement returns h minus one, it wraps around.
+func decrement(h []byte) []byte {
+	n := append([]byte(nil), h...)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]--
+		if n[i] != 0xff {
+			break
+		}
+	}
+	return n
+}
@@ -0,0 +1,261 @@
+package dnssec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestZoneSigningWhiteLies(t *testing.T) {
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	d.nsec3 = map[string]bool{"miek.nl.": true}
+
+	m := testNxdomainMsg()
+	state := request.Request{Req: m, Zone: "miek.nl."}
+	m = d.Sign(state, time.Now().UTC(), server)
+
+	if m.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected rcode %d, got %d", dns.RcodeNameError, m.Rcode)
+	}
+	if !section(m.Ns, 4) {
+		t.Errorf("Authority section should have 4 sigs")
+	}
+	nsec3s := nsec3Records(t, d, m.Ns)
+	if len(nsec3s) != 3 {
+		t.Fatalf("Expected 3 NSEC3 records, got %d", len(nsec3s))
+	}
+	if !nsec3s[0].Match("miek.nl.") {
+		t.Errorf("Expected NSEC3 matching the closest encloser %s: %s", "miek.nl.", nsec3s[0])
+	}
+	if !nsec3s[1].Cover("ww.miek.nl.") {
+		t.Errorf("Expected NSEC3 covering the next closer name %s: %s", "ww.miek.nl.", nsec3s[1])
+	}
+	if !nsec3s[2].Cover("*.miek.nl.") {
+		t.Errorf("Expected NSEC3 covering the wildcard %s: %s", "*.miek.nl.", nsec3s[2])
+	}
+	// White lies: the covering records must not cover any other name.
+	for _, name := range []string{"a.miek.nl.", "www.miek.nl.", "miek.nl."} {
+		if nsec3s[1].Cover(name) || nsec3s[2].Cover(name) {
+			t.Errorf("Expected NSEC3 records not to cover %s", name)
+		}
+	}
+}
+
+func TestZoneSigningWhiteLiesNoData(t *testing.T) {
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	d.nsec3 = map[string]bool{"miek.nl.": true}
+
+	m := testNoDataMsg()
+	state := request.Request{Req: m, Zone: "miek.nl."}
+	m = d.Sign(state, time.Now().UTC(), server)
+
+	if m.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected rcode %d, got %d", dns.RcodeSuccess, m.Rcode)
+	}
+	nsec3s := nsec3Records(t, d, m.Ns)
+	if len(nsec3s) != 1 {
+		t.Fatalf("Expected 1 NSEC3 record, got %d", len(nsec3s))
+	}
+	if !nsec3s[0].Match("www.miek.nl.") {
+		t.Errorf("Expected NSEC3 matching %s: %s", "www.miek.nl.", nsec3s[0])
+	}
+	for _, typ := range nsec3s[0].TypeBitMap {
+		if typ == dns.TypeTXT || typ == dns.TypeNSEC {
+			t.Errorf("Expected %s not to be in the type bit map", dns.TypeToString[typ])
+		}
+	}
+}
+
+func TestZoneSigningWhiteLiesOtherZone(t *testing.T) {
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	d.nsec3 = map[string]bool{"example.org.": true}
+
+	m := testNxdomainMsg()
+	state := request.Request{Req: m, Zone: "miek.nl."}
+	m = d.Sign(state, time.Now().UTC(), server)
+
+	// Black lies are used for miek.nl.
+	if m.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected rcode %d, got %d", dns.RcodeSuccess, m.Rcode)
+	}
+	if len(nsec3Records(t, d, m.Ns)) != 0 {
+		t.Errorf("Expected no NSEC3 records")
+	}
+}
+
+func TestZoneSigningWhiteLiesClosestEncloser(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(dbWhiteLies), "miek.nl.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm := file.File{Next: test.ErrorHandler(), Zones: file.Zones{Z: map[string]*file.Zone{"miek.nl.": zone}, Names: []string{"miek.nl."}}}
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	d.Next = fm
+	d.nsec3 = map[string]bool{"miek.nl.": true}
+
+	tests := []struct {
+		qname      string
+		ce         string
+		nextCloser string
+		types      []uint16 // types in the bit map of the closest encloser, besides RRSIG
+	}{
+		{"x.mail.miek.nl.", "mail.miek.nl.", "x.mail.miek.nl.", []uint16{dns.TypeA, dns.TypeMX}},
+		{"a.b.mail.miek.nl.", "mail.miek.nl.", "b.mail.miek.nl.", []uint16{dns.TypeA, dns.TypeMX}},
+		{"x.y.miek.nl.", "miek.nl.", "y.miek.nl.", []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeMX, dns.TypeDNSKEY}},
+		{"x.www.miek.nl.", "www.miek.nl.", "x.www.miek.nl.", []uint16{dns.TypeTXT}},
+	}
+	for _, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		m.SetEdns0(4096, true)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if rec.Msg.Rcode != dns.RcodeNameError {
+			t.Errorf("Test %s: expected rcode %d, got %d", tc.qname, dns.RcodeNameError, rec.Msg.Rcode)
+		}
+		nsec3s := nsec3Records(t, d, rec.Msg.Ns)
+		if len(nsec3s) != 3 {
+			t.Fatalf("Test %s: expected 3 NSEC3 records, got %d", tc.qname, len(nsec3s))
+		}
+		if !nsec3s[0].Match(tc.ce) {
+			t.Errorf("Test %s: expected NSEC3 matching the closest encloser %s: %s", tc.qname, tc.ce, nsec3s[0])
+		}
+		if !nsec3s[1].Cover(tc.nextCloser) {
+			t.Errorf("Test %s: expected NSEC3 covering the next closer name %s: %s", tc.qname, tc.nextCloser, nsec3s[1])
+		}
+		if !nsec3s[2].Cover("*." + tc.ce) {
+			t.Errorf("Test %s: expected NSEC3 covering the wildcard *.%s: %s", tc.qname, tc.ce, nsec3s[2])
+		}
+		types := map[uint16]bool{}
+		for _, typ := range nsec3s[0].TypeBitMap {
+			types[typ] = true
+		}
+		for _, typ := range append(tc.types, dns.TypeRRSIG) {
+			if !types[typ] {
+				t.Errorf("Test %s: expected %s in the type bit map of %s", tc.qname, dns.TypeToString[typ], tc.ce)
+			}
+			delete(types, typ)
+		}
+		for typ := range types {
+			t.Errorf("Test %s: expected %s not to be in the type bit map of %s", tc.qname, dns.TypeToString[typ], tc.ce)
+		}
+	}
+}
+
+func TestZoneSigningWhiteLiesLookups(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(dbWhiteLies), "miek.nl.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm := file.File{Next: test.ErrorHandler(), Zones: file.Zones{Z: map[string]*file.Zone{"miek.nl.": zone}, Names: []string{"miek.nl."}}}
+	d, rm1, rm2 := newDnssec(t, []string{"miek.nl."})
+	defer rm1()
+	defer rm2()
+	lookups := 0
+	d.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		lookups++
+		return fm.ServeDNS(ctx, w, r)
+	})
+	d.nsec3 = map[string]bool{"miek.nl.": true}
+
+	// Every query has a parent that doesn't exist, but they share the closest encloser: its types are only
+	// looked up once.
+	for i := 0; i < 10; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion(fmt.Sprintf("x.random%d.miek.nl.", i), dns.TypeA)
+		m.SetEdns0(4096, true)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if i == 0 {
+			lookups = 0
+		}
+	}
+	// The query itself, and the lookup of its parent.
+	if lookups != 9*2 {
+		t.Errorf("Expected %d lookups, got %d", 9*2, lookups)
+	}
+}
+
+const dbWhiteLies = `
+$TTL    30M
+$ORIGIN miek.nl.
+@       IN      SOA     linode.atoom.net. miek.miek.nl. 1282630057 4H 1H 7D 4H
+        IN      NS      linode.atoom.net.
+        IN      MX      10 mail.miek.nl.
+mail    IN      A       192.0.2.25
+        IN      MX      10 mail.miek.nl.
+www     IN      TXT     "www"
+`
+
+func TestIncrementDecrement(t *testing.T) {
+	tests := []struct {
+		in, inc, dec []byte
+	}{
+		{[]byte{0, 0}, []byte{0, 1}, []byte{0xff, 0xff}},
+		{[]byte{0, 0xff}, []byte{1, 0}, []byte{0, 0xfe}},
+		{[]byte{0xff, 0xff}, []byte{0, 0}, []byte{0xff, 0xfe}},
+		{[]byte{1, 0}, []byte{1, 1}, []byte{0, 0xff}},
+	}
+	for i, tc := range tests {
+		if x := increment(tc.in); string(x) != string(tc.inc) {
+			t.Errorf("Test %d: expected increment %v, got %v", i, tc.inc, x)
+		}
+		if x := decrement(tc.in); string(x) != string(tc.dec) {
+			t.Errorf("Test %d: expected decrement %v, got %v", i, tc.dec, x)
+		}
+	}
+}
+
+// nsec3Records returns the NSEC3 records in rrs, after checking their signatures.
+func nsec3Records(t *testing.T, d Dnssec, rrs []dns.RR) []*dns.NSEC3 {
+	var nsec3s []*dns.NSEC3
+	for _, rr := range rrs {
+		n, ok := rr.(*dns.NSEC3)
+		if !ok {
+			continue
+		}
+		nsec3s = append(nsec3s, n)
+		verified := false
+		for _, s := range rrs {
+			if sig, ok := s.(*dns.RRSIG); ok && sig.TypeCovered == dns.TypeNSEC3 && sig.Header().Name == n.Header().Name {
+				if err := sig.Verify(d.keys[0].K, []dns.RR{n}); err != nil {
+					t.Errorf("Failed to verify signature of %s: %s", n, err)
+				}
+				verified = true
+			}
+		}
+		if !verified {
+			t.Errorf("Expected signature for %s", n)
+		}
+	}
+	return nsec3s
+}
+
+func testNoDataMsg() *dns.Msg {
+	return &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeSuccess},
+		Question: []dns.Question{{Name: "www.miek.nl.", Qclass: dns.ClassINET, Qtype: dns.TypeTXT}},
+		Ns:       []dns.RR{test.SOA("miek.nl.	1800	IN	SOA	linode.atoom.net. miek.miek.nl. 1461471181 14400 3600 604800 14400")},
+	}
+}
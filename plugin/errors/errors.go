@@ -9,6 +9,7 @@ import (
 	"unsafe"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
 
@@ -32,9 +33,16 @@ func (p *pattern) setTimer(t *time.Timer) {
 	atomic.StorePointer(&p.ptimer, unsafe.Pointer(t))
 }
 
+// extendedError is the Extended DNS Error info-code for the errors matching pattern.
+type extendedError struct {
+	code    uint16
+	pattern *regexp.Regexp
+}
+
 // errorHandler handles DNS errors (and errors from other plugin).
 type errorHandler struct {
 	patterns []*pattern
+	extended []*extendedError
 	stopFlag uint32
 	Next     plugin.Handler
 }
@@ -83,6 +91,7 @@ func (h *errorHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dn
 	rcode, err := plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
 
 	if err != nil {
+		err = h.extendedError(err)
 		strErr := err.Error()
 		for i := range h.patterns {
 			if h.patterns[i].pattern.MatchString(strErr) {
@@ -99,5 +108,17 @@ func (h *errorHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dn
 	return rcode, err
 }
 
+// extendedError returns err with the info-code of the first extended error that matches it, the server adds
+// that to the error response. If none matches, err is returned as is.
+func (h *errorHandler) extendedError(err error) error {
+	strErr := err.Error()
+	for _, e := range h.extended {
+		if e.pattern.MatchString(strErr) {
+			return edns.NewError(e.code, err)
+		}
+	}
+	return err
+}
+
 // Name implements the plugin.Handler interface.
 func (h *errorHandler) Name() string { return "errors" }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/miekg/dns"
@@ -70,6 +71,37 @@ func TestErrors(t *testing.T) {
 	}
 }
 
+func TestExtendedError(t *testing.T) {
+	buf := bytes.Buffer{}
+	golog.SetOutput(&buf)
+	h := &errorHandler{extended: []*extendedError{
+		{code: edns.ExtendedErrorNetworkError, pattern: regexp.MustCompile("timeout$")},
+		{code: edns.ExtendedErrorOther, pattern: regexp.MustCompile(".*")},
+	}}
+
+	tests := []struct {
+		err      error
+		expected uint16
+	}{
+		{errors.New("read udp: i/o timeout"), edns.ExtendedErrorNetworkError},
+		{errors.New("something else"), edns.ExtendedErrorOther},
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	for i, tc := range tests {
+		h.Next = genErrorHandler(dns.RcodeServerFailure, tc.err)
+		_, err := h.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Test %d: expected the error to wrap %v, got %v", i, tc.err, err)
+		}
+		if code, ok := edns.ErrorCode(err); !ok || code != tc.expected {
+			t.Errorf("Test %d: expected info-code %d, got %d", i, tc.expected, code)
+		}
+	}
+}
+
 func TestLogPattern(t *testing.T) {
 	buf := bytes.Buffer{}
 	golog.SetOutput(&buf)
@@ -1,12 +1,16 @@
 package errors
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 )
 
 func init() { plugin.Register("errors", setup) }
@@ -61,23 +65,54 @@ func errorsParse(c *caddy.Controller) (*errorHandler, error) {
 }
 
 func parseBlock(c *caddy.Controller, h *errorHandler) error {
-	if c.Val() != "consolidate" {
-		return c.SyntaxErr("consolidate")
-	}
+	switch c.Val() {
+	case "consolidate":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		p, err := time.ParseDuration(args[0])
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		h.patterns = append(h.patterns, &pattern{period: p, pattern: re})
 
-	args := c.RemainingArgs()
-	if len(args) != 2 {
-		return c.ArgErr()
-	}
-	p, err := time.ParseDuration(args[0])
-	if err != nil {
-		return c.Err(err.Error())
-	}
-	re, err := regexp.Compile(args[1])
-	if err != nil {
-		return c.Err(err.Error())
+	case "extended_error":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		code, err := parseInfoCode(args[0])
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		h.extended = append(h.extended, &extendedError{code: code, pattern: re})
+
+	default:
+		return c.SyntaxErr("consolidate or extended_error")
 	}
-	h.patterns = append(h.patterns, &pattern{period: p, pattern: re})
 
 	return nil
 }
+
+// parseInfoCode parses an Extended DNS Error info-code, given as a number or as its name from RFC 8914
+// without the spaces, e.g. "NetworkError".
+func parseInfoCode(s string) (uint16, error) {
+	if code, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(code), nil
+	}
+	for code, name := range edns.ExtendedErrorToString {
+		if strings.EqualFold(s, strings.Replace(name, " ", "", -1)) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown extended error: %s", s)
+}
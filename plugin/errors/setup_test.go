@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 )
 
 func TestErrorsParse(t *testing.T) {
@@ -52,6 +53,25 @@ func TestErrorsParse(t *testing.T) {
 		    consolidate 1m error1
 		    consolidate 5s error2
 		  }`, false, 2},
+		{`errors {
+		    extended_error 23 "i/o timeout$"
+		  }`, false, 0},
+		{`errors {
+		    extended_error NetworkError "i/o timeout$"
+		    consolidate 1m error
+		  }`, false, 1},
+		{`errors {
+		    extended_error nosuchcode .*
+		  }`, true, 0},
+		{`errors {
+		    extended_error 65536 .*
+		  }`, true, 0},
+		{`errors {
+		    extended_error 23
+		  }`, true, 0},
+		{`errors {
+		    extended_error 23 ())
+		  }`, true, 0},
 	}
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", test.inputErrorsRules)
@@ -67,3 +87,28 @@ func TestErrorsParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInfoCode(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  uint16
+		shouldErr bool
+	}{
+		{"23", edns.ExtendedErrorNetworkError, false},
+		{"NetworkError", edns.ExtendedErrorNetworkError, false},
+		{"noreachableauthority", edns.ExtendedErrorNoReachableAuthority, false},
+		{"DNSSECBogus", edns.ExtendedErrorDNSSECBogus, false},
+		{"Network", 0, true},
+		{"-1", 0, true},
+	}
+	for i, tc := range tests {
+		code, err := parseInfoCode(tc.input)
+		if (err != nil) != tc.shouldErr {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if code != tc.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, tc.expected, code)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package file
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// CheckLevel tells what to do with the problems Check finds in a zone when it's loaded.
+type CheckLevel int
+
+const (
+	// CheckOff doesn't check the zone.
+	CheckOff CheckLevel = iota - 1
+	// CheckWarn logs the problems, but loads the zone. This is the zero value, so zones are checked unless
+	// they are configured not to be.
+	CheckWarn
+	// CheckError logs the problems, and refuses to load the zone if there are errors.
+	CheckError
+	// CheckStrict logs the problems, and refuses to load the zone if there are any.
+	CheckStrict
+)
+
+var checkLevels = map[string]CheckLevel{"off": CheckOff, "warn": CheckWarn, "error": CheckError, "strict": CheckStrict}
+
+// A Problem is something wrong in a zone, found by Check.
+type Problem struct {
+	Name   string
+	Type   uint16
+	Error  bool // Errors break the resolution of names in the zone, the other problems are warnings.
+	Reason string
+}
+
+func (p Problem) String() string { return fmt.Sprintf("%s %s: %s", p.Name, dns.Type(p.Type), p.Reason) }
+
+// Check checks zone z for mistakes that the zone file parser doesn't catch:
+//
+//   - records that are not in the zone;
+//   - a CNAME with other data (RFC 1034, section 3.6.2);
+//   - NS records whose target is in the zone, but has no address records, i.e. missing glue;
+//   - NS records whose target is a CNAME (RFC 2181, section 10.3), this is a warning;
+//   - signatures that are expired, or not yet valid, at time now.
+func (z *Zone) Check(now time.Time) []Problem {
+	z.RLock()
+	defer z.RUnlock()
+
+	var problems []Problem
+	problem := func(name string, qtype uint16, err bool, format string, a ...interface{}) {
+		problems = append(problems, Problem{Name: name, Type: qtype, Error: err, Reason: fmt.Sprintf(format, a...)})
+	}
+
+	checkSigs := func(sigs []dns.RR) {
+		for _, rr := range sigs {
+			sig, ok := rr.(*dns.RRSIG)
+			if !ok || sig.ValidityPeriod(now) {
+				continue
+			}
+			when := "expired"
+			if now.Before(time.Unix(int64(sig.Inception), 0)) {
+				when = "is not yet valid"
+			}
+			problem(sig.Header().Name, dns.TypeRRSIG, true, "signature covering %s with key tag %d %s", dns.Type(sig.TypeCovered), sig.KeyTag, when)
+		}
+	}
+
+	checkNS := func(ns []dns.RR) {
+		for _, rr := range ns {
+			target := rr.(*dns.NS).Ns
+			if !dns.IsSubDomain(z.origin, target) {
+				continue
+			}
+			e, found := z.Tree.Search(target)
+			switch {
+			case found && e.Type(dns.TypeCNAME) != nil:
+				problem(rr.Header().Name, dns.TypeNS, false, "target %s is a CNAME", target)
+			case !found || (e.Type(dns.TypeA) == nil && e.Type(dns.TypeAAAA) == nil):
+				problem(rr.Header().Name, dns.TypeNS, true, "target %s has no address records in the zone (missing glue)", target)
+			}
+		}
+	}
+
+	checkSigs(z.Apex.SIGSOA)
+	checkSigs(z.Apex.SIGNS)
+	checkNS(z.Apex.NS)
+
+	z.Tree.Walk(func(e *tree.Elem, rrs map[uint16][]dns.RR) error {
+		name := e.Name()
+		if !dns.IsSubDomain(z.origin, name) {
+			problem(name, e.Types()[0], true, "not in zone %s", z.origin)
+			return nil
+		}
+		if rrs[dns.TypeCNAME] != nil {
+			if name == z.origin {
+				problem(name, dns.TypeSOA, true, "CNAME and other data")
+			}
+			for _, t := range e.Types() {
+				switch t {
+				case dns.TypeCNAME, dns.TypeRRSIG, dns.TypeNSEC:
+				default:
+					problem(name, t, true, "CNAME and other data")
+				}
+			}
+		}
+		checkNS(rrs[dns.TypeNS])
+		checkSigs(rrs[dns.TypeRRSIG])
+		return nil
+	})
+	if z.Apex.NSEC3 != nil {
+		z.Apex.NSEC3.Walk(func(e *tree.Elem, rrs map[uint16][]dns.RR) error {
+			checkSigs(rrs[dns.TypeRRSIG])
+			return nil
+		})
+	}
+
+	return problems
+}
+
+// CheckLoad checks z, a zone that is about to be loaded, and logs the problems. It returns an error when
+// z.CheckLevel says z must not be loaded.
+func (z *Zone) CheckLoad() error { return z.check(z) }
+
+// check checks zone, the newly loaded version of z, and logs the problems. It returns an error when
+// z.CheckLevel says zone must not be loaded.
+func (z *Zone) check(zone *Zone) error {
+	if z.CheckLevel == CheckOff {
+		return nil
+	}
+	file := zone.File()
+	errs, warnings := 0, 0
+	for _, p := range zone.Check(time.Now()) {
+		if p.Error {
+			log.Errorf("Zone %q in %q: %s", z.origin, file, p)
+			errs++
+		} else {
+			log.Warningf("Zone %q in %q: %s", z.origin, file, p)
+			warnings++
+		}
+	}
+	if errs > 0 && z.CheckLevel >= CheckError || warnings > 0 && z.CheckLevel == CheckStrict {
+		return fmt.Errorf("zone %q in %q has %d errors and %d warnings", z.origin, file, errs, warnings)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package file
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheck(t *testing.T) {
+	zone, err := Parse(strings.NewReader(dbCheckExampleOrg), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	problems := zone.Check(now)
+
+	expected := []string{
+		"a.example.org. MX: CNAME and other data",
+		"example.org. RRSIG: signature covering SOA with key tag 12051 expired",
+		"lame.example.org. NS: target ns.lame.example.org. has no address records in the zone (missing glue)",
+		"other.example.org. NS: target alias.example.org. is a CNAME",
+		"www.example.net. A: not in zone example.org.",
+		"www.example.org. RRSIG: signature covering A with key tag 12051 is not yet valid",
+	}
+	got := make([]string, len(problems))
+	for i, p := range problems {
+		got[i] = p.String()
+	}
+	sort.Strings(got)
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	for _, p := range problems {
+		if isError := p.Type != dns.TypeNS || !strings.Contains(p.Reason, "CNAME"); p.Error != isError {
+			t.Errorf("Expected %q to have error %t", p, isError)
+		}
+	}
+
+	// A correct zone has no problems.
+	zone, err = Parse(strings.NewReader(dbMiekNL), testzone, "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := zone.Check(now); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestCheckLevel(t *testing.T) {
+	withErrors, err := Parse(strings.NewReader(dbCheckExampleOrg), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withWarning, err := Parse(strings.NewReader(dbCheckWarning), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		level     CheckLevel
+		zone      *Zone
+		shouldErr bool
+	}{
+		{CheckOff, withErrors, false},
+		{CheckWarn, withErrors, false},
+		{CheckError, withErrors, true},
+		{CheckError, withWarning, false},
+		{CheckStrict, withWarning, true},
+	}
+	for i, tc := range tests {
+		z := NewZone("example.org.", "stdin")
+		z.CheckLevel = tc.level
+		if err := z.check(tc.zone); (err != nil) != tc.shouldErr {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+		}
+	}
+
+	// Zones that aren't configured, e.g. those of auto and secondary, are checked.
+	if level := NewZone("example.org.", "stdin").CheckLevel; level != CheckWarn {
+		t.Errorf("Expected check level %d for a new zone, got %d", CheckWarn, level)
+	}
+}
+
+func TestTransferInRejected(t *testing.T) {
+	p, err := Parse(strings.NewReader(dbCheckWarning), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ixfr, axfr := int32(0), int32(0)
+	s := dnstest.NewServer(primary(t, p, &ixfr, &axfr))
+	defer s.Close()
+
+	z := NewZone("example.org.", "stdin")
+	z.TransferFrom = []string{s.Addr}
+	z.CheckLevel = CheckStrict
+	if err := z.TransferIn(); err == nil {
+		t.Errorf("Expected the transferred zone to be refused")
+	}
+	if x := z.SOASerialIfDefined(); x != -1 {
+		t.Errorf("Expected no zone, got serial %d", x)
+	}
+
+	// By default the problems are only logged.
+	z.CheckLevel = CheckWarn
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if x := z.SOASerialIfDefined(); x != 2016082534 {
+		t.Errorf("Expected serial %d, got %d", 2016082534, x)
+	}
+}
+
+func TestReloadRejected(t *testing.T) {
+	fileName, rm, err := test.TempFile(".", dbCheckWarning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+
+	z, err := Parse(strings.NewReader(dbCheckWarning), "example.org.", fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.CheckLevel = CheckError
+
+	// The new version has a CNAME and other data, and a higher serial.
+	bad := strings.Replace(dbCheckWarning, "2016082534", "2016082535", 1) + "alias IN A 127.0.0.2\n"
+	if err := ioutil.WriteFile(fileName, []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(ReloadRejectedCount.WithLabelValues("example.org."))
+	if z.ReloadFile(nil) {
+		t.Fatalf("Expected the reload to be rejected")
+	}
+	if x := z.SOASerialIfDefined(); x != 2016082534 {
+		t.Errorf("Expected the zone to keep serial %d, got %d", 2016082534, x)
+	}
+	if x := testutil.ToFloat64(ReloadRejectedCount.WithLabelValues("example.org.")) - before; x != 1 {
+		t.Errorf("Expected 1 rejected reload, got %f", x)
+	}
+}
+
+const dbCheckExampleOrg = `$ORIGIN example.org.
+@	IN	SOA	sns.dns.icann.org. noc.dns.icann.org. 2016082534 7200 3600 1209600 3600
+	IN	NS	ns.example.org.
+	IN	RRSIG	SOA 8 2 3600 20200101000000 20191201000000 12051 example.org. FIrzy07acBbtyQczy1dc=
+ns	IN	A	127.0.0.1
+a	IN	CNAME	www
+	IN	MX	10 mx.example.org.
+www	IN	A	127.0.0.1
+	IN	RRSIG	A 8 3 3600 20210101000000 20200701000000 12051 example.org. FIrzy07acBbtyQczy1dc=
+alias	IN	CNAME	ns
+lame	IN	NS	ns.lame
+other	IN	NS	alias
+www.example.net.	IN	A	127.0.0.1
+`
+
+const dbCheckWarning = `$ORIGIN example.org.
+@	IN	SOA	sns.dns.icann.org. noc.dns.icann.org. 2016082534 7200 3600 1209600 3600
+	IN	NS	ns.example.org.
+ns	IN	A	127.0.0.1
+alias	IN	CNAME	ns
+other	IN	NS	alias
+`
@@ -0,0 +1,67 @@
+// Command zonecompile converts a zone file to a snapshot that the file plugin loads with "format binary".
+//
+// Usage:
+//
+//	zonecompile -origin example.org db.example.org db.example.org.bin
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coredns/coredns/plugin/file"
+)
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	// Use our own flag set, the packages we import register flags of their own.
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	origin := fs.String("origin", "", "origin of the zone (required)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s -origin ORIGIN ZONEFILE SNAPSHOT\n", name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if *origin == "" || fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := compile(*origin, fs.Arg(0), fs.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// compile parses zone file in and writes it as a snapshot to out. The snapshot is written to a temporary file
+// first, so a running CoreDNS never sees a partially written snapshot.
+func compile(origin, in, out string) error {
+	r, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	z, err := file.Parse(r, origin, in, -1)
+	if err != nil {
+		return err
+	}
+
+	tmp := out + ".tmp"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // fails with not exist when the rename succeeded.
+
+	if err := file.WriteSnapshot(w, z); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
@@ -53,6 +54,9 @@ func (f File) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (i
 			m := new(dns.Msg)
 			m.SetReply(r)
 			m.Authoritative = true
+			if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+				m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+			}
 			w.WriteMsg(m)
 
 			log.Infof("Notify from %s for %s: checking transfer", state.IP(), zone)
@@ -71,6 +75,10 @@ func (f File) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (i
 		return dns.RcodeSuccess, nil
 	}
 
+	if r.Opcode == dns.OpcodeUpdate {
+		return f.serveUpdate(state, z)
+	}
+
 	z.RLock()
 	exp := z.Expired
 	z.RUnlock()
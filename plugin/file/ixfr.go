@@ -0,0 +1,142 @@
+package file
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// keepDiffs returns true when the changes to z are needed: to answer IXFR requests, or for the journal.
+func (z *Zone) keepDiffs() bool { return z.IXFR || z.Journal }
+
+// addDiff adds d to the changes kept in memory, these are used to answer IXFR requests. If d doesn't follow on
+// from the last change, the earlier changes are dropped. The lock must be held.
+func (z *Zone) addDiff(d diff) {
+	if n := len(z.diffs); n > 0 {
+		from, _ := d.serials()
+		if _, to := z.diffs[n-1].serials(); to != from {
+			z.diffs = nil
+		}
+	}
+	z.diffs = append(z.diffs, d)
+	if len(z.diffs) > maxJournal {
+		z.diffs = append([]diff(nil), z.diffs[len(z.diffs)-maxJournal:]...)
+	}
+}
+
+// incremental returns the changes that take the zone from serial from to serial to. If these aren't known
+// nil is returned. The lock must be held.
+func (z *Zone) incremental(from, to uint32) []diff {
+	n := len(z.diffs)
+	if n == 0 {
+		return nil
+	}
+	if _, last := z.diffs[n-1].serials(); last != to {
+		return nil
+	}
+	for i := range z.diffs {
+		if f, _ := z.diffs[i].serials(); f == from {
+			return z.diffs[i:]
+		}
+	}
+	return nil
+}
+
+// splitDiffs splits the records of an incremental transfer, without the leading and trailing SOA records,
+// into changes.
+func splitDiffs(rrs []dns.RR) ([]diff, error) {
+	diffs := []diff{}
+	for _, rr := range rrs {
+		_, soa := rr.(*dns.SOA)
+		last := len(diffs) - 1
+		switch {
+		case soa && (last < 0 || len(diffs[last].add) > 0):
+			diffs = append(diffs, diff{del: []dns.RR{rr}})
+		case soa:
+			diffs[last].add = []dns.RR{rr}
+		case last < 0:
+			return nil, fmt.Errorf("record before the SOA: %s", rr)
+		case len(diffs[last].add) == 0:
+			diffs[last].del = append(diffs[last].del, rr)
+		default:
+			diffs[last].add = append(diffs[last].add, rr)
+		}
+	}
+	if len(diffs) > 0 && len(diffs[len(diffs)-1].add) == 0 {
+		return nil, fmt.Errorf("truncated incremental transfer")
+	}
+	return diffs, nil
+}
+
+// transferIncremental requests an IXFR from primary tr, and applies the changes to z. It returns false if the
+// changes couldn't be applied, an AXFR should then be done. If the primary sent the entire zone instead, it
+// returns false and the records of the zone.
+func (z *Zone) transferIncremental(tr string) (bool, []dns.RR, error) {
+	z.RLock()
+	soa := z.Apex.SOA
+	z.RUnlock()
+	if soa == nil {
+		return false, nil, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetIxfr(z.origin, soa.Serial, soa.Ns, soa.Mbox)
+	t := new(dns.Transfer)
+	t.TsigSecret = z.sign(m)
+	c, err := t.In(m, tr)
+	if err != nil {
+		return false, nil, err
+	}
+	rrs := []dns.RR{}
+	for env := range c {
+		if env.Error != nil {
+			return false, nil, env.Error
+		}
+		rrs = append(rrs, env.RR...)
+	}
+
+	// A single SOA means we're up to date, otherwise an incremental transfer starts with two SOAs; the new and
+	// the old one. Anything else is the entire zone.
+	if len(rrs) == 1 {
+		return true, nil, nil
+	}
+	if len(rrs) < 3 {
+		return false, rrs, nil
+	}
+	if _, ok := rrs[1].(*dns.SOA); !ok {
+		return false, rrs, nil
+	}
+	diffs, err := splitDiffs(rrs[1 : len(rrs)-1])
+	if err != nil {
+		return false, nil, err
+	}
+
+	s := z.rrsets()
+	for _, d := range diffs {
+		from, _ := d.serials()
+		if cur := s.soa(z.origin); cur == nil || cur.Serial != from {
+			return false, nil, fmt.Errorf("change from %d SOA serial doesn't apply", from)
+		}
+		s.apply(z.origin, d)
+	}
+	z1, err := s.zone(z.origin, z.File())
+	if err != nil {
+		return false, nil, err
+	}
+	if err := z.check(z1); err != nil {
+		return false, nil, err
+	}
+
+	z.Lock()
+	z.Tree = z1.Tree
+	z.Apex = z1.Apex
+	z.Expired = false
+	if z.keepDiffs() {
+		for _, d := range diffs {
+			z.addDiff(d)
+		}
+	}
+	z.Unlock()
+	log.Infof("Transferred %d changes of %s from %s", len(diffs), z.origin, tr)
+	return true, nil, nil
+}
@@ -0,0 +1,252 @@
+package file
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func soaDiff(from, to uint32) diff {
+	soa := func(serial uint32) dns.RR {
+		s := test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 14400 3600 604800 14400")
+		s.Serial = serial
+		return s
+	}
+	return diff{del: []dns.RR{soa(from)}, add: []dns.RR{soa(to)}}
+}
+
+func TestIncremental(t *testing.T) {
+	z := NewZone("example.org.", "stdin")
+	z.addDiff(soaDiff(1, 2))
+	z.addDiff(soaDiff(2, 3))
+
+	tests := []struct {
+		from, to uint32
+		expected int
+	}{
+		{1, 3, 2},
+		{2, 3, 1},
+		{3, 3, 0},
+		{0, 3, 0},
+		{1, 4, 0},
+	}
+	for i, tc := range tests {
+		if n := len(z.incremental(tc.from, tc.to)); n != tc.expected {
+			t.Errorf("Test %d: expected %d changes from %d to %d, got %d", i, tc.expected, tc.from, tc.to, n)
+		}
+	}
+
+	// A change that doesn't follow on from the last one drops the earlier changes.
+	z.addDiff(soaDiff(10, 11))
+	if n := len(z.diffs); n != 1 {
+		t.Errorf("Expected %d change, got %d", 1, n)
+	}
+	for i := uint32(11); i < 11+2*maxJournal; i++ {
+		z.addDiff(soaDiff(i, i+1))
+	}
+	if n := len(z.diffs); n != maxJournal {
+		t.Errorf("Expected %d changes, got %d", maxJournal, n)
+	}
+}
+
+func TestTransferIncremental(t *testing.T) {
+	z, rm := updateZone(t)
+	defer rm()
+
+	for _, rr := range []dns.RR{test.A("host1.example.org. 300 IN A 10.0.0.1"), test.A("host2.example.org. 300 IN A 10.0.0.2")} {
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		m.Insert([]dns.RR{rr})
+		if resp := update(t, z, m); resp.Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected success, got %s", dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	tests := []struct {
+		serial   uint32
+		expected []uint16 // types of the records sent
+	}{
+		{1282630059, []uint16{dns.TypeSOA}},
+		{1282630058, []uint16{dns.TypeSOA, dns.TypeSOA, dns.TypeSOA, dns.TypeA, dns.TypeSOA}},
+		{1282630057, []uint16{dns.TypeSOA, dns.TypeSOA, dns.TypeSOA, dns.TypeA, dns.TypeSOA, dns.TypeSOA, dns.TypeA, dns.TypeSOA}},
+		// Unknown serial: the entire zone.
+		{1, []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeA, dns.TypeA, dns.TypeA, dns.TypeCNAME, dns.TypeSOA}},
+	}
+	for i, tc := range tests {
+		ch, err := z.Transfer(tc.serial)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		types := []uint16{}
+		for rrs := range ch {
+			for _, rr := range rrs {
+				types = append(types, rr.Header().Rrtype)
+			}
+		}
+		if len(types) != len(tc.expected) {
+			t.Errorf("Test %d: expected %d records, got %d", i, len(tc.expected), len(types))
+			continue
+		}
+		for j := range types {
+			if types[j] != tc.expected[j] {
+				t.Errorf("Test %d: expected %s for record %d, got %s", i, dns.TypeToString[tc.expected[j]], j, dns.TypeToString[types[j]])
+			}
+		}
+	}
+}
+
+// primary returns a handler that serves transfers of z, it counts the IXFR and AXFR requests.
+func primary(t *testing.T, z *Zone, ixfr, axfr *int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		serial := uint32(0)
+		if r.Question[0].Qtype == dns.TypeIXFR {
+			atomic.AddInt32(ixfr, 1)
+			serial = r.Ns[0].(*dns.SOA).Serial
+		} else {
+			atomic.AddInt32(axfr, 1)
+		}
+		ch, err := z.Transfer(serial)
+		if err != nil {
+			t.Errorf("Expected no error, got %s", err)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for rrs := range ch {
+			m.Answer = append(m.Answer, rrs...)
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestTransferInIncremental(t *testing.T) {
+	p, rm := updateZone(t)
+	defer rm()
+
+	ixfr, axfr := int32(0), int32(0)
+	s := dnstest.NewServer(primary(t, p, &ixfr, &axfr))
+	defer s.Close()
+
+	z, err := Parse(strings.NewReader(dbUpdateExampleOrg), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.TransferFrom = []string{s.Addr}
+	z.IXFR = true
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")})
+	m.Remove([]dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")})
+	update(t, p, m)
+
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if n := atomic.LoadInt32(&ixfr); n != 1 {
+		t.Errorf("Expected an IXFR, got %d", n)
+	}
+	if serial := z.SOASerialIfDefined(); serial != 1282630058 {
+		t.Errorf("Expected serial %d, got %d", 1282630058, serial)
+	}
+	if d := diffRRsets(p.rrsets(), z.rrsets()); len(d.del) != 0 || len(d.add) != 0 {
+		t.Errorf("Expected the same zone as the primary, got differences %v and %v", d.del, d.add)
+	}
+	// The changes can be transferred on from here.
+	if n := len(z.incremental(1282630057, 1282630058)); n != 1 {
+		t.Errorf("Expected %d change, got %d", 1, n)
+	}
+
+	// Up to date.
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// When the primary doesn't know the serial, it sends the entire zone in reply to the IXFR, this is used
+	// without asking for an AXFR.
+	z.Apex.SOA.Serial = 1
+	z.diffs = nil
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if n := atomic.LoadInt32(&axfr); n != 0 {
+		t.Errorf("Expected no AXFR, got %d", n)
+	}
+	if serial := z.SOASerialIfDefined(); serial != 1282630058 {
+		t.Errorf("Expected serial %d, got %d", 1282630058, serial)
+	}
+	if d := diffRRsets(p.rrsets(), z.rrsets()); len(d.del) != 0 || len(d.add) != 0 {
+		t.Errorf("Expected the same zone as the primary, got differences %v and %v", d.del, d.add)
+	}
+}
+
+func TestSplitDiffs(t *testing.T) {
+	soa1, soa2 := soaDiff(1, 2).del[0], soaDiff(1, 2).add[0]
+	a := test.A("a.example.org. 3600 IN A 127.0.0.1")
+
+	tests := []struct {
+		rrs   []dns.RR
+		diffs int
+		err   bool
+	}{
+		{[]dns.RR{soa1, a, soa2, a}, 1, false},
+		{[]dns.RR{soa1, soa2, soa2, a, soa1}, 2, false},
+		{[]dns.RR{a, soa1, soa2}, 0, true},
+		{[]dns.RR{soa1, a}, 0, true},
+	}
+	for i, tc := range tests {
+		diffs, err := splitDiffs(tc.rrs)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if len(diffs) != tc.diffs {
+			t.Errorf("Test %d: expected %d changes, got %d", i, tc.diffs, len(diffs))
+		}
+	}
+}
+
+func TestTransferInNoDiffs(t *testing.T) {
+	p, rm := updateZone(t)
+	defer rm()
+
+	ixfr, axfr := int32(0), int32(0)
+	s := dnstest.NewServer(primary(t, p, &ixfr, &axfr))
+	defer s.Close()
+
+	// Without IXFR or a journal the changes aren't kept.
+	z := NewZone("example.org.", "stdin")
+	z.TransferFrom = []string{s.Addr}
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if n := atomic.LoadInt32(&axfr); n != 1 {
+		t.Errorf("Expected an AXFR, got %d", n)
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")})
+	update(t, p, m)
+
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if serial := z.SOASerialIfDefined(); serial != 1282630058 {
+		t.Errorf("Expected serial %d, got %d", 1282630058, serial)
+	}
+	if n := len(z.diffs); n != 0 {
+		t.Errorf("Expected no changes to be kept, got %d", n)
+	}
+}
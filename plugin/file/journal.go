@@ -0,0 +1,277 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// The journal of a zone holds the changes made to it, so these survive a restart: dynamic updates are replayed
+// from it when the zone file couldn't be written, and the changes can be used for IXFR. It's stored next to the
+// zone file, with a ".jnl" extension. Each change is
+// written as the deleted records, one per line and prefixed with "-", followed by the added records,
+// prefixed with "+". Like in an IXFR both start with a SOA record: the old and the new one.
+
+// diff is a change to a zone. The first record of del is the old SOA, the first of add the new one.
+type diff struct {
+	del []dns.RR
+	add []dns.RR
+}
+
+func (d diff) serials() (uint32, uint32) {
+	return d.del[0].(*dns.SOA).Serial, d.add[0].(*dns.SOA).Serial
+}
+
+// journalFile returns the path of the journal of z.
+func (z *Zone) journalFile() string { return z.File() + ".jnl" }
+
+// journal appends d to the journal of z, and compacts the journal when it grows too large.
+func (z *Zone) journal(d diff) error {
+	f, err := os.OpenFile(z.journalFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(d.text()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	z.journalLen++
+	if z.journalLen <= 2*maxJournal {
+		return nil
+	}
+	if err := z.compactJournal(); err != nil {
+		log.Warningf("Failed to compact journal of %s: %s", z.origin, err)
+	}
+	return nil
+}
+
+// compactJournal rewrites the journal of z with only the last maxJournal changes.
+func (z *Zone) compactJournal() error {
+	diffs, err := z.readJournal()
+	if err != nil {
+		return err
+	}
+	if len(diffs) > maxJournal {
+		diffs = diffs[len(diffs)-maxJournal:]
+	}
+	buf := &bytes.Buffer{}
+	for _, d := range diffs {
+		buf.Write(d.text())
+	}
+	if err := writeFileAtomic(z.journalFile(), buf.Bytes()); err != nil {
+		return err
+	}
+	z.journalLen = len(diffs)
+	return nil
+}
+
+func (d diff) text() []byte {
+	buf := &bytes.Buffer{}
+	for _, rr := range d.del {
+		fmt.Fprintf(buf, "-%s\n", rr)
+	}
+	for _, rr := range d.add {
+		fmt.Fprintf(buf, "+%s\n", rr)
+	}
+	return buf.Bytes()
+}
+
+// readJournal reads the journal of z. A missing journal is an empty one.
+func (z *Zone) readJournal() ([]diff, error) {
+	f, err := os.Open(z.journalFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseJournal(f)
+}
+
+// parseJournal parses the changes in the journal in r.
+func parseJournal(r io.Reader) ([]diff, error) {
+	diffs := []diff{}
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rr, err := dns.NewRR(line[1:])
+		if err != nil || rr == nil {
+			return nil, fmt.Errorf("malformed record on line %d: %s", i, line)
+		}
+		_, soa := rr.(*dns.SOA)
+		last := len(diffs) - 1
+
+		switch {
+		case line[0] == '-' && soa:
+			diffs = append(diffs, diff{del: []dns.RR{rr}})
+		case line[0] == '-' && last >= 0 && len(diffs[last].add) == 0:
+			diffs[last].del = append(diffs[last].del, rr)
+		case line[0] == '+' && last >= 0 && (soa == (len(diffs[last].add) == 0)):
+			diffs[last].add = append(diffs[last].add, rr)
+		default:
+			return nil, fmt.Errorf("unexpected record on line %d: %s", i, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(diffs) > 0 && len(diffs[len(diffs)-1].add) == 0 {
+		return nil, fmt.Errorf("truncated journal")
+	}
+	return diffs, nil
+}
+
+// replayJournal applies the changes in the journal of z that follow on from the SOA serial of z. These are the
+// changes that didn't make it to the zone file. It returns the number of changes applied.
+func (z *Zone) replayJournal() (int, error) {
+	diffs, err := z.readJournal()
+	if err != nil {
+		return 0, err
+	}
+	z.journalLen = len(diffs)
+
+	s := z.rrsets()
+	soa := s.soa(z.origin)
+	if soa == nil {
+		return 0, nil
+	}
+	serial := soa.Serial
+	n := 0
+	for _, d := range diffs {
+		from, to := d.serials()
+		if from != serial {
+			continue
+		}
+		s.apply(z.origin, d)
+		serial = to
+		n++
+	}
+	var z1 *Zone
+	if n > 0 {
+		if z1, err = s.zone(z.origin, z.File()); err != nil {
+			return 0, err
+		}
+	}
+
+	z.Lock()
+	if z1 != nil {
+		z.Apex = z1.Apex
+		z.Tree = z1.Tree
+	}
+	// Keep the changes for IXFR.
+	for _, d := range diffs {
+		z.addDiff(d)
+	}
+	z.Unlock()
+	return n, nil
+}
+
+// apply applies the change d to s.
+func (s rrsets) apply(origin string, d diff) {
+	for _, rr := range d.del[1:] {
+		s.remove(rr)
+	}
+	for _, rr := range d.add[1:] {
+		s.add(rr)
+	}
+	s[origin][dns.TypeSOA] = []dns.RR{d.add[0]}
+}
+
+// remove removes rr from s.
+func (s rrsets) remove(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	t := rr.Header().Rrtype
+	rrs := s[name][t]
+	for i := range rrs {
+		if dns.IsDuplicate(rrs[i], rr) {
+			s[name][t] = append(rrs[:i:i], rrs[i+1:]...)
+			break
+		}
+	}
+	if len(s[name][t]) == 0 {
+		delete(s[name], t)
+	}
+	if len(s[name]) == 0 {
+		delete(s, name)
+	}
+}
+
+// writeFile writes the records of z to its zone file. Comments, formatting and $INCLUDEs of the original file
+// are lost.
+func (z *Zone) writeFile() error {
+	buf := &bytes.Buffer{}
+	z.RLock()
+	if z.Apex.SOA != nil {
+		fmt.Fprintln(buf, z.Apex.SOA)
+	}
+	for _, rrs := range [][]dns.RR{z.Apex.SIGSOA, z.Apex.NS, z.Apex.SIGNS} {
+		for _, rr := range rrs {
+			fmt.Fprintln(buf, rr)
+		}
+	}
+	for _, t := range []*tree.Tree{z.Tree, z.Apex.NSEC3} {
+		if t == nil {
+			continue
+		}
+		t.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error {
+			for _, rr := range e.All() {
+				fmt.Fprintln(buf, rr)
+			}
+			return nil
+		})
+	}
+	z.RUnlock()
+
+	return writeFileAtomic(z.File(), buf.Bytes())
+}
+
+// writeFileAtomic writes data to a temporary file, which is then renamed to file. The mode of an existing file
+// is kept.
+func writeFileAtomic(file string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(file); err == nil {
+		mode = fi.Mode()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails with not exist when the rename succeeded.
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// maxJournal is the number of changes kept in a journal when it is compacted.
+const maxJournal = 100
@@ -139,6 +139,10 @@ func (z *Zone) Lookup(ctx context.Context, state request.Request, qname string)
 			if do {
 				dss := typeFromElem(elem, dns.TypeDS, do)
 				nsrrs = append(nsrrs, dss...)
+				// An unsigned delegation needs to be proven to be insecure.
+				if len(dss) == 0 && ap.NSEC3 != nil {
+					nsrrs = append(nsrrs, nsec3NoData(ap.NSEC3, z.origin, elem.Name())...)
+				}
 			}
 
 			return nil, nsrrs, glue, Delegation
@@ -165,8 +169,12 @@ func (z *Zone) Lookup(ctx context.Context, state request.Request, qname string)
 		if len(rrs) == 0 {
 			ret := ap.soa(do)
 			if do {
-				nsec := typeFromElem(elem, dns.TypeNSEC, do)
-				ret = append(ret, nsec...)
+				if ap.NSEC3 != nil {
+					ret = append(ret, nsec3NoData(ap.NSEC3, z.origin, qname)...)
+				} else {
+					nsec := typeFromElem(elem, dns.TypeNSEC, do)
+					ret = append(ret, nsec...)
+				}
 			}
 			return nil, ret, nil, NoData
 		}
@@ -201,15 +209,21 @@ func (z *Zone) Lookup(ctx context.Context, state request.Request, qname string)
 		if len(rrs) == 0 {
 			ret := ap.soa(do)
 			if do {
-				nsec := typeFromElem(wildElem, dns.TypeNSEC, do)
-				ret = append(ret, nsec...)
+				if ap.NSEC3 != nil {
+					ret = append(ret, nsec3WildcardNoData(ap.NSEC3, z.origin, qname, wildElem.Name())...)
+				} else {
+					nsec := typeFromElem(wildElem, dns.TypeNSEC, do)
+					ret = append(ret, nsec...)
+				}
 			}
 			return nil, ret, nil, Success
 		}
 
 		if do {
 			// An NSEC is needed to say no longer name exists under this wildcard.
-			if deny, found := tr.Prev(qname); found {
+			if ap.NSEC3 != nil {
+				auth = append(auth, nsec3Wildcard(ap.NSEC3, z.origin, qname, wildElem.Name())...)
+			} else if deny, found := tr.Prev(qname); found {
 				nsec := typeFromElem(deny, dns.TypeNSEC, do)
 				auth = append(auth, nsec...)
 			}
@@ -233,6 +247,14 @@ func (z *Zone) Lookup(ctx context.Context, state request.Request, qname string)
 	}
 
 	ret := ap.soa(do)
+	if do && ap.NSEC3 != nil {
+		if rcode == NameError {
+			ret = append(ret, nsec3Denial(ap.NSEC3, z.origin, qname)...)
+		} else {
+			ret = append(ret, nsec3NoData(ap.NSEC3, z.origin, qname)...)
+		}
+		goto Out
+	}
 	if do {
 		deny, found := tr.Prev(qname)
 		if !found {
@@ -0,0 +1,18 @@
+package file
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Variables declared for monitoring.
+var (
+	ReloadRejectedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "file",
+		Name:      "reload_rejected_total",
+		Help:      "Counter of zone reloads rejected because of problems in the zone.",
+	}, []string{"zone"})
+)
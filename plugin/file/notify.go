@@ -2,6 +2,7 @@ package file
 
 import (
 	"net"
+	"strings"
 
 	"github.com/coredns/coredns/request"
 
@@ -10,7 +11,8 @@ import (
 
 // isNotify checks if state is a notify message and if so, will *also* check if it
 // is from one of the configured masters. If not it will not be a valid notify
-// message. If the zone z is not a secondary zone the message will also be ignored.
+// message. If the zone z is not a secondary zone the message will also be ignored. When z has a TSIG key,
+// the notify must be signed with it.
 func (z *Zone) isNotify(state request.Request) bool {
 	if state.Req.Opcode != dns.OpcodeNotify {
 		return false
@@ -18,6 +20,12 @@ func (z *Zone) isNotify(state request.Request) bool {
 	if len(z.TransferFrom) == 0 {
 		return false
 	}
+	if z.TsigKey != "" {
+		t := state.Req.IsTsig()
+		if t == nil || !strings.EqualFold(t.Hdr.Name, z.TsigKey) || state.W.TsigStatus() != nil {
+			return false
+		}
+	}
 	// If remote IP matches we accept.
 	remote := state.IP()
 	for _, f := range z.TransferFrom {
@@ -0,0 +1,138 @@
+package file
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// The NSEC3 records (and their signatures) of a zone are not stored in the zone's tree, but in a tree of their own,
+// Apex.NSEC3. All owner names in there are a single hashed label under the origin, so the tree's ordering is the
+// hash ordering (RFC 5155, Section 3.1.7), and covering records can be found with tree.Prev. As the hashed owner
+// names don't exist in the tree of the zone, queries for them return NXDOMAIN (RFC 5155, Section 7.2.8).
+
+// nsec3Hash returns the hashed owner name of name, using the parameters of the NSEC3 records in chain.
+func nsec3Hash(chain *tree.Tree, origin, name string) string {
+	min := chain.Min()
+	if min == nil {
+		return ""
+	}
+	rrs := min.Type(dns.TypeNSEC3)
+	if len(rrs) == 0 {
+		return ""
+	}
+	n := rrs[0].(*dns.NSEC3)
+	return strings.ToLower(dns.HashName(name, n.Hash, n.Iterations, n.Salt)) + "." + origin
+}
+
+// nsec3Match returns the NSEC3 record, with its signatures, that matches name. If there is no such record,
+// false is returned.
+func nsec3Match(chain *tree.Tree, origin, name string) ([]dns.RR, bool) {
+	elem, found := chain.Search(nsec3Hash(chain, origin, name))
+	if !found {
+		return nil, false
+	}
+	return append([]dns.RR(nil), typeFromElem(elem, dns.TypeNSEC3, true)...), true
+}
+
+// nsec3Cover returns the NSEC3 record, with its signatures, that covers name. If name is matched this
+// record is returned.
+func nsec3Cover(chain *tree.Tree, origin, name string) []dns.RR {
+	elem, found := chain.Prev(nsec3Hash(chain, origin, name))
+	if !found {
+		// The hash sorts before the first record in the chain, it is covered by the last one.
+		elem = chain.Max()
+	}
+	if elem == nil {
+		return nil
+	}
+	return append([]dns.RR(nil), typeFromElem(elem, dns.TypeNSEC3, true)...)
+}
+
+// closestEncloserProof returns the closest (provable) encloser proof for qname (RFC 5155, Section 7.2.1): the NSEC3
+// record matching the closest encloser of qname and the NSEC3 record covering the next closer name. The closest
+// encloser is returned as well. Because the closest provable encloser is the first ancestor of qname with a matching
+// NSEC3, this also works for names below opt-out spans and empty non-terminals.
+func closestEncloserProof(chain *tree.Tree, origin, qname string) ([]dns.RR, string) {
+	next := qname
+	for ce := qname; dns.IsSubDomain(origin, ce); {
+		off, end := dns.NextLabel(ce, 0)
+		if end {
+			break
+		}
+		next, ce = ce, ce[off:]
+		if ce == "" {
+			ce = "."
+		}
+		if match, ok := nsec3Match(chain, origin, ce); ok {
+			return append(match, nsec3Cover(chain, origin, next)...), ce
+		}
+	}
+	return nil, ""
+}
+
+// nextCloser returns the next closer name of qname for closest encloser ce: the name one label longer than ce.
+func nextCloser(qname, ce string) string {
+	labels := dns.CountLabel(qname) - dns.CountLabel(ce) - 1
+	off, _ := dns.NextLabel(qname, 0)
+	for i := 0; i < labels; i++ {
+		qname = qname[off:]
+		off, _ = dns.NextLabel(qname, 0)
+	}
+	return qname
+}
+
+// nsec3Denial returns the NSEC3 records to deny the existence of qname (RFC 5155, Section 7.2.2): the closest encloser
+// proof and the NSEC3 record covering the wildcard at the closest encloser.
+func nsec3Denial(chain *tree.Tree, origin, qname string) []dns.RR {
+	proof, ce := closestEncloserProof(chain, origin, qname)
+	if ce == "" {
+		return nil
+	}
+	wildcard := nsec3Cover(chain, origin, "*."+ce)
+	return dedupNSEC3(append(proof, wildcard...))
+}
+
+// nsec3NoData returns the NSEC3 records proving no data of type qtype exists for qname (RFC 5155, Sections 7.2.3
+// and 7.2.4). If qname has no matching NSEC3 record, i.e. it's an empty non-terminal or a delegation in an opt-out
+// span, the closest provable encloser proof is returned.
+func nsec3NoData(chain *tree.Tree, origin, qname string) []dns.RR {
+	if match, ok := nsec3Match(chain, origin, qname); ok {
+		return match
+	}
+	proof, _ := closestEncloserProof(chain, origin, qname)
+	return dedupNSEC3(proof)
+}
+
+// nsec3WildcardNoData returns the NSEC3 records proving no data of type qtype exists for qname, that is matched by
+// wildcard (RFC 5155, Section 7.2.5).
+func nsec3WildcardNoData(chain *tree.Tree, origin, qname, wildcard string) []dns.RR {
+	proof, _ := closestEncloserProof(chain, origin, qname)
+	match, _ := nsec3Match(chain, origin, wildcard)
+	return dedupNSEC3(append(proof, match...))
+}
+
+// nsec3Wildcard returns the NSEC3 record proving qname doesn't exist, for an answer synthesized from wildcard
+// (RFC 5155, Section 7.2.6).
+func nsec3Wildcard(chain *tree.Tree, origin, qname, wildcard string) []dns.RR {
+	ce := wildcard[2:] // strip "*."
+	return nsec3Cover(chain, origin, nextCloser(qname, ce))
+}
+
+// dedupNSEC3 removes the duplicate NSEC3 records, and their signatures, from rrs.
+func dedupNSEC3(rrs []dns.RR) []dns.RR {
+	seen := make(map[string]struct{})
+	j := 0
+	for i, rr := range rrs {
+		key := rr.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		rrs[j] = rrs[i]
+		j++
+	}
+	return rrs[:j]
+}
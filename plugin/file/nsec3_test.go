@@ -1,21 +1,157 @@
 package file
 
 import (
+	"context"
 	"strings"
 	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
 )
 
 func TestParseNSEC3PARAM(t *testing.T) {
-	_, err := Parse(strings.NewReader(nsec3paramTest), "miek.nl", "stdin", 0)
-	if err == nil {
-		t.Fatalf("Expected error when reading zone, got nothing")
+	z, err := Parse(strings.NewReader(nsec3paramTest), "miek.nl", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	apex, _ := z.Search("miek.nl.")
+	if x := apex.Type(dns.TypeNSEC3PARAM); len(x) != 1 {
+		t.Errorf("Expected %d NSEC3PARAM record, got %d", 1, len(x))
 	}
 }
 
 func TestParseNSEC3(t *testing.T) {
-	_, err := Parse(strings.NewReader(nsec3Test), "miek.nl", "stdin", 0)
-	if err == nil {
-		t.Fatalf("Expected error when reading zone, got nothing")
+	z, err := Parse(strings.NewReader(nsec3Test), "example.org", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	// NSEC3 records are kept out of the zone's tree.
+	if _, found := z.Search("aub8v9ce95ie18spjubsr058h41n7pa5.example.org."); found {
+		t.Errorf("Expected NSEC3 owner name not to be found in the zone")
+	}
+	if z.Apex.NSEC3 == nil {
+		t.Fatalf("Expected NSEC3 records, got none")
+	}
+	e, found := z.Apex.NSEC3.Search("aub8v9ce95ie18spjubsr058h41n7pa5.example.org.")
+	if !found {
+		t.Fatalf("Expected NSEC3 owner name to be found in the NSEC3 records")
+	}
+	if x := len(e.All()); x != 2 {
+		t.Errorf("Expected %d records (NSEC3 and RRSIG), got %d", 2, x)
+	}
+}
+
+// nsec3Proof is an NSEC3 record that must be present in the authority section of a response.
+type nsec3Proof struct {
+	name  string
+	cover bool // the record must cover name, instead of matching it
+}
+
+var nsec3TestCases = []struct {
+	test.Case
+	proofs []nsec3Proof
+}{
+	{ // NXDOMAIN
+		test.Case{Qname: "b.example.org.", Qtype: dns.TypeA, Do: true, Rcode: dns.RcodeNameError},
+		[]nsec3Proof{{"example.org.", false}, {"b.example.org.", true}, {"*.example.org.", true}},
+	},
+	{ // NXDOMAIN below an empty non-terminal
+		test.Case{Qname: "a.b.ent.example.org.", Qtype: dns.TypeA, Do: true, Rcode: dns.RcodeNameError},
+		[]nsec3Proof{{"ent.example.org.", false}, {"b.ent.example.org.", true}, {"*.ent.example.org.", true}},
+	},
+	{ // NODATA
+		test.Case{Qname: "a.example.org.", Qtype: dns.TypeAAAA, Do: true},
+		[]nsec3Proof{{"a.example.org.", false}},
+	},
+	{ // NODATA for an empty non-terminal
+		test.Case{Qname: "ent.example.org.", Qtype: dns.TypeA, Do: true},
+		[]nsec3Proof{{"ent.example.org.", false}},
+	},
+	{ // Wildcard answer, no closer name exists
+		test.Case{Qname: "x.w.example.org.", Qtype: dns.TypeA, Do: true},
+		[]nsec3Proof{{"x.w.example.org.", true}},
+	},
+	{ // Wildcard NODATA
+		test.Case{Qname: "x.w.example.org.", Qtype: dns.TypeAAAA, Do: true},
+		[]nsec3Proof{{"w.example.org.", false}, {"x.w.example.org.", true}, {"*.w.example.org.", false}},
+	},
+	{ // Secure delegation, the DS record is enough
+		test.Case{Qname: "www.secure.example.org.", Qtype: dns.TypeA, Do: true},
+		nil,
+	},
+	{ // Insecure delegation in an opt-out span
+		test.Case{Qname: "www.insecure.example.org.", Qtype: dns.TypeA, Do: true},
+		[]nsec3Proof{{"example.org.", false}, {"insecure.example.org.", true}},
+	},
+	{ // DS query for an insecure delegation in an opt-out span
+		test.Case{Qname: "insecure.example.org.", Qtype: dns.TypeDS, Do: true},
+		[]nsec3Proof{{"example.org.", false}, {"insecure.example.org.", true}},
+	},
+	{ // DS query for a secure delegation
+		test.Case{Qname: "secure.example.org.", Qtype: dns.TypeDS, Do: true},
+		nil,
+	},
+	{ // NSEC3 owner names don't exist
+		test.Case{Qname: "5vqm4iqg11nec1vv12hp2aonvg05a83i.example.org.", Qtype: dns.TypeNSEC3, Do: true, Rcode: dns.RcodeNameError},
+		[]nsec3Proof{{"example.org.", false}, {"5vqm4iqg11nec1vv12hp2aonvg05a83i.example.org.", true}, {"*.example.org.", true}},
+	},
+	{ // No DO, no proofs
+		test.Case{Qname: "b.example.org.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError},
+		nil,
+	},
+}
+
+func TestLookupNSEC3(t *testing.T) {
+	zone, err := Parse(strings.NewReader(dbExampleOrgNSEC3), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	fm := File{Next: test.ErrorHandler(), Zones: Zones{Z: map[string]*Zone{"example.org.": zone}, Names: []string{"example.org."}}}
+
+	for i, tc := range nsec3TestCases {
+		m := tc.Msg()
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := fm.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		resp := rec.Msg
+		if resp.Rcode != tc.Rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.Rcode], dns.RcodeToString[resp.Rcode])
+		}
+
+		nsec3s := []*dns.NSEC3{}
+		sigs := 0
+		for _, rr := range resp.Ns {
+			switch x := rr.(type) {
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, x)
+			case *dns.RRSIG:
+				if x.TypeCovered == dns.TypeNSEC3 {
+					sigs++
+				}
+			}
+		}
+		// A single record may cover and match multiple names.
+		if len(nsec3s) > len(tc.proofs) || (len(nsec3s) == 0) != (len(tc.proofs) == 0) {
+			t.Errorf("Test %d: expected at most %d NSEC3 records, got %d", i, len(tc.proofs), len(nsec3s))
+		}
+		if sigs != len(nsec3s) {
+			t.Errorf("Test %d: expected %d NSEC3 signatures, got %d", i, len(nsec3s), sigs)
+		}
+		for _, p := range tc.proofs {
+			ok := false
+			for _, n := range nsec3s {
+				if (p.cover && n.Cover(p.name)) || (!p.cover && n.Match(p.name)) {
+					ok = true
+				}
+			}
+			if !ok {
+				t.Errorf("Test %d: expected an NSEC3 record matching or covering %s (cover: %t)", i, p.name, p.cover)
+			}
+		}
 	}
 }
 
@@ -26,3 +162,50 @@ miek.nl.		0	IN	NSEC3PARAM 1 0 5 A3DEBC9CC4F695C7`
 const nsec3Test = `example.org.		1800	IN	SOA	sns.dns.icann.org. noc.dns.icann.org. 2016082508 7200 3600 1209600 3600
 aub8v9ce95ie18spjubsr058h41n7pa5.example.org. 284 IN NSEC3 1 1 5 D0CBEAAF0AC77314 AUB95P93VPKP55G6U5S4SGS7LS61ND85 NS SOA TXT RRSIG DNSKEY NSEC3PARAM
 aub8v9ce95ie18spjubsr058h41n7pa5.example.org. 284 IN RRSIG NSEC3 8 2 600 20160910232502 20160827231002 14028 example.org. XBNpA7KAIjorPbXvTinOHrc1f630aHic2U716GHLHA4QMx9cl9ss4QjR Wj2UpDM9zBW/jNYb1xb0yjQoez/Jv200w0taSWjRci5aUnRpOi9bmcrz STHb6wIUjUsbJ+NstQsUwVkj6679UviF1FqNwr4GlJnWG3ZrhYhE+NI6 s0k=`
+
+// dbExampleOrgNSEC3 is signed with the sign plugin with "nsec3 optout": ent.example.org. and w.example.org. are
+// empty non-terminals, insecure.example.org. is in an opt-out span.
+const dbExampleOrgNSEC3 = `
+example.org.	1800	IN	SOA	ns.example.org. hostmaster.example.org. 1601510400 14400 3600 604800 14400
+example.org.	1800	IN	RRSIG	SOA 13 2 1800 20201102172640 20200930161558 59725 example.org. Nwyz4ozkN9g2LPKraIyQAUIYhRyDVmJA8awwSzgFZtoZUSaf3AaWBqyR53dTBTN/56Vk7NgHV4QZqHl8A3ZWhA==
+example.org.	1800	IN	NS	ns.example.org.
+example.org.	1800	IN	RRSIG	NS 13 2 1800 20201102172640 20200930161558 59725 example.org. qQVi526mMbV887/71aGfDcjQmGnZoP8hlAkJi6p7lYH0D/xOuzIFKXNfMO7YYMDWpyzSUsXzr75DASTvBtVJwg==
+example.org.	1800	IN	DNSKEY	257 3 13 sfzRg5nDVxbeUc51su4MzjgwpOpUwnuu81SlRHqJuXe3SOYOeypR69tZ52XLmE56TAmPHsiB8Rgk+NTpf0o1Cw==
+example.org.	1800	IN	CDS	59725 13 1 F7593F55AF2272A23AA2D9E459803805AC8DB2D6
+example.org.	1800	IN	CDS	59725 13 2 7364624A4CD276977E13DAF561C5766692CEF98EF54FE2BD308A47EDE4481EBC
+example.org.	1800	IN	CDNSKEY	257 3 13 sfzRg5nDVxbeUc51su4MzjgwpOpUwnuu81SlRHqJuXe3SOYOeypR69tZ52XLmE56TAmPHsiB8Rgk+NTpf0o1Cw==
+example.org.	0	IN	NSEC3PARAM	1 0 0 -
+example.org.	1800	IN	RRSIG	CDS 13 2 1800 20201102172640 20200930161558 59725 example.org. eEbqERUbYh0WNXNcEMFZmtDL9sD3BfTrzav4ltUBVH39UUpFwgDPMIZ/bw9e5vpSYNB7bFzgIw7JR2llQ3VybA==
+example.org.	1800	IN	RRSIG	CDNSKEY 13 2 1800 20201102172640 20200930161558 59725 example.org. zqy5gzFri08Y8pHLWp+M5kU16Xn9HKrPNsY2K34g04BD+p7qfYDwiJ1Db1F/mdiLNcgVmTfEtWT2ExiOiZP3kw==
+example.org.	0	IN	RRSIG	NSEC3PARAM 13 2 0 20201102172640 20200930161558 59725 example.org. kX2GpvI997o/O4udSinnY2ggg5JTuHUWgqmjqJF2l1BPavYv5rRJrK7q+4wCzWMRrfgwFUm5paZW4B19C4WmcQ==
+example.org.	1800	IN	RRSIG	DNSKEY 13 2 1800 20201102172640 20200930161558 59725 example.org. MciStnbk343/Gis+uO1moag+sF2fmTJoV4iYsuunvbEVCkWKlGNsqwhqqrWtWu/d7Wmp3j7fOdXxZIYz02QYhA==
+a.example.org.	1800	IN	RRSIG	A 13 3 1800 20201102172640 20200930161558 59725 example.org. s7ZN+wOHNJ/SxfIftG+gyxgib60RPt3P/aclI9IkyMrZWn/ZamRzikEJWCRHIa2YrMdXUyOk8OGwxJhYizDn3A==
+a.example.org.	1800	IN	A	127.0.0.1
+host.ent.example.org.	1800	IN	AAAA	::1
+host.ent.example.org.	1800	IN	RRSIG	AAAA 13 4 1800 20201102172640 20200930161558 59725 example.org. iETHKcsZv/1wxdj9YpLcgKq2//MIDfv6a1RhWMesy5sGhD+5xWIjINla+MWg46eVaflskSp1sFp4Jbtr60YMXQ==
+insecure.example.org.	1800	IN	NS	ns.example.net.
+ns.example.org.	1800	IN	A	127.0.0.1
+ns.example.org.	1800	IN	RRSIG	A 13 3 1800 20201102172640 20200930161558 59725 example.org. q7jNMbn/rlqkI/ovyqYwK+yu+QCPg/nwaSZotMzgC3tZjxm7lZbEhFqIEH2S6DzhK3rguOuTNinNaOM++wbQgQ==
+secure.example.org.	1800	IN	NS	ns.secure.example.org.
+secure.example.org.	1800	IN	DS	34385 13 2 FC7397C77AFBCCB6742FCFF19C7B1410D0044661E7085FC200AE1AB3D15A5842
+secure.example.org.	1800	IN	RRSIG	DS 13 3 1800 20201102172640 20200930161558 59725 example.org. XOFkHqF8WYPzbTrkcfc9H8OQC9ihPn2Y164bDFJU87e0PooWFoWg8jDLxr9g1/yaqbhz3DKvnsVd2QfSNaTAkg==
+ns.secure.example.org.	1800	IN	A	127.0.0.3
+*.w.example.org.	1800	IN	A	127.0.0.2
+*.w.example.org.	1800	IN	RRSIG	A 13 3 1800 20201102172640 20200930161558 59725 example.org. wHfrcbnlBL4FE/kgdsvmkjUtlrZS8WI7/G2HXPeDhGssS+hiA+ErTeQRIbeTpsyEPb0xv7ZUPMtB3eUqExxs+A==
+5vqm4iqg11nec1vv12hp2aonvg05a83i.example.org.	14400	IN	NSEC3	1 1 0 - 6HSUDPCUGOVCSU6RIB34SA6RM87TQM57 A RRSIG
+5vqm4iqg11nec1vv12hp2aonvg05a83i.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. PfEKJhNYBw1tU3Z7gZr38s0Ex5JX95mT1r/tC5425UUqWpswQu+Y6GKZYJ4tyQRfpMCx7ggZv4HoQ56OildGzg==
+6hsudpcugovcsu6rib34sa6rm87tqm57.example.org.	14400	IN	NSEC3	1 1 0 - 8UM1KJCJMOFVVMQ7CB0OP7JT39LG8R9J A RRSIG
+6hsudpcugovcsu6rib34sa6rm87tqm57.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. VhvKk5E8px5EY9il/miH60Op/gPNpru3QhH1wyaVyVa+Q/QpkIlooQFgkrUCaC9nUc3FixkpsezRuevpGWvgJQ==
+8um1kjcjmofvvmq7cb0op7jt39lg8r9j.example.org.	14400	IN	NSEC3	1 1 0 - F6T3JR07GIMJ48DOOM86PRH9OB3J47J9 NS SOA RRSIG DNSKEY NSEC3PARAM CDS CDNSKEY
+8um1kjcjmofvvmq7cb0op7jt39lg8r9j.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. j8D8EDXvSBVCs6S6n3czmmabUbiArM2UT6Ks2TxrbVYvR1a2tkDdN6OtD4fbZAftJSw/vACSsgVZNaIEzMLPkA==
+f6t3jr07gimj48doom86prh9ob3j47j9.example.org.	14400	IN	NSEC3	1 1 0 - H0K0TC6LVJGBU028K6QCVDUJ3JT9URL5
+f6t3jr07gimj48doom86prh9ob3j47j9.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. GcFzoK8BXzdqLC9btH0b/4Hcz1PS/LF8V4SSX9nDmR4QAlICcBxOEMvzcQ3Rux/SOJld9+nSw8zbSE5UGewjSA==
+h0k0tc6lvjgbu028k6qcvduj3jt9url5.example.org.	14400	IN	NSEC3	1 1 0 - JRFH8DK3OOFI50C0CT4KAU7H45DL0K8C NS DS RRSIG
+h0k0tc6lvjgbu028k6qcvduj3jt9url5.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. u13CjzZaszi8tknE62bAR7KzZF7QO+uXAFhPfspjyLh4bLVfn6JounCmTsQoFQvfRilOutM6LU+f6/9735nC9Q==
+jrfh8dk3oofi50c0ct4kau7h45dl0k8c.example.org.	14400	IN	NSEC3	1 1 0 - JRR8KF1EO9JL3JV697CU0BD7SM2ROIAC
+jrfh8dk3oofi50c0ct4kau7h45dl0k8c.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. BFtSTR45FQ9QO3xumRdkfWAoA8hJLS/7Rb62Qy8kxPnCpF8n27AefBgEsMeMp5WTJtmyfvG+9yn4GI9cHtC4kA==
+jrr8kf1eo9jl3jv697cu0bd7sm2roiac.example.org.	14400	IN	NSEC3	1 1 0 - L9QCRTNKG05MBACGV440V6VLRI1DUP6M AAAA RRSIG
+jrr8kf1eo9jl3jv697cu0bd7sm2roiac.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. I2mCG4bZCtyCuAqTK4fNzrIy6Q0idAgvYXTUpjRuUbqLCfIFhZgaDtmdmIlpsh0Vkn3b648dtDCTUSCI4ed1OQ==
+l9qcrtnkg05mbacgv440v6vlri1dup6m.example.org.	14400	IN	NSEC3	1 1 0 - 5VQM4IQG11NEC1VV12HP2AONVG05A83I A RRSIG
+l9qcrtnkg05mbacgv440v6vlri1dup6m.example.org.	14400	IN	RRSIG	NSEC3 13 3 14400 20201102172640 20200930161558 59725 example.org. JdcBNBoJ70p5NhW5vcOUOZzxADO7FKIy2ptYpNjftFzbinXqRn7JasJpY3vJZUnnuwOXGactTLIXtfoIdGQhpw==
+`
@@ -18,35 +18,7 @@ func (z *Zone) Reload(t *transfer.Transfer) error {
 		for {
 			select {
 			case <-tick.C:
-				zFile := z.File()
-				reader, err := os.Open(zFile)
-				if err != nil {
-					log.Errorf("Failed to open zone %q in %q: %v", z.origin, zFile, err)
-					continue
-				}
-
-				serial := z.SOASerialIfDefined()
-				zone, err := Parse(reader, z.origin, zFile, serial)
-				reader.Close()
-				if err != nil {
-					if _, ok := err.(*serialErr); !ok {
-						log.Errorf("Parsing zone %q: %v", z.origin, err)
-					}
-					continue
-				}
-
-				// copy elements we need
-				z.Lock()
-				z.Apex = zone.Apex
-				z.Tree = zone.Tree
-				z.Unlock()
-
-				log.Infof("Successfully reloaded zone %q in %q with %d SOA serial", z.origin, zFile, z.Apex.SOA.Serial)
-				if t != nil {
-					if err := t.Notify(z.origin); err != nil {
-						log.Warningf("Failed sending notifies: %s", err)
-					}
-				}
+				z.ReloadFile(t)
 
 			case <-z.reloadShutdown:
 				tick.Stop()
@@ -57,6 +29,65 @@ func (z *Zone) Reload(t *transfer.Transfer) error {
 	return nil
 }
 
+// ReloadFile reads the zone's file and, if the SOA serial has changed, swaps in the new zone data. Notifies are
+// sent through t, when not nil. It returns true when the zone was reloaded.
+func (z *Zone) ReloadFile(t *transfer.Transfer) bool {
+	zFile := z.File()
+	reader, err := os.Open(zFile)
+	if err != nil {
+		log.Errorf("Failed to open zone %q in %q: %v", z.origin, zFile, err)
+		return false
+	}
+
+	serial := z.SOASerialIfDefined()
+	zone, err := z.parse(reader, serial)
+	reader.Close()
+	if err != nil {
+		if _, ok := err.(*serialErr); !ok {
+			log.Errorf("Parsing zone %q: %v", z.origin, err)
+		}
+		return false
+	}
+	if err := z.check(zone); err != nil {
+		log.Errorf("Not reloading zone %q: %s", z.origin, err)
+		ReloadRejectedCount.WithLabelValues(z.origin).Inc()
+		return false
+	}
+
+	// Keep the change for IXFR and the journal.
+	z.updateMu.Lock()
+	var (
+		d  diff
+		ok bool
+	)
+	if z.keepDiffs() {
+		d, ok = newDiff(z.origin, z.rrsets(), zone.rrsets())
+	}
+	if ok && z.Journal {
+		if err := z.journal(d); err != nil {
+			log.Warningf("Failed to journal reload of zone %q: %v", z.origin, err)
+		}
+	}
+
+	// copy elements we need
+	z.Lock()
+	z.Apex = zone.Apex
+	z.Tree = zone.Tree
+	if ok {
+		z.addDiff(d)
+	}
+	z.Unlock()
+	z.updateMu.Unlock()
+
+	log.Infof("Successfully reloaded zone %q in %q with %d SOA serial", z.origin, zFile, z.Apex.SOA.Serial)
+	if t != nil {
+		if err := t.Notify(z.origin); err != nil {
+			log.Warningf("Failed sending notifies: %s", err)
+		}
+	}
+	return true
+}
+
 // SOASerialIfDefined returns the SOA's serial if the zone has a SOA record in the Apex, or -1 otherwise.
 func (z *Zone) SOASerialIfDefined() int64 {
 	z.RLock()
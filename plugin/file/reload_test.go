@@ -78,6 +78,41 @@ func TestZoneReloadSOAChange(t *testing.T) {
 	}
 }
 
+func TestZoneReloadSnapshot(t *testing.T) {
+	fileName, rm, err := test.TempFile(".", string(snapshot(t, reloadZoneTest, "miek.nl.")))
+	if err != nil {
+		t.Fatalf("Failed to create zone: %s", err)
+	}
+	defer rm()
+	reader, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("Failed to open zone: %s", err)
+	}
+	z, err := ParseSnapshot(reader, "miek.nl.", fileName, 0)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse zone: %s", err)
+	}
+	z.Binary = true
+
+	if z.ReloadFile(nil) {
+		t.Errorf("Expected the zone not to be reloaded when the serial didn't change")
+	}
+	if err := ioutil.WriteFile(fileName, snapshot(t, reloadZone2Test, "miek.nl."), 0644); err != nil {
+		t.Fatalf("Failed to write new zone data: %s", err)
+	}
+	if !z.ReloadFile(nil) {
+		t.Fatalf("Expected the zone to be reloaded")
+	}
+	if x := z.SOASerialIfDefined(); x != 1460175182 {
+		t.Errorf("Expected serial %d, got %d", 1460175182, x)
+	}
+	// Without IXFR or a journal the change isn't kept.
+	if n := len(z.diffs); n != 0 {
+		t.Errorf("Expected no changes to be kept, got %d", n)
+	}
+}
+
 const reloadZoneTest = `miek.nl.		1627	IN	SOA	linode.atoom.net. miek.miek.nl. 1460175181 14400 3600 604800 14400
 miek.nl.		1627	IN	NS	ext.ns.whyscream.net.
 miek.nl.		1627	IN	NS	omval.tednet.nl.
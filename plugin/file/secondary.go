@@ -7,13 +7,43 @@ import (
 	"github.com/miekg/dns"
 )
 
-// TransferIn retrieves the zone from the masters, parses it and sets it live.
+// TransferIn retrieves the zone from the masters, parses it and sets it live. If we have the zone already an
+// IXFR is tried first, if the primary doesn't send the changes, but the entire zone, that is used. Otherwise
+// a full AXFR is done. OnTransfer, if set, is called when the zone is up to date.
 func (z *Zone) TransferIn() error {
+	if err := z.transferIn(); err != nil {
+		return err
+	}
+	if z.OnTransfer != nil && len(z.TransferFrom) > 0 {
+		z.OnTransfer()
+	}
+	return nil
+}
+
+func (z *Zone) transferIn() error {
 	if len(z.TransferFrom) == 0 {
 		return nil
 	}
-	m := new(dns.Msg)
-	m.SetAxfr(z.origin)
+	for _, tr := range z.TransferFrom {
+		ok, rrs, err := z.transferIncremental(tr)
+		if err != nil {
+			log.Warningf("Failed incremental transfer of `%s' from %q, falling back to AXFR: %v", z.origin, tr, err)
+			continue
+		}
+		if ok {
+			return nil
+		}
+		if rrs == nil {
+			break
+		}
+		// The primary sent the entire zone, there's no need to ask for it again (RFC 1995, section 4).
+		z1 := z.CopyWithoutApex()
+		if err := insert(z1, rrs); err != nil {
+			log.Errorf("Failed to parse transfer `%s' from: %q: %v", z.origin, tr, err)
+			break
+		}
+		return z.transferred(z1, tr)
+	}
 
 	z1 := z.CopyWithoutApex()
 	var (
@@ -23,7 +53,10 @@ func (z *Zone) TransferIn() error {
 
 Transfer:
 	for _, tr = range z.TransferFrom {
+		m := new(dns.Msg)
+		m.SetAxfr(z.origin)
 		t := new(dns.Transfer)
+		t.TsigSecret = z.sign(m)
 		c, err := t.In(m, tr)
 		if err != nil {
 			log.Errorf("Failed to setup transfer `%s' with `%q': %v", z.origin, tr, err)
@@ -36,12 +69,10 @@ Transfer:
 				Err = env.Error
 				continue Transfer
 			}
-			for _, rr := range env.RR {
-				if err := z1.Insert(rr); err != nil {
-					log.Errorf("Failed to parse transfer `%s' from: %q: %v", z.origin, tr, err)
-					Err = err
-					continue Transfer
-				}
+			if err := insert(z1, env.RR); err != nil {
+				log.Errorf("Failed to parse transfer `%s' from: %q: %v", z.origin, tr, err)
+				Err = err
+				continue Transfer
 			}
 		}
 		Err = nil
@@ -51,10 +82,42 @@ Transfer:
 		return Err
 	}
 
+	return z.transferred(z1, tr)
+}
+
+// insert inserts rrs into z.
+func insert(z *Zone, rrs []dns.RR) error {
+	for _, rr := range rrs {
+		if err := z.Insert(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferred sets z1, the zone transferred from tr, live. It returns an error when z1 is refused by the
+// zone checks.
+func (z *Zone) transferred(z1 *Zone, tr string) error {
+	if err := z.check(z1); err != nil {
+		return err
+	}
+
+	// Keep the change for IXFR.
+	var (
+		d  diff
+		ok bool
+	)
+	if z.keepDiffs() {
+		d, ok = newDiff(z.origin, z.rrsets(), z1.rrsets())
+	}
+
 	z.Lock()
 	z.Tree = z1.Tree
 	z.Apex = z1.Apex
 	z.Expired = false
+	if ok {
+		z.addDiff(d)
+	}
 	z.Unlock()
 	log.Infof("Transferred: %s from %s", z.origin, tr)
 	return nil
@@ -65,8 +128,6 @@ Transfer:
 func (z *Zone) shouldTransfer() (bool, error) {
 	c := new(dns.Client)
 	c.Net = "tcp" // do this query over TCP to minimize spoofing
-	m := new(dns.Msg)
-	m.SetQuestion(z.origin, dns.TypeSOA)
 
 	var Err error
 	serial := -1
@@ -74,6 +135,9 @@ func (z *Zone) shouldTransfer() (bool, error) {
 Transfer:
 	for _, tr := range z.TransferFrom {
 		Err = nil
+		m := new(dns.Msg)
+		m.SetQuestion(z.origin, dns.TypeSOA)
+		c.TsigSecret = z.sign(m)
 		ret, _, err := c.Exchange(m, tr)
 		if err != nil || ret.Rcode != dns.RcodeSuccess {
 			Err = err
@@ -89,10 +153,11 @@ Transfer:
 	if serial == -1 {
 		return false, Err
 	}
-	if z.Apex.SOA == nil {
+	soa := z.soa()
+	if soa == nil {
 		return true, Err
 	}
-	return less(z.Apex.SOA.Serial, uint32(serial)), Err
+	return less(soa.Serial, uint32(serial)), Err
 }
 
 // less returns true of a is smaller than b when taking RFC 1982 serial arithmetic into account.
@@ -103,21 +168,26 @@ func less(a, b uint32) bool {
 	return (a - b) > MaxSerialIncrement
 }
 
-// Update updates the secondary zone according to its SOA. It will run for the life time of the server
+// Update updates the secondary zone according to its SOA. It will run until StopUpdate is called
 // and uses the SOA parameters. Every refresh it will check for a new SOA number. If that fails (for all
 // server) it will retry every retry interval. If the zone failed to transfer before the expire, the zone
 // will be marked expired.
 func (z *Zone) Update() error {
 	// If we don't have a SOA, we don't have a zone, wait for it to appear.
-	for z.Apex.SOA == nil {
-		time.Sleep(1 * time.Second)
+	for z.soa() == nil {
+		select {
+		case <-z.updateShutdown:
+			return nil
+		case <-time.After(1 * time.Second):
+		}
 	}
 	retryActive := false
 
 Restart:
-	refresh := time.Second * time.Duration(z.Apex.SOA.Refresh)
-	retry := time.Second * time.Duration(z.Apex.SOA.Retry)
-	expire := time.Second * time.Duration(z.Apex.SOA.Expire)
+	soa := z.soa()
+	refresh := time.Second * time.Duration(soa.Refresh)
+	retry := time.Second * time.Duration(soa.Retry)
+	expire := time.Second * time.Duration(soa.Expire)
 
 	refreshTicker := time.NewTicker(refresh)
 	retryTicker := time.NewTicker(retry)
@@ -125,6 +195,12 @@ Restart:
 
 	for {
 		select {
+		case <-z.updateShutdown:
+			refreshTicker.Stop()
+			retryTicker.Stop()
+			expireTicker.Stop()
+			return nil
+
 		case <-expireTicker.C:
 			if !retryActive {
 				break
@@ -188,6 +264,13 @@ Restart:
 	}
 }
 
+// soa returns the SOA record of z.
+func (z *Zone) soa() *dns.SOA {
+	z.RLock()
+	defer z.RUnlock()
+	return z.Apex.SOA
+}
+
 // jitter returns a random duration between [0,n) * time.Millisecond
 func jitter(n int) time.Duration {
 	r := rand.Intn(n)
@@ -198,3 +281,13 @@ func jitter(n int) time.Duration {
 // MaxSerialIncrement is the maximum difference between two serial numbers. If the difference between
 // two serials is greater than this number, the smaller one is considered greater.
 const MaxSerialIncrement uint32 = 2147483647
+
+// sign adds a TSIG record to m when z has a TSIG key, and returns the secrets to sign m and verify the reply with.
+// Signing removes the TSIG record again, so m must be signed each time it is sent.
+func (z *Zone) sign(m *dns.Msg) map[string]string {
+	if z.TsigKey == "" {
+		return nil
+	}
+	m.SetTsig(z.TsigKey, z.TsigAlgorithm, 300, time.Now().Unix())
+	return map[string]string{z.TsigKey: z.TsigSecret}
+}
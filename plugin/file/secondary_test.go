@@ -2,7 +2,9 @@ package file
 
 import (
 	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
@@ -121,6 +123,59 @@ func TestTransferIn(t *testing.T) {
 	}
 }
 
+func TestTransferInTSIG(t *testing.T) {
+	const secret = "c2VjcmV0"
+	s := &soa{250}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{Listener: l, TsigSecret: map[string]string{"xfr.example.org.": secret}, NotifyStartedFunc: func() { close(started) }}
+	srv.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if t := r.IsTsig(); t == nil || w.TsigStatus() != nil {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+		s.Handler(&signedWriter{w, r.IsTsig()}, r)
+	})
+	go srv.ActivateAndServe()
+	<-started
+	defer srv.Shutdown()
+
+	z := new(Zone)
+	z.origin = testZone
+	z.TransferFrom = []string{l.Addr().String()}
+	if ok, _ := z.shouldTransfer(); ok {
+		t.Fatal("Expected the unsigned request to be refused")
+	}
+
+	z.TsigKey, z.TsigAlgorithm, z.TsigSecret = "xfr.example.org.", dns.HmacSHA256, secret
+	if err := z.TransferIn(); err != nil {
+		t.Fatalf("Unable to run TransferIn: %v", err)
+	}
+	if z.Apex.SOA == nil || z.Apex.SOA.Serial != 250 {
+		t.Fatalf("Expected SOA with serial 250, got %v", z.Apex.SOA)
+	}
+	z.Apex.SOA.Serial = 249
+	if ok, err := z.shouldTransfer(); !ok || err != nil {
+		t.Fatalf("Expected a transfer to be needed, got %t and %v", ok, err)
+	}
+}
+
+// signedWriter signs the responses written to it with the TSIG key of tsig.
+type signedWriter struct {
+	dns.ResponseWriter
+	tsig *dns.TSIG
+}
+
+func (w *signedWriter) WriteMsg(m *dns.Msg) error {
+	m.SetTsig(w.tsig.Hdr.Name, w.tsig.Algorithm, 300, time.Now().Unix())
+	return w.ResponseWriter.WriteMsg(m)
+}
+
 func TestIsNotify(t *testing.T) {
 	z := new(Zone)
 	z.origin = testZone
@@ -136,6 +191,21 @@ func TestIsNotify(t *testing.T) {
 	if z.isNotify(state) {
 		t.Fatal("Should have been invalid notify")
 	}
+
+	// With a TSIG key the notify must be signed with it.
+	z.TransferFrom = []string{"10.240.0.1:53"}
+	z.TsigKey = "xfr.example.org."
+	if z.isNotify(state) {
+		t.Fatal("Should have been invalid notify, without TSIG")
+	}
+	state.Req.SetTsig("xfr.example.org.", dns.HmacSHA256, 300, 0)
+	if !z.isNotify(state) {
+		t.Fatal("Should have been valid notify")
+	}
+	state.W = &test.ResponseWriter{TsigErr: dns.ErrSig}
+	if z.isNotify(state) {
+		t.Fatal("Should have been invalid notify, with a bad TSIG")
+	}
 }
 
 func newRequest(zone string, qtype uint16) request.Request {
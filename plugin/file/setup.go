@@ -1,6 +1,8 @@
 package file
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -8,6 +10,7 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/parse"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	"github.com/coredns/coredns/plugin/transfer"
 )
@@ -28,6 +31,9 @@ func setup(c *caddy.Controller) error {
 			return nil
 		}
 		f.transfer = t.(*transfer.Transfer) // if found this must be OK.
+		for _, n := range zones.Names {
+			zones.Z[n].IXFR = true
+		}
 		go func() {
 			for _, n := range zones.Names {
 				f.transfer.Notify(n)
@@ -93,6 +99,83 @@ func fileParse(c *caddy.Controller) (Zones, error) {
 			fileName = filepath.Join(config.Root, fileName)
 		}
 
+		var (
+			updateFrom []*net.IPNet
+			updateKeys map[string]string
+			journal    bool
+			binary     bool
+			check      = CheckWarn
+		)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "check":
+				if !c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+				level, ok := checkLevels[c.Val()]
+				if !ok {
+					return Zones{}, c.Errf("unknown check level '%s'", c.Val())
+				}
+				if c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+				check = level
+			case "format":
+				if !c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+				switch c.Val() {
+				case "text":
+					binary = false
+				case "binary":
+					binary = true
+				default:
+					return Zones{}, c.Errf("unknown zone file format '%s'", c.Val())
+				}
+				if c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+			case "reload":
+				d, err := time.ParseDuration(c.RemainingArgs()[0])
+				if err != nil {
+					return Zones{}, plugin.Error("file", err)
+				}
+				reload = d
+			case "upstream":
+				// remove soon
+				c.RemainingArgs()
+			case "journal":
+				if c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+				journal = true
+			case "update":
+				nets := c.RemainingArgs()
+				if len(nets) == 0 {
+					nets = []string{"127.0.0.0/8", "::1/128"}
+				}
+				for _, n := range nets {
+					_, ipnet, err := net.ParseCIDR(n)
+					if err != nil {
+						return Zones{}, err
+					}
+					updateFrom = append(updateFrom, ipnet)
+				}
+			case "update_key":
+				name, alg, secret, err := parse.TSIGKey(c)
+				if err != nil {
+					return Zones{}, err
+				}
+				if updateKeys == nil {
+					updateKeys = make(map[string]string)
+				}
+				updateKeys[name] = alg
+				config.AddTsigSecret(name, secret)
+			default:
+				return Zones{}, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+
 		reader, err := os.Open(fileName)
 		if err != nil {
 			openErr = err
@@ -101,32 +184,47 @@ func fileParse(c *caddy.Controller) (Zones, error) {
 		for i := range origins {
 			origins[i] = plugin.Host(origins[i]).Normalize()
 			z[origins[i]] = NewZone(origins[i], fileName)
+			z[origins[i]].Binary = binary
+			z[origins[i]].CheckLevel = check
 			if openErr == nil {
 				reader.Seek(0, 0)
-				zone, err := Parse(reader, origins[i], fileName, 0)
-				if err == nil {
-					z[origins[i]] = zone
-				} else {
+				zone, err := z[origins[i]].parse(reader, 0)
+				if err != nil {
+					return Zones{}, err
+				}
+				zone.Binary = binary
+				zone.CheckLevel = check
+				if err := zone.check(zone); err != nil {
 					return Zones{}, err
 				}
+				z[origins[i]] = zone
 			}
 			names = append(names, origins[i])
 		}
+		if reader != nil {
+			reader.Close()
+		}
 
-		for c.NextBlock() {
-			switch c.Val() {
-			case "reload":
-				d, err := time.ParseDuration(c.RemainingArgs()[0])
-				if err != nil {
-					return Zones{}, plugin.Error("file", err)
-				}
-				reload = d
-			case "upstream":
-				// remove soon
-				c.RemainingArgs()
-
-			default:
-				return Zones{}, c.Errf("unknown property '%s'", c.Val())
+		if updateFrom == nil && updateKeys == nil && !journal {
+			continue
+		}
+		// Updates are written back to the zone file and the journal is kept next to it, so it can't be shared.
+		if len(origins) > 1 {
+			return Zones{}, fmt.Errorf("dynamic updates and journals need a zone file per zone: %s", fileName)
+		}
+		if binary {
+			return Zones{}, fmt.Errorf("dynamic updates and journals need a zone file in text format: %s", fileName)
+		}
+		zone := z[origins[0]]
+		zone.UpdateFrom, zone.UpdateKeys = updateFrom, updateKeys
+		zone.Journal = true
+		if openErr == nil {
+			n, err := zone.replayJournal()
+			if err != nil {
+				return Zones{}, fmt.Errorf("failed to replay journal of %s: %s", origins[0], err)
+			}
+			if n > 0 {
+				log.Infof("Replayed %d changes from the journal of %s", n, origins[0])
 			}
 		}
 	}
@@ -122,3 +122,203 @@ func TestParseReload(t *testing.T) {
 		}
 	}
 }
+
+func TestParseUpdate(t *testing.T) {
+	name, rm, err := test.TempFile(".", dbMiekNL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		from      int
+		keys      int
+		journal   bool
+	}{
+		{`file ` + name + ` example.org.`, false, 0, 0, false},
+		{`file ` + name + ` example.org. {
+			journal
+		}`, false, 0, 0, true},
+		{`file ` + name + ` example.org. {
+			update
+		}`, false, 2, 0, true},
+		{`file ` + name + ` example.org. {
+			update 10.0.0.0/8
+			update_key dhcp hmac-sha256 c2VjcmV0
+		}`, false, 1, 1, true},
+		{`file ` + name + ` example.org. {
+			update_key dhcp. hmac-sha512. c2VjcmV0
+		}`, false, 0, 1, true},
+		// fails
+		{`file ` + name + ` example.org. {
+			update 10.0.0.0
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. {
+			update_key dhcp hmac-sha256
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. {
+			update_key dhcp hmac-foo c2VjcmV0
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. {
+			update_key dhcp hmac-sha256 !!!
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. example.net. {
+			update
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. example.net. {
+			journal
+		}`, true, 0, 0, false},
+		{`file ` + name + ` example.org. {
+			journal yes
+		}`, true, 0, 0, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		z, err := fileParse(c)
+		if err == nil && test.shouldErr {
+			t.Errorf("Test %d expected errors, but got no error", i)
+			continue
+		} else if err != nil && !test.shouldErr {
+			t.Errorf("Test %d expected no errors, but got '%v'", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		zone := z.Z["example.org."]
+		if len(zone.UpdateFrom) != test.from {
+			t.Errorf("Test %d expected %d networks, got %d", i, test.from, len(zone.UpdateFrom))
+		}
+		if len(zone.UpdateKeys) != test.keys {
+			t.Errorf("Test %d expected %d keys, got %d", i, test.keys, len(zone.UpdateKeys))
+		}
+		if zone.Journal != test.journal {
+			t.Errorf("Test %d expected journal %t, got %t", i, test.journal, zone.Journal)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	text, rm, err := test.TempFile(".", dbMiekNL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+	binary, rm, err := test.TempFile(".", string(snapshot(t, dbMiekNL, testzone)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		binary    bool
+	}{
+		{`file ` + text + ` miek.nl.`, false, false},
+		{`file ` + text + ` miek.nl. {
+			format text
+		}`, false, false},
+		{`file ` + binary + ` miek.nl. {
+			format binary
+		}`, false, true},
+		// fails
+		{`file ` + binary + ` miek.nl.`, true, false},
+		{`file ` + text + ` miek.nl. {
+			format binary
+		}`, true, false},
+		{`file ` + binary + ` example.org. {
+			format binary
+		}`, true, false},
+		{`file ` + binary + ` miek.nl. {
+			format binary
+			journal
+		}`, true, false},
+		{`file ` + text + ` miek.nl. {
+			format json
+		}`, true, false},
+		{`file ` + text + ` miek.nl. {
+			format
+		}`, true, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		z, err := fileParse(c)
+		if (err != nil) != test.shouldErr {
+			t.Errorf("Test %d expected error %t, got '%v'", i, test.shouldErr, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		zone := z.Z["miek.nl."]
+		if zone.Binary != test.binary {
+			t.Errorf("Test %d expected binary %t, got %t", i, test.binary, zone.Binary)
+		}
+		if zone.Apex.SOA == nil {
+			t.Errorf("Test %d expected the zone to be loaded", i)
+		}
+	}
+}
+
+func TestParseCheck(t *testing.T) {
+	warning, rm, err := test.TempFile(".", dbCheckWarning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+	errors, rm, err := test.TempFile(".", dbCheckExampleOrg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		level     CheckLevel
+	}{
+		{`file ` + errors + ` example.org.`, false, CheckWarn},
+		{`file ` + errors + ` example.org. {
+			check off
+		}`, false, CheckOff},
+		{`file ` + warning + ` example.org. {
+			check error
+		}`, false, CheckError},
+		// fails
+		{`file ` + errors + ` example.org. {
+			check error
+		}`, true, CheckError},
+		{`file ` + warning + ` example.org. {
+			check strict
+		}`, true, CheckStrict},
+		{`file ` + warning + ` example.org. {
+			check loud
+		}`, true, CheckOff},
+		{`file ` + warning + ` example.org. {
+			check warn error
+		}`, true, CheckOff},
+		{`file ` + warning + ` example.org. {
+			check
+		}`, true, CheckOff},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		z, err := fileParse(c)
+		if (err != nil) != test.shouldErr {
+			t.Errorf("Test %d expected error %t, got '%v'", i, test.shouldErr, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if x := z.Z["example.org."].CheckLevel; x != test.level {
+			t.Errorf("Test %d expected check level %d, got %d", i, test.level, x)
+		}
+	}
+}
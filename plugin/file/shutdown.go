@@ -7,3 +7,8 @@ func (z *Zone) OnShutdown() error {
 	}
 	return nil
 }
+
+// StopUpdate stops the Update go-routine of a secondary zone, it must be called only once.
+func (z *Zone) StopUpdate() {
+	close(z.updateShutdown)
+}
@@ -0,0 +1,101 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+)
+
+// snapshot returns the snapshot of the zone in the zone file s.
+func snapshot(t *testing.T, s, origin string) []byte {
+	z, err := Parse(strings.NewReader(s), origin, "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteSnapshot(buf, z); err != nil {
+		t.Fatalf("Expected no error when writing snapshot, got %q", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotLookupDNSSEC(t *testing.T) {
+	zone, err := ParseSnapshot(bytes.NewReader(snapshot(t, dbMiekNLSigned, testzone)), testzone, "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading snapshot, got %q", err)
+	}
+
+	fm := File{Next: test.ErrorHandler(), Zones: Zones{Z: map[string]*Zone{testzone: zone}, Names: []string{testzone}}}
+	for _, tc := range dnssecTestCases {
+		m := tc.Msg()
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := fm.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tests := []struct {
+		zone, origin string
+	}{
+		{dbMiekNLSigned, testzone},
+		{dbExampleOrgNSEC3, "example.org."},
+		{dbMiekNLDelegation, testzone},
+		{exampleOrg, "example.org."},
+	}
+	for i, tc := range tests {
+		snap := snapshot(t, tc.zone, tc.origin)
+		z, err := ParseSnapshot(bytes.NewReader(snap), tc.origin, "stdin", 0)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error when reading snapshot, got %q", i, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := WriteSnapshot(buf, z); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), snap) {
+			t.Errorf("Test %d: expected the snapshot of the loaded snapshot to be the same", i)
+		}
+	}
+}
+
+func TestParseSnapshotError(t *testing.T) {
+	snap := snapshot(t, dbMiekNLSigned, testzone)
+
+	corrupt := append([]byte{}, snap...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	version := append([]byte{}, snap...)
+	version[len(snapshotMagic)] = 2
+
+	tests := []struct {
+		data   []byte
+		origin string
+		serial int64
+		errStr string
+	}{
+		{[]byte(dbMiekNL), testzone, 0, "not a zone snapshot"},
+		{version, testzone, 0, "unsupported snapshot version"},
+		{corrupt, testzone, 0, "bad checksum"},
+		{snap[:len(snap)-10], testzone, 0, "bad checksum"},
+		{snap, "example.org.", 0, "not of origin"},
+		{snap, testzone, 1459051981, "no change in SOA serial"},
+	}
+	for i, tc := range tests {
+		_, err := ParseSnapshot(bytes.NewReader(tc.data), tc.origin, "stdin", tc.serial)
+		if err == nil {
+			t.Errorf("Test %d: expected error, got none", i)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.errStr) {
+			t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.errStr, err)
+		}
+	}
+}
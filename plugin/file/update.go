@@ -0,0 +1,442 @@
+package file
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// serveUpdate handles an RFC 2136 dynamic update for zone z.
+func (f File) serveUpdate(state request.Request, z *Zone) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+
+	t := state.Req.IsTsig()
+	switch {
+	case t != nil && state.W.TsigStatus() != nil:
+		log.Warningf("Update for %s from %s with invalid TSIG: %s", z.origin, state.IP(), state.W.TsigStatus())
+		m.Rcode = dns.RcodeNotAuth
+	case !z.allowUpdate(state):
+		log.Infof("Refusing update for %s from %s", z.origin, state.IP())
+		m.Rcode = dns.RcodeRefused
+	default:
+		changed := false
+		m.Rcode, changed = z.applyUpdate(state.Req)
+		if changed {
+			log.Infof("Update for %s from %s: zone is now at %d SOA serial", z.origin, state.IP(), z.SOASerialIfDefined())
+			if f.transfer != nil {
+				go func() {
+					if err := f.transfer.Notify(z.origin); err != nil {
+						log.Warningf("Failed sending notifies: %s", err)
+					}
+				}()
+			}
+		}
+	}
+
+	if t != nil && state.W.TsigStatus() == nil {
+		// The server signs the reply with the key of the request.
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+	state.W.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+// allowUpdate returns true if the update in state may be applied. An update signed with TSIG must be signed
+// with one of z.UpdateKeys, otherwise it must come from one of z.UpdateFrom.
+func (z *Zone) allowUpdate(state request.Request) bool {
+	if t := state.Req.IsTsig(); t != nil {
+		alg, ok := z.UpdateKeys[strings.ToLower(t.Hdr.Name)]
+		return ok && strings.EqualFold(alg, t.Algorithm) && state.W.TsigStatus() == nil
+	}
+	ip := net.ParseIP(state.IP())
+	for _, n := range z.UpdateFrom {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUpdate checks the prerequisites of the update in r and applies its updates to z (RFC 2136, section 3).
+// If the zone changed its SOA serial is increased, the change is journaled and the zone file is written.
+// It returns the rcode for the reply, and true if z was changed.
+func (z *Zone) applyUpdate(r *dns.Msg) (int, bool) {
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError, false
+	}
+	if strings.ToLower(r.Question[0].Name) != z.origin {
+		return dns.RcodeNotAuth, false
+	}
+
+	z.updateMu.Lock()
+	defer z.updateMu.Unlock()
+
+	z.RLock()
+	signed := len(z.Apex.SIGSOA) > 0
+	z.RUnlock()
+	if signed {
+		log.Warningf("Refusing update for signed zone %s", z.origin)
+		return dns.RcodeRefused, false
+	}
+
+	old := z.rrsets()
+	oldSOA := old.soa(z.origin)
+	if oldSOA == nil {
+		return dns.RcodeServerFailure, false
+	}
+	if rcode := old.prerequisites(z.origin, r.Answer); rcode != dns.RcodeSuccess {
+		return rcode, false
+	}
+	if rcode := prescan(z.origin, r.Ns); rcode != dns.RcodeSuccess {
+		return rcode, false
+	}
+
+	s := old.copy()
+	for _, rr := range r.Ns {
+		s.update(z.origin, rr)
+	}
+
+	newSOA := s.soa(z.origin)
+	if newSOA.Serial == oldSOA.Serial {
+		d := diffRRsets(old, s)
+		if len(d.del) == 0 && len(d.add) == 0 {
+			return dns.RcodeSuccess, false
+		}
+		// Increase the serial, as the update didn't do that itself.
+		newSOA = dns.Copy(oldSOA).(*dns.SOA)
+		newSOA.Serial++
+		s[z.origin][dns.TypeSOA] = []dns.RR{newSOA}
+	}
+	d, _ := newDiff(z.origin, old, s)
+
+	z1, err := s.zone(z.origin, z.File())
+	if err != nil {
+		log.Errorf("Failed to apply update for %s: %s", z.origin, err)
+		return dns.RcodeServerFailure, false
+	}
+	if err := z.journal(d); err != nil {
+		log.Errorf("Failed to journal update for %s: %s", z.origin, err)
+		return dns.RcodeServerFailure, false
+	}
+
+	z.Lock()
+	z.Apex = z1.Apex
+	z.Tree = z1.Tree
+	z.addDiff(d)
+	z.Unlock()
+
+	if err := z.writeFile(); err != nil {
+		// The change is in the journal, and is replayed from there on startup.
+		log.Errorf("Failed to write zone file for %s: %s", z.origin, err)
+	}
+	return dns.RcodeSuccess, true
+}
+
+// rrsets holds the records of a zone, keyed on owner name and type.
+type rrsets map[string]map[uint16][]dns.RR
+
+// rrsets returns the records in z.
+func (z *Zone) rrsets() rrsets {
+	s := rrsets{}
+	z.RLock()
+	defer z.RUnlock()
+
+	if z.Apex.SOA != nil {
+		s.add(z.Apex.SOA)
+	}
+	for _, rrs := range [][]dns.RR{z.Apex.SIGSOA, z.Apex.NS, z.Apex.SIGNS} {
+		for _, rr := range rrs {
+			s.add(rr)
+		}
+	}
+	for _, t := range []*tree.Tree{z.Tree, z.Apex.NSEC3} {
+		if t == nil {
+			continue
+		}
+		t.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error {
+			for _, rr := range e.All() {
+				s.add(rr)
+			}
+			return nil
+		})
+	}
+	return s
+}
+
+func (s rrsets) add(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	if s[name] == nil {
+		s[name] = make(map[uint16][]dns.RR)
+	}
+	s[name][rr.Header().Rrtype] = append(s[name][rr.Header().Rrtype], rr)
+}
+
+// copy returns a copy of s. The records themselves are shared, so these should not be modified.
+func (s rrsets) copy() rrsets {
+	s1 := make(rrsets, len(s))
+	for name, types := range s {
+		s1[name] = make(map[uint16][]dns.RR, len(types))
+		for t, rrs := range types {
+			s1[name][t] = append([]dns.RR(nil), rrs...)
+		}
+	}
+	return s1
+}
+
+func (s rrsets) soa(origin string) *dns.SOA {
+	if rrs := s[origin][dns.TypeSOA]; len(rrs) > 0 {
+		return rrs[0].(*dns.SOA)
+	}
+	return nil
+}
+
+// zone returns a new zone with the records in s.
+func (s rrsets) zone(origin, file string) (*Zone, error) {
+	z := NewZone(origin, file)
+	for _, types := range s {
+		for _, rrs := range types {
+			for _, rr := range rrs {
+				if err := z.Insert(dns.Copy(rr)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return z, nil
+}
+
+// prerequisites checks the prerequisites of an update against s (RFC 2136, section 3.2).
+func (s rrsets) prerequisites(origin string, prereqs []dns.RR) int {
+	values := rrsets{}
+	for _, rr := range prereqs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(origin, name) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if !empty(rr) {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(s[name]) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if len(s[name][h.Rrtype]) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if !empty(rr) {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(s[name]) > 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if len(s[name][h.Rrtype]) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			values.add(rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	// Value dependent prerequisites must match the entire RRset.
+	for name, types := range values {
+		for t, rrs := range types {
+			if !equalRRset(s[name][t], rrs) {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// prescan checks the update section of an update (RFC 2136, section 3.4.1).
+func prescan(origin string, updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dns.IsSubDomain(origin, strings.ToLower(h.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if meta(h.Rrtype) || h.Rrtype == dns.TypeANY || empty(rr) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || !empty(rr) || meta(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || meta(h.Rrtype) || h.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// update applies a single update to s (RFC 2136, section 3.4.2). The SOA and the apex NS records can't be
+// deleted, and CNAMEs can't be added to names with other data, or the other way around.
+func (s rrsets) update(origin string, rr dns.RR) {
+	h := rr.Header()
+	name := strings.ToLower(h.Name)
+	apex := name == origin
+
+	switch h.Class {
+	case dns.ClassINET:
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		switch h.Rrtype {
+		case dns.TypeSOA:
+			if apex && serialGreater(rr.(*dns.SOA).Serial, s.soa(origin).Serial) {
+				s[name][dns.TypeSOA] = []dns.RR{rr}
+			}
+			return
+		case dns.TypeCNAME:
+			for t := range s[name] {
+				if t != dns.TypeCNAME && t != dns.TypeRRSIG && t != dns.TypeNSEC {
+					return
+				}
+			}
+			if len(s[name][dns.TypeCNAME]) > 0 {
+				s[name][dns.TypeCNAME] = []dns.RR{rr}
+				return
+			}
+		default:
+			if len(s[name][dns.TypeCNAME]) > 0 {
+				return
+			}
+		}
+		rrs := s[name][h.Rrtype]
+		for i := range rrs {
+			if dns.IsDuplicate(rrs[i], rr) {
+				rrs[i] = rr // Only the TTL can differ.
+				return
+			}
+		}
+		s.add(rr)
+
+	case dns.ClassANY:
+		if h.Rrtype == dns.TypeANY {
+			for t := range s[name] {
+				if !apex || (t != dns.TypeSOA && t != dns.TypeNS) {
+					delete(s[name], t)
+				}
+			}
+		} else if !apex || (h.Rrtype != dns.TypeSOA && h.Rrtype != dns.TypeNS) {
+			delete(s[name], h.Rrtype)
+		}
+		if len(s[name]) == 0 {
+			delete(s, name)
+		}
+
+	case dns.ClassNONE:
+		rrs := s[name][h.Rrtype]
+		if h.Rrtype == dns.TypeSOA || (apex && h.Rrtype == dns.TypeNS && len(rrs) <= 1) {
+			return
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		for i := range rrs {
+			if dns.IsDuplicate(rrs[i], rr) {
+				s[name][h.Rrtype] = append(rrs[:i:i], rrs[i+1:]...)
+				break
+			}
+		}
+		if len(s[name][h.Rrtype]) == 0 {
+			delete(s[name], h.Rrtype)
+		}
+		if len(s[name]) == 0 {
+			delete(s, name)
+		}
+	}
+}
+
+// newDiff returns the change from old to cur. It returns false if either doesn't have a SOA record.
+func newDiff(origin string, old, cur rrsets) (diff, bool) {
+	oldSOA, curSOA := old.soa(origin), cur.soa(origin)
+	if oldSOA == nil || curSOA == nil {
+		return diff{}, false
+	}
+	d := diffRRsets(old, cur)
+	d.del = append([]dns.RR{oldSOA}, d.del...)
+	d.add = append([]dns.RR{curSOA}, d.add...)
+	return d, true
+}
+
+// diffRRsets returns the records that are deleted from old and the ones that are added to it, to get cur. SOA
+// records are left out.
+func diffRRsets(old, cur rrsets) diff {
+	return diff{del: missing(old, cur), add: missing(cur, old)}
+}
+
+// missing returns the records in a that aren't in b, except SOA records.
+func missing(a, b rrsets) []dns.RR {
+	rrs := []dns.RR{}
+	for name, types := range a {
+		for t, set := range types {
+			if t == dns.TypeSOA {
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, rr := range b[name][t] {
+				seen[rr.String()] = true
+			}
+			for _, rr := range set {
+				if !seen[rr.String()] {
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+	}
+	return rrs
+}
+
+// equalRRset returns true if a and b hold the same records, ignoring TTLs and the order.
+func equalRRset(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+Next:
+	for _, rb := range b {
+		for _, ra := range a {
+			if dns.IsDuplicate(ra, rb) {
+				continue Next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// empty returns true if rr has no rdata. The records of an update are unpacked from the wire, where a
+// record without rdata still gets its concrete type (e.g. an *dns.A with a nil address), so only the
+// rdata length tells.
+func empty(rr dns.RR) bool { return rr.Header().Rdlength == 0 }
+
+// meta returns true for the meta types that can't be updated.
+func meta(t uint16) bool {
+	switch t {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG:
+		return true
+	}
+	return false
+}
+
+// serialGreater returns true if serial a is greater than b, using serial number arithmetic (RFC 1982).
+func serialGreater(a, b uint32) bool { return a != b && a-b < 1<<31 }
@@ -0,0 +1,329 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// updateZone writes dbUpdateExampleOrg to a temporary directory and returns the parsed zone. Updates are
+// allowed from 10.240.0.0/16, which holds the address of test.ResponseWriter.
+func updateZone(t *testing.T) (*Zone, func()) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "db.example.org")
+	if err := ioutil.WriteFile(file, []byte(dbUpdateExampleOrg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	z, err := Parse(strings.NewReader(dbUpdateExampleOrg), "example.org.", file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, n, _ := net.ParseCIDR("10.240.0.0/16")
+	z.UpdateFrom = []*net.IPNet{n}
+	return z, func() { os.RemoveAll(dir) }
+}
+
+// update sends m to zone z and returns the reply. Like a real update m is packed and unpacked first, so its
+// records look as they do when received from the wire.
+func update(t *testing.T, z *Zone, m *dns.Msg) *dns.Msg {
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	f := File{Zones: Zones{Z: map[string]*Zone{"example.org.": z}, Names: []string{"example.org."}}}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	return rec.Msg
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		prereq  []dns.RR
+		insert  []dns.RR
+		remove  []dns.RR
+		rcode   int
+		serial  uint32
+		lookup  string
+		qtype   uint16
+		answers int
+	}{
+		// Add a record, and a duplicate of an existing one.
+		{insert: []dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1"), test.A("a.example.org. 300 IN A 127.0.0.1")},
+			serial: 1282630058, lookup: "host.example.org.", qtype: dns.TypeA, answers: 1},
+		// Nothing changes, the serial stays the same.
+		{insert: []dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")},
+			serial: 1282630057, lookup: "a.example.org.", qtype: dns.TypeA, answers: 1},
+		// Remove a single record.
+		{remove: []dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")},
+			serial: 1282630058, lookup: "a.example.org.", qtype: dns.TypeA, answers: 0},
+		// Can't add a CNAME to a name with other data, or the other way around.
+		{insert: []dns.RR{test.CNAME("a.example.org. 300 IN CNAME b.example.org.")},
+			serial: 1282630057, lookup: "a.example.org.", qtype: dns.TypeCNAME, answers: 0},
+		{insert: []dns.RR{test.A("www.example.org. 300 IN A 10.0.0.1")},
+			serial: 1282630057, lookup: "www.example.org.", qtype: dns.TypeA, answers: 2}, // CNAME and target
+		// The last NS can't be removed.
+		{remove: []dns.RR{test.NS("example.org. 3600 IN NS a.iana-servers.net.")},
+			serial: 1282630057, lookup: "example.org.", qtype: dns.TypeNS, answers: 1},
+		// A SOA with a higher serial replaces the existing one.
+		{insert: []dns.RR{test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1282630100 14400 3600 604800 14400")},
+			serial: 1282630100, lookup: "example.org.", qtype: dns.TypeSOA, answers: 1},
+		// Prerequisites.
+		{prereq: []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "host.example.org.", Rrtype: dns.TypeANY, Class: dns.ClassANY}}},
+			insert: []dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")}, rcode: dns.RcodeNameError, serial: 1282630057},
+		{prereq: []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeANY, Class: dns.ClassNONE}}},
+			rcode: dns.RcodeYXDomain, serial: 1282630057},
+		{prereq: []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeAAAA, Class: dns.ClassANY}}},
+			rcode: dns.RcodeNXRrset, serial: 1282630057},
+		{prereq: []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeA, Class: dns.ClassNONE}}},
+			rcode: dns.RcodeYXRrset, serial: 1282630057},
+		{prereq: []dns.RR{test.A("a.example.org. 0 IN A 127.0.0.2")},
+			rcode: dns.RcodeNXRrset, serial: 1282630057},
+		{prereq: []dns.RR{test.A("a.example.org. 0 IN A 127.0.0.1")}, remove: []dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")},
+			serial: 1282630058, lookup: "a.example.org.", qtype: dns.TypeA, answers: 0},
+		{prereq: []dns.RR{test.A("a.example.org. 300 IN A 127.0.0.1")},
+			rcode: dns.RcodeFormatError, serial: 1282630057},
+		// Out of zone.
+		{insert: []dns.RR{test.A("host.example.net. 300 IN A 10.0.0.1")}, rcode: dns.RcodeNotZone, serial: 1282630057},
+	}
+
+	for i, tc := range tests {
+		z, rm := updateZone(t)
+
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		m.Answer = tc.prereq
+		m.Insert(tc.insert)
+		m.Remove(tc.remove)
+		resp := update(t, z, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+		if serial := z.SOASerialIfDefined(); serial != int64(tc.serial) {
+			t.Errorf("Test %d: expected serial %d, got %d", i, tc.serial, serial)
+		}
+		if tc.lookup != "" {
+			r := new(dns.Msg)
+			r.SetQuestion(tc.lookup, tc.qtype)
+			resp := update(t, z, r)
+			if len(resp.Answer) != tc.answers {
+				t.Errorf("Test %d: expected %d answers, got %d: %v", i, tc.answers, len(resp.Answer), resp.Answer)
+			}
+		}
+		rm()
+	}
+}
+
+func TestUpdateRemoveRRset(t *testing.T) {
+	z, rm := updateZone(t)
+	defer rm()
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.RemoveRRset([]dns.RR{test.A("a.example.org. 0 IN A 127.0.0.1"), test.NS("example.org. 0 IN NS a.iana-servers.net.")})
+	m.RemoveName([]dns.RR{test.A("www.example.org. 0 IN A 127.0.0.1")})
+	if resp := update(t, z, m); resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected success, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	s := z.rrsets()
+	if len(s["a.example.org."]) != 0 || len(s["www.example.org."]) != 0 {
+		t.Errorf("Expected a.example.org. and www.example.org. to be removed, got %v", s)
+	}
+	if len(s["example.org."][dns.TypeNS]) != 1 {
+		t.Errorf("Expected apex NS records to be kept")
+	}
+}
+
+func TestUpdateRRsetPrereq(t *testing.T) {
+	tests := []struct {
+		used, notUsed []dns.RR
+		rcode         int
+	}{
+		{used: []dns.RR{test.A("a.example.org. 0 IN A 127.0.0.1")}, rcode: dns.RcodeSuccess},
+		{used: []dns.RR{test.AAAA("a.example.org. 0 IN AAAA ::1")}, rcode: dns.RcodeNXRrset},
+		{notUsed: []dns.RR{test.AAAA("a.example.org. 0 IN AAAA ::1")}, rcode: dns.RcodeSuccess},
+		{notUsed: []dns.RR{test.A("a.example.org. 0 IN A 127.0.0.1")}, rcode: dns.RcodeYXRrset},
+	}
+	for i, tc := range tests {
+		z, rm := updateZone(t)
+
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		if tc.used != nil {
+			m.RRsetUsed(tc.used)
+		}
+		if tc.notUsed != nil {
+			m.RRsetNotUsed(tc.notUsed)
+		}
+		m.Insert([]dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")})
+		if resp := update(t, z, m); resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+		rm()
+	}
+}
+
+func TestUpdateACL(t *testing.T) {
+	z, rm := updateZone(t)
+	defer rm()
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")})
+
+	z.UpdateFrom = nil
+	if resp := update(t, z, m); resp.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED without ACL, got %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	z.UpdateKeys = map[string]string{"update.": dns.HmacSHA256}
+	m.SetTsig("other.", dns.HmacSHA256, 300, time.Now().Unix())
+	if resp := update(t, z, m); resp.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED with unknown key, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	m.Extra = nil
+	m.SetTsig("update.", dns.HmacSHA256, 300, time.Now().Unix())
+	resp := update(t, z, m)
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected success with key, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if resp.IsTsig() == nil {
+		t.Errorf("Expected reply to be signed")
+	}
+
+	// Queries for another zone than the zone's origin.
+	m = new(dns.Msg)
+	m.SetUpdate("a.example.org.")
+	z.UpdateFrom = []*net.IPNet{{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}}
+	if resp := update(t, z, m); resp.Rcode != dns.RcodeNotAuth {
+		t.Errorf("Expected NOTAUTH for update of a.example.org., got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestUpdateJournal(t *testing.T) {
+	z, rm := updateZone(t)
+	defer rm()
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("host.example.org. 300 IN A 10.0.0.1")})
+	m.Remove([]dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")})
+	if resp := update(t, z, m); resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected success, got %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	diffs, err := z.readJournal()
+	if err != nil {
+		t.Fatalf("Expected no error reading journal, got %s", err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("Expected 1 change in the journal, got %d", len(diffs))
+	}
+	if from, to := diffs[0].serials(); from != 1282630057 || to != 1282630058 {
+		t.Errorf("Expected change from serial %d to %d, got %d to %d", 1282630057, 1282630058, from, to)
+	}
+	if len(diffs[0].del) != 2 || len(diffs[0].add) != 2 {
+		t.Errorf("Expected 2 deleted and 2 added records, got %v and %v", diffs[0].del, diffs[0].add)
+	}
+
+	// The zone file is written back.
+	f, err := os.Open(z.File())
+	if err != nil {
+		t.Fatal(err)
+	}
+	z1, err := Parse(f, "example.org.", z.File(), 0)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Expected zone file to be valid, got %s", err)
+	}
+	if z1.Apex.SOA.Serial != 1282630058 {
+		t.Errorf("Expected serial %d in zone file, got %d", 1282630058, z1.Apex.SOA.Serial)
+	}
+
+	// When the zone file wasn't written, the change is replayed from the journal.
+	if err := ioutil.WriteFile(z.File(), []byte(dbUpdateExampleOrg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	z2, err := Parse(strings.NewReader(dbUpdateExampleOrg), "example.org.", z.File(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := z2.replayJournal()
+	if err != nil {
+		t.Fatalf("Expected no error replaying journal, got %s", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 change replayed, got %d", n)
+	}
+	s := z2.rrsets()
+	if len(s["host.example.org."][dns.TypeA]) != 1 || len(s["a.example.org."][dns.TypeA]) != 0 {
+		t.Errorf("Expected the change to be replayed, got %v", s)
+	}
+	if z2.SOASerialIfDefined() != 1282630058 {
+		t.Errorf("Expected serial %d, got %d", 1282630058, z2.SOASerialIfDefined())
+	}
+}
+
+func TestParseJournal(t *testing.T) {
+	soa1 := "example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 14400 3600 604800 14400\n"
+	soa2 := "example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 2 14400 3600 604800 14400\n"
+	a := "a.example.org. 3600 IN A 127.0.0.1\n"
+
+	tests := []struct {
+		in    string
+		diffs int
+		err   bool
+	}{
+		{"", 0, false},
+		{"-" + soa1 + "-" + a + "+" + soa2, 1, false},
+		{"-" + soa1 + "+" + soa2 + "+" + a + "\n-" + soa2 + "+" + soa1, 2, false},
+		{"-" + soa1, 0, true},           // truncated
+		{"+" + soa2, 0, true},           // no deletions
+		{"-" + a + "+" + soa2, 0, true}, // no old SOA
+		{"-" + soa1 + "+" + a, 0, true}, // no new SOA
+		{"-" + soa1 + "+" + soa2 + "-" + a, 0, true},
+		{"-garbage\n", 0, true},
+	}
+	for i, tc := range tests {
+		diffs, err := parseJournal(strings.NewReader(tc.in))
+		if tc.err {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if len(diffs) != tc.diffs {
+			t.Errorf("Test %d: expected %d changes, got %d", i, tc.diffs, len(diffs))
+		}
+	}
+}
+
+const dbUpdateExampleOrg = `$TTL 3600
+@	IN	SOA	ns.example.org. hostmaster.example.org. 1282630057 14400 3600 604800 14400
+	IN	NS	a.iana-servers.net.
+a	IN	A	127.0.0.1
+www	IN	CNAME	a
+`
@@ -16,14 +16,22 @@ func (f File) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
 	return z.Transfer(serial)
 }
 
-// Transfer transfers a zone with serial in the returned channel and implements IXFR fallback, by just
-// sending a single SOA record.
+// Transfer transfers a zone with serial in the returned channel. For an IXFR (serial isn't 0) the changes since
+// serial are sent if these are known, otherwise the entire zone is sent. If the zone is up to date only the SOA
+// record is sent.
 func (z *Zone) Transfer(serial uint32) (<-chan []dns.RR, error) {
 	// get soa and apex
 	apex, err := z.ApexIfDefined()
 	if err != nil {
 		return nil, err
 	}
+	var diffs []diff
+	z.RLock()
+	t, nsec3 := z.Tree, z.Apex.NSEC3 // both are replaced, not changed, when the zone changes
+	if serial != 0 {
+		diffs = z.incremental(serial, apex[0].(*dns.SOA).Serial)
+	}
+	z.RUnlock()
 
 	ch := make(chan []dns.RR)
 	go func() {
@@ -34,8 +42,22 @@ func (z *Zone) Transfer(serial uint32) (<-chan []dns.RR, error) {
 			return
 		}
 
+		if len(diffs) > 0 {
+			ch <- []dns.RR{apex[0]}
+			for _, d := range diffs {
+				ch <- append(append([]dns.RR{}, d.del...), d.add...)
+			}
+			ch <- []dns.RR{apex[0]}
+
+			close(ch)
+			return
+		}
+
 		ch <- apex
-		z.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error { ch <- e.All(); return nil })
+		t.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error { ch <- e.All(); return nil })
+		if nsec3 != nil {
+			nsec3.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error { ch <- e.All(); return nil })
+		}
 		ch <- []dns.RR{apex[0]}
 
 		close(ch)
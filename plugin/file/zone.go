@@ -2,6 +2,7 @@ package file
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -27,18 +28,49 @@ type Zone struct {
 	StartupOnce  sync.Once
 	TransferFrom []string
 
+	// TsigKey is the name of the TSIG key, if any, that signs the transfers from, and the NOTIFYs of, the
+	// primaries in TransferFrom.
+	TsigKey       string
+	TsigAlgorithm string
+	TsigSecret    string
+
+	// Binary is true when the zone's file is a snapshot, see WriteSnapshot, instead of a zone file.
+	Binary bool
+	// CheckLevel tells what to do with the problems in the zone found when it is reloaded.
+	CheckLevel CheckLevel
+	// OnTransfer is called after a transfer of a secondary zone went through, also when it was started by a
+	// NOTIFY.
+	OnTransfer func()
+
 	ReloadInterval time.Duration
 	reloadShutdown chan bool
+	updateShutdown chan bool
 
 	Upstream *upstream.Upstream // Upstream for looking up external names during the resolution process.
+
+	// Dynamic updates, these are allowed from UpdateFrom, or when signed with one of the TSIG keys
+	// in UpdateKeys (mapping the key name to its algorithm).
+	UpdateFrom []*net.IPNet
+	UpdateKeys map[string]string
+	updateMu   sync.Mutex // serializes updates
+
+	// Journal enables writing the changes to the zone to the journal on disk. Updates are always journaled.
+	Journal bool
+	// IXFR enables keeping the changes to the zone in memory, to answer IXFR requests. It's set when the
+	// transfer plugin is used.
+	IXFR       bool
+	journalLen int    // number of changes in the journal
+	diffs      []diff // last changes to the zone, for IXFR
 }
 
-// Apex contains the apex records of a zone: SOA, NS and their potential signatures.
+// Apex contains the apex records of a zone: SOA, NS and their potential signatures. If the zone is signed
+// with NSEC3, the NSEC3 records are kept here as well, as they are swapped with the apex.
 type Apex struct {
 	SOA    *dns.SOA
 	NS     []dns.RR
 	SIGSOA []dns.RR
 	SIGNS  []dns.RR
+	NSEC3  *tree.Tree // NSEC3 records and their signatures, in hash order
 }
 
 // NewZone returns a new zone.
@@ -49,6 +81,7 @@ func NewZone(name, file string) *Zone {
 		file:           filepath.Clean(file),
 		Tree:           &tree.Tree{},
 		reloadShutdown: make(chan bool),
+		updateShutdown: make(chan bool),
 	}
 }
 
@@ -89,11 +122,15 @@ func (z *Zone) Insert(r dns.RR) error {
 
 		z.Apex.SOA = r.(*dns.SOA)
 		return nil
-	case dns.TypeNSEC3, dns.TypeNSEC3PARAM:
-		return fmt.Errorf("NSEC3 zone is not supported, dropping RR: %s for zone: %s", r.Header().Name, z.origin)
+	case dns.TypeNSEC3:
+		z.insertNSEC3(r)
+		return nil
 	case dns.TypeRRSIG:
 		x := r.(*dns.RRSIG)
 		switch x.TypeCovered {
+		case dns.TypeNSEC3:
+			z.insertNSEC3(r)
+			return nil
 		case dns.TypeSOA:
 			z.Apex.SIGSOA = append(z.Apex.SIGSOA, x)
 			return nil
@@ -115,6 +152,14 @@ func (z *Zone) Insert(r dns.RR) error {
 	return nil
 }
 
+// insertNSEC3 inserts r into the NSEC3 records of z.
+func (z *Zone) insertNSEC3(r dns.RR) {
+	if z.Apex.NSEC3 == nil {
+		z.Apex.NSEC3 = &tree.Tree{}
+	}
+	z.Apex.NSEC3.Insert(r)
+}
+
 // File retrieves the file path in a safe way.
 func (z *Zone) File() string {
 	z.RLock()
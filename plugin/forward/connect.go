@@ -50,8 +50,13 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 		proto = "tcp-tls"
 	}
 
-	t.dial <- proto
-	pc := <-t.ret
+	var pc *persistConn
+	select {
+	case t.dial <- proto:
+		pc = <-t.ret
+	case <-t.stop:
+		return nil, false, ErrTransportStopped
+	}
 
 	if pc != nil {
 		ConnCacheHitsCount.WithLabelValues(t.addr, proto).Add(1)
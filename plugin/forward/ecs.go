@@ -0,0 +1,143 @@
+package forward
+
+import (
+	"net"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// clientSubnet holds the EDNS0 Client Subnet (RFC 7871) settings that are applied to the queries
+// we send upstream.
+type clientSubnet struct {
+	add      bool  // add an ECS option derived from the client's address
+	strip    bool  // strip any ECS option the client has sent
+	v4Prefix uint8 // source prefix length used for IPv4 clients
+	v6Prefix uint8 // source prefix length used for IPv6 clients
+}
+
+func newClientSubnet() *clientSubnet {
+	return &clientSubnet{v4Prefix: defaultECSv4Prefix, v6Prefix: defaultECSv6Prefix}
+}
+
+// msg returns a copy of the request in state with the ECS option added and/or stripped as configured.
+// The original request is not modified, as other plugins may still hold on to it.
+func (cs *clientSubnet) msg(state request.Request) *dns.Msg {
+	m := state.Req.Copy()
+	o := m.IsEdns0()
+	if cs.strip {
+		removeSubnet(m)
+	}
+
+	if !cs.add {
+		return m
+	}
+	// An ECS option that was sent (and not stripped) is the client's choice, we leave it alone.
+	if subnet(m) != nil {
+		return m
+	}
+
+	ecs := cs.option(net.ParseIP(state.IP()))
+	if ecs == nil {
+		return m
+	}
+	if o == nil {
+		o = &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		o.SetUDPSize(dns.MinMsgSize)
+		m.Extra = append(m.Extra, o)
+	}
+	o.Option = append(o.Option, ecs)
+	return m
+}
+
+// option returns an ECS option for ip truncated to the configured source prefix length. If ip is
+// nil, nil is returned.
+func (cs *clientSubnet) option(ip net.IP) *dns.EDNS0_SUBNET {
+	if ip == nil {
+		return nil
+	}
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if ip4 := ip.To4(); ip4 != nil {
+		ecs.Family = 1
+		ecs.SourceNetmask = cs.v4Prefix
+		ecs.Address = ip4.Mask(net.CIDRMask(int(cs.v4Prefix), net.IPv4len*8))
+		return ecs
+	}
+	ecs.Family = 2
+	ecs.SourceNetmask = cs.v6Prefix
+	ecs.Address = ip.Mask(net.CIDRMask(int(cs.v6Prefix), net.IPv6len*8))
+	return ecs
+}
+
+// subnet returns the ECS option in m, or nil if there is none.
+func subnet(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// restoreSubnet puts a copy of ecs, the option the client sent, in the reply m in place of the one the upstream
+// returned for the option we sent instead. The reply must echo the client's option (RFC 7871, Section 7.2.1); the
+// scope the upstream returned is kept.
+func restoreSubnet(m *dns.Msg, ecs *dns.EDNS0_SUBNET) {
+	o := m.IsEdns0()
+	if o == nil {
+		return
+	}
+	scope, _ := removeSubnet(m)
+	e := *ecs
+	e.SourceScope = scope
+	o.Option = append(o.Option, &e)
+}
+
+// removeSubnet removes any ECS option from m. It returns the scope of the removed option and true if there was
+// one. This is also used on replies: a client that didn't send an ECS option must not get one back (RFC 7871,
+// Section 7.2.2), even if we added one to the query sent upstream.
+func removeSubnet(m *dns.Msg) (uint8, bool) {
+	o := m.IsEdns0()
+	if o == nil {
+		return 0, false
+	}
+	var (
+		scope   uint8
+		removed bool
+	)
+	j := 0
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			scope, removed = e.SourceScope, true
+			continue
+		}
+		o.Option[j] = s
+		j++
+	}
+	o.Option = o.Option[:j]
+	return scope, removed
+}
+
+// removeOPT removes the OPT RR from the reply m. This is used when we added an OPT RR to a request of a
+// client that didn't send one; that client must not see an OPT RR in the reply.
+func removeOPT(m *dns.Msg) {
+	j := 0
+	for _, e := range m.Extra {
+		if e.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		m.Extra[j] = e
+		j++
+	}
+	m.Extra = m.Extra[:j]
+}
+
+const (
+	defaultECSv4Prefix = 24
+	defaultECSv6Prefix = 56
+)
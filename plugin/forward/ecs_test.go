@@ -0,0 +1,308 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupECS(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    *clientSubnet
+		expectedErr string
+	}{
+		// positive
+		{"forward . 127.0.0.1", false, nil, ""},
+		{"forward . 127.0.0.1 {\necs_add\n}\n", false, &clientSubnet{add: true, v4Prefix: 24, v6Prefix: 56}, ""},
+		{"forward . 127.0.0.1 {\necs_add 16\n}\n", false, &clientSubnet{add: true, v4Prefix: 16, v6Prefix: 56}, ""},
+		{"forward . 127.0.0.1 {\necs_add 32 64\n}\n", false, &clientSubnet{add: true, v4Prefix: 32, v6Prefix: 64}, ""},
+		{"forward . 127.0.0.1 {\necs_strip\n}\n", false, &clientSubnet{strip: true, v4Prefix: 24, v6Prefix: 56}, ""},
+		{"forward . 127.0.0.1 {\necs_strip\necs_add 0 0\n}\n", false, &clientSubnet{add: true, strip: true}, ""},
+		// negative
+		{"forward . 127.0.0.1 {\necs_add 33\n}\n", true, nil, "invalid IPv4 source prefix length"},
+		{"forward . 127.0.0.1 {\necs_add 24 129\n}\n", true, nil, "invalid IPv6 source prefix length"},
+		{"forward . 127.0.0.1 {\necs_add 24 56 64\n}\n", true, nil, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\necs_add x\n}\n", true, nil, "invalid syntax"},
+		{"forward . 127.0.0.1 {\necs_strip yes\n}\n", true, nil, "Wrong argument count"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			continue
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+
+		if test.expected == nil {
+			if f.ecs != nil {
+				t.Errorf("Test %d: expected no ECS settings, got %+v", i, f.ecs)
+			}
+			continue
+		}
+		if f.ecs == nil || *f.ecs != *test.expected {
+			t.Errorf("Test %d: expected %+v, got %+v", i, test.expected, f.ecs)
+		}
+	}
+}
+
+func TestClientSubnetMsg(t *testing.T) {
+	tests := []struct {
+		cs       clientSubnet
+		w        dns.ResponseWriter
+		edns     bool
+		ecs      *dns.EDNS0_SUBNET // sent by the client
+		expected *dns.EDNS0_SUBNET // nil means no ECS option
+	}{
+		{clientSubnet{add: true, v4Prefix: 24, v6Prefix: 56}, &test.ResponseWriter{}, false, nil,
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 24, Address: []byte{10, 240, 0, 0}}},
+		{clientSubnet{add: true, v4Prefix: 16, v6Prefix: 56}, &test.ResponseWriter{}, true, nil,
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 16, Address: []byte{10, 240, 0, 0}}},
+		{clientSubnet{add: true, v4Prefix: 24, v6Prefix: 48}, &test.ResponseWriter6{}, true, nil,
+			&dns.EDNS0_SUBNET{Family: 2, SourceNetmask: 48, Address: []byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}},
+		// client supplied option is kept
+		{clientSubnet{add: true, v4Prefix: 24, v6Prefix: 56}, &test.ResponseWriter{}, true,
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 8, Address: []byte{192, 0, 0, 0}},
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 8, Address: []byte{192, 0, 0, 0}}},
+		// client supplied option is replaced
+		{clientSubnet{add: true, strip: true, v4Prefix: 24, v6Prefix: 56}, &test.ResponseWriter{}, true,
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 8, Address: []byte{192, 0, 0, 0}},
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 24, Address: []byte{10, 240, 0, 0}}},
+		// client supplied option is removed
+		{clientSubnet{strip: true}, &test.ResponseWriter{}, true,
+			&dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 8, Address: []byte{192, 0, 0, 0}}, nil},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+			if tc.ecs != nil {
+				o := m.IsEdns0()
+				ecs := *tc.ecs
+				ecs.Code = dns.EDNS0SUBNET
+				o.Option = append(o.Option, &ecs)
+			}
+		}
+		orig := m.Copy()
+
+		up := tc.cs.msg(request.Request{W: tc.w, Req: m})
+
+		if m.String() != orig.String() {
+			t.Errorf("Test %d: expected original request to be unmodified", i)
+		}
+
+		var got *dns.EDNS0_SUBNET
+		if o := up.IsEdns0(); o != nil {
+			for _, s := range o.Option {
+				if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+					got = e
+				}
+			}
+		}
+		if tc.expected == nil {
+			if got != nil {
+				t.Errorf("Test %d: expected no ECS option, got %s", i, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("Test %d: expected ECS option %s, got none", i, tc.expected)
+			continue
+		}
+		if got.Family != tc.expected.Family || got.SourceNetmask != tc.expected.SourceNetmask || !got.Address.Equal(tc.expected.Address) {
+			t.Errorf("Test %d: expected ECS option %s, got %s", i, tc.expected, got)
+		}
+	}
+}
+
+// ecsHandler echoes the OPT RR of the query in the reply, with the scope of the ECS option set to its source
+// prefix length.
+func ecsHandler(w dns.ResponseWriter, r *dns.Msg) {
+	ret := new(dns.Msg)
+	ret.SetReply(r)
+	ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+	if o := r.IsEdns0(); o != nil {
+		for _, s := range o.Option {
+			if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+				e.SourceScope = e.SourceNetmask
+			}
+		}
+		ret.Extra = append(ret.Extra, o)
+	}
+	w.WriteMsg(ret)
+}
+
+func TestClientSubnetReply(t *testing.T) {
+	s := dnstest.NewServer(ecsHandler)
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\necs_add\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// Client without EDNS0 must not see the OPT RR we added.
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+	if rec.Msg.IsEdns0() != nil {
+		t.Errorf("Expected no OPT RR in reply, got %s", rec.Msg.IsEdns0())
+	}
+
+	// Client with EDNS0, but without ECS, must not see the ECS option we added; the writer is told the scope.
+	m = new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	sc := &scoper{}
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(edns.WithScoper(context.TODO(), sc), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+	o := rec.Msg.IsEdns0()
+	if o == nil {
+		t.Fatal("Expected OPT RR in reply, got none")
+	}
+	if len(o.Option) != 0 {
+		t.Errorf("Expected no ECS option in reply, got %v", o.Option)
+	}
+	if sc.scope != 24 {
+		t.Errorf("Expected scope 24 to be reported, got %d", sc.scope)
+	}
+
+	// Client with ECS sees the returned ECS option.
+	m = new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{192, 0, 2, 0}})
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+	o = rec.Msg.IsEdns0()
+	if o == nil || len(o.Option) != 1 {
+		t.Fatalf("Expected OPT RR with ECS option in reply, got %v", o)
+	}
+	if e, ok := o.Option[0].(*dns.EDNS0_SUBNET); !ok || e.SourceScope != 24 {
+		t.Errorf("Expected ECS option with scope 24, got %s", o.Option[0])
+	}
+}
+
+// scoper is an edns.Scoper that records the scope it's told.
+type scoper struct{ scope uint8 }
+
+func (s *scoper) SetScope(scope uint8) { s.scope = scope }
+
+func TestClientSubnetReplyReplaced(t *testing.T) {
+	s := dnstest.NewServer(ecsHandler)
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\necs_strip\necs_add\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// The client's option is replaced upstream, but the reply must echo it.
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 8, Address: []byte{192, 0, 0, 0}})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+	o := rec.Msg.IsEdns0()
+	if o == nil || len(o.Option) != 1 {
+		t.Fatalf("Expected OPT RR with ECS option in reply, got %v", o)
+	}
+	e, ok := o.Option[0].(*dns.EDNS0_SUBNET)
+	if !ok || e.Family != 1 || e.SourceNetmask != 8 || !e.Address.Equal(net.IPv4(192, 0, 0, 0)) {
+		t.Errorf("Expected the client's ECS option, got %s", o.Option[0])
+	}
+	if ok && e.SourceScope != 24 {
+		t.Errorf("Expected the scope of the upstream, 24, got %d", e.SourceScope)
+	}
+}
+
+func TestClientSubnetCache(t *testing.T) {
+	var count int32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&count, 1)
+		ecsHandler(w, r)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\necs_add\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// A plugin between the cache and forward that wraps the writer, and the context.
+	ca := cache.New()
+	ca.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		ctx = context.WithValue(ctx, struct{}{}, true)
+		return f.ServeDNS(ctx, dnstest.NewRecorder(w), r)
+	})
+
+	tests := []struct {
+		client   string
+		upstream int32 // expected number of upstream queries so far.
+	}{
+		{"10.240.0.1", 1},
+		{"10.240.1.1", 2}, // another /24, the answer isn't shared.
+		{"10.240.1.2", 2},
+		{"10.240.0.2", 2},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.client})
+		if _, err := ca.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected to receive reply, but didn't: %s", i, err)
+		}
+		if o := rec.Msg.IsEdns0(); o != nil && len(o.Option) != 0 {
+			t.Errorf("Test %d: expected no ECS option in reply, got %v", i, o.Option)
+		}
+		if n := atomic.LoadInt32(&count); n != tc.upstream {
+			t.Errorf("Test %d: expected %d upstream queries, got %d", i, tc.upstream, n)
+		}
+	}
+}
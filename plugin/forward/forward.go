@@ -8,13 +8,16 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/debug"
 	"github.com/coredns/coredns/plugin/dnstap"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/validator"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -29,9 +32,15 @@ type Forward struct {
 	concurrent int64 // atomic counters need to be first in struct for proper alignment
 
 	proxies    []*Proxy
+	proxyMu    sync.RWMutex // protects proxies, these are swapped when an upstream file changes.
 	p          Policy
 	hcInterval time.Duration
 
+	to        []string             // TO as given in the configuration.
+	files     map[string]fileStamp // files in to we read upstreams from.
+	reload    time.Duration        // how often to check files for changes.
+	stopWatch chan bool
+
 	from    string
 	ignored []string
 
@@ -41,6 +50,9 @@ type Forward struct {
 	expire        time.Duration
 	maxConcurrent int64
 
+	ecs       *clientSubnet        // EDNS0 Client Subnet handling, nil when not configured.
+	validator *validator.Validator // DNSSEC validation, nil when not configured.
+
 	opts options // also here for testing
 
 	// ErrLimitExceeded indicates that a query was rejected because the number of concurrent queries has exceeded
@@ -54,18 +66,24 @@ type Forward struct {
 
 // New returns a new Forward.
 func New() *Forward {
-	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, p: new(random), from: ".", hcInterval: hcInterval, opts: options{forceTCP: false, preferUDP: false, hcRecursionDesired: true}}
+	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, p: new(random), from: ".", hcInterval: hcInterval, reload: defaultReload, opts: options{forceTCP: false, preferUDP: false, hcRecursionDesired: true}}
 	return f
 }
 
 // SetProxy appends p to the proxy list and starts healthchecking.
 func (f *Forward) SetProxy(p *Proxy) {
+	f.proxyMu.Lock()
 	f.proxies = append(f.proxies, p)
+	f.proxyMu.Unlock()
 	p.start(f.hcInterval)
 }
 
 // Len returns the number of configured proxies.
-func (f *Forward) Len() int { return len(f.proxies) }
+func (f *Forward) Len() int {
+	f.proxyMu.RLock()
+	defer f.proxyMu.RUnlock()
+	return len(f.proxies)
+}
 
 // Name implements plugin.Handler.
 func (f *Forward) Name() string { return "forward" }
@@ -87,6 +105,18 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		}
 	}
 
+	noEdns := r.IsEdns0() == nil
+	ecs := subnet(r)
+	if f.ecs != nil {
+		state = request.Request{W: w, Req: f.ecs.msg(state)}
+	}
+	// Validate unless the client wants to do that itself.
+	validate := f.validator != nil && !r.CheckingDisabled
+	do := state.Do()
+	if validate {
+		state = request.Request{W: w, Req: validating(state.Req)}
+	}
+
 	fails := 0
 	var span, child ot.Span
 	var upstreamErr error
@@ -106,13 +136,13 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		i++
 		if proxy.Down(f.maxfails) {
 			fails++
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 			// All upstream proxies are dead, assume healthcheck is completely broken and randomly
 			// select an upstream to connect to.
 			r := new(random)
-			proxy = r.List(f.proxies)[0]
+			proxy = r.List(list)[0]
 
 			HealthcheckBrokenCount.Add(1)
 		}
@@ -156,7 +186,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				proxy.Healthcheck()
 			}
 
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 			break
@@ -172,15 +202,34 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			return 0, nil
 		}
 
+		// A truncated reply lacks records, the client will retry over TCP.
+		if validate && !ret.Truncated {
+			ret = f.validate(ctx, state, ret, do, r.AuthenticatedData)
+		}
+		switch {
+		case ecs == nil:
+			// The cache still needs the scope of the answer, so it isn't handed out to other subnets.
+			if scope, ok := removeSubnet(ret); ok {
+				if s := edns.ScoperFrom(ctx); s != nil {
+					s.SetScope(scope)
+				}
+			}
+		case f.ecs != nil && f.ecs.strip:
+			restoreSubnet(ret, ecs)
+		}
+		if noEdns {
+			removeOPT(ret)
+		}
 		w.WriteMsg(ret)
 		return 0, nil
 	}
 
+	// The server adds the info-codes of these errors as Extended DNS Errors to the SERVFAIL it writes.
 	if upstreamErr != nil {
-		return dns.RcodeServerFailure, upstreamErr
+		return dns.RcodeServerFailure, edns.NewError(edns.ExtendedErrorNetworkError, upstreamErr)
 	}
 
-	return dns.RcodeServerFailure, ErrNoHealthy
+	return dns.RcodeServerFailure, edns.NewError(edns.ExtendedErrorNoReachableAuthority, ErrNoHealthy)
 }
 
 func (f *Forward) match(state request.Request) bool {
@@ -211,7 +260,11 @@ func (f *Forward) ForceTCP() bool { return f.opts.forceTCP }
 func (f *Forward) PreferUDP() bool { return f.opts.preferUDP }
 
 // List returns a set of proxies to be used for this client depending on the policy in f.
-func (f *Forward) List() []*Proxy { return f.p.List(f.proxies) }
+func (f *Forward) List() []*Proxy {
+	f.proxyMu.RLock()
+	defer f.proxyMu.RUnlock()
+	return f.p.List(f.proxies)
+}
 
 var (
 	// ErrNoHealthy means no healthy proxies left.
@@ -220,6 +273,8 @@ var (
 	ErrNoForward = errors.New("no forwarder defined")
 	// ErrCachedClosed means cached connection was closed by peer.
 	ErrCachedClosed = errors.New("cached connection was closed by peer")
+	// ErrTransportStopped means the transport of the proxy has been stopped.
+	ErrTransportStopped = errors.New("transport stopped")
 )
 
 // options holds various options that can be set.
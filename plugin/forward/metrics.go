@@ -64,4 +64,10 @@ var (
 		Name:      "conn_cache_misses_total",
 		Help:      "Counter of connection cache misses per upstream and protocol.",
 	}, []string{"to", "proto"})
+	ValidationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "dnssec_validations_total",
+		Help:      "Counter of DNSSEC validation results.",
+	}, []string{"result"})
 )
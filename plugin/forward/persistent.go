@@ -131,6 +131,9 @@ func (t *Transport) Yield(pc *persistConn) {
 	select {
 	case t.yield <- pc:
 		return
+	case <-t.stop:
+		pc.c.Close()
+		return
 	case <-time.After(yieldTimeout):
 		return
 	}
@@ -48,6 +48,26 @@ func TestCached(t *testing.T) {
 	tr.Yield(c4)
 }
 
+func TestDialStopped(t *testing.T) {
+	tr := newTransport("127.0.0.1:53")
+	tr.Start()
+	tr.Stop()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := tr.Dial("udp")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrTransportStopped {
+			t.Errorf("Expected %q, got %v", ErrTransportStopped, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dial on a stopped transport blocks")
+	}
+}
+
 func TestCleanupByTimer(t *testing.T) {
 	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
 		ret := new(dns.Msg)
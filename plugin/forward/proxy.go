@@ -65,6 +65,9 @@ func (p *Proxy) Down(maxfails uint32) bool {
 	return fails > maxfails
 }
 
+// key returns the key of p, see proxyKey.
+func (p *Proxy) key() string { return proxyKey(p.addr, p.transport.tlsConfig) }
+
 // close stops the health checking goroutine.
 func (p *Proxy) stop()      { p.probe.Stop() }
 func (p *Proxy) finalizer() { p.transport.Stop() }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/pkg/transport"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
@@ -64,9 +65,13 @@ func TestProxyTLSFail(t *testing.T) {
 	m.SetQuestion("example.org.", dns.TypeA)
 	rec := dnstest.NewRecorder(&test.ResponseWriter{})
 
-	if _, err := f.ServeDNS(context.TODO(), rec, m); err == nil {
+	_, err = f.ServeDNS(context.TODO(), rec, m)
+	if err == nil {
 		t.Fatal("Expected *not* to receive reply, but got one")
 	}
+	if code, ok := edns.ErrorCode(err); !ok || code != edns.ExtendedErrorNetworkError {
+		t.Errorf("Expected the error to carry info-code %d, got %d", edns.ExtendedErrorNetworkError, code)
+	}
 }
 
 func TestProtocolSelection(t *testing.T) {
@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/coredns/coredns/plugin/pkg/parse"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/pkg/validator"
 )
 
 func init() { plugin.Register("forward", setup) }
@@ -51,19 +54,33 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
-// OnStartup starts a goroutines for all proxies.
+// OnStartup starts a goroutines for all proxies, and one to watch the files upstreams are read from.
 func (f *Forward) OnStartup() (err error) {
+	f.proxyMu.RLock()
 	for _, p := range f.proxies {
 		p.start(f.hcInterval)
 	}
+	f.proxyMu.RUnlock()
+
+	if len(f.files) > 0 && f.reload > 0 {
+		f.stopWatch = make(chan bool)
+		go f.watch(f.stopWatch)
+	}
 	return nil
 }
 
 // OnShutdown stops all configured proxies.
 func (f *Forward) OnShutdown() error {
+	if f.stopWatch != nil {
+		close(f.stopWatch)
+		f.stopWatch = nil
+	}
+
+	f.proxyMu.RLock()
 	for _, p := range f.proxies {
 		p.stop()
 	}
+	f.proxyMu.RUnlock()
 	return nil
 }
 
@@ -99,22 +116,16 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		return f, c.ArgErr()
 	}
 
-	toHosts, err := parse.HostPortOrFile(to...)
+	f.to = to
+	toHosts, err := f.upstreams()
 	if err != nil {
 		return f, err
 	}
 
-	transports := make([]string, len(toHosts))
-	allowedTrans := map[string]bool{"dns": true, "tls": true}
-	for i, host := range toHosts {
-		trans, h := parse.Transport(host)
-
-		if !allowedTrans[trans] {
+	for _, host := range toHosts {
+		if trans, _ := parse.Transport(host); !allowedTrans[trans] {
 			return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
 		}
-		p := NewProxy(h, trans)
-		f.proxies = append(f.proxies, p)
-		transports[i] = trans
 	}
 
 	for c.NextBlock() {
@@ -129,20 +140,28 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
 	// in upcoming connections to the same TLS server.
-	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
+	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(toHosts))
 
-	for i := range f.proxies {
-		// Only set this for proxies that need it.
-		if transports[i] == transport.TLS {
-			f.proxies[i].SetTLSConfig(f.tlsConfig)
-		}
-		f.proxies[i].SetExpire(f.expire)
-		f.proxies[i].health.SetRecursionDesired(f.opts.hcRecursionDesired)
+	for _, host := range toHosts {
+		f.proxies = append(f.proxies, f.newProxy(host))
 	}
 
 	return f, nil
 }
 
+// newProxy returns a proxy for host, configured with the TLS, expire and health check settings from f.
+func (f *Forward) newProxy(host string) *Proxy {
+	trans, h := parse.Transport(host)
+	p := NewProxy(h, trans)
+	// Only set this for proxies that need it.
+	if trans == transport.TLS {
+		p.SetTLSConfig(f.tlsConfig)
+	}
+	p.SetExpire(f.expire)
+	p.health.SetRecursionDesired(f.opts.hcRecursionDesired)
+	return p
+}
+
 func parseBlock(c *caddy.Controller, f *Forward) error {
 	switch c.Val() {
 	case "except":
@@ -253,6 +272,73 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		}
 		f.ErrLimitExceeded = errors.New("concurrent queries exceeded maximum " + c.Val())
 		f.maxConcurrent = int64(n)
+	case "reload":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		dur, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return err
+		}
+		if dur < 0 {
+			return fmt.Errorf("reload can't be negative: %s", dur)
+		}
+		f.reload = dur
+	case "ecs_add":
+		args := c.RemainingArgs()
+		if len(args) > 2 {
+			return c.ArgErr()
+		}
+		if f.ecs == nil {
+			f.ecs = newClientSubnet()
+		}
+		f.ecs.add = true
+		if len(args) > 0 {
+			n, err := strconv.ParseUint(args[0], 10, 8)
+			if err != nil {
+				return err
+			}
+			if n > net.IPv4len*8 {
+				return fmt.Errorf("invalid IPv4 source prefix length: %d", n)
+			}
+			f.ecs.v4Prefix = uint8(n)
+		}
+		if len(args) > 1 {
+			n, err := strconv.ParseUint(args[1], 10, 8)
+			if err != nil {
+				return err
+			}
+			if n > net.IPv6len*8 {
+				return fmt.Errorf("invalid IPv6 source prefix length: %d", n)
+			}
+			f.ecs.v6Prefix = uint8(n)
+		}
+	case "ecs_strip":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		if f.ecs == nil {
+			f.ecs = newClientSubnet()
+		}
+		f.ecs.strip = true
+	case "validate":
+		files := c.RemainingArgs()
+		anchors := validator.RootAnchors()
+		if len(files) > 0 {
+			anchors = nil
+		}
+		config := dnsserver.GetConfig(c)
+		for _, file := range files {
+			if !filepath.IsAbs(file) && config.Root != "" {
+				file = filepath.Join(config.Root, file)
+			}
+			a, err := validator.ReadAnchorFile(file)
+			if err != nil {
+				return err
+			}
+			anchors = append(anchors, a...)
+		}
+		f.validator = validator.New(anchors)
 
 	default:
 		return c.Errf("unknown property '%s'", c.Val())
@@ -262,3 +348,6 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 }
 
 const max = 15 // Maximum number of upstreams.
+
+// allowedTrans are the transports forward can use to talk to upstreams.
+var allowedTrans = map[string]bool{"dns": true, "tls": true}
@@ -0,0 +1,104 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/pkg/validator"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// validating returns a copy of r that asks the upstream for the signatures and denial of existence records
+// we need to validate the response.
+func validating(r *dns.Msg) *dns.Msg {
+	m := r.Copy()
+	if o := m.IsEdns0(); o != nil {
+		o.SetDo()
+		if o.UDPSize() < defaultValidateBufSize {
+			o.SetUDPSize(defaultValidateBufSize)
+		}
+	} else {
+		m.SetEdns0(defaultValidateBufSize, true)
+	}
+	m.CheckingDisabled = true
+	return m
+}
+
+// validate validates ret, the upstream's reply to state. The AD bit is set for secure replies, and bogus replies
+// are turned into a SERVFAIL with an Extended DNS Error that tells why. The DNSSEC records are removed if the client (as described by do) didn't ask for them.
+func (f *Forward) validate(ctx context.Context, state request.Request, ret *dns.Msg, do, ad bool) *dns.Msg {
+	result, err := f.validator.Validate(ctx, ret, f.lookup(state))
+	ValidationCount.WithLabelValues(result.String()).Add(1)
+
+	switch result {
+	case validator.Bogus:
+		log.Warningf("Validation of %s %s failed: %s", state.QName(), state.Type(), err)
+		m := new(dns.Msg)
+		m.SetRcode(state.Req, dns.RcodeServerFailure)
+		code := edns.ExtendedErrorDNSSECBogus
+		if e, ok := err.(*validator.Error); ok {
+			code = e.Reason.ExtendedError()
+		}
+		edns.SetExtendedError(m, code, err.Error())
+		return m
+	case validator.Secure:
+		// RFC 6840, section 5.7: only set AD when the client indicated it understands it.
+		ret.AuthenticatedData = do || ad
+	default:
+		ret.AuthenticatedData = false
+	}
+	ret.CheckingDisabled = false
+
+	if !do {
+		ret.Answer = validator.FilterDNSSEC(ret.Answer, state.QType())
+		ret.Ns = validator.FilterDNSSEC(ret.Ns, state.QType())
+		ret.Extra = validator.FilterDNSSEC(ret.Extra, state.QType())
+	}
+	return ret
+}
+
+// lookup returns a validator.Lookup that queries the upstreams for the DNSKEY and DS records the validator needs.
+func (f *Forward) lookup(state request.Request) validator.Lookup {
+	return func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		m.SetEdns0(defaultValidateBufSize, true)
+		m.CheckingDisabled = true
+		q := request.Request{W: state.W, Req: m}
+
+		var (
+			ret *dns.Msg
+			err = ErrNoHealthy
+		)
+		for _, proxy := range f.List() {
+			if proxy.Down(f.maxfails) {
+				continue
+			}
+			opts := f.opts
+			for {
+				ret, err = proxy.Connect(ctx, q, opts)
+				if err == ErrCachedClosed {
+					continue
+				}
+				if ret != nil && ret.Truncated && !opts.forceTCP {
+					opts.forceTCP = true
+					continue
+				}
+				break
+			}
+			if err != nil {
+				continue
+			}
+			if q.Match(ret) {
+				return ret, nil
+			}
+			err = fmt.Errorf("wrong reply for %s %s", name, dns.Type(qtype))
+		}
+		return nil, err
+	}
+}
+
+const defaultValidateBufSize = 1232 // EDNS0 buffer size used for queries when validating.
@@ -0,0 +1,196 @@
+package forward
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/pkg/validator"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	anchors := filepath.Join(dir, "anchors")
+	if err := ioutil.WriteFile(anchors, []byte("example.org. IN DS 60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expectedErr string
+	}{
+		// positive
+		{"forward . 127.0.0.1 {\nvalidate\n}\n", false, ""},
+		{"forward . 127.0.0.1 {\nvalidate " + anchors + "\n}\n", false, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nvalidate " + filepath.Join(dir, "missing") + "\n}\n", true, "no such file"},
+		{"forward . 127.0.0.1 {\nvalidate /dev/null\n}\n", true, "no trust anchors"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			continue
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if f.validator == nil {
+			t.Errorf("Test %d: expected validator to be set", i)
+		}
+	}
+}
+
+// signedZone is a minimal signed example.org. zone, used as the upstream in TestValidate.
+type signedZone struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func (z *signedZone) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: "example.org.",
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func TestValidate(t *testing.T) {
+	z := &signedZone{key: &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}}
+	priv, err := z.key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.priv = priv.(crypto.Signer)
+
+	dnskey := z.sign(t, z.key)
+	nsec := z.sign(t, test.NSEC("www.example.org. 3600 IN NSEC zzz.example.org. A RRSIG NSEC"))
+	a := z.sign(t, test.A("www.example.org. 3600 IN A 127.0.0.1"))
+	bogus := z.sign(t, test.A("bogus.example.org. 3600 IN A 127.0.0.1"))
+	bogus[0].(*dns.A).A = []byte{127, 0, 0, 2}
+	bogusNsec := z.sign(t, test.NSEC("bogus.example.org. 3600 IN NSEC www.example.org. A RRSIG NSEC"))
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if !r.IsEdns0().Do() || !r.CheckingDisabled {
+			ret.Rcode = dns.RcodeRefused
+			w.WriteMsg(ret)
+			return
+		}
+		ret.SetEdns0(4096, true)
+		switch r.Question[0].Name + dns.Type(r.Question[0].Qtype).String() {
+		case "example.org.DNSKEY":
+			ret.Answer = dnskey
+		case "www.example.org.DS":
+			ret.Ns = nsec
+		case "www.example.org.A":
+			ret.Answer = a
+		case "bogus.example.org.DS":
+			ret.Ns = bogusNsec
+		case "bogus.example.org.A":
+			ret.Answer = bogus
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+s.Addr)
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.validator = validator.New([]dns.RR{z.key})
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	tests := []struct {
+		qname        string
+		do, cd       bool
+		expectedCode int
+		expectedAD   bool
+		expectedSigs int
+	}{
+		{"www.example.org.", true, false, dns.RcodeSuccess, true, 1},
+		{"www.example.org.", false, false, dns.RcodeSuccess, false, 0},
+		{"www.example.org.", true, true, dns.RcodeSuccess, false, 1},
+		{"bogus.example.org.", false, false, dns.RcodeServerFailure, false, 0},
+		{"bogus.example.org.", true, false, dns.RcodeServerFailure, false, 0},
+		{"bogus.example.org.", true, true, dns.RcodeSuccess, false, 1},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		m.CheckingDisabled = tc.cd
+		if tc.do {
+			m.SetEdns0(4096, true)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		ret := rec.Msg
+		if ret.Rcode != tc.expectedCode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.expectedCode], dns.RcodeToString[ret.Rcode])
+		}
+		if ret.AuthenticatedData != tc.expectedAD {
+			t.Errorf("Test %d: expected AD to be %t", i, tc.expectedAD)
+		}
+		sigs := 0
+		for _, rr := range ret.Answer {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				sigs++
+			}
+		}
+		if sigs != tc.expectedSigs {
+			t.Errorf("Test %d: expected %d signatures, got %d", i, tc.expectedSigs, sigs)
+		}
+		if !tc.do && ret.IsEdns0() != nil {
+			t.Errorf("Test %d: expected no OPT record in reply", i)
+		}
+		if _, _, ok := edns.ExtendedError(ret); ok != (tc.do && tc.expectedCode == dns.RcodeServerFailure) {
+			t.Errorf("Test %d: expected an extended error to be %t", i, !ok)
+		}
+	}
+}
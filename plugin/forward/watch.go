@@ -0,0 +1,211 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+)
+
+// fileStamp holds the modification time and size of a file, these are used to detect changes.
+type fileStamp struct {
+	mtime time.Time
+	size  int64
+}
+
+// upstreams returns the upstreams listed in f.to. Any element of f.to that is a file is read, and
+// recorded in f.files so it can be watched for changes. A file that contains 'nameserver' lines is
+// parsed as a resolv.conf, any other file is taken to list an upstream on each line.
+func (f *Forward) upstreams() ([]string, error) {
+	var hosts []string
+	files := map[string]fileStamp{}
+	for _, h := range f.to {
+		_, path := parse.Transport(h)
+		if !isFile(path) {
+			hs, err := parse.HostPortOrFile(h)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, hs...)
+			continue
+		}
+
+		hs, stamp, err := readUpstreamFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = stamp
+		hosts = append(hosts, hs...)
+	}
+	if len(files) > 0 {
+		f.files = files
+	}
+	return hosts, nil
+}
+
+// isFile returns true if s is not an address, but an existing file.
+func isFile(s string) bool {
+	if net.ParseIP(s) != nil {
+		return false
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil && net.ParseIP(host) != nil {
+		return false
+	}
+	fi, err := os.Stat(s)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// readUpstreamFile reads the upstreams from the file path. See (*Forward).upstreams for the formats
+// we support.
+func readUpstreamFile(path string) ([]string, fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fileStamp{}, err
+	}
+	stamp := fileStamp{mtime: fi.ModTime(), size: fi.Size()}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, stamp, err
+	}
+
+	var list []string
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == "nameserver" {
+			// This is a resolv.conf, let the parse package deal with it.
+			hosts, err := parse.HostPortOrFile(path)
+			return hosts, stamp, err
+		}
+		list = append(list, fields...)
+	}
+	if len(list) == 0 {
+		return nil, stamp, fmt.Errorf("no nameservers found in %q", path)
+	}
+	hosts, err := parse.HostPortOrFile(list...)
+	return hosts, stamp, err
+}
+
+// watch checks the files we read upstreams from every f.reload and updates the proxies when any of
+// them changed. It returns when stop is closed.
+func (f *Forward) watch(stop chan bool) {
+	ticker := time.NewTicker(f.reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !f.changed() {
+				continue
+			}
+			if err := f.updateProxies(); err != nil {
+				log.Warningf("Failed to update upstreams, keeping the current ones: %s", err)
+			}
+		}
+	}
+}
+
+// changed returns true if one of the files we read upstreams from has changed since we last looked at it.
+// The stamps in f.files are only read and modified by the watch goroutine (after setup), they are updated
+// here so that a file with errors is only complained about once.
+func (f *Forward) changed() bool {
+	changed := false
+	for path, stamp := range f.files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// File is (temporarily) gone, keep what we have.
+			continue
+		}
+		if !stamp.mtime.Equal(fi.ModTime()) || stamp.size != fi.Size() {
+			f.files[path] = fileStamp{mtime: fi.ModTime(), size: fi.Size()}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// updateProxies re-reads the upstreams and swaps in the new list of proxies. Proxies for upstreams that remain
+// are kept, so their health state and cached connections survive. Proxies that are new are started, and the ones
+// that are removed are stopped.
+func (f *Forward) updateProxies() error {
+	hosts, err := f.upstreams()
+	if err != nil {
+		return err
+	}
+	if len(hosts) > max {
+		return fmt.Errorf("more than %d TOs configured: %d", max, len(hosts))
+	}
+	for _, host := range hosts {
+		if trans, _ := parse.Transport(host); !allowedTrans[trans] {
+			return fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
+		}
+	}
+
+	f.proxyMu.Lock()
+	current := make(map[string]*Proxy, len(f.proxies))
+	for _, p := range f.proxies {
+		current[p.key()] = p
+	}
+
+	proxies := make([]*Proxy, 0, len(hosts))
+	var started []*Proxy
+	for _, host := range hosts {
+		trans, addr := parse.Transport(host)
+		var cfg *tls.Config
+		if trans == transport.TLS {
+			cfg = f.tlsConfig
+		}
+		k := proxyKey(addr, cfg)
+		if p, ok := current[k]; ok {
+			proxies = append(proxies, p)
+			delete(current, k)
+			continue
+		}
+		p := f.newProxy(host)
+		proxies = append(proxies, p)
+		started = append(started, p)
+	}
+	f.proxies = proxies
+	f.proxyMu.Unlock()
+
+	for _, p := range started {
+		p.start(f.hcInterval)
+	}
+	// What's left in current has been removed. Queries in flight may still use these proxies; their transports
+	// are stopped when the proxies are garbage collected, and a stopped transport returns ErrTransportStopped.
+	for _, p := range current {
+		p.stop()
+	}
+
+	log.Infof("Upstreams updated to: %s", strings.Join(hosts, ", "))
+	return nil
+}
+
+// proxyKey returns the key under which updateProxies finds the proxy for addr, which uses the TLS config cfg, nil
+// for plain DNS. A proxy is only kept when the address, the transport and the TLS server name are unchanged.
+func proxyKey(addr string, cfg *tls.Config) string {
+	if cfg == nil {
+		return transport.DNS + "://" + addr
+	}
+	return transport.TLS + "://" + addr + " " + cfg.ServerName
+}
+
+// defaultReload is the default interval we check the files with upstreams for changes.
+const defaultReload = 5 * time.Second
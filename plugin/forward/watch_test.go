@@ -0,0 +1,233 @@
+package forward
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestReadUpstreamFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		content       string
+		shouldErr     bool
+		expectedHosts []string
+	}{
+		{"nameserver 10.10.255.252\nnameserver 10.10.255.253\n", false, []string{"10.10.255.252:53", "10.10.255.253:53"}},
+		{"# comment\nsearch example.org\nnameserver 10.10.255.252\n", false, []string{"10.10.255.252:53"}},
+		{"10.10.255.252\n\n10.10.255.253:1053 # a comment\ntls://10.10.255.254\n", false, []string{"10.10.255.252:53", "10.10.255.253:1053", "tls://10.10.255.254:853"}},
+		{"10.10.255.252 [2003::1]:53\n", false, []string{"10.10.255.252:53", "[2003::1]:53"}},
+		{"# nothing here\n", true, nil},
+		{"not-an-address\n", true, nil},
+	}
+
+	for i, tc := range tests {
+		path := filepath.Join(dir, "upstreams")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hosts, _, err := readUpstreamFile(path)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if strings.Join(hosts, " ") != strings.Join(tc.expectedHosts, " ") {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.expectedHosts, hosts)
+		}
+	}
+}
+
+func TestSetupReload(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expectedVal time.Duration
+		expectedErr string
+	}{
+		// positive
+		{"forward . 127.0.0.1", false, defaultReload, ""},
+		{"forward . 127.0.0.1 {\nreload 10s\n}\n", false, 10 * time.Second, ""},
+		{"forward . 127.0.0.1 {\nreload 0s\n}\n", false, 0, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nreload\n}\n", true, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nreload -1s\n}\n", true, 0, "negative"},
+		{"forward . 127.0.0.1 {\nreload often\n}\n", true, 0, "invalid duration"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			continue
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if f.reload != test.expectedVal {
+			t.Errorf("Test %d: expected: %s, got: %s", i, test.expectedVal, f.reload)
+		}
+	}
+}
+
+func TestUpdateProxies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	resolv := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(resolv, []byte("nameserver 10.10.255.252\nnameserver 10.10.255.253\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . 10.10.255.251 "+resolv+" {\nreload 0\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	if len(f.files) != 1 {
+		t.Fatalf("Expected 1 file to be watched, got %d", len(f.files))
+	}
+
+	kept := f.proxies[2]
+	kept.fails = 3
+
+	if err := ioutil.WriteFile(resolv, []byte("nameserver 10.10.255.253\nnameserver 10.10.255.254\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.updateProxies(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	expected := []string{"10.10.255.251:53", "10.10.255.253:53", "10.10.255.254:53"}
+	if f.Len() != len(expected) {
+		t.Fatalf("Expected %d proxies, got %d", len(expected), f.Len())
+	}
+	for i, p := range f.proxies {
+		if p.addr != expected[i] {
+			t.Errorf("Expected proxy %d to be %q, got %q", i, expected[i], p.addr)
+		}
+	}
+	if f.proxies[1] != kept || f.proxies[1].fails != 3 {
+		t.Errorf("Expected proxy for %q to be kept, including its health state", kept.addr)
+	}
+
+	// An empty file does not remove all upstreams.
+	if err := ioutil.WriteFile(resolv, []byte("# empty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.updateProxies(); err == nil {
+		t.Errorf("Expected error for empty file, got none")
+	}
+	if f.Len() != len(expected) {
+		t.Errorf("Expected %d proxies, got %d", len(expected), f.Len())
+	}
+}
+
+func TestUpdateProxiesTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	upstreams := filepath.Join(dir, "upstreams")
+	if err := ioutil.WriteFile(upstreams, []byte("10.10.255.252:853\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . "+upstreams+" {\nreload 0\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// Same address, but now over TLS: the plain DNS proxy can't be kept.
+	old := f.proxies[0]
+	if err := ioutil.WriteFile(upstreams, []byte("tls://10.10.255.252:853\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.updateProxies(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if f.proxies[0] == old {
+		t.Fatalf("Expected a new proxy when the transport changes")
+	}
+	if f.proxies[0].transport.tlsConfig == nil {
+		t.Errorf("Expected the new proxy to use TLS")
+	}
+
+	// And a new server name is a new proxy as well.
+	old = f.proxies[0]
+	f.tlsConfig = f.tlsConfig.Clone()
+	f.tlsConfig.ServerName = "dns.example.org"
+	if err := f.updateProxies(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if f.proxies[0] == old {
+		t.Errorf("Expected a new proxy when the TLS server name changes")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	upstreams := filepath.Join(dir, "upstreams")
+	if err := ioutil.WriteFile(upstreams, []byte("10.10.255.252\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . "+upstreams+" {\nreload 10ms\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	if err := ioutil.WriteFile(upstreams, []byte("10.10.255.252\n10.10.255.253\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if f.Len() == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected 2 proxies after the file changed, got %d", f.Len())
+}
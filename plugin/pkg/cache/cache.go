@@ -1,9 +1,10 @@
 // Package cache implements a cache. The cache hold 256 shards, each shard
-// holds a cache: a map with a mutex. There is no fancy expunge algorithm, it
-// just randomly evicts elements when it gets full.
+// holds a cache: a map with a mutex. When a shard gets full an element is evicted
+// according to the cache's Policy, by default a random element is evicted.
 package cache
 
 import (
+	"container/list"
 	"hash/fnv"
 	"sync"
 )
@@ -15,21 +16,43 @@ func Hash(what []byte) uint64 {
 	return h.Sum64()
 }
 
+// Policy is the eviction policy of a cache.
+type Policy int
+
+const (
+	// Random evicts a random element.
+	Random Policy = iota
+	// LRU evicts the least recently used element.
+	LRU
+	// LFU evicts the least recently used element, but only admits a new element when it's used more often
+	// than the element it replaces (TinyLFU). This keeps popular elements in the cache when lots of
+	// elements are only used once.
+	LFU
+)
+
 // Cache is cache.
 type Cache struct {
 	shards [shardSize]*shard
 }
 
-// shard is a cache with random eviction.
+// shard is a cache with random, LRU or LFU eviction.
 type shard struct {
 	items map[uint64]interface{}
 	size  int
 
+	policy Policy
+	recent *list.List               // LRU and LFU: keys, most recently used first.
+	elems  map[uint64]*list.Element // LRU and LFU: key -> element in recent.
+	freq   *sketch                  // LFU: estimated frequencies of keys.
+
 	sync.RWMutex
 }
 
-// New returns a new cache.
-func New(size int) *Cache {
+// New returns a new cache with random eviction.
+func New(size int) *Cache { return NewWithPolicy(size, Random) }
+
+// NewWithPolicy returns a new cache that evicts elements according to p.
+func NewWithPolicy(size int, p Policy) *Cache {
 	ssize := size / shardSize
 	if ssize < 4 {
 		ssize = 4
@@ -39,7 +62,7 @@ func New(size int) *Cache {
 
 	// Initialize all the shards
 	for i := 0; i < shardSize; i++ {
-		c.shards[i] = newShard(ssize)
+		c.shards[i] = newPolicyShard(ssize, p)
 	}
 	return c
 }
@@ -50,6 +73,13 @@ func (c *Cache) Add(key uint64, el interface{}) {
 	c.shards[shard].Add(key, el)
 }
 
+// Admit is Add, but it reports whether the element was admitted (with LFU it may not be), and whether another
+// element was evicted to make room.
+func (c *Cache) Admit(key uint64, el interface{}) (admitted, evicted bool) {
+	shard := key & (shardSize - 1)
+	return c.shards[shard].Admit(key, el)
+}
+
 // Get looks up element index under key.
 func (c *Cache) Get(key uint64) (interface{}, bool) {
 	shard := key & (shardSize - 1)
@@ -71,46 +101,105 @@ func (c *Cache) Len() int {
 	return l
 }
 
-// newShard returns a new shard with size.
-func newShard(size int) *shard { return &shard{items: make(map[uint64]interface{}), size: size} }
+// Walk calls f for each element in the cache, until f returns false. Elements added or removed while
+// walking may or may not be seen.
+func (c *Cache) Walk(f func(key uint64, el interface{}) bool) {
+	for _, s := range c.shards {
+		if !s.Walk(f) {
+			return
+		}
+	}
+}
+
+// newShard returns a new shard with size and random eviction.
+func newShard(size int) *shard { return newPolicyShard(size, Random) }
+
+// newPolicyShard returns a new shard with size that evicts according to p.
+func newPolicyShard(size int, p Policy) *shard {
+	s := &shard{items: make(map[uint64]interface{}), size: size, policy: p}
+	if p == LRU || p == LFU {
+		s.recent = list.New()
+		s.elems = make(map[uint64]*list.Element)
+	}
+	if p == LFU {
+		s.freq = newSketch(size)
+	}
+	return s
+}
 
 // Add adds element indexed by key into the cache. Any existing element is overwritten
-func (s *shard) Add(key uint64, el interface{}) {
+func (s *shard) Add(key uint64, el interface{}) { s.Admit(key, el) }
+
+// Admit adds element indexed by key into the cache, like Add. It returns true if the element was admitted,
+// and true if another element was evicted.
+func (s *shard) Admit(key uint64, el interface{}) (bool, bool) {
 	s.Lock()
+	defer s.Unlock()
+
+	if s.freq != nil {
+		s.freq.increment(key)
+	}
+
+	if _, ok := s.items[key]; ok {
+		s.items[key] = el
+		s.touch(key)
+		return true, false
+	}
+
+	evicted := false
 	if len(s.items) >= s.size {
-		if _, ok := s.items[key]; !ok {
-			for k := range s.items {
-				delete(s.items, k)
-				break
-			}
+		victim, ok := s.victim()
+		if ok && s.freq != nil && s.freq.estimate(key) <= s.freq.estimate(victim) {
+			// The element we would evict is more popular than the new one: don't admit it.
+			return false, false
+		}
+		if ok {
+			s.remove(victim)
+			evicted = true
 		}
 	}
 	s.items[key] = el
-	s.Unlock()
+	if s.elems != nil {
+		s.elems[key] = s.recent.PushFront(key)
+	}
+	return true, evicted
 }
 
 // Remove removes the element indexed by key from the cache.
 func (s *shard) Remove(key uint64) {
 	s.Lock()
-	delete(s.items, key)
+	s.remove(key)
 	s.Unlock()
 }
 
-// Evict removes a random element from the cache.
+// Evict removes an element from the cache, chosen according to the eviction policy.
 func (s *shard) Evict() {
 	s.Lock()
-	for k := range s.items {
-		delete(s.items, k)
-		break
+	if victim, ok := s.victim(); ok {
+		s.remove(victim)
 	}
 	s.Unlock()
 }
 
 // Get looks up the element indexed under key.
 func (s *shard) Get(key uint64) (interface{}, bool) {
-	s.RLock()
+	if s.policy == Random {
+		s.RLock()
+		el, found := s.items[key]
+		s.RUnlock()
+		return el, found
+	}
+
+	// Both LRU and LFU need to record the use of key.
+	s.Lock()
 	el, found := s.items[key]
-	s.RUnlock()
+	if found {
+		s.touch(key)
+	}
+	if s.freq != nil {
+		s.freq.increment(key)
+	}
+	s.Unlock()
 	return el, found
 }
 
@@ -122,4 +211,55 @@ func (s *shard) Len() int {
 	return l
 }
 
+// Walk calls f for each element in the shard, until f returns false. It returns false if f did.
+// f is called without holding the lock, so it may access the shard.
+func (s *shard) Walk(f func(key uint64, el interface{}) bool) bool {
+	s.RLock()
+	keys := make([]uint64, 0, len(s.items))
+	els := make([]interface{}, 0, len(s.items))
+	for k, el := range s.items {
+		keys = append(keys, k)
+		els = append(els, el)
+	}
+	s.RUnlock()
+
+	for i := range keys {
+		if !f(keys[i], els[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// victim returns the key of the element to evict. The lock must be held.
+func (s *shard) victim() (uint64, bool) {
+	if s.recent != nil {
+		e := s.recent.Back()
+		if e == nil {
+			return 0, false
+		}
+		return e.Value.(uint64), true
+	}
+	for k := range s.items {
+		return k, true
+	}
+	return 0, false
+}
+
+// touch marks key as the most recently used. The lock must be held.
+func (s *shard) touch(key uint64) {
+	if e, ok := s.elems[key]; ok {
+		s.recent.MoveToFront(e)
+	}
+}
+
+// remove removes key from the shard. The lock must be held.
+func (s *shard) remove(key uint64) {
+	delete(s.items, key)
+	if e, ok := s.elems[key]; ok {
+		s.recent.Remove(e)
+		delete(s.elems, key)
+	}
+}
+
 const shardSize = 256
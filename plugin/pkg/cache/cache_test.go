@@ -1,6 +1,10 @@
 package cache
 
-import "testing"
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
 
 func TestCacheAddAndGet(t *testing.T) {
 	const N = shardSize * 4
@@ -53,6 +57,34 @@ func TestCacheSharding(t *testing.T) {
 	}
 }
 
+func TestCacheWalk(t *testing.T) {
+	c := New(shardSize * 4)
+	for i := 0; i < 100; i++ {
+		c.Add(uint64(i), i)
+	}
+
+	seen := make(map[uint64]bool)
+	c.Walk(func(k uint64, el interface{}) bool {
+		if el.(int) != int(k) {
+			t.Errorf("Expected element %d for key %d, got %d", k, k, el)
+		}
+		seen[k] = true
+		return true
+	})
+	if len(seen) != 100 {
+		t.Errorf("Expected to walk %d elements, got %d", 100, len(seen))
+	}
+
+	n := 0
+	c.Walk(func(k uint64, el interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("Expected walk to stop after %d elements, got %d", 10, n)
+	}
+}
+
 func BenchmarkCache(b *testing.B) {
 	b.ReportAllocs()
 
@@ -62,3 +94,36 @@ func BenchmarkCache(b *testing.B) {
 		c.Get(1)
 	}
 }
+
+// BenchmarkCachePolicy runs a skewed (Zipf) workload against the eviction policies and reports the hit ratio.
+func BenchmarkCachePolicy(b *testing.B) {
+	for _, p := range []struct {
+		name   string
+		policy Policy
+	}{{"random", Random}, {"lru", LRU}, {"lfu", LFU}} {
+		b.Run(p.name, func(b *testing.B) {
+			c := NewWithPolicy(shardSize*16, p.policy)
+			z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, shardSize*1024)
+			hits := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				k := z.Uint64()
+				if _, ok := c.Get(k); ok {
+					hits++
+					continue
+				}
+				c.Add(k, k)
+			}
+			b.ReportMetric(float64(hits)/float64(b.N), "hits/op")
+		})
+	}
+}
+
+func ExampleNewWithPolicy() {
+	c := NewWithPolicy(1024, LRU)
+	c.Add(1, "one")
+	el, _ := c.Get(1)
+	fmt.Println(el)
+	// Output: one
+}
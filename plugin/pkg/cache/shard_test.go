@@ -116,6 +116,82 @@ func TestShardEvictParallel(t *testing.T) {
 	}
 }
 
+func TestShardLRU(t *testing.T) {
+	s := newPolicyShard(2, LRU)
+	s.Add(1, 1)
+	s.Add(2, 2)
+	s.Get(1) // 2 is now the least recently used.
+
+	if admitted, evicted := s.Admit(3, 3); !admitted || !evicted {
+		t.Fatal("Expected an element to be evicted")
+	}
+	if _, found := s.Get(2); found {
+		t.Fatal("Found item that should have been evicted")
+	}
+	for _, k := range []uint64{1, 3} {
+		if _, found := s.Get(k); !found {
+			t.Fatalf("Failed to find record %d", k)
+		}
+	}
+
+	s.Remove(1)
+	if s.Len() != 1 || s.recent.Len() != 1 || len(s.elems) != 1 {
+		t.Fatalf("Expected 1 element after remove, got %d, %d, %d", s.Len(), s.recent.Len(), len(s.elems))
+	}
+}
+
+func TestShardLFU(t *testing.T) {
+	s := newPolicyShard(4, LFU)
+	for k := uint64(1); k <= 4; k++ {
+		s.Add(k, k)
+		for i := 0; i < 5; i++ {
+			s.Get(k)
+		}
+	}
+
+	// A scan of keys used only once must not push out the popular ones.
+	for k := uint64(100); k < 200; k++ {
+		s.Get(k)
+		if admitted, _ := s.Admit(k, k); admitted {
+			t.Errorf("Expected record %d not to be admitted", k)
+		}
+	}
+	for k := uint64(1); k <= 4; k++ {
+		if _, found := s.Get(k); !found {
+			t.Errorf("Popular record %d was evicted", k)
+		}
+	}
+
+	// Once a key is used more often than the least recently used one, it is admitted.
+	for i := 0; i < 10; i++ {
+		s.Get(300)
+	}
+	if admitted, evicted := s.Admit(300, 300); !admitted || !evicted {
+		t.Error("Expected popular record to be admitted")
+	}
+	if _, found := s.Get(300); !found {
+		t.Error("Failed to find admitted record")
+	}
+	if s.Len() != 4 {
+		t.Errorf("Shard size should %d, got %d", 4, s.Len())
+	}
+}
+
+func TestShardEvictPolicy(t *testing.T) {
+	for _, p := range []Policy{Random, LRU, LFU} {
+		s := newPolicyShard(4, p)
+		for k := uint64(0); k < 4; k++ {
+			s.Add(k, k)
+		}
+		for i := 0; i < 4; i++ {
+			s.Evict()
+		}
+		if s.Len() != 0 {
+			t.Errorf("Policy %d: failed to evict all keys: %d", p, s.Len())
+		}
+	}
+}
+
 func BenchmarkShard(b *testing.B) {
 	s := newShard(shardSize)
 	b.ResetTimer()
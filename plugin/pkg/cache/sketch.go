@@ -0,0 +1,73 @@
+package cache
+
+// sketch is a count-min sketch that estimates how often keys are used. It uses 4 rows of 4 bit counters
+// (kept in a byte each, for simplicity). The counters are halved after a number of increments, so the
+// estimates favor recent use, as in TinyLFU (https://arxiv.org/abs/1512.00727).
+type sketch struct {
+	rows  [sketchDepth][]uint8
+	mask  uint64
+	added int
+	reset int // halve all counters after this many increments.
+}
+
+// newSketch returns a sketch sized for a cache with size elements.
+func newSketch(size int) *sketch {
+	width := 64
+	for width < 4*size {
+		width *= 2
+	}
+	s := &sketch{mask: uint64(width - 1), reset: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter of key in row i.
+func (s *sketch) index(key uint64, i int) uint64 {
+	h := key * sketchSeeds[i]
+	h ^= h >> 32
+	return h & s.mask
+}
+
+// increment records a use of key.
+func (s *sketch) increment(key uint64) {
+	for i := range s.rows {
+		j := s.index(key, i)
+		if s.rows[i][j] < sketchMax {
+			s.rows[i][j]++
+		}
+	}
+	s.added++
+	if s.added >= s.reset {
+		s.age()
+	}
+}
+
+// estimate returns the estimated number of uses of key.
+func (s *sketch) estimate(key uint64) uint8 {
+	min := uint8(sketchMax)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(key, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// age halves all counters.
+func (s *sketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.added /= 2
+}
+
+const (
+	sketchDepth = 4
+	sketchMax   = 15
+)
+
+var sketchSeeds = [sketchDepth]uint64{0x9e3779b97f4a7c15, 0xc2b2ae3d27d4eb4f, 0x165667b19e3779f9, 0xd6e8feb86659fd93}
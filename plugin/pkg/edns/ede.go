@@ -0,0 +1,137 @@
+package edns
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/miekg/dns"
+)
+
+// EDNS0EDE is the option code of the Extended DNS Error option (RFC 8914).
+const EDNS0EDE = 15
+
+// The Extended DNS Error info-codes from RFC 8914.
+const (
+	ExtendedErrorOther uint16 = iota
+	ExtendedErrorUnsupportedDNSKEYAlgorithm
+	ExtendedErrorUnsupportedDSDigestType
+	ExtendedErrorStaleAnswer
+	ExtendedErrorForgedAnswer
+	ExtendedErrorDNSSECIndeterminate
+	ExtendedErrorDNSSECBogus
+	ExtendedErrorSignatureExpired
+	ExtendedErrorSignatureNotYetValid
+	ExtendedErrorDNSKEYMissing
+	ExtendedErrorRRSIGsMissing
+	ExtendedErrorNoZoneKeyBitSet
+	ExtendedErrorNSECMissing
+	ExtendedErrorCachedError
+	ExtendedErrorNotReady
+	ExtendedErrorBlocked
+	ExtendedErrorCensored
+	ExtendedErrorFiltered
+	ExtendedErrorProhibited
+	ExtendedErrorStaleNXDOMAINAnswer
+	ExtendedErrorNotAuthoritative
+	ExtendedErrorNotSupported
+	ExtendedErrorNoReachableAuthority
+	ExtendedErrorNetworkError
+	ExtendedErrorInvalidData
+)
+
+// ExtendedErrorToString maps the Extended DNS Error info-codes to their names in RFC 8914.
+var ExtendedErrorToString = map[uint16]string{
+	ExtendedErrorOther:                      "Other",
+	ExtendedErrorUnsupportedDNSKEYAlgorithm: "Unsupported DNSKEY Algorithm",
+	ExtendedErrorUnsupportedDSDigestType:    "Unsupported DS Digest Type",
+	ExtendedErrorStaleAnswer:                "Stale Answer",
+	ExtendedErrorForgedAnswer:               "Forged Answer",
+	ExtendedErrorDNSSECIndeterminate:        "DNSSEC Indeterminate",
+	ExtendedErrorDNSSECBogus:                "DNSSEC Bogus",
+	ExtendedErrorSignatureExpired:           "Signature Expired",
+	ExtendedErrorSignatureNotYetValid:       "Signature Not Yet Valid",
+	ExtendedErrorDNSKEYMissing:              "DNSKEY Missing",
+	ExtendedErrorRRSIGsMissing:              "RRSIGs Missing",
+	ExtendedErrorNoZoneKeyBitSet:            "No Zone Key Bit Set",
+	ExtendedErrorNSECMissing:                "NSEC Missing",
+	ExtendedErrorCachedError:                "Cached Error",
+	ExtendedErrorNotReady:                   "Not Ready",
+	ExtendedErrorBlocked:                    "Blocked",
+	ExtendedErrorCensored:                   "Censored",
+	ExtendedErrorFiltered:                   "Filtered",
+	ExtendedErrorProhibited:                 "Prohibited",
+	ExtendedErrorStaleNXDOMAINAnswer:        "Stale NXDOMAIN Answer",
+	ExtendedErrorNotAuthoritative:           "Not Authoritative",
+	ExtendedErrorNotSupported:               "Not Supported",
+	ExtendedErrorNoReachableAuthority:       "No Reachable Authority",
+	ExtendedErrorNetworkError:               "Network Error",
+	ExtendedErrorInvalidData:                "Invalid Data",
+}
+
+// SetExtendedError adds an Extended DNS Error option with info-code code and extra text to m. An OPT record is
+// added to m if it doesn't have one, any Extended DNS Error option already in m is replaced.
+func SetExtendedError(m *dns.Msg, code uint16, text string) {
+	o := m.IsEdns0()
+	if o == nil {
+		o = new(dns.OPT)
+		o.Hdr.Name = "."
+		o.Hdr.Rrtype = dns.TypeOPT
+		o.SetUDPSize(dns.MinMsgSize)
+		m.Extra = append(m.Extra, o)
+	}
+
+	data := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(data, code)
+	copy(data[2:], text)
+	e := &dns.EDNS0_LOCAL{Code: EDNS0EDE, Data: data}
+
+	for i, opt := range o.Option {
+		if opt.Option() == EDNS0EDE {
+			o.Option[i] = e
+			return
+		}
+	}
+	o.Option = append(o.Option, e)
+}
+
+// ExtendedError returns the info-code and extra text of the Extended DNS Error option in m. If m doesn't have
+// one, ok is false.
+func ExtendedError(m *dns.Msg) (code uint16, text string, ok bool) {
+	o := m.IsEdns0()
+	if o == nil {
+		return 0, "", false
+	}
+	for _, opt := range o.Option {
+		e, isLocal := opt.(*dns.EDNS0_LOCAL)
+		if !isLocal || e.Code != EDNS0EDE || len(e.Data) < 2 {
+			continue
+		}
+		return binary.BigEndian.Uint16(e.Data), string(e.Data[2:]), true
+	}
+	return 0, "", false
+}
+
+// Error is an error that carries the Extended DNS Error info-code to return to the client. When a plugin chain
+// returns an Error and the server writes the error response itself, the info-code is added to that response.
+type Error struct {
+	Code uint16
+	Err  error
+}
+
+// NewError returns an Error that wraps err with info-code code.
+func NewError(code uint16, err error) *Error { return &Error{Code: code, Err: err} }
+
+// Error implements the error interface.
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrorCode returns the info-code of the first Error in err's chain. If there is none, ok is false.
+func ErrorCode(err error) (code uint16, ok bool) {
+	var e *Error
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	return e.Code, true
+}
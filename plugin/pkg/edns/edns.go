@@ -2,6 +2,7 @@
 package edns
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -72,3 +73,24 @@ func Size(proto string, size uint16) uint16 {
 	}
 	return size
 }
+
+// Scoper wants to know the scope prefix length of the EDNS0 Client Subnet option (RFC 7871) in a reply. A plugin
+// that removes that option from a reply, because the client didn't send one, reports the scope with SetScope
+// before writing the reply.
+type Scoper interface {
+	SetScope(scope uint8)
+}
+
+type scoperKey struct{}
+
+// WithScoper returns a copy of ctx that carries s. A plugin further down the chain finds it with ScoperFrom, no
+// matter how the dns.ResponseWriter it's handed is wrapped by the plugins in between.
+func WithScoper(ctx context.Context, s Scoper) context.Context {
+	return context.WithValue(ctx, scoperKey{}, s)
+}
+
+// ScoperFrom returns the Scoper in ctx, or nil if there is none.
+func ScoperFrom(ctx context.Context) Scoper {
+	s, _ := ctx.Value(scoperKey{}).(Scoper)
+	return s
+}
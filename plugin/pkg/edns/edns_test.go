@@ -1,6 +1,8 @@
 package edns
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -35,3 +37,53 @@ func ednsMsg() *dns.Msg {
 	m.Extra = append(m.Extra, o)
 	return m
 }
+
+func TestExtendedError(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	if _, _, ok := ExtendedError(m); ok {
+		t.Errorf("Expected no extended error")
+	}
+
+	SetExtendedError(m, ExtendedErrorDNSSECBogus, "bogus")
+	SetExtendedError(m, ExtendedErrorSignatureExpired, "expired")
+
+	code, text, ok := ExtendedError(m)
+	if !ok {
+		t.Fatalf("Expected extended error")
+	}
+	if code != ExtendedErrorSignatureExpired || text != "expired" {
+		t.Errorf("Expected %d %q, got %d %q", ExtendedErrorSignatureExpired, "expired", code, text)
+	}
+	if x := len(m.IsEdns0().Option); x != 1 {
+		t.Errorf("Expected 1 option, got %d", x)
+	}
+
+	// It must survive a round trip through the wire format.
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := new(dns.Msg)
+	if err := m1.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if code, text, _ := ExtendedError(m1); code != ExtendedErrorSignatureExpired || text != "expired" {
+		t.Errorf("Expected %d %q after unpacking, got %d %q", ExtendedErrorSignatureExpired, "expired", code, text)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if _, ok := ErrorCode(errors.New("plain")); ok {
+		t.Errorf("Expected no info-code for a plain error")
+	}
+
+	err := fmt.Errorf("wrapped: %w", NewError(ExtendedErrorNetworkError, errors.New("timeout")))
+	code, ok := ErrorCode(err)
+	if !ok || code != ExtendedErrorNetworkError {
+		t.Errorf("Expected info-code %d, got %d (%t)", ExtendedErrorNetworkError, code, ok)
+	}
+	if err.Error() != "wrapped: timeout" {
+		t.Errorf("Expected %q, got %q", "wrapped: timeout", err.Error())
+	}
+}
@@ -0,0 +1,38 @@
+package keypair
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"os"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/ed25519"
+)
+
+// File loads the private keys from BIND style .private files.
+type File struct{}
+
+// Signer implements Loader.
+func (File) Signer(dnskey *dns.DNSKEY, base string) (crypto.Signer, error) {
+	f, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	privkey, err := dnskey.ReadPrivateKey(f, base+".private")
+	if err != nil {
+		return nil, err
+	}
+	switch signer := privkey.(type) {
+	case *ecdsa.PrivateKey:
+		return signer, nil
+	case ed25519.PrivateKey:
+		return signer, nil
+	case *rsa.PrivateKey:
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", dns.AlgorithmToString[dnskey.Algorithm])
+	}
+}
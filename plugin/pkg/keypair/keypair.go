@@ -0,0 +1,106 @@
+// Package keypair loads DNSSEC keys. The public key is always read from a BIND style .key file, the private key
+// is provided by a Loader: the default, File, reads the BIND style .private file, PKCS11 uses a key stored in a
+// hardware security module (or any other PKCS#11 token).
+package keypair
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+
+	"github.com/miekg/dns"
+)
+
+// Pair holds DNSSEC key information, both the public and private components are stored here.
+type Pair struct {
+	Public  *dns.DNSKEY
+	KeyTag  uint16
+	Private crypto.Signer
+}
+
+// Loader provides the private keys of DNSKEYs.
+type Loader interface {
+	// Signer returns the private key of dnskey. Base is the name of the key's files, without extension.
+	Signer(dnskey *dns.DNSKEY, base string) (crypto.Signer, error)
+}
+
+// Read reads the DNSKEY from base.key and uses l to load its private key.
+func Read(base string, l Loader) (Pair, error) {
+	f, err := os.Open(base + ".key")
+	if err != nil {
+		return Pair{}, err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, base+".key")
+	if err != nil {
+		return Pair{}, err
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return Pair{}, fmt.Errorf("RR in %q is not a DNSKEY", base+".key")
+	}
+	if dnskey.Flags&dns.ZONE != dns.ZONE {
+		return Pair{}, fmt.Errorf("DNSKEY in %q is not a zone key", base+".key")
+	}
+
+	signer, err := l.Signer(dnskey, base)
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{Public: dnskey, KeyTag: dnskey.KeyTag(), Private: signer}, nil
+}
+
+// Base returns the name of the files of key k without extension: Kmiek.nl.+013+26205.key,
+// Kmiek.nl.+013+26205.private and Kmiek.nl.+013+26205 all return Kmiek.nl.+013+26205. Relative names are
+// made relative to root, if not empty.
+func Base(k, root string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(k, ".key"), ".private")
+	if !filepath.IsAbs(base) && root != "" {
+		base = filepath.Join(root, base)
+	}
+	return base
+}
+
+// Parse parses the key source, which is the current token, and its arguments, and loads the keys:
+//
+//	file KEY...
+//	pkcs11 MODULE TOKEN PIN KEY...
+func Parse(c *caddy.Controller) ([]Pair, error) {
+	var l Loader
+	source := c.Val()
+	ks := c.RemainingArgs()
+	switch source {
+	case "file":
+		l = File{}
+	case "pkcs11":
+		if len(ks) < 3 {
+			return nil, c.ArgErr()
+		}
+		p, err := NewPKCS11(ks[0], ks[1], ks[2])
+		if err != nil {
+			return nil, err
+		}
+		l, ks = p, ks[3:]
+	default:
+		return nil, c.Errf("unknown key source '%s'", source)
+	}
+	if len(ks) == 0 {
+		return nil, c.ArgErr()
+	}
+
+	root := dnsserver.GetConfig(c).Root
+	pairs := make([]Pair, len(ks))
+	for i, k := range ks {
+		pair, err := Read(Base(k, root), l)
+		if err != nil {
+			return nil, err
+		}
+		pairs[i] = pair
+	}
+	return pairs, nil
+}
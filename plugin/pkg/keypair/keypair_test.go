@@ -0,0 +1,94 @@
+package keypair
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
+)
+
+func TestBase(t *testing.T) {
+	tests := []struct {
+		in       string
+		root     string
+		expected string
+	}{
+		{"Kmiek.nl.+013+59725", "", "Kmiek.nl.+013+59725"},
+		{"Kmiek.nl.+013+59725.key", "", "Kmiek.nl.+013+59725"},
+		{"Kmiek.nl.+013+59725.private", "", "Kmiek.nl.+013+59725"},
+		{"Kmiek.nl.+013+59725.key", "/etc/coredns", "/etc/coredns/Kmiek.nl.+013+59725"},
+		{"/keys/Kmiek.nl.+013+59725", "/etc/coredns", "/keys/Kmiek.nl.+013+59725"},
+	}
+	for i, tc := range tests {
+		if got := Base(tc.in, tc.root); got != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expected, got)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	p, err := Read("testdata/Kmiek.nl.+013+59725", File{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.KeyTag != 59725 {
+		t.Errorf("Expected key tag %d, got %d", 59725, p.KeyTag)
+	}
+
+	rrs := []dns.RR{test("miek.nl. 3600 IN A 127.0.0.1")}
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: "miek.nl.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  p.Public.Algorithm,
+		SignerName: "miek.nl.",
+		KeyTag:     p.KeyTag,
+		Inception:  1,
+		Expiration: 2,
+	}
+	if err := sig.Sign(p.Private, rrs); err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Verify(p.Public, rrs); err != nil {
+		t.Errorf("Expected signature to verify: %s", err)
+	}
+
+	if _, err := Read("testdata/Kmiek.nl.+013+0", File{}); err == nil {
+		t.Errorf("Expected error for non-existent key")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		keys      int
+	}{
+		{`file testdata/Kmiek.nl.+013+59725`, false, 1},
+		{`file testdata/Kmiek.nl.+013+59725.key testdata/Kmiek.nl.+013+59725.private`, false, 2},
+		{`file`, true, 0},
+		{`file testdata/Kmiek.nl.+013+0`, true, 0},
+		{`pkcs11 /usr/lib/softhsm/libsofthsm2.so`, true, 0},
+		{`directory testdata`, true, 0},
+	}
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		c.Next()
+		pairs, err := Parse(c)
+		if err == nil && tc.shouldErr {
+			t.Errorf("Test %d: expected error, got none", i)
+			continue
+		}
+		if err != nil && !tc.shouldErr {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if len(pairs) != tc.keys {
+			t.Errorf("Test %d: expected %d keys, got %d", i, tc.keys, len(pairs))
+		}
+	}
+}
+
+func test(s string) dns.RR {
+	rr, _ := dns.NewRR(s)
+	return rr
+}
@@ -0,0 +1,313 @@
+//go:build cgo
+// +build cgo
+
+package keypair
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/pkcs11"
+	"golang.org/x/crypto/ed25519"
+)
+
+// PKCS11 loads the private keys from a PKCS#11 token. The private key that belongs to a DNSKEY is found by
+// comparing the DNSKEY with the public keys on the token, the private key must have the same CKA_ID as its
+// public key. The private keys never leave the token, all signing is done by the token.
+type PKCS11 struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	mu sync.Mutex // a session can only be used for one operation at a time
+}
+
+var (
+	tokensMu sync.Mutex
+	modules  = map[string]*pkcs11.Ctx{}
+	tokens   = map[string]*PKCS11{} // keyed by module and token label
+)
+
+// NewPKCS11 loads PKCS#11 module, and logs in on the token labeled token with pin. Tokens are only opened once,
+// and kept open for the lifetime of the process.
+func NewPKCS11(module, token, pin string) (*PKCS11, error) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	if p, ok := tokens[module+"\x00"+token]; ok {
+		return p, nil
+	}
+
+	ctx, ok := modules[module]
+	if !ok {
+		ctx = pkcs11.New(module)
+		if ctx == nil {
+			return nil, fmt.Errorf("failed to load PKCS#11 module %q", module)
+		}
+		if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+			ctx.Destroy()
+			return nil, fmt.Errorf("failed to initialize PKCS#11 module %q: %s", module, err)
+		}
+		modules[module] = ctx
+	}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(info.Label) != token {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			ctx.CloseSession(session)
+			return nil, fmt.Errorf("failed to login on PKCS#11 token %q: %s", token, err)
+		}
+		p := &PKCS11{ctx: ctx, session: session}
+		tokens[module+"\x00"+token] = p
+		return p, nil
+	}
+	return nil, fmt.Errorf("PKCS#11 token %q not found", token)
+}
+
+// Key types and mechanisms from PKCS#11 v3.0 that are not defined in github.com/miekg/pkcs11.
+const (
+	ckkECEdwards = 0x00000040
+	ckmEDDSA     = 0x00001057
+)
+
+// Signer implements Loader.
+func (p *PKCS11) Signer(dnskey *dns.DNSKEY, base string) (crypto.Signer, error) {
+	var keyType uint
+	switch dnskey.Algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+		keyType = pkcs11.CKK_RSA
+	case dns.ECDSAP256SHA256, dns.ECDSAP384SHA384:
+		keyType = pkcs11.CKK_EC
+	case dns.ED25519:
+		keyType = ckkECEdwards
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", dns.AlgorithmToString[dnskey.Algorithm])
+	}
+	want, err := base64.StdEncoding.DecodeString(dnskey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	public, err := publicKey(dnskey.Algorithm, want)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	objs, err := p.find(pkcs11.CKO_PUBLIC_KEY, keyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		raw, id, err := p.publicKey(obj, keyType)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(raw, want) {
+			continue
+		}
+		privs, err := p.find(pkcs11.CKO_PRIVATE_KEY, keyType, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(privs) == 0 {
+			return nil, fmt.Errorf("no private key for %q on PKCS#11 token", base+".key")
+		}
+		return &signer{p: p, key: privs[0], alg: dnskey.Algorithm, public: public}, nil
+	}
+	return nil, fmt.Errorf("no key for %q on PKCS#11 token", base+".key")
+}
+
+// find returns the objects of class and keyType, with CKA_ID id if not nil. The caller must hold p.mu.
+func (p *PKCS11) find(class, keyType uint, id []byte) ([]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+	}
+	if id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return nil, err
+	}
+	defer p.ctx.FindObjectsFinal(p.session)
+
+	objs := []pkcs11.ObjectHandle{}
+	for {
+		found, _, err := p.ctx.FindObjects(p.session, 16)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return objs, nil
+		}
+		objs = append(objs, found...)
+	}
+}
+
+// publicKey returns the public key of obj in the wire format of the public key field of a DNSKEY, and the
+// CKA_ID of obj. The caller must hold p.mu.
+func (p *PKCS11) publicKey(obj pkcs11.ObjectHandle, keyType uint) ([]byte, []byte, error) {
+	if keyType == pkcs11.CKK_RSA {
+		attrs, err := p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		// RFC 3110, Section 2.
+		e, n := bytes.TrimLeft(attrs[1].Value, "\x00"), bytes.TrimLeft(attrs[2].Value, "\x00")
+		raw := []byte{byte(len(e))}
+		if len(e) > 255 {
+			raw = []byte{0, byte(len(e) >> 8), byte(len(e))}
+		}
+		raw = append(raw, e...)
+		return append(raw, n...), attrs[0].Value, nil
+	}
+
+	attrs, err := p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ecPoint(attrs[1].Value, keyType), attrs[0].Value, nil
+}
+
+// ecPoint returns the EC point in CKA_EC_POINT in the wire format of the public key field of a DNSKEY: the
+// (DER encoded) uncompressed point X | Y for ECDSA (RFC 6605, Section 4), the raw key for Ed25519 (RFC 8080,
+// Section 3).
+func ecPoint(point []byte, keyType uint) []byte {
+	var octets []byte
+	if rest, err := asn1.Unmarshal(point, &octets); err == nil && len(rest) == 0 {
+		point = octets
+	}
+	if keyType == pkcs11.CKK_EC && len(point) > 0 && point[0] == 4 {
+		point = point[1:]
+	}
+	return point
+}
+
+// publicKey returns the crypto.PublicKey for the public key field raw of a DNSKEY of algorithm alg.
+func publicKey(alg uint8, raw []byte) (crypto.PublicKey, error) {
+	switch alg {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+		if len(raw) < 3 {
+			return nil, fmt.Errorf("invalid RSA public key")
+		}
+		elen, off := int(raw[0]), 1
+		if elen == 0 {
+			elen, off = int(raw[1])<<8|int(raw[2]), 3
+		}
+		if elen > 4 || len(raw) <= off+elen {
+			return nil, fmt.Errorf("invalid RSA public key")
+		}
+		e := 0
+		for _, b := range raw[off : off+elen] {
+			e = e<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(raw[off+elen:]), E: e}, nil
+	case dns.ECDSAP256SHA256, dns.ECDSAP384SHA384:
+		curve := elliptic.P256()
+		if alg == dns.ECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		size := curve.Params().BitSize / 8
+		if len(raw) != 2*size {
+			return nil, fmt.Errorf("invalid ECDSA public key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(raw[:size]), Y: new(big.Int).SetBytes(raw[size:])}, nil
+	case dns.ED25519:
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ED25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", dns.AlgorithmToString[alg])
+}
+
+// signer is a crypto.Signer for a private key on a PKCS#11 token.
+type signer struct {
+	p      *PKCS11
+	key    pkcs11.ObjectHandle
+	alg    uint8
+	public crypto.PublicKey
+}
+
+// Public implements crypto.Signer.
+func (s *signer) Public() crypto.PublicKey { return s.public }
+
+// Sign implements crypto.Signer. Signatures are returned in the format of the standard library, i.e. the PKCS #1
+// v1.5 signature for RSA, and the ASN.1 encoded signature for ECDSA.
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech uint
+	data := digest
+	switch s.alg {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+		// CKM_RSA_PKCS does not hash, the DigestInfo must be added to the digest.
+		prefix, ok := hashPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function %d", opts.HashFunc())
+		}
+		data = make([]byte, 0, len(prefix)+len(digest))
+		data = append(append(data, prefix...), digest...)
+		mech = pkcs11.CKM_RSA_PKCS
+	case dns.ECDSAP256SHA256, dns.ECDSAP384SHA384:
+		mech = pkcs11.CKM_ECDSA
+	case dns.ED25519:
+		mech = ckmEDDSA
+	}
+
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+
+	if err := s.p.ctx.SignInit(s.p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)}, s.key); err != nil {
+		return nil, err
+	}
+	sig, err := s.p.ctx.Sign(s.p.session, data)
+	if err != nil {
+		return nil, err
+	}
+	if mech != pkcs11.CKM_ECDSA {
+		return sig, nil
+	}
+	// CKM_ECDSA returns r | s.
+	half := len(sig) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+}
+
+// hashPrefixes are the DER encoded DigestInfo prefixes of the hash functions used by the RSA algorithms.
+var hashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
@@ -0,0 +1,24 @@
+//go:build !cgo
+// +build !cgo
+
+package keypair
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// PKCS11 loads the private keys from a PKCS#11 token. PKCS#11 modules are shared libraries, so this needs cgo.
+type PKCS11 struct{}
+
+// NewPKCS11 returns an error: this binary is built without cgo.
+func NewPKCS11(module, token, pin string) (*PKCS11, error) {
+	return nil, fmt.Errorf("PKCS#11 is not supported: built without cgo")
+}
+
+// Signer implements Loader.
+func (p *PKCS11) Signer(dnskey *dns.DNSKEY, base string) (crypto.Signer, error) {
+	return nil, fmt.Errorf("PKCS#11 is not supported: built without cgo")
+}
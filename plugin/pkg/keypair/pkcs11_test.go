@@ -0,0 +1,179 @@
+//go:build cgo
+// +build cgo
+
+package keypair
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/pkcs11"
+)
+
+// softHSM returns the path of the SoftHSM v2 module, or the empty string if it's not installed. The
+// environment variable SOFTHSM2_MODULE overrides the default locations.
+func softHSM() string {
+	paths := []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// softHSMKey initializes a SoftHSM token labeled token in dir, generates an ECDSA P-256 key pair on it and writes
+// the DNSKEY to dir. The base name of the key file is returned.
+func softHSMKey(t *testing.T, module, dir, token, pin string) string {
+	conf := filepath.Join(dir, "softhsm2.conf")
+	tokendir := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokendir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(conf, []byte("directories.tokendir = "+tokendir+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	slots, err := ctx.GetSlotList(false)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("No free slot: %s", err)
+	}
+	if err := ctx.InitToken(slots[0], pin, token); err != nil {
+		t.Fatal(err)
+	}
+	// SoftHSM renumbers the slot of an initialized token.
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := slots[0]
+	for _, s := range slots {
+		if info, err := ctx.GetTokenInfo(s); err == nil && strings.TrimSpace(info.Label) == token {
+			slot = s
+		}
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.CloseSession(session)
+	if err := ctx.Login(session, pkcs11.CKU_SO, pin); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.InitPIN(session, pin); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Logout(session)
+
+	id := []byte{1}
+	p256 := []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07} // OID of P-256
+	pub, _, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := ctx.GetAttributeValue(session, pub, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+		PublicKey: base64.StdEncoding.EncodeToString(ecPoint(attrs[0].Value, pkcs11.CKK_EC)),
+	}
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", dnskey.Header().Name, dnskey.Algorithm, dnskey.KeyTag()))
+	if err := ioutil.WriteFile(base+".key", []byte(dnskey.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestReadPKCS11(t *testing.T) {
+	module := softHSM()
+	if module == "" {
+		t.Skip("SoftHSM v2 is not installed")
+	}
+	dir, err := ioutil.TempDir("", "coredns-pkcs11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := softHSMKey(t, module, dir, "coredns", "1234")
+
+	if _, err := NewPKCS11(module, "coredns", "0000"); err == nil {
+		t.Errorf("Expected error for wrong PIN")
+	}
+	if _, err := NewPKCS11(module, "nothere", "1234"); err == nil {
+		t.Errorf("Expected error for non-existent token")
+	}
+	hsm, err := NewPKCS11(module, "coredns", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Read(base, hsm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrs := []dns.RR{test("example.org. 3600 IN A 127.0.0.1"), test("example.org. 3600 IN A 127.0.0.2")}
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  p.Public.Algorithm,
+		SignerName: "example.org.",
+		KeyTag:     p.KeyTag,
+		Inception:  1,
+		Expiration: 2,
+	}
+	if err := sig.Sign(p.Private, rrs); err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Verify(p.Public, rrs); err != nil {
+		t.Errorf("Expected signature to verify: %s", err)
+	}
+
+	// A key that is not on the token.
+	if _, err := Read("testdata/Kmiek.nl.+013+59725", hsm); err == nil {
+		t.Errorf("Expected error for key not on the token")
+	}
+}
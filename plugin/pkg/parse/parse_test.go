@@ -57,3 +57,35 @@ func TestTransferIn(t *testing.T) {
 		}
 	}
 }
+
+func TestTSIGKey(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		name      string
+		algorithm string
+	}{
+		{`key hmac-sha256 c2VjcmV0`, false, "key.", "hmac-sha256."},
+		{`Key.Example.Org. HMAC-SHA512. c2VjcmV0`, false, "key.example.org.", "hmac-sha512."},
+		{`key hmac-md5.sig-alg.reg.int c2VjcmV0`, true, "", ""},
+		{`key hmac-sha256 !!!`, true, "", ""},
+		{`key hmac-sha256`, true, "", ""},
+		{`key hmac-sha256 c2VjcmV0 c2VjcmV0`, true, "", ""},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "tsig "+test.input)
+		c.Next()
+		name, algorithm, _, err := TSIGKey(c)
+		if err == nil && test.shouldErr {
+			t.Errorf("Test %d expected errors, but got no error", i)
+			continue
+		} else if err != nil && !test.shouldErr {
+			t.Errorf("Test %d expected no errors, but got '%v'", i, err)
+			continue
+		}
+		if name != test.name || algorithm != test.algorithm {
+			t.Errorf("Test %d expected %s %s, got %s %s", i, test.name, test.algorithm, name, algorithm)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package parse
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGKey parses a TSIG key: 'NAME ALGORITHM SECRET'. The name and algorithm are returned fully qualified and
+// lowercased, the secret must be base64 encoded.
+func TSIGKey(c *caddy.Controller) (name, algorithm, secret string, err error) {
+	args := c.RemainingArgs()
+	if len(args) != 3 {
+		return "", "", "", c.ArgErr()
+	}
+	name, algorithm, secret = strings.ToLower(dns.Fqdn(args[0])), strings.ToLower(dns.Fqdn(args[1])), args[2]
+	if _, ok := TSIGAlgorithms[algorithm]; !ok {
+		return "", "", "", fmt.Errorf("unknown TSIG algorithm: %s", args[1])
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return "", "", "", fmt.Errorf("invalid TSIG secret for key %s: %s", args[0], err)
+	}
+	return name, algorithm, secret, nil
+}
+
+// TSIGAlgorithms are the supported TSIG algorithms.
+var TSIGAlgorithms = map[string]struct{}{
+	dns.HmacSHA1:   {},
+	dns.HmacSHA224: {},
+	dns.HmacSHA256: {},
+	dns.HmacSHA384: {},
+	dns.HmacSHA512: {},
+}
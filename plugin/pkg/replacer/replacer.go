@@ -9,6 +9,7 @@ import (
 
 	"github.com/coredns/coredns/plugin/metadata"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -53,6 +54,7 @@ var labels = map[string]struct{}{
 	"{rcode}":                  {},
 	"{rsize}":                  {},
 	"{duration}":               {},
+	"{ede}":                    {},
 	headerReplacer + "rflags}": {},
 }
 
@@ -104,6 +106,13 @@ func appendValue(b []byte, state request.Request, rr *dnstest.Recorder, label st
 		}
 		secs := time.Since(rr.Start).Seconds()
 		return append(strconv.AppendFloat(b, secs, 'f', -1, 64), 's')
+	case "{ede}":
+		if rr != nil && rr.Msg != nil {
+			if code, _, ok := edns.ExtendedError(rr.Msg); ok {
+				return strconv.AppendInt(b, int64(code), 10)
+			}
+		}
+		return append(b, EmptyValue...)
 	case headerReplacer + "rflags}":
 		if rr != nil && rr.Msg != nil {
 			return appendFlags(b, rr.Msg.MsgHdr)
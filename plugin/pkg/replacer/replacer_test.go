@@ -8,6 +8,7 @@ import (
 
 	"github.com/coredns/coredns/plugin/metadata"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
 
@@ -239,6 +240,7 @@ func TestLabels(t *testing.T) {
 		"{rcode}":                   "NOERROR",
 		"{rsize}":                   "29",
 		"{duration}":                "0",
+		"{ede}":                     "-",
 		headerReplacer + "rflags}":  "rd,ad,cd",
 	}
 	if len(expect) != len(labels) {
@@ -259,6 +261,26 @@ func TestLabels(t *testing.T) {
 	}
 }
 
+func TestExtendedErrorLabel(t *testing.T) {
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	state := request.Request{W: w, Req: r}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	edns.SetExtendedError(m, edns.ExtendedErrorBlocked, "")
+	w.WriteMsg(m)
+
+	if x := New().Replace(context.TODO(), state, w, "{ede}"); x != "15" {
+		t.Errorf("Expected extended error 15, got %q", x)
+	}
+	if x := New().Replace(context.TODO(), state, nil, "{ede}"); x != EmptyValue {
+		t.Errorf("Expected %q without a response, got %q", EmptyValue, x)
+	}
+}
+
 func BenchmarkReplacer(b *testing.B) {
 	w := dnstest.NewRecorder(&test.ResponseWriter{})
 	r := new(dns.Msg)
@@ -0,0 +1,57 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/miekg/dns"
+)
+
+// RootAnchors returns the DS records of the root zone's key signing keys, as published by IANA.
+func RootAnchors() []dns.RR {
+	var anchors []dns.RR
+	for _, s := range rootAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err) // can't happen, these are constants.
+		}
+		anchors = append(anchors, rr)
+	}
+	return anchors
+}
+
+var rootAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// ReadAnchors parses the trust anchors from r, which holds DS or DNSKEY records in zone file format. Any other
+// records are ignored. The name of the file is only used in error messages.
+func ReadAnchors(r io.Reader, file string) ([]dns.RR, error) {
+	var anchors []dns.RR
+	zp := dns.NewZoneParser(r, ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+			anchors = append(anchors, rr)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("no trust anchors found in %q", file)
+	}
+	return anchors, nil
+}
+
+// ReadAnchorFile is like ReadAnchors, but reads the trust anchors from the file named file.
+func ReadAnchorFile(file string) ([]dns.RR, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAnchors(f, file)
+}
@@ -0,0 +1,255 @@
+package validator
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// The functions in this file check proofs of denial of existence, using NSEC (RFC 4035, section 5.4) or
+// NSEC3 (RFC 5155, section 8) records. All NSEC and NSEC3 records given have been validated.
+
+// ProvesNameError returns true if the (validated) NSEC or NSEC3 records in nsec prove that name doesn't exist,
+// and that there is no wildcard that could have been used to synthesize it.
+func ProvesNameError(nsec []dns.RR, name string) bool {
+	if ce, ok := nsecCovered(nsec, name); ok {
+		wc := "*." + ce
+		if _, ok := nsecMatch(nsec, wc); ok {
+			return false
+		}
+		_, ok := nsecCovered(nsec, wc)
+		return ok
+	}
+
+	if _, ok := nsec3Match(nsec, name); ok {
+		return false
+	}
+	ce, _, ok := closestEncloser(nsec, name)
+	if !ok {
+		return false
+	}
+	_, ok = nsec3Covered(nsec, "*."+ce)
+	return ok
+}
+
+// ProvesNoData returns true if the (validated) NSEC or NSEC3 records in nsec prove that name exists, but does
+// not have the type qtype.
+func ProvesNoData(nsec []dns.RR, name string, qtype uint16) bool {
+	if types, ok := nsecMatch(nsec, name); ok {
+		return !hasType(types, qtype) && !hasType(types, dns.TypeCNAME)
+	}
+	if n := nsecCovering(nsec, name); n != nil {
+		// name is an empty non-terminal.
+		if dns.IsSubDomain(name, strings.ToLower(n.NextDomain)) {
+			return true
+		}
+		// name is synthesized from a wildcard that doesn't have qtype.
+		ce, _ := nsecCovered(nsec, name)
+		if types, ok := nsecMatch(nsec, "*."+ce); ok {
+			return !hasType(types, qtype) && !hasType(types, dns.TypeCNAME)
+		}
+		return false
+	}
+
+	if types, ok := nsec3Match(nsec, name); ok {
+		return !hasType(types, qtype) && !hasType(types, dns.TypeCNAME)
+	}
+	ce, optOut, ok := closestEncloser(nsec, name)
+	if !ok {
+		return false
+	}
+	// RFC 5155, section 8.6: an opt-out span proves there is no (signed) DS.
+	if qtype == dns.TypeDS && optOut {
+		return true
+	}
+	if types, ok := nsec3Match(nsec, "*."+ce); ok {
+		return !hasType(types, qtype) && !hasType(types, dns.TypeCNAME)
+	}
+	return false
+}
+
+// provesWildcard returns true if nsec proves that name, which was synthesized from the wildcard wc, does not exist.
+func provesWildcard(nsec []dns.RR, name, wc string) bool {
+	if nsecCovering(nsec, name) != nil {
+		return true
+	}
+	_, ok := nsec3Covered(nsec, nextCloser(name, wc[2:]))
+	return ok
+}
+
+// Results of delegation.
+const (
+	unknownDelegation = iota
+	noDelegation
+	insecureDelegation
+)
+
+// delegation uses the proof that there is no DS record for name to tell if name is an insecure delegation, or
+// not a zone cut at all.
+func delegation(nsec []dns.RR, name string) int {
+	if types, ok := nsecMatch(nsec, name); ok {
+		return cut(types)
+	}
+	if nsecCovering(nsec, name) != nil {
+		return noDelegation
+	}
+
+	if types, ok := nsec3Match(nsec, name); ok {
+		return cut(types)
+	}
+	if n, ok := nsec3Covered(nsec, name); ok {
+		// The parent of name exists (we got there), so name is the next closer name.
+		if n.Flags&optOut != 0 {
+			return insecureDelegation
+		}
+		return noDelegation
+	}
+	return unknownDelegation
+}
+
+// cut tells what kind of delegation the types in the NSEC(3) bitmap of a name that has no DS indicate.
+func cut(types []uint16) int {
+	if hasType(types, dns.TypeDS) || hasType(types, dns.TypeSOA) {
+		// There is a DS after all, or this is from the child side of the zone cut.
+		return unknownDelegation
+	}
+	if hasType(types, dns.TypeNS) {
+		return insecureDelegation
+	}
+	return noDelegation
+}
+
+// nsecMatch returns the type bitmap of the NSEC record in nsec that has name as its owner.
+func nsecMatch(nsec []dns.RR, name string) ([]uint16, bool) {
+	for _, rr := range nsec {
+		if n, ok := rr.(*dns.NSEC); ok && strings.EqualFold(n.Hdr.Name, name) {
+			return n.TypeBitMap, true
+		}
+	}
+	return nil, false
+}
+
+// nsecCovering returns the NSEC record from nsec that covers name.
+func nsecCovering(nsec []dns.RR, name string) *dns.NSEC {
+	for _, rr := range nsec {
+		if n, ok := rr.(*dns.NSEC); ok && covers(n, name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsecCovered returns the closest encloser of name if there is an NSEC record in nsec that covers name.
+func nsecCovered(nsec []dns.RR, name string) (string, bool) {
+	n := nsecCovering(nsec, name)
+	if n == nil {
+		return "", false
+	}
+	// The closest encloser is the longest ancestor that name shares with the owner or the next name.
+	labels := dns.CompareDomainName(name, n.Hdr.Name)
+	if l := dns.CompareDomainName(name, n.NextDomain); l > labels {
+		labels = l
+	}
+	return ancestor(name, labels), true
+}
+
+// covers returns true if name falls between the owner name and the next name of n, in canonical order.
+func covers(n *dns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	if Compare(owner, name) >= 0 {
+		return false
+	}
+	if Compare(owner, next) < 0 {
+		return Compare(name, next) < 0
+	}
+	// This is the last NSEC in the zone, next is the apex.
+	return dns.IsSubDomain(next, name)
+}
+
+// nsec3Match returns the type bitmap of the NSEC3 record in nsec that matches name.
+func nsec3Match(nsec []dns.RR, name string) ([]uint16, bool) {
+	for _, rr := range nsec {
+		if n, ok := rr.(*dns.NSEC3); ok && n.Match(name) {
+			return n.TypeBitMap, true
+		}
+	}
+	return nil, false
+}
+
+// nsec3Covered returns the NSEC3 record in nsec that covers name.
+func nsec3Covered(nsec []dns.RR, name string) (*dns.NSEC3, bool) {
+	for _, rr := range nsec {
+		if n, ok := rr.(*dns.NSEC3); ok && n.Cover(name) {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// closestEncloser performs the closest encloser proof (RFC 5155, section 8.3) for name. It returns the closest
+// encloser and whether the NSEC3 that covers the next closer name has the opt-out flag set.
+func closestEncloser(nsec []dns.RR, name string) (string, bool, bool) {
+	labels := dns.CountLabel(name)
+	for i := labels - 1; i >= 0; i-- {
+		ce := ancestor(name, i)
+		if _, ok := nsec3Match(nsec, ce); !ok {
+			continue
+		}
+		n, ok := nsec3Covered(nsec, nextCloser(name, ce))
+		if !ok {
+			return "", false, false
+		}
+		return ce, n.Flags&optOut != 0, true
+	}
+	return "", false, false
+}
+
+// nextCloser returns the name that is one label longer than the closest encloser ce, on the way to name.
+func nextCloser(name, ce string) string {
+	return ancestor(name, dns.CountLabel(ce)+1)
+}
+
+// ancestor returns the last n labels of name as a fully qualified name.
+func ancestor(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	if n <= 0 || len(labels) == 0 {
+		return "."
+	}
+	if n > len(labels) {
+		n = len(labels)
+	}
+	return strings.ToLower(dns.Fqdn(strings.Join(labels[len(labels)-n:], ".")))
+}
+
+// Compare compares a and b in canonical DNS name order (RFC 4034, section 6.1).
+func Compare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	i, j := len(la)-1, len(lb)-1
+	for i >= 0 && j >= 0 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+		i--
+		j--
+	}
+	switch {
+	case i < 0 && j < 0:
+		return 0
+	case i < 0:
+		return -1
+	}
+	return 1
+}
+
+func hasType(types []uint16, t uint16) bool {
+	for _, x := range types {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// optOut is the opt-out flag in the NSEC3 flags field.
+const optOut = 1
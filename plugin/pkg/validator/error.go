@@ -0,0 +1,73 @@
+package validator
+
+import (
+	"fmt"
+
+	"github.com/coredns/coredns/plugin/pkg/edns"
+
+	"github.com/miekg/dns"
+)
+
+// Reason tells why a response is bogus.
+type Reason int
+
+const (
+	// DNSSECBogus is used when a signature doesn't verify, or for any other failure not listed below.
+	DNSSECBogus Reason = iota
+	// SignatureExpired is used when the only signatures found have expired.
+	SignatureExpired
+	// SignatureNotYetValid is used when the only signatures found are not valid yet.
+	SignatureNotYetValid
+	// DNSKEYMissing is used when no DNSKEY could be found that matches a DS or signature.
+	DNSKEYMissing
+	// RRSIGsMissing is used when an RRset from a secure zone isn't signed.
+	RRSIGsMissing
+	// NSECMissing is used when a proof of denial of existence is missing or incomplete.
+	NSECMissing
+)
+
+func (r Reason) String() string {
+	switch r {
+	case DNSSECBogus:
+		return "DNSSEC bogus"
+	case SignatureExpired:
+		return "signature expired"
+	case SignatureNotYetValid:
+		return "signature not yet valid"
+	case DNSKEYMissing:
+		return "DNSKEY missing"
+	case RRSIGsMissing:
+		return "RRSIGs missing"
+	case NSECMissing:
+		return "NSEC missing"
+	}
+	return "unknown"
+}
+
+// ExtendedError returns the Extended DNS Error (RFC 8914) info-code for r.
+func (r Reason) ExtendedError() uint16 {
+	switch r {
+	case SignatureExpired:
+		return edns.ExtendedErrorSignatureExpired
+	case SignatureNotYetValid:
+		return edns.ExtendedErrorSignatureNotYetValid
+	case DNSKEYMissing:
+		return edns.ExtendedErrorDNSKEYMissing
+	case RRSIGsMissing:
+		return edns.ExtendedErrorRRSIGsMissing
+	case NSECMissing:
+		return edns.ExtendedErrorNSECMissing
+	}
+	return edns.ExtendedErrorDNSSECBogus
+}
+
+// Error is returned by Validate when a response is bogus.
+type Error struct {
+	Reason Reason
+	Name   string // owner name of the offending RRset.
+	Type   uint16 // type of the offending RRset.
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Name, dns.Type(e.Type), e.Reason)
+}
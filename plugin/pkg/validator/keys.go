@@ -0,0 +1,226 @@
+package validator
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+
+	"github.com/miekg/dns"
+)
+
+// zoneKeys holds what we know about the zone a name is in.
+type zoneKeys struct {
+	zone     string        // apex of the zone.
+	keys     []*dns.DNSKEY // validated zone keys, empty when insecure.
+	insecure bool          // zone is not signed, or is below an unsigned delegation.
+	expire   time.Time
+}
+
+// zoneKeys returns the keys of the zone name is in. The chain of trust is followed from the closest trust
+// anchor down to name. For names that are not a zone cut the keys of the enclosing zone are returned.
+func (v *Validator) zoneKeys(ctx context.Context, name string, lookup Lookup) (*zoneKeys, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	k := cache.Hash([]byte(name))
+	zones := v.cache()
+	if zk, ok := zones.Get(k); ok && v.now().Before(zk.(*zoneKeys).expire) {
+		return zk.(*zoneKeys), nil
+	}
+
+	zk, err := v.buildZoneKeys(ctx, name, lookup)
+	if err != nil {
+		return nil, err
+	}
+	zones.Add(k, zk)
+	return zk, nil
+}
+
+func (v *Validator) buildZoneKeys(ctx context.Context, name string, lookup Lookup) (*zoneKeys, error) {
+	now := v.now()
+	if anchors, ok := v.anchor(name); ok {
+		return v.verifyKeys(ctx, name, anchors, now.Add(maxTTL), lookup)
+	}
+	if name == "." {
+		// No trust anchor above this name.
+		return &zoneKeys{zone: name, insecure: true, expire: now.Add(maxTTL)}, nil
+	}
+
+	p, err := v.zoneKeys(ctx, parent(name), lookup)
+	if err != nil {
+		return nil, err
+	}
+	if p.insecure {
+		return p, nil
+	}
+
+	m, err := lookup(ctx, name, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := rrsets(m.Answer)
+	for _, set := range sets {
+		if set.qtype != dns.TypeDS || set.name != name {
+			continue
+		}
+		if len(set.sigs) == 0 {
+			return nil, &Error{Reason: RRSIGsMissing, Name: name, Type: dns.TypeDS}
+		}
+		if err := v.verify(set, p); err != nil {
+			return nil, err
+		}
+		ds := supportedDS(set.rrs)
+		if len(ds) == 0 {
+			// RFC 4035, section 5.2: if none of the algorithms are supported, the zone is treated as insecure.
+			return &zoneKeys{zone: name, insecure: true, expire: expire(now, set, p.expire)}, nil
+		}
+		return v.verifyKeys(ctx, name, ds, expire(now, set, p.expire), lookup)
+	}
+
+	// No DS, the denial of existence tells us if there is no zone cut at all, or an insecure delegation.
+	var nsec []dns.RR
+	exp := p.expire
+	for _, set := range rrsets(m.Ns) {
+		if set.qtype != dns.TypeNSEC && set.qtype != dns.TypeNSEC3 {
+			continue
+		}
+		if len(set.sigs) == 0 {
+			continue
+		}
+		if err := v.verify(set, p); err != nil {
+			return nil, err
+		}
+		nsec = append(nsec, set.rrs...)
+		exp = expire(now, set, exp)
+	}
+	if len(nsec) == 0 {
+		return nil, &Error{Reason: NSECMissing, Name: name, Type: dns.TypeDS}
+	}
+
+	switch delegation(nsec, name) {
+	case noDelegation:
+		return &zoneKeys{zone: p.zone, keys: p.keys, expire: exp}, nil
+	case insecureDelegation:
+		return &zoneKeys{zone: name, insecure: true, expire: exp}, nil
+	}
+	return nil, &Error{Reason: NSECMissing, Name: name, Type: dns.TypeDS}
+}
+
+// verifyKeys retrieves the DNSKEY RRset for zone and checks that it is signed by a key that matches one of trusted,
+// which are DS or DNSKEY records. If so the zone keys in the RRset are returned. The returned zoneKeys expire at
+// exp, or earlier when the DNSKEY RRset's TTL or signatures say so.
+func (v *Validator) verifyKeys(ctx context.Context, zone string, trusted []dns.RR, exp time.Time, lookup Lookup) (*zoneKeys, error) {
+	m, err := lookup(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+
+	var set *rrset
+	for _, s := range rrsets(m.Answer) {
+		if s.qtype == dns.TypeDNSKEY && s.name == zone {
+			set = s
+			break
+		}
+	}
+	if set == nil {
+		return nil, &Error{Reason: DNSKEYMissing, Name: zone, Type: dns.TypeDNSKEY}
+	}
+
+	var (
+		keys []*dns.DNSKEY // all zone keys
+		sep  []*dns.DNSKEY // keys that match a trust anchor or DS
+	)
+	for _, rr := range set.rrs {
+		k := rr.(*dns.DNSKEY)
+		if k.Flags&dns.ZONE == 0 || k.Protocol != 3 {
+			continue
+		}
+		keys = append(keys, k)
+		for _, t := range trusted {
+			if matches(k, t) {
+				sep = append(sep, k)
+				break
+			}
+		}
+	}
+	if len(sep) == 0 {
+		return nil, &Error{Reason: DNSKEYMissing, Name: zone, Type: dns.TypeDNSKEY}
+	}
+
+	// The DNSKEY RRset must be signed by one of the trusted keys.
+	if err := v.verify(set, &zoneKeys{zone: zone, keys: sep}); err != nil {
+		return nil, err
+	}
+	return &zoneKeys{zone: zone, keys: keys, expire: expire(v.now(), set, exp)}, nil
+}
+
+// matches returns true if the DNSKEY k matches the trust anchor t, which is a DS or DNSKEY.
+func matches(k *dns.DNSKEY, t dns.RR) bool {
+	switch t := t.(type) {
+	case *dns.DS:
+		if t.KeyTag != k.KeyTag() || t.Algorithm != k.Algorithm {
+			return false
+		}
+		ds := k.ToDS(t.DigestType)
+		return ds != nil && strings.EqualFold(ds.Digest, t.Digest)
+	case *dns.DNSKEY:
+		return t.Algorithm == k.Algorithm && t.Protocol == k.Protocol && t.PublicKey == k.PublicKey
+	}
+	return false
+}
+
+// supportedDS returns the DS records we can use to validate a DNSKEY.
+func supportedDS(rrs []dns.RR) []dns.RR {
+	var ds []dns.RR
+	for _, rr := range rrs {
+		d := rr.(*dns.DS)
+		if !algorithms[d.Algorithm] || !digests[d.DigestType] {
+			continue
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// expire returns the time the data in set expires, this is the minimum of the TTL, the expiration of its
+// signatures and exp.
+func expire(now time.Time, set *rrset, exp time.Time) time.Time {
+	ttl := maxTTL
+	for _, rr := range set.rrs {
+		if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+	if e := now.Add(ttl); e.Before(exp) {
+		exp = e
+	}
+	for _, sig := range set.sigs {
+		if e := time.Unix(int64(sig.Expiration), 0); e.Before(exp) {
+			exp = e
+		}
+	}
+	return exp
+}
+
+var (
+	// algorithms are the DNSSEC algorithms we can validate.
+	algorithms = map[uint8]bool{
+		dns.RSASHA1:          true,
+		dns.RSASHA1NSEC3SHA1: true,
+		dns.RSASHA256:        true,
+		dns.RSASHA512:        true,
+		dns.ECDSAP256SHA256:  true,
+		dns.ECDSAP384SHA384:  true,
+		dns.ED25519:          true,
+	}
+	// digests are the DS digest types we support.
+	digests = map[uint8]bool{
+		dns.SHA1:   true,
+		dns.SHA256: true,
+		dns.SHA384: true,
+	}
+)
+
+// maxTTL is the maximum time we cache zone keys.
+const maxTTL = 1 * time.Hour
@@ -0,0 +1,434 @@
+// Package validator implements DNSSEC validation (RFC 4033, RFC 4034 and RFC 4035) of DNS responses.
+//
+// The chain of trust is build top down, starting at a trust anchor; the DNSKEY and DS RRsets needed are
+// retrieved with a Lookup function supplied by the caller. Validated zone keys are cached.
+package validator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+
+	"github.com/miekg/dns"
+)
+
+// Result is the outcome of validating a response.
+type Result int
+
+const (
+	// Insecure means the response is not protected by DNSSEC: there is no trust anchor that covers it, or the
+	// chain of trust is broken by a provably unsigned delegation.
+	Insecure Result = iota
+	// Secure means the response validated with an unbroken chain of trust to a trust anchor.
+	Secure
+	// Bogus means validation failed, the Error returned by Validate tells why.
+	Bogus
+)
+
+func (r Result) String() string {
+	switch r {
+	case Insecure:
+		return "insecure"
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	}
+	return "unknown"
+}
+
+// Lookup is called by the validator to retrieve the DNSKEY and DS RRsets it needs to build the chain of trust.
+// Implementations must send the query with the DO and CD bits set.
+type Lookup func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+
+// Validator validates responses against a set of trust anchors.
+type Validator struct {
+	mu      sync.RWMutex
+	anchors map[string][]dns.RR // DS or DNSKEY records, keyed by lower cased zone name.
+	zones   *cache.Cache        // *zoneKeys we have build, keyed on the hash of the lower cased name.
+
+	now func() time.Time
+}
+
+// New returns a new Validator that uses anchors, which are DS or DNSKEY records, as its trust anchors.
+func New(anchors []dns.RR) *Validator {
+	v := &Validator{now: time.Now}
+	v.SetAnchors(anchors)
+	return v
+}
+
+// SetAnchors replaces the trust anchors of v with anchors. All zone keys build with the previous trust anchors
+// are forgotten.
+func (v *Validator) SetAnchors(anchors []dns.RR) {
+	m := make(map[string][]dns.RR)
+	for _, a := range anchors {
+		switch a.(type) {
+		case *dns.DS, *dns.DNSKEY:
+			zone := strings.ToLower(a.Header().Name)
+			m[zone] = append(m[zone], a)
+		}
+	}
+	v.mu.Lock()
+	v.anchors = m
+	v.zones = cache.New(defaultCap)
+	v.mu.Unlock()
+}
+
+// anchor returns the trust anchors for zone.
+func (v *Validator) anchor(zone string) ([]dns.RR, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	a, ok := v.anchors[zone]
+	return a, ok
+}
+
+// cache returns the cache with the zone keys.
+func (v *Validator) cache() *cache.Cache {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.zones
+}
+
+// Validate validates the response m using lookup to retrieve any DNSKEYs and DSs needed. When the result is Bogus
+// the returned error is an *Error that describes what is wrong. Unsigned RRsets in the authority section of a
+// response from a secure zone are removed from m.
+func (v *Validator) Validate(ctx context.Context, m *dns.Msg, lookup Lookup) (Result, error) {
+	if len(m.Question) == 0 {
+		return Insecure, nil
+	}
+	q := m.Question[0]
+	qname := strings.ToLower(q.Name)
+	if !v.covered(qname) {
+		return Insecure, nil
+	}
+
+	result := Secure
+	var nsec []dns.RR // validated NSEC and NSEC3 records from the authority section.
+
+	// The authority section goes first, as we need the NSEC records it holds to check wildcard answers.
+	for _, section := range []int{ns, answer} {
+		rrs := m.Answer
+		if section == ns {
+			rrs = m.Ns
+		}
+		sets := rrsets(rrs)
+		var unsigned []dns.RR // unsigned RRsets in a secure zone in the authority section, these are removed.
+
+		for _, set := range sets {
+			rs, err := v.validateRRset(ctx, set, sets, lookup)
+			if err != nil {
+				return Bogus, err
+			}
+			switch rs {
+			case Insecure:
+				result = Insecure
+			case Bogus:
+				if section == answer {
+					return Bogus, &Error{Reason: RRSIGsMissing, Name: set.name, Type: set.qtype}
+				}
+				unsigned = append(unsigned, set.rrs...)
+				continue
+			}
+			if section == ns && len(set.sigs) > 0 && (set.qtype == dns.TypeNSEC || set.qtype == dns.TypeNSEC3) {
+				nsec = append(nsec, set.rrs...)
+			}
+			if section == answer {
+				if wc := wildcard(set); wc != "" && rs == Secure {
+					// The answer was synthesized from a wildcard, there must be proof that the name itself doesn't exist.
+					if !provesWildcard(nsec, set.name, wc) {
+						return Bogus, &Error{Reason: NSECMissing, Name: set.name, Type: set.qtype}
+					}
+				}
+			}
+		}
+		if section == ns && len(unsigned) > 0 {
+			m.Ns = remove(m.Ns, unsigned)
+		}
+	}
+
+	if result != Secure {
+		return result, nil
+	}
+
+	// A secure negative answer needs a proof of denial of existence.
+	final := finalName(qname, m.Answer)
+	switch {
+	case m.Rcode == dns.RcodeNameError:
+		if ok, err := v.secureName(ctx, final, m.Ns, lookup); err != nil || !ok {
+			return Insecure, err
+		}
+		if !ProvesNameError(nsec, final) {
+			return Bogus, &Error{Reason: NSECMissing, Name: final, Type: q.Qtype}
+		}
+	case m.Rcode == dns.RcodeSuccess && !hasAnswer(m.Answer, final, q.Qtype):
+		if ok, err := v.secureName(ctx, final, m.Ns, lookup); err != nil || !ok {
+			return Insecure, err
+		}
+		if !ProvesNoData(nsec, final, q.Qtype) {
+			return Bogus, &Error{Reason: NSECMissing, Name: final, Type: q.Qtype}
+		}
+	}
+
+	return Secure, nil
+}
+
+// validateRRset validates set. It returns Bogus (and no error) if the set is unsigned while it is in a secure zone.
+func (v *Validator) validateRRset(ctx context.Context, set *rrset, sets []*rrset, lookup Lookup) (Result, error) {
+	if len(set.sigs) == 0 {
+		// A CNAME synthesized from a (signed) DNAME is never signed.
+		if set.qtype == dns.TypeCNAME && synthesized(set.name, sets) {
+			return Secure, nil
+		}
+		zk, err := v.zoneKeys(ctx, owner(set, set.name), lookup)
+		if err != nil {
+			return Bogus, err
+		}
+		if zk.insecure {
+			return Insecure, nil
+		}
+		return Bogus, nil
+	}
+
+	signer := strings.ToLower(set.sigs[0].SignerName)
+	if !dns.IsSubDomain(signer, set.name) {
+		return Bogus, &Error{Reason: DNSSECBogus, Name: set.name, Type: set.qtype}
+	}
+	zk, err := v.zoneKeys(ctx, owner(set, signer), lookup)
+	if err != nil {
+		return Bogus, err
+	}
+	if zk.insecure {
+		return Insecure, nil
+	}
+	if zk.zone != signer {
+		// The signer isn't the zone the RRset is in, so there can't be a DNSKEY for it.
+		return Bogus, &Error{Reason: DNSKEYMissing, Name: set.name, Type: set.qtype}
+	}
+	if err := v.verify(set, zk); err != nil {
+		return Bogus, err
+	}
+	return Secure, nil
+}
+
+// owner returns the name to look up the zone keys for set with. This is normally the owner name of set, but DS
+// records live on the parent side of a zone cut, and NSEC and NSEC3 records are taken from their signer, which
+// saves a DS lookup for each of them.
+func owner(set *rrset, signer string) string {
+	switch set.qtype {
+	case dns.TypeDS:
+		if set.name != "." {
+			return parent(set.name)
+		}
+	case dns.TypeNSEC, dns.TypeNSEC3:
+		return signer
+	}
+	return set.name
+}
+
+// secureName returns true if name is in a secure zone. The SOA record in the authority section is used to find
+// the zone, if there isn't one the name itself is used.
+func (v *Validator) secureName(ctx context.Context, name string, ns []dns.RR, lookup Lookup) (bool, error) {
+	for _, rr := range ns {
+		if rr.Header().Rrtype == dns.TypeSOA && dns.IsSubDomain(rr.Header().Name, name) {
+			name = strings.ToLower(rr.Header().Name)
+			break
+		}
+	}
+	zk, err := v.zoneKeys(ctx, name, lookup)
+	if err != nil {
+		return false, err
+	}
+	return !zk.insecure, nil
+}
+
+// covered returns true if there is a trust anchor at or above name.
+func (v *Validator) covered(name string) bool {
+	for {
+		if _, ok := v.anchor(name); ok {
+			return true
+		}
+		if name == "." {
+			return false
+		}
+		name = parent(name)
+	}
+}
+
+// verify verifies the signatures on set with the keys in zk, a single valid signature is enough.
+func (v *Validator) verify(set *rrset, zk *zoneKeys) error {
+	now := v.now()
+	reason := DNSKEYMissing
+	for _, sig := range set.sigs {
+		if !strings.EqualFold(sig.SignerName, zk.zone) {
+			continue
+		}
+		if !sig.ValidityPeriod(now) {
+			reason = SignatureExpired
+			if now.Before(time.Unix(int64(sig.Inception), 0)) {
+				reason = SignatureNotYetValid
+			}
+			continue
+		}
+		for _, k := range zk.keys {
+			if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err := sig.Verify(k, set.rrs); err == nil {
+				return nil
+			}
+			reason = DNSSECBogus
+		}
+	}
+	return &Error{Reason: reason, Name: set.name, Type: set.qtype}
+}
+
+// rrset is a set of RRs with the same owner name and type, together with their signatures.
+type rrset struct {
+	name  string // lower cased owner name.
+	qtype uint16
+	rrs   []dns.RR
+	sigs  []*dns.RRSIG
+}
+
+// rrsets groups rrs into RRsets, RRSIGs are added to the RRset they cover.
+func rrsets(rrs []dns.RR) []*rrset {
+	var sets []*rrset
+	find := func(name string, qtype uint16) *rrset {
+		for _, s := range sets {
+			if s.name == name && s.qtype == qtype {
+				return s
+			}
+		}
+		s := &rrset{name: name, qtype: qtype}
+		sets = append(sets, s)
+		return s
+	}
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			s := find(name, sig.TypeCovered)
+			s.sigs = append(s.sigs, sig)
+			continue
+		}
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		s := find(name, rr.Header().Rrtype)
+		s.rrs = append(s.rrs, rr)
+	}
+	// Drop signatures that don't cover anything.
+	j := 0
+	for _, s := range sets {
+		if len(s.rrs) == 0 {
+			continue
+		}
+		sets[j] = s
+		j++
+	}
+	return sets[:j]
+}
+
+// wildcard returns the wildcard name set was synthesized from, or the empty string if it wasn't.
+func wildcard(set *rrset) string {
+	labels := dns.SplitDomainName(set.name)
+	for _, sig := range set.sigs {
+		if int(sig.Labels) < len(labels) {
+			return "*." + dns.Fqdn(strings.Join(labels[len(labels)-int(sig.Labels):], "."))
+		}
+	}
+	return ""
+}
+
+// synthesized returns true if there is a DNAME RRset in sets that could have synthesized a CNAME for name.
+func synthesized(name string, sets []*rrset) bool {
+	for _, s := range sets {
+		if s.qtype == dns.TypeDNAME && s.name != name && dns.IsSubDomain(s.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// finalName follows the CNAME chain in rrs starting at qname, and returns the name at the end of the chain.
+func finalName(qname string, rrs []dns.RR) string {
+	name := qname
+	for i := 0; i < len(rrs); i++ { // bounded to prevent loops.
+		found := false
+		for _, rr := range rrs {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+				name = strings.ToLower(c.Target)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return name
+}
+
+// hasAnswer returns true if rrs contains an RR for name and qtype.
+func hasAnswer(rrs []dns.RR, name string, qtype uint16) bool {
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterDNSSEC removes the DNSSEC records from rrs, unless they are of type qtype. This is used for clients that
+// didn't set the DO bit.
+func FilterDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	j := 0
+	for _, rr := range rrs {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			if t != qtype {
+				continue
+			}
+		}
+		rrs[j] = rr
+		j++
+	}
+	return rrs[:j]
+}
+
+// remove returns rrs without the RRs in del.
+func remove(rrs, del []dns.RR) []dns.RR {
+	j := 0
+Loop:
+	for _, rr := range rrs {
+		for _, d := range del {
+			if rr == d {
+				continue Loop
+			}
+		}
+		rrs[j] = rr
+		j++
+	}
+	return rrs[:j]
+}
+
+// parent returns the parent of name, name must not be the root.
+func parent(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+const (
+	answer = iota
+	ns
+)
+
+const defaultCap = 10000 // default number of zones we keep keys for.
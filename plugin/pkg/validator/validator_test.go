@@ -0,0 +1,375 @@
+package validator
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// zone is a signed zone in the test hierarchy.
+type zone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newZone(t *testing.T, name string) *zone {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &zone{name: name, key: k, priv: priv.(crypto.Signer)}
+}
+
+// sign returns rrs and a signature over them, valid from inception until expiration.
+func (z *zone) sign(t *testing.T, inception, expiration time.Time, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func (z *zone) signNow(t *testing.T, rrs ...dns.RR) []dns.RR {
+	now := time.Now()
+	return z.sign(t, now.Add(-time.Hour), now.Add(time.Hour), rrs...)
+}
+
+// hierarchy is a signed ".", "org." and "example.org.", with an insecure delegation for "insecure.org.".
+type hierarchy struct {
+	root, org, example *zone
+	answers            map[string]*dns.Msg
+}
+
+func newHierarchy(t *testing.T) *hierarchy {
+	h := &hierarchy{
+		root:    newZone(t, "."),
+		org:     newZone(t, "org."),
+		example: newZone(t, "example.org."),
+		answers: make(map[string]*dns.Msg),
+	}
+	for _, z := range []*zone{h.root, h.org, h.example} {
+		h.add(z.name, dns.TypeDNSKEY, z.signNow(t, z.key))
+	}
+	h.add("org.", dns.TypeDS, h.root.signNow(t, h.org.key.ToDS(dns.SHA256)))
+	h.add("example.org.", dns.TypeDS, h.org.signNow(t, h.example.key.ToDS(dns.SHA256)))
+
+	h.addNs("insecure.org.", dns.TypeDS, h.org.signNow(t, test.NSEC("insecure.org. 3600 IN NSEC zzz.org. NS RRSIG NSEC")))
+	h.addNs("www.example.org.", dns.TypeDS, h.example.signNow(t, test.NSEC("www.example.org. 3600 IN NSEC zzz.example.org. A RRSIG NSEC")))
+	h.addNs("b.example.org.", dns.TypeDS, h.example.signNow(t, test.NSEC("aa.example.org. 3600 IN NSEC www.example.org. A RRSIG NSEC")))
+	return h
+}
+
+func (h *hierarchy) add(name string, qtype uint16, answer []dns.RR) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Answer = answer
+	h.answers[key(name, qtype)] = m
+}
+
+func (h *hierarchy) addNs(name string, qtype uint16, ns []dns.RR) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Ns = ns
+	h.answers[key(name, qtype)] = m
+}
+
+func (h *hierarchy) lookup(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if m, ok := h.answers[key(name, qtype)]; ok {
+		return m.Copy(), nil
+	}
+	return nil, fmt.Errorf("no answer for %s %s", name, dns.Type(qtype))
+}
+
+func (h *hierarchy) anchors() []dns.RR { return []dns.RR{h.root.key.ToDS(dns.SHA256)} }
+
+func key(name string, qtype uint16) string { return name + "/" + dns.Type(qtype).String() }
+
+func TestValidate(t *testing.T) {
+	h := newHierarchy(t)
+	now := time.Now()
+
+	soa := test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600")
+	a := test.A("www.example.org. 3600 IN A 127.0.0.1")
+	nsec := test.NSEC("www.example.org. 3600 IN NSEC zzz.example.org. A RRSIG NSEC")
+	nsecApex := test.NSEC("example.org. 3600 IN NSEC aa.example.org. SOA NS RRSIG NSEC DNSKEY")
+	nsecAA := test.NSEC("aa.example.org. 3600 IN NSEC www.example.org. A RRSIG NSEC")
+
+	tampered := h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.1"))
+	tampered[0].(*dns.A).A[3] = 2
+
+	tests := []struct {
+		qname          string
+		qtype          uint16
+		rcode          int
+		answer         []dns.RR
+		ns             []dns.RR
+		expectedResult Result
+		expectedReason Reason
+	}{
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         h.example.signNow(t, a),
+			expectedResult: Secure,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         tampered,
+			expectedResult: Bogus, expectedReason: DNSSECBogus,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         []dns.RR{a},
+			expectedResult: Bogus, expectedReason: RRSIGsMissing,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         h.example.sign(t, now.Add(-2*time.Hour), now.Add(-time.Hour), a),
+			expectedResult: Bogus, expectedReason: SignatureExpired,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         h.example.sign(t, now.Add(time.Hour), now.Add(2*time.Hour), a),
+			expectedResult: Bogus, expectedReason: SignatureNotYetValid,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			answer:         h.org.signNow(t, a), // signed by the wrong zone
+			expectedResult: Bogus, expectedReason: DNSKEYMissing,
+		},
+		{
+			qname: "www.insecure.org.", qtype: dns.TypeA,
+			answer:         []dns.RR{test.A("www.insecure.org. 3600 IN A 127.0.0.1")},
+			expectedResult: Insecure,
+		},
+		// NXDOMAIN
+		{
+			qname: "b.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns:             append(append(h.example.signNow(t, soa), h.example.signNow(t, nsecApex)...), h.example.signNow(t, nsecAA)...),
+			expectedResult: Secure,
+		},
+		{
+			qname: "b.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns:             append(h.example.signNow(t, soa), h.example.signNow(t, nsecAA)...), // no proof the wildcard doesn't exist
+			expectedResult: Bogus, expectedReason: NSECMissing,
+		},
+		{
+			qname: "b.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns:             h.example.signNow(t, soa),
+			expectedResult: Bogus, expectedReason: NSECMissing,
+		},
+		// NODATA
+		{
+			qname: "www.example.org.", qtype: dns.TypeAAAA,
+			ns:             append(h.example.signNow(t, soa), h.example.signNow(t, nsec)...),
+			expectedResult: Secure,
+		},
+		{
+			qname: "www.example.org.", qtype: dns.TypeA,
+			ns:             append(h.example.signNow(t, soa), h.example.signNow(t, nsec)...),
+			expectedResult: Bogus, expectedReason: NSECMissing,
+		},
+		// Wildcard
+		{
+			qname: "b.example.org.", qtype: dns.TypeA,
+			answer:         wildcardSig(h.example.signNow(t, test.A("*.example.org. 3600 IN A 127.0.0.1")), "b.example.org."),
+			ns:             h.example.signNow(t, nsecAA),
+			expectedResult: Secure,
+		},
+		{
+			qname: "b.example.org.", qtype: dns.TypeA,
+			answer:         wildcardSig(h.example.signNow(t, test.A("*.example.org. 3600 IN A 127.0.0.1")), "b.example.org."),
+			expectedResult: Bogus, expectedReason: NSECMissing,
+		},
+	}
+
+	for i, tc := range tests {
+		v := New(h.anchors())
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		m.Rcode = tc.rcode
+		m.Answer = tc.answer
+		m.Ns = tc.ns
+
+		result, err := v.Validate(context.TODO(), m, h.lookup)
+		if result != tc.expectedResult {
+			t.Errorf("Test %d: expected result %s, got %s (%v)", i, tc.expectedResult, result, err)
+			continue
+		}
+		if result != Bogus {
+			if err != nil {
+				t.Errorf("Test %d: expected no error, got %s", i, err)
+			}
+			continue
+		}
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Test %d: expected *Error, got %T: %v", i, err, err)
+			continue
+		}
+		if e.Reason != tc.expectedReason {
+			t.Errorf("Test %d: expected reason %q, got %q", i, tc.expectedReason, e.Reason)
+		}
+	}
+}
+
+func TestValidateNoAnchor(t *testing.T) {
+	h := newHierarchy(t)
+	v := New(nil)
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.Answer = []dns.RR{test.A("www.example.org. 3600 IN A 127.0.0.1")}
+
+	result, err := v.Validate(context.TODO(), m, h.lookup)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if result != Insecure {
+		t.Errorf("Expected %s, got %s", Insecure, result)
+	}
+}
+
+func TestValidateUnsignedAuthority(t *testing.T) {
+	h := newHierarchy(t)
+	v := New(h.anchors())
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.Answer = h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.1"))
+	m.Ns = []dns.RR{test.NS("example.org. 3600 IN NS ns.example.org.")}
+
+	result, err := v.Validate(context.TODO(), m, h.lookup)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if result != Secure {
+		t.Errorf("Expected %s, got %s", Secure, result)
+	}
+	if len(m.Ns) != 0 {
+		t.Errorf("Expected unsigned authority section to be removed, got %v", m.Ns)
+	}
+}
+
+func TestDelegation(t *testing.T) {
+	tests := []struct {
+		nsec     string
+		name     string
+		expected int
+	}{
+		{"insecure.org. 3600 IN NSEC zzz.org. NS RRSIG NSEC", "insecure.org.", insecureDelegation},
+		{"insecure.org. 3600 IN NSEC zzz.org. NS DS RRSIG NSEC", "insecure.org.", unknownDelegation},
+		{"www.org. 3600 IN NSEC zzz.org. A RRSIG NSEC", "www.org.", noDelegation},
+		{"aaa.org. 3600 IN NSEC zzz.org. A RRSIG NSEC", "www.org.", noDelegation},
+		{"aaa.org. 3600 IN NSEC bbb.org. A RRSIG NSEC", "www.org.", unknownDelegation},
+	}
+	for i, tc := range tests {
+		if x := delegation([]dns.RR{test.NSEC(tc.nsec)}, tc.name); x != tc.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, tc.expected, x)
+		}
+	}
+}
+
+func TestReadAnchors(t *testing.T) {
+	anchors, err := ReadAnchors(strings.NewReader(". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D\n"), "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(anchors) != 1 {
+		t.Errorf("Expected 1 anchor, got %d", len(anchors))
+	}
+	if _, err := ReadAnchors(strings.NewReader("example.org. IN A 127.0.0.1\n"), "test"); err == nil {
+		t.Errorf("Expected error for file without anchors, got none")
+	}
+	if len(RootAnchors()) != 2 {
+		t.Errorf("Expected 2 root anchors, got %d", len(RootAnchors()))
+	}
+}
+
+// wildcardSig renames the RRs in rrs, signed as a wildcard, to name; just as a server would do.
+func wildcardSig(rrs []dns.RR, name string) []dns.RR {
+	for _, rr := range rrs {
+		rr.Header().Name = name
+	}
+	return rrs
+}
+
+func TestProvesNSEC3(t *testing.T) {
+	// A zone with just example.org. and www.example.org., with NSEC3 records that form a chain.
+	names := []string{"example.org.", "www.example.org."}
+	hashes := make([]string, len(names))
+	for i, n := range names {
+		hashes[i] = strings.ToLower(dns.HashName(n, dns.SHA1, 0, ""))
+	}
+	var nsec3 []dns.RR
+	for i := range names {
+		next := hashes[(i+1)%len(hashes)]
+		types := "A RRSIG"
+		if i == 0 {
+			types = "SOA NS RRSIG DNSKEY NSEC3PARAM"
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s.example.org. 3600 IN NSEC3 1 0 0 - %s %s", hashes[i], next, types))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nsec3 = append(nsec3, rr)
+	}
+
+	if !ProvesNameError(nsec3, "a.example.org.") {
+		t.Errorf("Expected name error to be proven for a.example.org.")
+	}
+	if ProvesNameError(nsec3, "www.example.org.") {
+		t.Errorf("Expected name error not to be proven for www.example.org.")
+	}
+	if !ProvesNoData(nsec3, "www.example.org.", dns.TypeAAAA) {
+		t.Errorf("Expected no data to be proven for www.example.org. AAAA")
+	}
+	if ProvesNoData(nsec3, "www.example.org.", dns.TypeA) {
+		t.Errorf("Expected no data not to be proven for www.example.org. A")
+	}
+	if x := delegation(nsec3, "a.example.org."); x != noDelegation {
+		t.Errorf("Expected %d for a.example.org., got %d", noDelegation, x)
+	}
+}
+
+func TestSetAnchors(t *testing.T) {
+	h := newHierarchy(t)
+	v := New(nil)
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.Answer = h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.1"))
+
+	if result, _ := v.Validate(context.TODO(), m.Copy(), h.lookup); result != Insecure {
+		t.Errorf("Expected %s without trust anchors, got %s", Insecure, result)
+	}
+	v.SetAnchors(h.anchors())
+	if result, err := v.Validate(context.TODO(), m.Copy(), h.lookup); result != Secure {
+		t.Errorf("Expected %s, got %s: %v", Secure, result, err)
+	}
+	// Replacing the anchors with an unrelated key makes the zone keys we have build bogus.
+	v.SetAnchors([]dns.RR{newZone(t, ".").key})
+	if result, _ := v.Validate(context.TODO(), m.Copy(), h.lookup); result != Bogus {
+		t.Errorf("Expected %s with the wrong trust anchor, got %s", Bogus, result)
+	}
+}
@@ -0,0 +1,77 @@
+package recursive
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// rootHints are the IPv4 addresses of the root servers, from https://www.internic.net/domain/named.root.
+var rootHints = []string{
+	"198.41.0.4:53",     // a.root-servers.net.
+	"170.247.170.2:53",  // b.root-servers.net.
+	"192.33.4.12:53",    // c.root-servers.net.
+	"199.7.91.13:53",    // d.root-servers.net.
+	"192.203.230.10:53", // e.root-servers.net.
+	"192.5.5.241:53",    // f.root-servers.net.
+	"192.112.36.4:53",   // g.root-servers.net.
+	"198.97.190.53:53",  // h.root-servers.net.
+	"192.36.148.17:53",  // i.root-servers.net.
+	"192.58.128.30:53",  // j.root-servers.net.
+	"193.0.14.129:53",   // k.root-servers.net.
+	"199.7.83.42:53",    // l.root-servers.net.
+	"202.12.27.33:53",   // m.root-servers.net.
+}
+
+// readHints parses root hints in zone file format, like named.root. It returns the addresses of the name servers
+// listed in the NS records for the root.
+func readHints(r io.Reader, file string) ([]string, error) {
+	var (
+		names = map[string]bool{}
+		addrs = map[string][]string{}
+		order []string
+	)
+	zp := dns.NewZoneParser(r, ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		switch x := rr.(type) {
+		case *dns.NS:
+			if name == "." {
+				ns := strings.ToLower(x.Ns)
+				if !names[ns] {
+					order = append(order, ns)
+				}
+				names[ns] = true
+			}
+		case *dns.A:
+			addrs[name] = append(addrs[name], net.JoinHostPort(x.A.String(), "53"))
+		case *dns.AAAA:
+			addrs[name] = append(addrs[name], net.JoinHostPort(x.AAAA.String(), "53"))
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	var hints []string
+	for _, ns := range order {
+		hints = append(hints, addrs[ns]...)
+	}
+	if len(hints) == 0 {
+		return nil, fmt.Errorf("no root server addresses found in %q", file)
+	}
+	return hints, nil
+}
+
+func readHintsFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readHints(f, file)
+}
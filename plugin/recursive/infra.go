@@ -0,0 +1,61 @@
+package recursive
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+)
+
+// delegation holds the name servers of a zone.
+type delegation struct {
+	zone    string   // lower cased zone name.
+	servers []string // addresses (host:port) of the name servers.
+	expire  time.Time
+}
+
+// infra is the infrastructure cache: it holds the delegations (NS records and the addresses of the name servers)
+// we have learned while resolving.
+type infra struct {
+	c   *cache.Cache
+	now func() time.Time
+}
+
+func newInfra(size int) *infra { return &infra{c: cache.New(size), now: time.Now} }
+
+// get returns the delegation for zone, or nil if we don't have one or it expired.
+func (i *infra) get(zone string) *delegation {
+	k := cache.Hash([]byte(strings.ToLower(zone)))
+	d, ok := i.c.Get(k)
+	if !ok {
+		return nil
+	}
+	if i.now().After(d.(*delegation).expire) {
+		i.c.Remove(k)
+		return nil
+	}
+	return d.(*delegation)
+}
+
+// add adds d to the cache.
+func (i *infra) add(d *delegation) {
+	i.c.Add(cache.Hash([]byte(d.zone)), d)
+}
+
+// closest returns the delegation for the closest enclosing zone of name that is in the cache, or the root
+// delegation made from hints.
+func (i *infra) closest(name string, hints []string) *delegation {
+	name = strings.ToLower(name)
+	for {
+		if d := i.get(name); d != nil {
+			return d
+		}
+		if name == "." {
+			break
+		}
+		name = parent(name)
+	}
+	return &delegation{zone: ".", servers: hints}
+}
+
+const defaultInfraSize = 10000 // default number of delegations in the infrastructure cache.
@@ -0,0 +1,18 @@
+package recursive
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Variables declared for monitoring.
+var (
+	QueryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "recursive",
+		Name:      "queries_total",
+		Help:      "Counter of queries sent to authoritative servers, per returned RCODE.",
+	}, []string{"rcode"})
+)
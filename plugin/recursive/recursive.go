@@ -0,0 +1,109 @@
+// Package recursive implements a plugin that resolves queries by iterating from the root name servers.
+package recursive
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("recursive")
+
+// Recursive is a plugin that resolves queries itself, starting at the root hints and following referrals down to
+// the authoritative name servers.
+type Recursive struct {
+	from    string
+	ignored []string
+
+	hints    []string // addresses of the root servers.
+	infra    *infra   // cache of delegations we have seen.
+	qmin     bool     // use QNAME minimisation.
+	random   bool     // randomise the case of the query name (0x20).
+	maxDepth int      // maximum number of nested lookups, for CNAMEs and name server addresses.
+
+	// exchange sends m to the server at addr and returns the reply, it is replaced in tests.
+	exchange func(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error)
+
+	Next plugin.Handler
+}
+
+// New returns a new Recursive that uses the built-in root hints.
+func New() *Recursive {
+	return &Recursive{
+		from:     ".",
+		hints:    rootHints,
+		infra:    newInfra(defaultInfraSize),
+		qmin:     true,
+		random:   true,
+		maxDepth: defaultMaxDepth,
+		exchange: exchange,
+	}
+}
+
+// Name implements plugin.Handler.
+func (r *Recursive) Name() string { return "recursive" }
+
+// ServeDNS implements plugin.Handler.
+func (r *Recursive) ServeDNS(ctx context.Context, w dns.ResponseWriter, req *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: req}
+	if !r.match(state) {
+		return plugin.NextOrFailure(r.Name(), r.Next, ctx, w, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	ret, err := r.resolve(ctx, state.QName(), state.QType(), 0, &work{lookups: maxLookups})
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	m.Rcode = ret.Rcode
+	m.Answer = ret.Answer
+	m.Ns = ret.Ns
+	state.SizeAndDo(m)
+	w.WriteMsg(m)
+	return 0, nil
+}
+
+func (r *Recursive) match(state request.Request) bool {
+	if !plugin.Name(r.from).Matches(state.Name()) {
+		return false
+	}
+	if dns.Name(state.Name()) == dns.Name(r.from) {
+		return true
+	}
+	for _, ignore := range r.ignored {
+		if plugin.Name(ignore).Matches(state.Name()) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// errMaxDepth is returned when resolving a name takes too many nested lookups.
+	errMaxDepth = errors.New("maximum recursion depth exceeded")
+	// errMaxIterations is returned when following referrals doesn't end.
+	errMaxIterations = errors.New("maximum number of referrals exceeded")
+	// errNoServers is returned when none of the name servers for a zone gave a usable reply.
+	errNoServers = errors.New("no usable name servers")
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultMaxDepth = 8
+	maxIterations   = 32 // maximum number of referrals we follow to resolve a single name.
+	maxMinimise     = 10 // maximum number of minimised queries for a single name, MAX_MINIMISE_COUNT in RFC 9156.
+	maxGlueless     = 3  // maximum number of name servers without glue we look up for a referral.
+	maxLookups      = 12 // maximum number of name servers without glue we look up for a query.
+)
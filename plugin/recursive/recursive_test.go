@@ -0,0 +1,367 @@
+package recursive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// hierarchy is a mock DNS hierarchy: each zone is served by its own file plugin. The name servers in the zones
+// have addresses in 127.0.0.0/8, exchange sends the queries for these to the right plugin.
+type hierarchy struct {
+	servers map[string]file.File // glue address -> zone.
+
+	mu      sync.Mutex
+	queries []string // queries seen, as "address name type".
+}
+
+func newHierarchy(t *testing.T, zones map[string]string) *hierarchy {
+	h := &hierarchy{servers: make(map[string]file.File)}
+	for addr, db := range zones {
+		// The origin is the owner name of the first record, the SOA.
+		origin := strings.Fields(db)[0]
+		z, err := file.Parse(strings.NewReader(db), origin, "stdin", 0)
+		if err != nil {
+			t.Fatalf("Failed to parse zone %s: %s", origin, err)
+		}
+		h.servers[addr] = file.File{Next: test.ErrorHandler(), Zones: file.Zones{Z: map[string]*file.Zone{origin: z}, Names: []string{origin}}}
+	}
+	return h
+}
+
+func (h *hierarchy) exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	h.mu.Lock()
+	h.queries = append(h.queries, fmt.Sprintf("%s %s %s", addr, strings.ToLower(m.Question[0].Name), dns.Type(m.Question[0].Qtype)))
+	h.mu.Unlock()
+
+	f, ok := h.servers[addr]
+	if !ok {
+		return nil, fmt.Errorf("no server at %s", addr)
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(ctx, rec, m.Copy()); err != nil {
+		return nil, err
+	}
+	if rec.Msg == nil {
+		return nil, fmt.Errorf("no reply from %s", addr)
+	}
+	return rec.Msg.Copy(), nil // the file plugin returns the RRs from the zone itself.
+}
+
+func (h *hierarchy) reset() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q := h.queries
+	h.queries = nil
+	return q
+}
+
+func (h *hierarchy) recursive() *Recursive {
+	r := New()
+	r.hints = []string{"127.0.0.1:53"}
+	r.exchange = h.exchange
+	return r
+}
+
+var zones = map[string]string{
+	"127.0.0.1:53": `.	3600	IN	SOA	ns.root. hostmaster.root. 1 7200 3600 1209600 3600
+.		3600	IN	NS	ns.root.
+ns.root.	3600	IN	A	127.0.0.1
+org.		3600	IN	NS	ns1.org.
+ns1.org.	3600	IN	A	127.0.0.2
+net.		3600	IN	NS	ns1.net.
+ns1.net.	3600	IN	A	127.0.0.4
+`,
+	"127.0.0.2:53": `org.	3600	IN	SOA	ns1.org. hostmaster.org. 1 7200 3600 1209600 3600
+org.		3600	IN	NS	ns1.org.
+ns1.org.	3600	IN	A	127.0.0.2
+example.org.	3600	IN	NS	ns.example.org.
+ns.example.org.	3600	IN	A	127.0.0.3
+other.org.	3600	IN	NS	ns.example.net.
+`,
+	"127.0.0.3:53": `example.org.	3600	IN	SOA	ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600
+example.org.	3600	IN	NS	ns.example.org.
+ns.example.org.	3600	IN	A	127.0.0.3
+www.example.org.	3600	IN	A	192.0.2.1
+alias.example.org.	3600	IN	CNAME	www.example.org.
+far.example.org.	3600	IN	CNAME	www.other.org.
+a.b.example.org.	3600	IN	A	192.0.2.2
+`,
+	"127.0.0.4:53": `net.	3600	IN	SOA	ns1.net. hostmaster.net. 1 7200 3600 1209600 3600
+net.		3600	IN	NS	ns1.net.
+ns1.net.	3600	IN	A	127.0.0.4
+example.net.	3600	IN	A	127.0.0.5
+ns.example.net.	3600	IN	A	127.0.0.5
+`,
+	"127.0.0.5:53": `other.org.	3600	IN	SOA	ns.example.net. hostmaster.other.org. 1 7200 3600 1209600 3600
+other.org.	3600	IN	NS	ns.example.net.
+www.other.org.	3600	IN	A	192.0.2.3
+`,
+}
+
+func TestRecursive(t *testing.T) {
+	h := newHierarchy(t, zones)
+
+	tests := []struct {
+		qname          string
+		qtype          uint16
+		expectedCode   int
+		expectedAnswer []string
+	}{
+		{"www.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"www.example.org.\t3600\tIN\tA\t192.0.2.1"}},
+		{"WWW.Example.ORG.", dns.TypeA, dns.RcodeSuccess, []string{"WWW.Example.ORG.\t3600\tIN\tA\t192.0.2.1"}},
+		{"alias.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"alias.example.org.\t3600\tIN\tCNAME\twww.example.org.", "www.example.org.\t3600\tIN\tA\t192.0.2.1"}},
+		// CNAME into another zone, whose name server has no glue.
+		{"far.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"far.example.org.\t3600\tIN\tCNAME\twww.other.org.", "www.other.org.\t3600\tIN\tA\t192.0.2.3"}},
+		// Empty non-terminal on the way.
+		{"a.b.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"a.b.example.org.\t3600\tIN\tA\t192.0.2.2"}},
+		{"www.example.org.", dns.TypeAAAA, dns.RcodeSuccess, nil},
+		{"missing.example.org.", dns.TypeA, dns.RcodeNameError, nil},
+		{"a.missing.example.org.", dns.TypeA, dns.RcodeNameError, nil},
+		{"missing.", dns.TypeA, dns.RcodeNameError, nil},
+	}
+
+	for i, tc := range tests {
+		r := h.recursive()
+		r.random = false // keep the case of the qname predictable
+
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if rec.Msg.Rcode != tc.expectedCode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.expectedCode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+		if !rec.Msg.RecursionAvailable {
+			t.Errorf("Test %d: expected RA to be set", i)
+		}
+		if len(rec.Msg.Answer) != len(tc.expectedAnswer) {
+			t.Errorf("Test %d: expected %d answers, got %d: %v", i, len(tc.expectedAnswer), len(rec.Msg.Answer), rec.Msg.Answer)
+			continue
+		}
+		for j, rr := range rec.Msg.Answer {
+			if rr.String() != tc.expectedAnswer[j] {
+				t.Errorf("Test %d: expected answer %q, got %q", i, tc.expectedAnswer[j], rr.String())
+			}
+		}
+	}
+}
+
+func TestRecursiveEdns(t *testing.T) {
+	h := newHierarchy(t, zones)
+
+	r := h.recursive()
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if rec.Msg.IsEdns0() == nil {
+		t.Errorf("Expected OPT RR in the reply to a query with EDNS0")
+	}
+}
+
+func TestGluelessLimit(t *testing.T) {
+	// A referral to many name servers that don't exist.
+	nxns := ""
+	for i := 0; i < 20; i++ {
+		nxns += fmt.Sprintf("nxns.org.\t3600\tIN\tNS\tns%d.nowhere.net.\n", i)
+	}
+	z := make(map[string]string, len(zones))
+	for addr, db := range zones {
+		z[addr] = db
+	}
+	z["127.0.0.2:53"] += nxns
+	h := newHierarchy(t, z)
+
+	r := h.recursive()
+	m := new(dns.Msg)
+	m.SetQuestion("www.nxns.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err == nil {
+		t.Errorf("Expected error, got none")
+	}
+
+	looked := map[string]bool{}
+	for _, q := range h.reset() {
+		if f := strings.Fields(q); strings.HasSuffix(f[1], ".nowhere.net.") {
+			looked[f[1]] = true
+		}
+	}
+	if len(looked) != maxGlueless {
+		t.Errorf("Expected %d name servers to be looked up, got %d: %v", maxGlueless, len(looked), looked)
+	}
+}
+
+func TestGluelessIPv6(t *testing.T) {
+	// The name server of v6.org. only has an IPv6 address.
+	z := make(map[string]string, len(zones))
+	for addr, db := range zones {
+		z[addr] = db
+	}
+	z["127.0.0.2:53"] += "v6.org.\t3600\tIN\tNS\tns.v6.net.\n"
+	z["127.0.0.4:53"] += "ns.v6.net.\t3600\tIN\tAAAA\t2001:db8::6\n"
+	z["[2001:db8::6]:53"] = `v6.org.	3600	IN	SOA	ns.v6.net. hostmaster.v6.org. 1 7200 3600 1209600 3600
+v6.org.		3600	IN	NS	ns.v6.net.
+www.v6.org.	3600	IN	A	192.0.2.6
+`
+	h := newHierarchy(t, z)
+
+	r := h.recursive()
+	m := new(dns.Msg)
+	m.SetQuestion("www.v6.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(rec.Msg.Answer) != 1 {
+		t.Errorf("Expected 1 answer, got %v", rec.Msg.Answer)
+	}
+}
+
+func TestQnameMinimisation(t *testing.T) {
+	h := newHierarchy(t, zones)
+
+	r := h.recursive()
+	m := new(dns.Msg)
+	m.SetQuestion("a.b.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	expected := []string{
+		"127.0.0.1:53 org. NS",
+		"127.0.0.2:53 example.org. NS",
+		"127.0.0.3:53 b.example.org. NS",
+		"127.0.0.3:53 a.b.example.org. A",
+	}
+	queries := h.reset()
+	if strings.Join(queries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected queries:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(queries, "\n"))
+	}
+
+	// The delegations are cached, so a second query goes straight to the servers for example.org.
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	queries = h.reset()
+	if len(queries) != 1 || queries[0] != "127.0.0.3:53 www.example.org. A" {
+		t.Errorf("Expected a single query to 127.0.0.3:53, got %v", queries)
+	}
+}
+
+func TestQnameMinimisationLimit(t *testing.T) {
+	// A name with more labels than the referrals we follow; all names on the way to it exist.
+	deep := strings.Repeat("a.", 40) + "example.org."
+	z := make(map[string]string, len(zones))
+	for addr, db := range zones {
+		z[addr] = db
+	}
+	z["127.0.0.3:53"] += deep + "\t3600\tIN\tA\t192.0.2.4\n"
+	h := newHierarchy(t, z)
+
+	r := h.recursive()
+	m := new(dns.Msg)
+	m.SetQuestion(deep, dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(rec.Msg.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(rec.Msg.Answer))
+	}
+
+	queries := h.reset()
+	if len(queries) != maxMinimise+1 {
+		t.Errorf("Expected %d queries, got %d", maxMinimise+1, len(queries))
+	}
+	if last := queries[len(queries)-1]; last != "127.0.0.3:53 "+deep+" A" {
+		t.Errorf("Expected the full name to be sent after %d minimised queries, got %q", maxMinimise, last)
+	}
+}
+
+func TestNoQnameMinimisation(t *testing.T) {
+	h := newHierarchy(t, zones)
+
+	r := h.recursive()
+	r.qmin = false
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	for _, q := range h.reset() {
+		if !strings.HasSuffix(q, " www.example.org. A") {
+			t.Errorf("Expected only queries for the full name, got %q", q)
+		}
+	}
+}
+
+func TestCaseRandomisation(t *testing.T) {
+	// A server that lower cases the question, like a spoofed reply would have.
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Question[0].Name = strings.ToLower(m.Question[0].Name)
+		m.Answer = []dns.RR{test.A(m.Question[0].Name + " 3600 IN A 192.0.2.1")}
+		w.WriteMsg(m)
+	})
+	defer s.Close()
+
+	r := New()
+	r.hints = []string{s.Addr}
+	// Use a name long enough that randomCase is all but certain to change it.
+	m := new(dns.Msg)
+	m.SetQuestion("abcdefghijklmnopqrstuvwxyz.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err == nil {
+		t.Errorf("Expected error for reply with the wrong case, got none")
+	}
+
+	r.random = false
+	if _, err := r.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+}
+
+func TestRandomCase(t *testing.T) {
+	for _, name := range []string{"example.org.", "a\\.b.example.org.", "."} {
+		if x := randomCase(name); !strings.EqualFold(x, name) {
+			t.Errorf("Expected %q to only differ in case from %q", x, name)
+		}
+	}
+}
+
+func TestChild(t *testing.T) {
+	tests := []struct {
+		known, name, expected string
+	}{
+		{".", "www.example.org.", "org."},
+		{"org.", "www.example.org.", "example.org."},
+		{"example.org.", "www.example.org.", "www.example.org."},
+		{"www.example.org.", "www.example.org.", "www.example.org."},
+	}
+	for i, tc := range tests {
+		if x := child(tc.known, tc.name); x != tc.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, tc.expected, x)
+		}
+	}
+}
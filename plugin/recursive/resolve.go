@@ -0,0 +1,326 @@
+package recursive
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// resolve resolves qname and qtype, starting at the closest delegation we know of. With QNAME minimisation
+// (RFC 7816) only one label more than the name we know exists is sent to the servers, until we reach
+// the servers authoritative for qname. After maxMinimise minimised queries the full qname is sent, so a
+// name with many labels can't exhaust the referrals we follow (RFC 9156, section 2.3). Depth is the number
+// of nested lookups we're in, wk the work budget of the query.
+func (r *Recursive) resolve(ctx context.Context, qname string, qtype uint16, depth int, wk *work) (*dns.Msg, error) {
+	if depth > r.maxDepth {
+		return nil, errMaxDepth
+	}
+
+	d := r.infra.closest(qname, r.hints)
+	known := d.zone // longest name we know exists on the way to qname.
+	minimise := r.qmin
+	steps := 0 // minimised queries sent.
+
+	for i := 0; i < maxIterations; {
+		qn, qt := qname, qtype
+		if minimise && steps < maxMinimise {
+			if n := child(known, qname); !strings.EqualFold(n, qname) {
+				qn, qt = n, dns.TypeNS
+				steps++
+			}
+		}
+
+		ret, err := r.query(ctx, d.servers, qn, qt)
+		if err != nil {
+			return nil, err
+		}
+
+		if zone, ns := referral(ret, d.zone, qn); zone != "" {
+			nd, err := r.delegation(ctx, ret, ns, zone, d.zone, depth, wk)
+			if err != nil {
+				return nil, err
+			}
+			r.infra.add(nd)
+			d, known = nd, nd.zone
+			i++
+			continue
+		}
+
+		if qn != qname {
+			// A minimised query; if it exists we can add a label, otherwise ask for qname itself, as some
+			// servers get this wrong (RFC 7816, section 3).
+			if ret.Rcode == dns.RcodeSuccess {
+				known = qn
+				continue
+			}
+			minimise = false
+			continue
+		}
+
+		return r.chase(ctx, ret, qname, qtype, depth, wk)
+	}
+	return nil, errMaxIterations
+}
+
+// chase follows the CNAME chain in ret, when it ends outside of ret a new lookup is done for the target.
+func (r *Recursive) chase(ctx context.Context, ret *dns.Msg, qname string, qtype uint16, depth int, wk *work) (*dns.Msg, error) {
+	if ret.Rcode != dns.RcodeSuccess || qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return ret, nil
+	}
+
+	name := qname
+	for i := 0; i < len(ret.Answer); i++ { // bounded to prevent loops.
+		target := ""
+		for _, rr := range ret.Answer {
+			if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+				return ret, nil
+			}
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+				target = c.Target
+			}
+		}
+		if target == "" {
+			break
+		}
+		name = target
+	}
+	if name == qname {
+		return ret, nil
+	}
+
+	next, err := r.resolve(ctx, name, qtype, depth+1, wk)
+	if err != nil {
+		return nil, err
+	}
+	ret.Rcode = next.Rcode
+	ret.Answer = append(ret.Answer, next.Answer...)
+	ret.Ns = next.Ns
+	return ret, nil
+}
+
+// referral returns the zone ret delegates to, if ret is a referral from the servers of zone for qname, together
+// with the NS records for the new zone. The new zone must be below zone, and at or above qname. As some servers
+// answer a query for the NS records of a delegation with these records in the answer section, that is also
+// treated as a referral.
+func referral(ret *dns.Msg, zone, qname string) (string, []dns.RR) {
+	if ret.Rcode != dns.RcodeSuccess {
+		return "", nil
+	}
+	rrs := ret.Ns
+	if len(ret.Answer) > 0 {
+		if ret.Question[0].Qtype != dns.TypeNS {
+			return "", nil
+		}
+		rrs = ret.Answer
+	} else if ret.Authoritative {
+		return "", nil
+	}
+
+	qname = strings.ToLower(qname)
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeNS {
+			continue
+		}
+		cut := strings.ToLower(rr.Header().Name)
+		if cut != zone && dns.IsSubDomain(zone, cut) && dns.IsSubDomain(cut, qname) {
+			return cut, rrs
+		}
+	}
+	return "", nil
+}
+
+// delegation returns the delegation to zone from the NS records ns and the glue in ret, sent by the servers of
+// parent. Glue is only used when it is in parent's bailiwick, if there is no usable glue the addresses of the
+// name servers are looked up. At most maxGlueless names are looked up, and each lookup is taken from the work
+// budget wk: a referral to many name servers that don't resolve can't make us do many lookups (NXNS attack).
+func (r *Recursive) delegation(ctx context.Context, ret *dns.Msg, ns []dns.RR, zone, parent string, depth int, wk *work) (*delegation, error) {
+	ttl := uint32(maxInfraTTL / time.Second)
+	var names []string
+	for _, rr := range ns {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, zone) {
+			names = append(names, strings.ToLower(ns.Ns))
+			if ns.Hdr.Ttl < ttl {
+				ttl = ns.Hdr.Ttl
+			}
+		}
+	}
+
+	var servers []string
+	for _, rr := range ret.Extra {
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(parent, name) || !contains(names, name) {
+			continue
+		}
+		switch a := rr.(type) {
+		case *dns.A:
+			servers = append(servers, net.JoinHostPort(a.A.String(), "53"))
+		case *dns.AAAA:
+			servers = append(servers, net.JoinHostPort(a.AAAA.String(), "53"))
+		}
+	}
+
+	if len(servers) == 0 {
+		tried := 0
+		for _, name := range names {
+			if dns.IsSubDomain(zone, name) {
+				continue // would need glue, which we don't have.
+			}
+			if tried == maxGlueless || wk.lookups == 0 {
+				log.Debugf("Not resolving more name servers for %s, the limit has been reached", zone)
+				break
+			}
+			tried++
+			wk.lookups--
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				m, err := r.resolve(ctx, name, qtype, depth+1, wk)
+				if err != nil {
+					log.Debugf("Failed to resolve name server %s %s for %s: %s", name, dns.Type(qtype), zone, err)
+					continue
+				}
+				for _, rr := range m.Answer {
+					switch a := rr.(type) {
+					case *dns.A:
+						servers = append(servers, net.JoinHostPort(a.A.String(), "53"))
+					case *dns.AAAA:
+						servers = append(servers, net.JoinHostPort(a.AAAA.String(), "53"))
+					}
+				}
+			}
+			if len(servers) > 0 {
+				break
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errNoServers
+	}
+
+	return &delegation{zone: zone, servers: servers, expire: r.infra.now().Add(time.Duration(ttl) * time.Second)}, nil
+}
+
+// query sends a non-recursive query for name and qtype to servers, until one of them gives a usable reply.
+func (r *Recursive) query(ctx context.Context, servers []string, name string, qtype uint16) (*dns.Msg, error) {
+	qname := name
+	if r.random {
+		qname = randomCase(name)
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(defaultBufSize, false)
+
+	err := errNoServers
+	start := rand.Intn(len(servers))
+	for i := range servers {
+		addr := servers[(start+i)%len(servers)]
+		ret, e := r.exchange(ctx, m, addr)
+		if e != nil {
+			log.Debugf("Failed to query %s for %s %s: %s", addr, name, dns.Type(qtype), e)
+			err = e
+			continue
+		}
+		QueryCount.WithLabelValues(dns.RcodeToString[ret.Rcode]).Inc()
+		// With 0x20 the reply must have the question exactly as we sent it.
+		if len(ret.Question) != 1 || ret.Question[0].Name != qname || ret.Question[0].Qtype != qtype {
+			log.Debugf("Wrong question in reply from %s for %s %s", addr, name, dns.Type(qtype))
+			continue
+		}
+		if ret.Rcode != dns.RcodeSuccess && ret.Rcode != dns.RcodeNameError {
+			continue
+		}
+		restoreCase(ret, qname, name)
+		return ret, nil
+	}
+	return nil, err
+}
+
+// exchange is the default exchange function, it uses UDP and retries over TCP when the reply is truncated.
+func exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: defaultQueryTimeout}
+	ret, _, err := c.ExchangeContext(ctx, m, addr)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Truncated {
+		c.Net = "tcp"
+		ret, _, err = c.ExchangeContext(ctx, m, addr)
+	}
+	return ret, err
+}
+
+// randomCase randomises the case of the letters in name (draft-vixie-dnsext-dns0x20), to make spoofing replies
+// harder.
+func randomCase(name string) string {
+	b := []byte(name)
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' {
+			i++ // leave escaped characters alone.
+			continue
+		}
+		if rand.Intn(2) == 0 {
+			continue
+		}
+		switch c := b[i]; {
+		case c >= 'a' && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+// restoreCase replaces sent, the randomised name, with name in ret.
+func restoreCase(ret *dns.Msg, sent, name string) {
+	ret.Question[0].Name = name
+	for _, rrs := range [][]dns.RR{ret.Answer, ret.Ns, ret.Extra} {
+		for _, rr := range rrs {
+			if strings.EqualFold(rr.Header().Name, sent) {
+				rr.Header().Name = name
+			}
+		}
+	}
+}
+
+// child returns the name one label longer than known, on the way to name.
+func child(known, name string) string {
+	labels := dns.SplitDomainName(name)
+	n := dns.CountLabel(known) + 1
+	if n >= len(labels) {
+		return name
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// parent returns the parent of name, name must not be the root.
+func parent(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// work is the work budget of a query, it's shared by all the nested lookups done for it.
+type work struct {
+	lookups int // name servers without glue we may still look up.
+}
+
+const (
+	defaultBufSize      = 1232
+	defaultQueryTimeout = 2 * time.Second
+	maxInfraTTL         = 24 * time.Hour // maximum time we keep a delegation.
+)
@@ -0,0 +1,112 @@
+package recursive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+func init() { plugin.Register("recursive", setup) }
+
+func setup(c *caddy.Controller) error {
+	r, err := parseRecursive(c)
+	if err != nil {
+		return plugin.Error("recursive", err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		r.Next = next
+		return r
+	})
+
+	return nil
+}
+
+func parseRecursive(c *caddy.Controller) (*Recursive, error) {
+	r := New()
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+		if len(args) > 1 {
+			return nil, c.ArgErr()
+		}
+		if len(args) == 1 {
+			r.from = plugin.Host(args[0]).Normalize()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "except":
+				ignore := c.RemainingArgs()
+				if len(ignore) == 0 {
+					return nil, c.ArgErr()
+				}
+				for i := range ignore {
+					ignore[i] = plugin.Host(ignore[i]).Normalize()
+				}
+				r.ignored = ignore
+			case "root_hints":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				file := c.Val()
+				config := dnsserver.GetConfig(c)
+				if !filepath.IsAbs(file) && config.Root != "" {
+					file = filepath.Join(config.Root, file)
+				}
+				hints, err := readHintsFile(file)
+				if err != nil {
+					return nil, err
+				}
+				r.hints = hints
+			case "no_qname_minimization":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				r.qmin = false
+			case "no_0x20":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				r.random = false
+			case "max_depth":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+				if n <= 0 {
+					return nil, fmt.Errorf("max_depth must be positive: %d", n)
+				}
+				r.maxDepth = n
+			case "infra_cache":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+				if n <= 0 {
+					return nil, fmt.Errorf("infra_cache must be positive: %d", n)
+				}
+				r.infra = newInfra(n)
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+	return r, nil
+}
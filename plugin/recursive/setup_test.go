@@ -0,0 +1,88 @@
+package recursive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recursive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hints := filepath.Join(dir, "named.root")
+	if err := ioutil.WriteFile(hints, []byte(namedRoot), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedFrom  string
+		expectedHints int
+		expectedQmin  bool
+		expectedErr   string
+	}{
+		// positive
+		{"recursive", false, ".", len(rootHints), true, ""},
+		{"recursive example.org", false, "example.org.", len(rootHints), true, ""},
+		{"recursive . {\nexcept example.org\nno_qname_minimization\nno_0x20\n}\n", false, ".", len(rootHints), false, ""},
+		{"recursive . {\nroot_hints " + hints + "\n}\n", false, ".", 3, true, ""},
+		{"recursive . {\nmax_depth 4\ninfra_cache 100\n}\n", false, ".", len(rootHints), true, ""},
+		// negative
+		{"recursive . example.org", true, "", 0, false, "Wrong argument count"},
+		{"recursive . {\nroot_hints " + filepath.Join(dir, "missing") + "\n}\n", true, "", 0, false, "no such file"},
+		{"recursive . {\nmax_depth 0\n}\n", true, "", 0, false, "positive"},
+		{"recursive . {\ninfra_cache many\n}\n", true, "", 0, false, "invalid syntax"},
+		{"recursive . {\nno_0x20 yes\n}\n", true, "", 0, false, "Wrong argument count"},
+		{"recursive . {\nforwarders 8.8.8.8\n}\n", true, "", 0, false, "unknown property"},
+		{"recursive\nrecursive", true, "", 0, false, "plugin"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		r, err := parseRecursive(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			continue
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if r.from != test.expectedFrom {
+			t.Errorf("Test %d: expected from %q, got %q", i, test.expectedFrom, r.from)
+		}
+		if len(r.hints) != test.expectedHints {
+			t.Errorf("Test %d: expected %d root hints, got %d", i, test.expectedHints, len(r.hints))
+		}
+		if r.qmin != test.expectedQmin {
+			t.Errorf("Test %d: expected qname minimization to be %t", i, test.expectedQmin)
+		}
+	}
+}
+
+const namedRoot = `; formerly NS.INTERNIC.NET
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+;
+; FORMERLY NS1.ISI.EDU
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+; not a root server
+C.EXAMPLE.NET.           3600000      A     192.0.2.1
+`
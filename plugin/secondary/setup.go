@@ -23,6 +23,7 @@ func setup(c *caddy.Controller) error {
 		if len(z.TransferFrom) > 0 {
 			c.OnStartup(func() error {
 				z.StartupOnce.Do(func() {
+					z.IXFR = dnsserver.GetConfig(c).Handler("transfer") != nil
 					go func() {
 						z.TransferIn()
 						z.Update()
@@ -70,6 +71,16 @@ func secondaryParse(c *caddy.Controller) (file.Zones, error) {
 					if err != nil {
 						return file.Zones{}, err
 					}
+				case "tsig":
+					name, alg, secret, err := parse.TSIGKey(c)
+					if err != nil {
+						return file.Zones{}, err
+					}
+					for _, origin := range origins {
+						z[origin].TsigKey, z[origin].TsigAlgorithm, z[origin].TsigSecret = name, alg, secret
+					}
+					// The NOTIFYs of the primaries are signed with the key as well.
+					dnsserver.GetConfig(c).AddTsigSecret(name, secret)
 				default:
 					return file.Zones{}, c.Errf("unknown property '%s'", c.Val())
 				}
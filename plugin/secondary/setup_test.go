@@ -12,12 +12,14 @@ func TestSecondaryParse(t *testing.T) {
 		shouldErr      bool
 		transferFrom   string
 		zones          []string
+		key            string
 	}{
 		{
 			`secondary`,
 			false, // TODO(miek): should actually be true, because without transfer lines this does not make sense
 			"",
 			nil,
+			"",
 		},
 		{
 			`secondary {
@@ -26,14 +28,36 @@ func TestSecondaryParse(t *testing.T) {
 			false,
 			"127.0.0.1:53",
 			nil,
+			"",
+		},
+		{
+			`secondary example.org {
+				transfer from 127.0.0.1
+			}`,
+			false,
+			"127.0.0.1:53",
+			[]string{"example.org."},
+			"",
 		},
 		{
 			`secondary example.org {
 				transfer from 127.0.0.1
+				tsig xfr.example.org hmac-sha256 c2VjcmV0
 			}`,
 			false,
 			"127.0.0.1:53",
 			[]string{"example.org."},
+			"xfr.example.org.",
+		},
+		{
+			`secondary example.org {
+				transfer from 127.0.0.1
+				tsig xfr.example.org hmac-foo c2VjcmV0
+			}`,
+			true,
+			"",
+			nil,
+			"",
 		},
 	}
 
@@ -46,6 +70,9 @@ func TestSecondaryParse(t *testing.T) {
 		} else if err != nil && !test.shouldErr {
 			t.Fatalf("Test %d expected no errors, but got '%v'", i, err)
 		}
+		if test.shouldErr {
+			continue
+		}
 
 		for i, name := range test.zones {
 			if x := s.Names[i]; x != name {
@@ -58,6 +85,9 @@ func TestSecondaryParse(t *testing.T) {
 			if x := v.TransferFrom[0]; x != test.transferFrom {
 				t.Fatalf("Test %d transform from names don't match expected %q, but got %q", i, test.transferFrom, x)
 			}
+			if v.TsigKey != test.key {
+				t.Fatalf("Test %d expected TSIG key %q, but got %q", i, test.key, v.TsigKey)
+			}
 		}
 	}
 }
@@ -1,109 +1,36 @@
 package sign
 
 import (
-	"crypto"
-	"crypto/ecdsa"
-	"crypto/rsa"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/coredns/caddy"
-	"github.com/coredns/coredns/core/dnsserver"
-
-	"github.com/miekg/dns"
-	"golang.org/x/crypto/ed25519"
+	"github.com/coredns/coredns/plugin/pkg/keypair"
 )
 
 // Pair holds DNSSEC key information, both the public and private components are stored here.
-type Pair struct {
-	Public  *dns.DNSKEY
-	KeyTag  uint16
-	Private crypto.Signer
-}
+type Pair keypair.Pair
 
-// keyParse reads the public and private key from disk.
+// keyParse reads the public and private keys, the key source is the current token.
 func keyParse(c *caddy.Controller) ([]Pair, error) {
-	if !c.NextArg() {
-		return nil, c.ArgErr()
-	}
-	pairs := []Pair{}
-	config := dnsserver.GetConfig(c)
-
-	switch c.Val() {
-	case "file":
-		ks := c.RemainingArgs()
-		if len(ks) == 0 {
-			return nil, c.ArgErr()
-		}
-		for _, k := range ks {
-			base := k
-			// Kmiek.nl.+013+26205.key, handle .private or without extension: Kmiek.nl.+013+26205
-			if strings.HasSuffix(k, ".key") {
-				base = k[:len(k)-4]
-			}
-			if strings.HasSuffix(k, ".private") {
-				base = k[:len(k)-8]
-			}
-			if !filepath.IsAbs(base) && config.Root != "" {
-				base = filepath.Join(config.Root, base)
-			}
-
-			pair, err := readKeyPair(base+".key", base+".private")
-			if err != nil {
-				return nil, err
-			}
-			pairs = append(pairs, pair)
-		}
-	case "directory":
+	if c.Val() == "directory" {
 		return nil, fmt.Errorf("directory: not implemented")
 	}
-
-	return pairs, nil
-}
-
-func readKeyPair(public, private string) (Pair, error) {
-	rk, err := os.Open(public)
+	kps, err := keypair.Parse(c)
 	if err != nil {
-		return Pair{}, err
+		return nil, err
 	}
-	b, err := ioutil.ReadAll(rk)
-	if err != nil {
-		return Pair{}, err
-	}
-	dnskey, err := dns.NewRR(string(b))
-	if err != nil {
-		return Pair{}, err
-	}
-	if _, ok := dnskey.(*dns.DNSKEY); !ok {
-		return Pair{}, fmt.Errorf("RR in %q is not a DNSKEY: %d", public, dnskey.Header().Rrtype)
-	}
-	ksk := dnskey.(*dns.DNSKEY).Flags&(1<<8) == (1<<8) && dnskey.(*dns.DNSKEY).Flags&1 == 1
-	if !ksk {
-		return Pair{}, fmt.Errorf("DNSKEY in %q is not a CSK/KSK", public)
+	pairs := make([]Pair, len(kps))
+	for i := range kps {
+		pairs[i] = Pair(kps[i])
 	}
+	return pairs, nil
+}
 
-	rp, err := os.Open(private)
-	if err != nil {
-		return Pair{}, err
-	}
-	privkey, err := dnskey.(*dns.DNSKEY).ReadPrivateKey(rp, private)
-	if err != nil {
-		return Pair{}, err
-	}
-	switch signer := privkey.(type) {
-	case *ecdsa.PrivateKey:
-		return Pair{Public: dnskey.(*dns.DNSKEY), KeyTag: dnskey.(*dns.DNSKEY).KeyTag(), Private: signer}, nil
-	case ed25519.PrivateKey:
-		return Pair{Public: dnskey.(*dns.DNSKEY), KeyTag: dnskey.(*dns.DNSKEY).KeyTag(), Private: signer}, nil
-	case *rsa.PrivateKey:
-		return Pair{Public: dnskey.(*dns.DNSKEY), KeyTag: dnskey.(*dns.DNSKEY).KeyTag(), Private: signer}, nil
-	default:
-		return Pair{}, fmt.Errorf("unsupported algorithm %s", signer)
-	}
+// readKeyPair reads the key pair from the files base.key and base.private.
+func readKeyPair(base string) (Pair, error) {
+	p, err := keypair.Read(base, keypair.File{})
+	return Pair(p), err
 }
 
 // keyTag returns the key tags of the keys in ps as a formatted string.
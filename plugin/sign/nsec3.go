@@ -0,0 +1,99 @@
+package sign
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// nsec3 holds the parameters for the NSEC3 chain of a zone.
+type nsec3 struct {
+	salt       string // hex encoded, empty when no salt is used
+	iterations uint16
+	optout     bool
+}
+
+// param returns the NSEC3PARAM record for n. Its flags are always zero, opt-out is only signaled in the NSEC3
+// records (RFC 5155, Section 4.1.2).
+func (n *nsec3) param(origin string) *dns.NSEC3PARAM {
+	return &dns.NSEC3PARAM{
+		Hdr:        dns.RR_Header{Name: origin, Ttl: 0, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET},
+		Hash:       dns.SHA1,
+		Iterations: n.iterations,
+		SaltLength: uint8(len(n.salt) / 2),
+		Salt:       n.salt,
+	}
+}
+
+// chain returns the NSEC3 records for all the authoritative names and empty non-terminals in z, in hash order.
+// With opt-out, delegations without a DS record (and empty non-terminals that only lead to these) are left out.
+// This must be called before the zone is signed, as the RRSIG type is added to the type bitmaps here.
+func (n *nsec3) chain(origin string, z *file.Zone, ttl uint32) []*dns.NSEC3 {
+	bitmaps := map[string][]uint16{}
+	z.AuthWalk(func(e *tree.Elem, _ map[uint16][]dns.RR, auth bool) error {
+		if !auth {
+			return nil
+		}
+
+		name := e.Name()
+		switch {
+		case name == origin:
+			bitmaps[name] = append(e.Types(), dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG)
+		case e.Type(dns.TypeNS) != nil && e.Type(dns.TypeDS) == nil:
+			if n.optout {
+				return nil
+			}
+			bitmaps[name] = e.Types() // an unsigned delegation only has NS, which isn't signed.
+		default:
+			bitmaps[name] = append(e.Types(), dns.TypeRRSIG)
+		}
+
+		// Add the empty non-terminals between name and the origin.
+		for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+			ent := name[off:]
+			if !dns.IsSubDomain(origin, ent) || ent == origin {
+				break
+			}
+			if _, ok := bitmaps[ent]; !ok {
+				bitmaps[ent] = nil
+			}
+		}
+		return nil
+	})
+
+	hashes := make([]string, 0, len(bitmaps))
+	hashed := make(map[string]string, len(bitmaps))
+	for name := range bitmaps {
+		h := dns.HashName(name, dns.SHA1, n.iterations, n.salt)
+		hashes = append(hashes, h)
+		hashed[h] = name
+	}
+	sort.Strings(hashes)
+
+	flags := uint8(0)
+	if n.optout {
+		flags = 1
+	}
+	chain := make([]*dns.NSEC3, len(hashes))
+	for i, h := range hashes {
+		bitmap := bitmaps[hashed[h]]
+		sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+
+		chain[i] = &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(h) + "." + origin, Ttl: ttl, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			Iterations: n.iterations,
+			SaltLength: uint8(len(n.salt) / 2),
+			Salt:       n.salt,
+			HashLength: 20, // SHA1
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: bitmap,
+		}
+	}
+	return chain
+}
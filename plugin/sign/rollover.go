@@ -0,0 +1,460 @@
+package sign
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// rollover holds the timing parameters of the automated key rollovers. ZSKs are rolled with the pre-publish
+// method and KSKs (or CSKs when there are no ZSKs) with the double signature method, see RFC 7583.
+type rollover struct {
+	ksk         time.Duration // lifetime of a KSK, zero when KSKs aren't rolled
+	zsk         time.Duration // lifetime of a ZSK, zero when ZSKs aren't rolled
+	dsWait      time.Duration // time for the parent to replace the DS records, including the TTL of the old ones
+	propagation time.Duration // time for a change to the zone to reach all the secondaries
+}
+
+// Default timing parameters of the rollovers.
+const (
+	defaultDSWait      = 48 * time.Hour
+	defaultPropagation = 1 * time.Hour
+)
+
+// managed returns true if the keys with the role of ksk are rolled.
+func (r rollover) managed(ksk bool) bool {
+	if ksk {
+		return r.ksk > 0
+	}
+	return r.zsk > 0
+}
+
+// The states of a key during its lifetime. A published key is in the DNSKEY RRset, an active key signs and a
+// retired key is still published, but on its way out. A KSK signs the DNSKEY RRset in all these states, but only
+// an active KSK is published in the CDS and CDNSKEY records, i.e. it should be in the DS RRset of the parent.
+const (
+	statePublished = iota
+	stateActive
+	stateRetired
+	stateRemoved
+)
+
+// key is a key together with its state.
+type key struct {
+	Pair
+	file string // base name of the key files, i.e. without extension
+
+	published, active, retired, removed time.Time // when the key entered the state
+	next                                time.Time // when the key is due to enter the next state, if any
+}
+
+func (k *key) ksk() bool { return k.Public.Flags&dns.SEP == dns.SEP }
+
+func (k *key) unseen() bool { return k.published.IsZero() && k.active.IsZero() && k.removed.IsZero() }
+
+func (k *key) state() int {
+	switch {
+	case !k.removed.IsZero():
+		return stateRemoved
+	case !k.retired.IsZero():
+		return stateRetired
+	case !k.active.IsZero():
+		return stateActive
+	case !k.published.IsZero():
+		return statePublished
+	}
+	// Keys that aren't rolled have no state.
+	return stateActive
+}
+
+// keyStates returns the keys to sign the zone with at now. For the roles that are rolled, the keys' states are
+// advanced, new keys are generated and the state is written to the directory. dnskeyTTL and maxTTL are the TTL of
+// the DNSKEY RRset and the maximum TTL in the zone.
+func (s *Signer) keyStates(now time.Time, dnskeyTTL, maxTTL uint32) ([]*key, error) {
+	keys := make([]*key, 0, len(s.keys))
+	if !s.roll.managed(true) && !s.roll.managed(false) {
+		for _, p := range s.keys {
+			keys = append(keys, &key{Pair: p})
+		}
+		return keys, nil
+	}
+
+	keys, err := s.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		// A configured key that hasn't been seen before is taken to be published and active from now on.
+		if s.roll.managed(k.ksk()) && k.unseen() {
+			k.published, k.active = now, now
+		}
+	}
+
+	dnskey := time.Duration(dnskeyTTL) * time.Second
+	for _, ksk := range []bool{true, false} {
+		if !s.roll.managed(ksk) {
+			continue
+		}
+		lifetime, retire := s.roll.zsk, time.Duration(maxTTL)*time.Second+s.roll.propagation
+		if ksk {
+			lifetime, retire = s.roll.ksk, s.roll.dsWait
+		}
+		if keys, err = s.advance(keys, now, ksk, lifetime, dnskey+s.roll.propagation, retire); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range keys {
+		if !s.roll.managed(k.ksk()) {
+			continue
+		}
+		if err := s.writeState(k); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// advance advances the states of the keys with the role of ksk at now, and returns the (new) set of keys. A key is
+// replaced by a new key pre-published ahead of the end of its lifetime. Once that key has been published for publish,
+// it becomes active and the old key is retired. Retired keys are removed after retire.
+func (s *Signer) advance(keys []*key, now time.Time, ksk bool, lifetime, publish, retire time.Duration) ([]*key, error) {
+	var cur, succ *key
+	for _, k := range keys {
+		if k.ksk() != ksk {
+			continue
+		}
+		switch k.state() {
+		case statePublished:
+			succ = k
+		case stateActive:
+			if cur == nil || k.active.After(cur.active) {
+				cur = k
+			}
+		case stateRetired:
+			if !now.Before(k.retired.Add(retire)) {
+				k.removed = now
+				log.Infof("Removing %s with key tag %d from %q", role(ksk), k.KeyTag, s.origin)
+			}
+		}
+	}
+
+	if succ != nil && !now.Before(succ.published.Add(publish)) {
+		succ.active = now
+		log.Infof("Activating %s with key tag %d for %q", role(ksk), succ.KeyTag, s.origin)
+		// Every key that was active is retired, not only the current one.
+		for _, k := range keys {
+			if k.ksk() == ksk && k != succ && k.state() == stateActive {
+				k.retired = now
+				log.Infof("Retiring %s with key tag %d from %q", role(ksk), k.KeyTag, s.origin)
+			}
+		}
+		cur, succ = succ, nil
+	}
+
+	switch {
+	case cur == nil && succ == nil:
+		k, err := s.generate(keys, ksk)
+		if err != nil {
+			return nil, err
+		}
+		// The first key is published and active at once.
+		k.published, k.active = now, now
+		keys = append(keys, k)
+		cur = k
+		log.Infof("Generated %s with key tag %d for %q", role(ksk), k.KeyTag, s.origin)
+	case cur != nil && succ == nil && !now.Before(cur.active.Add(lifetime-publish)):
+		k, err := s.generate(keys, ksk)
+		if err != nil {
+			return nil, err
+		}
+		k.published = now
+		keys = append(keys, k)
+		succ = k
+		log.Infof("Generated %s with key tag %d for %q, pre-publishing it", role(ksk), k.KeyTag, s.origin)
+	}
+
+	// Note when the next step is due, so the zone is resigned then.
+	for _, k := range keys {
+		if k.ksk() != ksk {
+			continue
+		}
+		k.next = time.Time{}
+		switch k.state() {
+		case statePublished:
+			k.next = k.published.Add(publish)
+		case stateActive:
+			if k == cur && succ == nil {
+				k.next = k.active.Add(lifetime - publish)
+			}
+		case stateRetired:
+			k.next = k.retired.Add(retire)
+		}
+	}
+	return keys, nil
+}
+
+// hasZSK returns true if there are ZSKs in keys, that aren't removed.
+func hasZSK(keys []*key) bool {
+	for _, k := range keys {
+		if !k.ksk() && k.state() != stateRemoved {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTTL returns the maximum TTL of the records in z.
+func maxTTL(z *file.Zone) uint32 {
+	max := z.Apex.SOA.Header().Ttl
+	for _, rr := range z.Apex.NS {
+		if rr.Header().Ttl > max {
+			max = rr.Header().Ttl
+		}
+	}
+	z.Walk(func(_ *tree.Elem, rrs map[uint16][]dns.RR) error {
+		for _, rrset := range rrs {
+			for _, rr := range rrset {
+				if rr.Header().Ttl > max {
+					max = rr.Header().Ttl
+				}
+			}
+		}
+		return nil
+	})
+	return max
+}
+
+func role(ksk bool) string {
+	if ksk {
+		return "KSK"
+	}
+	return "ZSK"
+}
+
+// loadKeys returns the configured keys together with the keys in the directory, with their states.
+func (s *Signer) loadKeys() ([]*key, error) {
+	keys := []*key{}
+	seen := map[string]bool{}
+	for _, p := range s.keys {
+		k := &key{Pair: p, file: keyFile(p.Public)}
+		if err := s.readState(k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		seen[k.file] = true
+	}
+
+	states, err := filepath.Glob(filepath.Join(s.directory, "K"+s.origin+"+*.state"))
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		file := strings.TrimSuffix(filepath.Base(state), ".state")
+		if seen[file] {
+			continue
+		}
+		base := filepath.Join(s.directory, file)
+		p, err := readKeyPair(base)
+		if err != nil {
+			return nil, err
+		}
+		k := &key{Pair: p, file: file}
+		if err := s.readState(k); err != nil {
+			return nil, err
+		}
+		if k.state() == stateRemoved {
+			continue
+		}
+		// The owner name of the keys must be the origin, see parse.
+		k.Public.Header().Name = s.origin
+		keys = append(keys, k)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].published.Before(keys[j].published) })
+	return keys, nil
+}
+
+// keyFile returns the base name of the key files of k: K<name>+<alg>+<id>.
+func keyFile(k *dns.DNSKEY) string {
+	return fmt.Sprintf("K%s+%03d+%05d", dns.Fqdn(strings.ToLower(k.Header().Name)), k.Algorithm, k.KeyTag())
+}
+
+// generate generates a new key with the role of ksk and writes it to the directory. The algorithm is the
+// one of the existing keys, or ECDSAP256SHA256 if there are none.
+func (s *Signer) generate(keys []*key, ksk bool) (*key, error) {
+	alg := uint8(dns.ECDSAP256SHA256)
+	for _, k := range keys {
+		alg = k.Public.Algorithm
+		if k.ksk() == ksk {
+			break
+		}
+	}
+	bits := 256
+	switch alg {
+	case dns.RSASHA256, dns.RSASHA512:
+		bits = 2048
+	case dns.ECDSAP384SHA384:
+		bits = 384
+	}
+
+	flags := uint16(dns.ZONE)
+	if ksk {
+		flags |= dns.SEP
+	}
+
+	for {
+		dnskey := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: s.origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: alg,
+		}
+		priv, err := dnskey.Generate(bits)
+		if err != nil {
+			return nil, err
+		}
+		tag := dnskey.KeyTag()
+		collision := false
+		for _, k := range keys {
+			if k.KeyTag == tag {
+				collision = true
+			}
+		}
+		if collision {
+			continue
+		}
+
+		base := filepath.Join(s.directory, keyFile(dnskey))
+		if err := writeFileAtomic(base+".private", []byte(dnskey.PrivateKeyString(priv)), 0600); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(base+".key", []byte(dnskey.String()+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		p, err := readKeyPair(base)
+		if err != nil {
+			return nil, err
+		}
+		return &key{Pair: p, file: keyFile(dnskey)}, nil
+	}
+}
+
+// The state of a key is kept in the directory, in K<name>+<alg>+<id>.state, in a format similar to the one
+// of BIND9:
+//
+//	; This is the state of key 59725, for example.org.
+//	Published: 20201019120000
+//	Active: 20201019120000
+//	Next: 20210118110000
+const stateFmt = "20060102150405"
+
+func (s *Signer) readState(k *key) error {
+	buf, err := ioutil.ReadFile(filepath.Join(s.directory, k.file+".state"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return fmt.Errorf("malformed line in state of %s: %q", k.file, line)
+		}
+		t, err := time.Parse(stateFmt, strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("malformed time in state of %s: %s", k.file, err)
+		}
+		switch line[:i] {
+		case "Published":
+			k.published = t
+		case "Active":
+			k.active = t
+		case "Retired":
+			k.retired = t
+		case "Removed":
+			k.removed = t
+		case "Next":
+			k.next = t
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Signer) writeState(k *key) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "; This is the state of key %d, for %s\n", k.KeyTag, s.origin)
+	for _, t := range []struct {
+		name string
+		t    time.Time
+	}{{"Published", k.published}, {"Active", k.active}, {"Retired", k.retired}, {"Removed", k.removed}, {"Next", k.next}} {
+		if !t.t.IsZero() {
+			fmt.Fprintf(buf, "%s: %s\n", t.name, t.t.UTC().Format(stateFmt))
+		}
+	}
+	return writeFileAtomic(filepath.Join(s.directory, k.file+".state"), buf.Bytes(), 0644)
+}
+
+// rolloverDue returns an error when the next step of a key rollover is due at now.
+func (s *Signer) rolloverDue(now time.Time) error {
+	if !s.roll.managed(true) && !s.roll.managed(false) {
+		return nil
+	}
+	keys, err := s.loadKeys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !s.roll.managed(k.ksk()) {
+			continue
+		}
+		if k.unseen() {
+			return fmt.Errorf("%s with key tag %d has no rollover state", role(k.ksk()), k.KeyTag)
+		}
+		if !k.next.IsZero() && !now.Before(k.next) {
+			return fmt.Errorf("rollover of %s with key tag %d is due at %s", role(k.ksk()), k.KeyTag, k.next.Format(timeFmt))
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys")
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as file, which is then renamed to file.
+func writeFileAtomic(file string, data []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+	return os.Rename(f.Name(), file)
+}
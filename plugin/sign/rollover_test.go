@@ -0,0 +1,193 @@
+package sign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/file"
+
+	"github.com/miekg/dns"
+)
+
+// rolloverSign parses input, as after a restart, and signs the zone at now.
+func rolloverSign(t *testing.T, input string, now time.Time) (*Signer, *file.Zone) {
+	t.Helper()
+	c := caddy.NewTestController("dns", input)
+	sign, err := parse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sign.signers[0]
+	z, err := s.Sign(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, z
+}
+
+// signers returns the key tags of the keys that signed the records of type tc in the apex of z.
+func signers(z *file.Zone, tc uint16) map[uint16]bool {
+	tags := map[uint16]bool{}
+	sigs := z.Apex.SIGSOA
+	if tc != dns.TypeSOA {
+		apex, _ := z.Search(z.Apex.SOA.Header().Name)
+		sigs = apex.Type(dns.TypeRRSIG)
+	}
+	for _, sig := range sigs {
+		if sig.(*dns.RRSIG).TypeCovered == tc {
+			tags[sig.(*dns.RRSIG).KeyTag] = true
+		}
+	}
+	return tags
+}
+
+func apexKeys(z *file.Zone) (ksks, zsks []uint16) {
+	apex, _ := z.Search(z.Apex.SOA.Header().Name)
+	for _, rr := range apex.Type(dns.TypeDNSKEY) {
+		k := rr.(*dns.DNSKEY)
+		if k.Flags&dns.SEP == dns.SEP {
+			ksks = append(ksks, k.KeyTag())
+		} else {
+			zsks = append(zsks, k.KeyTag())
+		}
+	}
+	return ksks, zsks
+}
+
+func TestRolloverZSK(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := `sign testdata/db.miek.nl miek.nl {
+		key file testdata/Kmiek.nl.+013+59725
+		directory ` + dir + `
+		rollover zsk 30d
+		rollover propagation 1h
+	}`
+	publish := 30*time.Minute + time.Hour // DNSKEY TTL + propagation
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// Initial signing: a ZSK is generated and signs the zone, the KSK only signs the DNSKEY RRset.
+	s, z := rolloverSign(t, input, now)
+	ksks, zsks := apexKeys(z)
+	if len(ksks) != 1 || len(zsks) != 1 {
+		t.Fatalf("Expected 1 KSK and 1 ZSK, got %d and %d", len(ksks), len(zsks))
+	}
+	old := zsks[0]
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || !x[old] {
+		t.Errorf("Expected SOA to be signed by ZSK %d only, got %v", old, x)
+	}
+	if x := signers(z, dns.TypeDNSKEY); len(x) != 1 || !x[59725] {
+		t.Errorf("Expected DNSKEY to be signed by KSK %d only, got %v", 59725, x)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("Kmiek.nl.+013+%05d.private", old))); err != nil {
+		t.Errorf("Expected private key of generated ZSK: %s", err)
+	}
+	if err := s.rolloverDue(now.Add(time.Hour)); err != nil {
+		t.Errorf("Expected no rollover to be due, got %s", err)
+	}
+
+	// Pre-publish the new ZSK ahead of the end of the lifetime of the old one.
+	now = now.Add(30*24*time.Hour - publish)
+	if err := s.rolloverDue(now); err == nil {
+		t.Errorf("Expected rollover to be due, got none")
+	}
+	_, z = rolloverSign(t, input, now)
+	if _, zsks = apexKeys(z); len(zsks) != 2 {
+		t.Fatalf("Expected 2 ZSKs, got %d", len(zsks))
+	}
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || !x[old] {
+		t.Errorf("Expected SOA to be signed by ZSK %d only, got %v", old, x)
+	}
+
+	// Activate the new ZSK.
+	now = now.Add(publish)
+	_, z = rolloverSign(t, input, now)
+	if _, zsks = apexKeys(z); len(zsks) != 2 {
+		t.Fatalf("Expected 2 ZSKs, got %d", len(zsks))
+	}
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || x[old] {
+		t.Errorf("Expected SOA to be signed by the new ZSK only, got %v", x)
+	}
+
+	// Remove the old ZSK after the maximum TTL and propagation delay.
+	now = now.Add(30*time.Minute + time.Hour)
+	_, z = rolloverSign(t, input, now)
+	if _, zsks = apexKeys(z); len(zsks) != 1 || zsks[0] == old {
+		t.Fatalf("Expected only the new ZSK, got %v", zsks)
+	}
+}
+
+func TestRolloverKSK(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := `sign testdata/db.miek.nl miek.nl {
+		key file testdata/Kmiek.nl.+013+59725
+		directory ` + dir + `
+		rollover ksk 365d
+		rollover parent 24h
+	}`
+	publish := 30*time.Minute + time.Hour // DNSKEY TTL + propagation
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// Without ZSKs, the KSK is used as a CSK.
+	_, z := rolloverSign(t, input, now)
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || !x[59725] {
+		t.Errorf("Expected SOA to be signed by %d, got %v", 59725, x)
+	}
+
+	// Publish the new KSK, it signs the DNSKEY RRset along with the old one, but isn't in the CDS RRset yet.
+	now = now.Add(365*24*time.Hour - publish)
+	_, z = rolloverSign(t, input, now)
+	ksks, _ := apexKeys(z)
+	if len(ksks) != 2 {
+		t.Fatalf("Expected 2 KSKs, got %d", len(ksks))
+	}
+	if x := signers(z, dns.TypeDNSKEY); len(x) != 2 {
+		t.Errorf("Expected DNSKEY to be signed by 2 KSKs, got %v", x)
+	}
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || !x[59725] {
+		t.Errorf("Expected SOA to be signed by %d only, got %v", 59725, x)
+	}
+	apex, _ := z.Search("miek.nl.")
+	for _, cds := range apex.Type(dns.TypeCDS) {
+		if x := cds.(*dns.CDS).KeyTag; x != 59725 {
+			t.Errorf("Expected CDS for %d only, got %d", 59725, x)
+		}
+	}
+
+	// The new KSK becomes active, and replaces the old one in the CDS RRset.
+	now = now.Add(publish)
+	_, z = rolloverSign(t, input, now)
+	if x := signers(z, dns.TypeDNSKEY); len(x) != 2 {
+		t.Errorf("Expected DNSKEY to be signed by 2 KSKs, got %v", x)
+	}
+	apex, _ = z.Search("miek.nl.")
+	for _, cds := range apex.Type(dns.TypeCDS) {
+		if x := cds.(*dns.CDS).KeyTag; x == 59725 {
+			t.Errorf("Expected CDS for the new KSK only, got %d", x)
+		}
+	}
+	if x := signers(z, dns.TypeSOA); len(x) != 1 || x[59725] {
+		t.Errorf("Expected SOA to be signed by the new KSK only, got %v", x)
+	}
+
+	// The old KSK is removed once the parent has replaced the DS records.
+	now = now.Add(24 * time.Hour)
+	_, z = rolloverSign(t, input, now)
+	if ksks, _ = apexKeys(z); len(ksks) != 1 || ksks[0] == 59725 {
+		t.Errorf("Expected only the new KSK, got %v", ksks)
+	}
+}
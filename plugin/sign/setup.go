@@ -1,9 +1,12 @@
 package sign
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -73,9 +76,21 @@ func parse(c *caddy.Controller) (*Sign, error) {
 			}
 		}
 
+		roll := rollover{dsWait: defaultDSWait, propagation: defaultPropagation}
+		hsm := false
 		for c.NextBlock() {
 			switch c.Val() {
+			case "rollover":
+				if err := rolloverParse(c, &roll); err != nil {
+					return sign, err
+				}
 			case "key":
+				if !c.NextArg() {
+					return sign, c.ArgErr()
+				}
+				if c.Val() == "pkcs11" {
+					hsm = true
+				}
 				pairs, err := keyParse(c)
 				if err != nil {
 					return sign, err
@@ -98,12 +113,115 @@ func parse(c *caddy.Controller) (*Sign, error) {
 					signers[i].directory = dir[0]
 					signers[i].signedfile = fmt.Sprintf("db.%ssigned", signers[i].origin)
 				}
+			case "nsec3":
+				n, err := nsec3Parse(c)
+				if err != nil {
+					return sign, err
+				}
+				for i := range signers {
+					signers[i].nsec3 = n
+				}
 			default:
 				return nil, c.Errf("unknown property '%s'", c.Val())
 			}
 		}
+		if hsm && (roll.managed(true) || roll.managed(false)) {
+			return sign, c.Err("rollover can not generate keys on a PKCS#11 token")
+		}
+		for i := range signers {
+			signers[i].roll = roll
+		}
 		sign.signers = append(sign.signers, signers...)
 	}
 
 	return sign, nil
 }
+
+// nsec3Parse parses: nsec3 [salt SALT] [iterations ITERATIONS] [optout].
+func nsec3Parse(c *caddy.Controller) (*nsec3, error) {
+	n := &nsec3{}
+	args := c.RemainingArgs()
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "salt":
+			i++
+			if i == len(args) {
+				return nil, c.ArgErr()
+			}
+			if args[i] == "-" {
+				n.salt = ""
+				continue
+			}
+			salt, err := hex.DecodeString(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid NSEC3 salt %q: %s", args[i], err)
+			}
+			if len(salt) > 255 {
+				return nil, fmt.Errorf("NSEC3 salt %q is too long", args[i])
+			}
+			n.salt = strings.ToUpper(args[i])
+		case "iterations":
+			i++
+			if i == len(args) {
+				return nil, c.ArgErr()
+			}
+			it, err := strconv.ParseUint(args[i], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NSEC3 iterations %q: %s", args[i], err)
+			}
+			if it > maxIterations {
+				return nil, fmt.Errorf("NSEC3 iterations %d is more than %d", it, maxIterations)
+			}
+			n.iterations = uint16(it)
+		case "optout":
+			n.optout = true
+		default:
+			return nil, c.Errf("unknown nsec3 property '%s'", args[i])
+		}
+	}
+	return n, nil
+}
+
+// maxIterations is the maximum number of additional NSEC3 hash iterations, validators treat zones with
+// more as insecure (RFC 9276, Section 3.2).
+const maxIterations = 150
+
+// rolloverParse parses: rollover ksk|zsk LIFETIME, rollover parent DURATION and rollover propagation DURATION.
+func rolloverParse(c *caddy.Controller, roll *rollover) error {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return c.ArgErr()
+	}
+	d, err := parseDuration(args[1])
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("rollover %s must be positive: %s", args[0], args[1])
+	}
+	switch args[0] {
+	case "ksk":
+		roll.ksk = d
+	case "zsk":
+		roll.zsk = d
+	case "parent":
+		roll.dsWait = d
+	case "propagation":
+		roll.propagation = d
+	default:
+		return c.Errf("unknown rollover property '%s'", args[0])
+	}
+	return nil
+}
+
+// parseDuration parses a duration as time.ParseDuration does, but also accepts a number of days, i.e. "90d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
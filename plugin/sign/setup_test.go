@@ -2,6 +2,7 @@ package sign
 
 import (
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 )
@@ -37,6 +38,50 @@ func TestParse(t *testing.T) {
 				signedfile: "db.example.org.signed",
 			},
 		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 salt - iterations 0 optout
+		 }`,
+			false,
+			&Signer{
+				keys:       []Pair{},
+				origin:     "miek.nl.",
+				dbfile:     "testdata/db.miek.nl",
+				directory:  "/var/lib/coredns",
+				signedfile: "db.miek.nl.signed",
+				nsec3:      &nsec3{optout: true},
+			},
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 salt aabbccdd iterations 10
+		 }`,
+			false,
+			&Signer{
+				keys:       []Pair{},
+				origin:     "miek.nl.",
+				dbfile:     "testdata/db.miek.nl",
+				directory:  "/var/lib/coredns",
+				signedfile: "db.miek.nl.signed",
+				nsec3:      &nsec3{salt: "AABBCCDD", iterations: 10},
+			},
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			rollover ksk 365d
+			rollover zsk 720h
+			rollover parent 24h
+		 }`,
+			false,
+			&Signer{
+				keys:       []Pair{},
+				origin:     "miek.nl.",
+				dbfile:     "testdata/db.miek.nl",
+				directory:  "/var/lib/coredns",
+				signedfile: "db.miek.nl.signed",
+				roll:       rollover{ksk: 365 * 24 * time.Hour, zsk: 720 * time.Hour, dsWait: 24 * time.Hour, propagation: defaultPropagation},
+			},
+		},
 		// errors
 		{`sign db.example.org {
 			key file /etc/coredns/keys/Kexample.org
@@ -44,6 +89,62 @@ func TestParse(t *testing.T) {
 			true,
 			nil,
 		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 salt xyz
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 iterations 151
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 salt
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			nsec3 optin
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			rollover csk 30d
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			rollover zsk 0d
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			rollover zsk thirty
+		 }`,
+			true,
+			nil,
+		},
+		{`sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			rollover zsk
+		 }`,
+			true,
+			nil,
+		},
 	}
 	for i, tc := range tests {
 		c := caddy.NewTestController("dns", tc.input)
@@ -71,5 +172,11 @@ func TestParse(t *testing.T) {
 		if x := signer.signedfile; x != tc.exp.signedfile {
 			t.Errorf("Test %d expected %s as signedfile, got %s", i, tc.exp.signedfile, x)
 		}
+		if tc.exp.roll != (rollover{}) && signer.roll != tc.exp.roll {
+			t.Errorf("Test %d expected %v as rollover, got %v", i, tc.exp.roll, signer.roll)
+		}
+		if (signer.nsec3 == nil) != (tc.exp.nsec3 == nil) || (signer.nsec3 != nil && *signer.nsec3 != *tc.exp.nsec3) {
+			t.Errorf("Test %d expected %v as nsec3, got %v", i, tc.exp.nsec3, signer.nsec3)
+		}
 	}
 }
@@ -24,6 +24,8 @@ type Signer struct {
 	directory   string
 	jitterIncep time.Duration
 	jitterExpir time.Duration
+	nsec3       *nsec3 // if nil, the zone is signed with NSEC
+	roll        rollover
 
 	signedfile string
 	stop       chan struct{}
@@ -46,18 +48,48 @@ func (s *Signer) Sign(now time.Time) (*file.Zone, error) {
 	inception, expiration := lifetime(now, s.jitterIncep, s.jitterExpir)
 	z.Apex.SOA.Serial = uint32(now.Unix())
 
-	for _, pair := range s.keys {
-		pair.Public.Header().Ttl = ttl // set TTL on key so it matches the RRSIG.
-		z.Insert(pair.Public)
-		z.Insert(pair.Public.ToDS(dns.SHA1).ToCDS())
-		z.Insert(pair.Public.ToDS(dns.SHA256).ToCDS())
-		z.Insert(pair.Public.ToCDNSKEY())
+	keys, err := s.keyStates(now, ttl, maxTTL(z))
+	if err != nil {
+		return nil, err
+	}
+	// The KSKs sign the DNSKEY, CDS and CDNSKEY RRsets, the ZSKs sign all other RRsets. Without ZSKs the
+	// KSKs sign these as well.
+	var ksks, zsks, csks []Pair
+	for _, k := range keys {
+		state := k.state()
+		if state == stateRemoved {
+			continue
+		}
+		k.Public.Header().Ttl = ttl // set TTL on key so it matches the RRSIG.
+		z.Insert(k.Public)
+		if !k.ksk() {
+			if state == stateActive {
+				zsks = append(zsks, k.Pair)
+			}
+			continue
+		}
+		ksks = append(ksks, k.Pair)
+		// Only active KSKs should be in the DS RRset of the parent.
+		if state == stateActive {
+			z.Insert(k.Public.ToDS(dns.SHA1).ToCDS())
+			z.Insert(k.Public.ToDS(dns.SHA256).ToCDS())
+			z.Insert(k.Public.ToCDNSKEY())
+			csks = append(csks, k.Pair)
+		}
+	}
+	if !hasZSK(keys) {
+		zsks = csks
+	}
+	var chain []*dns.NSEC3
+	if s.nsec3 != nil {
+		z.Insert(s.nsec3.param(s.origin))
+		chain = s.nsec3.chain(s.origin, z, mttl)
 	}
 
 	names := names(s.origin, z)
 	ln := len(names)
 
-	for _, pair := range s.keys {
+	for _, pair := range zsks {
 		rrsig, err := pair.signRRs([]dns.RR{z.Apex.SOA}, s.origin, ttl, inception, expiration)
 		if err != nil {
 			return nil, err
@@ -80,10 +112,13 @@ func (s *Signer) Sign(now time.Time) (*file.Zone, error) {
 			return nil
 		}
 
-		if e.Name() == s.origin {
+		switch {
+		case s.nsec3 != nil:
+			// The NSEC3 chain is added after the walk.
+		case e.Name() == s.origin:
 			nsec := NSEC(e.Name(), names[(ln+i)%ln], mttl, append(e.Types(), dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC))
 			z.Insert(nsec)
-		} else {
+		default:
 			nsec := NSEC(e.Name(), names[(ln+i)%ln], mttl, append(e.Types(), dns.TypeRRSIG, dns.TypeNSEC))
 			z.Insert(nsec)
 		}
@@ -94,7 +129,11 @@ func (s *Signer) Sign(now time.Time) (*file.Zone, error) {
 			if t == dns.TypeRRSIG || t == dns.TypeNS {
 				continue
 			}
-			for _, pair := range s.keys {
+			signers := zsks
+			if t == dns.TypeDNSKEY || t == dns.TypeCDS || t == dns.TypeCDNSKEY {
+				signers = ksks
+			}
+			for _, pair := range signers {
 				rrsig, err := pair.signRRs(rrs, s.origin, rrs[0].Header().Ttl, inception, expiration)
 				if err != nil {
 					return err
@@ -105,18 +144,36 @@ func (s *Signer) Sign(now time.Time) (*file.Zone, error) {
 		i++
 		return nil
 	})
-	return z, err
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nsec3 := range chain {
+		z.Insert(nsec3)
+		for _, pair := range zsks {
+			rrsig, err := pair.signRRs([]dns.RR{nsec3}, s.origin, mttl, inception, expiration)
+			if err != nil {
+				return nil, err
+			}
+			z.Insert(rrsig)
+		}
+	}
+	return z, nil
 }
 
 // resign checks if the signed zone exists, or needs resigning.
 func (s *Signer) resign() error {
+	now := time.Now().UTC()
+	if err := s.rolloverDue(now); err != nil {
+		return err
+	}
+
 	signedfile := filepath.Join(s.directory, s.signedfile)
 	rd, err := os.Open(signedfile)
 	if err != nil && os.IsNotExist(err) {
 		return err
 	}
 
-	now := time.Now().UTC()
 	return resign(rd, now)
 }
 
@@ -3,6 +3,7 @@ package sign
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -176,3 +177,73 @@ func TestSignDS(t *testing.T) {
 		t.Errorf("Expected no NSEC TTL to be %d for %s, got %d", minttl, "www.miek.nl.", x)
 	}
 }
+
+func TestSignNSEC3(t *testing.T) {
+	tests := []struct {
+		nsec3  string
+		names  []string // names with an NSEC3 record
+		optout bool
+	}{
+		{"nsec3", []string{"miek.nl.", "a.miek.nl.", "www.miek.nl.", "bla.miek.nl.", "blaaat.miek.nl.", "ns3.blaaat.miek.nl."}, false},
+		{"nsec3 salt AABBCCDD iterations 5 optout", []string{"miek.nl.", "a.miek.nl.", "www.miek.nl.", "blaaat.miek.nl.", "ns3.blaaat.miek.nl."}, true},
+	}
+	for i, tc := range tests {
+		input := `sign testdata/db.miek.nl miek.nl {
+			key file testdata/Kmiek.nl.+013+59725
+			directory testdata
+			` + tc.nsec3 + `
+		}`
+		c := caddy.NewTestController("dns", input)
+		sign, err := parse(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := sign.signers[0].Sign(time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		apex, _ := z.Search("miek.nl.")
+		if x := apex.Type(dns.TypeNSEC3PARAM); len(x) != 1 {
+			t.Errorf("Test %d: expected %d NSEC3PARAM record, got %d", i, 1, len(x))
+		}
+		if x := apex.Type(dns.TypeNSEC); len(x) != 0 {
+			t.Errorf("Test %d: expected no NSEC records, got %d", i, len(x))
+		}
+
+		chain := z.Apex.NSEC3.All()
+		if len(chain) != len(tc.names) {
+			t.Fatalf("Test %d: expected %d NSEC3 records, got %d", i, len(tc.names), len(chain))
+		}
+		dnskey := apex.Type(dns.TypeDNSKEY)[0].(*dns.DNSKEY)
+		for j, e := range chain {
+			nsec3 := e.Type(dns.TypeNSEC3)[0].(*dns.NSEC3)
+			if x := nsec3.Flags == 1; x != tc.optout {
+				t.Errorf("Test %d: expected opt-out to be %t, got %t", i, tc.optout, x)
+			}
+			// The chain must be closed.
+			next := chain[(j+1)%len(chain)].Name()
+			if x := strings.ToLower(nsec3.NextDomain) + ".miek.nl."; x != next {
+				t.Errorf("Test %d: expected next hashed owner name %s, got %s", i, next, x)
+			}
+			sigs := e.Type(dns.TypeRRSIG)
+			if len(sigs) != 1 {
+				t.Fatalf("Test %d: expected %d RRSIG for %s, got %d", i, 1, e.Name(), len(sigs))
+			}
+			if err := sigs[0].(*dns.RRSIG).Verify(dnskey, []dns.RR{nsec3}); err != nil {
+				t.Errorf("Test %d: expected valid RRSIG for %s, got %s", i, e.Name(), err)
+			}
+		}
+		for _, name := range tc.names {
+			matched := false
+			for _, e := range chain {
+				if e.Type(dns.TypeNSEC3)[0].(*dns.NSEC3).Match(name) {
+					matched = true
+				}
+			}
+			if !matched {
+				t.Errorf("Test %d: expected an NSEC3 record for %s", i, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/transfer"
+
+	"github.com/lib/pq"             // PostgreSQL driver, "postgres"
+	_ "github.com/mattn/go-sqlite3" // SQLite driver, "sqlite3"
+)
+
+var log = clog.NewWithPlugin("sql")
+
+func init() { plugin.Register("sql", setup) }
+
+func setup(c *caddy.Controller) error {
+	s, err := sqlParse(c)
+	if err != nil {
+		return plugin.Error("sql", err)
+	}
+
+	s.db, err = sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return plugin.Error("sql", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	_, err = s.update(ctx)
+	cancel()
+	if err != nil {
+		s.db.Close()
+		return plugin.Error("sql", fmt.Errorf("failed to read records from table %s: %s", s.schema.table, err))
+	}
+
+	stop := make(chan bool)
+	var l *pq.Listener
+
+	c.OnStartup(func() error {
+		if t := dnsserver.GetConfig(c).Handler("transfer"); t != nil {
+			s.transfer = t.(*transfer.Transfer) // if found this must be OK.
+		}
+		if s.notify != "" {
+			l = pq.NewListener(s.dsn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+				if err != nil {
+					log.Warningf("Connection for notifications on %q: %s", s.notify, err)
+				}
+			})
+			// Listen blocks until the listener is connected.
+			go func() {
+				if err := l.Listen(s.notify); err != nil {
+					log.Errorf("Failed to listen for notifications on %q: %s", s.notify, err)
+				}
+			}()
+		}
+		go s.Run(stop, l)
+		return nil
+	})
+
+	c.OnShutdown(func() error {
+		close(stop)
+		if l != nil {
+			l.Close()
+		}
+		return s.db.Close()
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		s.Next = next
+		return s
+	})
+
+	return nil
+}
+
+func sqlParse(c *caddy.Controller) (*SQL, error) {
+	s := &SQL{
+		schema:   schema{table: "records", name: "name", ttl: "ttl", rrtype: "type", content: "content"},
+		refresh:  time.Minute,
+		upstream: upstream.New(),
+		digests:  make(map[string][]byte),
+	}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		// sql DRIVER DSN [ZONES...]
+		if !c.NextArg() {
+			return nil, c.ArgErr()
+		}
+		s.driver = c.Val()
+		if !c.NextArg() {
+			return nil, c.ArgErr()
+		}
+		s.dsn = c.Val()
+
+		s.origins = make([]string, len(c.ServerBlockKeys))
+		copy(s.origins, c.ServerBlockKeys)
+		if args := c.RemainingArgs(); len(args) > 0 {
+			s.origins = args
+		}
+		for j := range s.origins {
+			s.origins[j] = plugin.Host(s.origins[j]).Normalize()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "table":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				s.schema.table = c.Val()
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+			case "columns":
+				args := c.RemainingArgs()
+				if len(args) != 4 {
+					return nil, c.ArgErr()
+				}
+				s.schema.name, s.schema.ttl, s.schema.rrtype, s.schema.content = args[0], args[1], args[2], args[3]
+			case "refresh":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, c.Errf("unable to parse duration: %s", err)
+				}
+				if d < 0 {
+					return nil, c.Errf("refresh can't be negative: %s", c.Val())
+				}
+				s.refresh = d
+			case "notify":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				s.notify = c.Val()
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	for _, id := range []string{s.schema.table, s.schema.name, s.schema.ttl, s.schema.rrtype, s.schema.content} {
+		if !identifier.MatchString(id) {
+			return nil, c.Errf("invalid table or column name '%s'", id)
+		}
+	}
+	if s.notify != "" && s.driver != "postgres" {
+		return nil, c.Errf("notify needs the postgres driver, not '%s'", s.driver)
+	}
+	if s.notify == "" && s.refresh == 0 {
+		return nil, c.Err("refresh can only be 0 with notify")
+	}
+
+	return s, nil
+}
+
+// identifier matches the table and column names that can be used in the query, the table name may include the schema.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetupSQL(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		origins   []string
+		schema    schema
+		refresh   time.Duration
+		notify    string
+	}{
+		{`sql sqlite3 /var/lib/ipam.db`, false, []string{"example.org."},
+			schema{"records", "name", "ttl", "type", "content"}, time.Minute, ""},
+		{`sql sqlite3 /var/lib/ipam.db example.net 2.0.192.in-addr.arpa`, false, []string{"example.net.", "2.0.192.in-addr.arpa."},
+			schema{"records", "name", "ttl", "type", "content"}, time.Minute, ""},
+		{`sql postgres "host=db dbname=ipam" {
+			table dns.hosts
+			columns host lifetime kind address
+			refresh 0
+			notify records_changed
+		}`, false, []string{"example.org."},
+			schema{"dns.hosts", "host", "lifetime", "kind", "address"}, 0, "records_changed"},
+		{`sql sqlite3 /var/lib/ipam.db {
+			refresh 30s
+		}`, false, []string{"example.org."},
+			schema{"records", "name", "ttl", "type", "content"}, 30 * time.Second, ""},
+		// fails
+		{`sql`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			table "records; DROP TABLE records"
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			columns name ttl type
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			refresh -1s
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			refresh 0
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			notify records_changed
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db {
+			blah
+		}`, true, nil, schema{}, 0, ""},
+		{`sql sqlite3 /var/lib/ipam.db
+		  sql sqlite3 /var/lib/ipam.db`, true, nil, schema{}, 0, ""},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		c.ServerBlockKeys = []string{"example.org"}
+		s, err := sqlParse(c)
+		if (err != nil) != tc.shouldErr {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(s.origins) != len(tc.origins) {
+			t.Fatalf("Test %d: expected origins %v, got %v", i, tc.origins, s.origins)
+		}
+		for j, o := range tc.origins {
+			if s.origins[j] != o {
+				t.Errorf("Test %d: expected origin %s, got %s", i, o, s.origins[j])
+			}
+		}
+		if s.schema != tc.schema {
+			t.Errorf("Test %d: expected schema %v, got %v", i, tc.schema, s.schema)
+		}
+		if s.refresh != tc.refresh {
+			t.Errorf("Test %d: expected refresh %s, got %s", i, tc.refresh, s.refresh)
+		}
+		if s.notify != tc.notify {
+			t.Errorf("Test %d: expected notify %q, got %q", i, tc.notify, s.notify)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package sql implements a plugin that serves zones from the records in a table of an SQL database.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// SQL serves the zones it builds from the records in a table of an SQL database.
+type SQL struct {
+	Next plugin.Handler
+
+	db       *sql.DB
+	driver   string
+	dsn      string
+	schema   schema
+	origins  []string
+	refresh  time.Duration // how often the table is read, 0 means never
+	notify   string        // PostgreSQL channel on which changes to the table are announced
+	upstream *upstream.Upstream
+	transfer *transfer.Transfer
+
+	mu      sync.RWMutex
+	zones   file.Zones
+	digests map[string][]byte // digest of the records of each zone, to see if the zone has changed
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (s *SQL) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+
+	if plugin.Zones(s.origins).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(s.Name(), s.Next, ctx, w, r)
+	}
+
+	return file.File{Next: s.Next, Zones: s.Zones()}.ServeDNS(ctx, w, r)
+}
+
+// Transfer implements the transfer.Transferer interface.
+func (s *SQL) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	z, ok := s.Zones().Z[zone]
+	if !ok || z == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	return z.Transfer(serial)
+}
+
+// Zones returns the zones s serves. When the records in the database change the zones are replaced, not
+// changed, so they can be used after the lock has been released.
+func (s *SQL) Zones() file.Zones {
+	s.mu.RLock()
+	zs := s.zones
+	s.mu.RUnlock()
+	return zs
+}
+
+// Name implements the Handler interface.
+func (s *SQL) Name() string { return "sql" }
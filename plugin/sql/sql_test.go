@@ -0,0 +1,278 @@
+//go:build cgo
+// +build cgo
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newSQL returns a SQL serving origins from a SQLite database, the records table is filled with stmts.
+func newSQL(t *testing.T, origins []string, stmts ...string) (*SQL, func()) {
+	dir, err := ioutil.TempDir("", "coredns-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "records.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := func() { db.Close(); os.RemoveAll(dir) }
+
+	stmts = append([]string{`CREATE TABLE records (name TEXT, ttl INTEGER, type TEXT, content TEXT)`}, stmts...)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			rm()
+			t.Fatalf("Failed to execute %q: %s", stmt, err)
+		}
+	}
+
+	s := &SQL{
+		Next:    test.ErrorHandler(),
+		db:      db,
+		schema:  schema{table: "records", name: "name", ttl: "ttl", rrtype: "type", content: "content"},
+		origins: origins,
+		digests: make(map[string][]byte),
+	}
+	if _, err := s.update(context.TODO()); err != nil {
+		rm()
+		t.Fatalf("Expected no error when reading records, got %s", err)
+	}
+	return s, rm
+}
+
+var records = []string{
+	`INSERT INTO records VALUES ('example.org', 300, 'NS', 'ns.example.org.')`,
+	`INSERT INTO records VALUES ('ns.example.org', 300, 'A', '192.0.2.53')`,
+	`INSERT INTO records VALUES ('www.example.org', 300, 'A', '192.0.2.1')`,
+	`INSERT INTO records VALUES ('www.example.org', 300, 'A', '192.0.2.2')`,
+	`INSERT INTO records VALUES ('WWW.Example.ORG.', NULL, 'AAAA', '2001:db8::1')`,
+	`INSERT INTO records VALUES ('alias.example.org', 300, 'CNAME', 'www.example.org.')`,
+	`INSERT INTO records VALUES ('1.2.0.192.in-addr.arpa', 300, 'PTR', 'www.example.org.')`,
+	`INSERT INTO records VALUES ('www.example.net', 300, 'A', '192.0.2.3')`, // not in a zone
+	`INSERT INTO records VALUES ('bad.example.org', 300, 'A', 'not-an-ip')`, // can't be parsed
+	`INSERT INTO records VALUES ('bad.example.org', 300, 'BOGUS', 'bogus')`, // can't be parsed
+	`INSERT INTO records VALUES ('mx.example.org', 300, 'MX', '10 www.example.org.')`,
+}
+
+var sqlTestCases = []test.Case{
+	{
+		Qname: "www.example.org.", Qtype: dns.TypeA,
+		Answer: []dns.RR{
+			test.A("www.example.org.	300	IN	A	192.0.2.1"),
+			test.A("www.example.org.	300	IN	A	192.0.2.2"),
+		},
+		Ns: []dns.RR{test.NS("example.org.	300	IN	NS	ns.example.org.")},
+	},
+	{
+		Qname: "www.example.org.", Qtype: dns.TypeAAAA,
+		Answer: []dns.RR{test.AAAA("www.example.org.	3600	IN	AAAA	2001:db8::1")},
+		Ns:     []dns.RR{test.NS("example.org.	300	IN	NS	ns.example.org.")},
+	},
+	{
+		Qname: "alias.example.org.", Qtype: dns.TypeA,
+		Answer: []dns.RR{
+			test.CNAME("alias.example.org.	300	IN	CNAME	www.example.org."),
+			test.A("www.example.org.	300	IN	A	192.0.2.1"),
+			test.A("www.example.org.	300	IN	A	192.0.2.2"),
+		},
+		Ns: []dns.RR{test.NS("example.org.	300	IN	NS	ns.example.org.")},
+	},
+	{
+		Qname: "bad.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns:    []dns.RR{test.SOA("example.org.	3600	IN	SOA	ns.dns.example.org. hostmaster.example.org. 0 7200 1800 86400 3600")},
+	},
+	{
+		Qname: "1.2.0.192.in-addr.arpa.", Qtype: dns.TypePTR,
+		Answer: []dns.RR{test.PTR("1.2.0.192.in-addr.arpa.	300	IN	PTR	www.example.org.")},
+	},
+}
+
+func TestLookup(t *testing.T) {
+	s, rm := newSQL(t, []string{"example.org.", "2.0.192.in-addr.arpa."}, records...)
+	defer rm()
+
+	for _, tc := range sqlTestCases {
+		m := tc.Msg()
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := s.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+			continue
+		}
+		// The serial of the SOA is the time the zone was read.
+		for _, rr := range rec.Msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soa.Serial = 0
+			}
+		}
+		if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A name that is not in any of the zones goes to the next plugin.
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.net.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := s.ServeDNS(context.TODO(), rec, m); code != dns.RcodeServerFailure {
+		t.Errorf("Expected the next plugin to be called for www.example.net.")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s, rm := newSQL(t, []string{"example.org.", "example.com."}, records...)
+	defer rm()
+
+	serial := s.Zones().Z["example.org."].SOASerialIfDefined()
+
+	// Nothing changed.
+	changed, err := s.update(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("Expected no changed zones, got %v", changed)
+	}
+
+	if _, err := s.db.Exec(`UPDATE records SET content = '192.0.2.10' WHERE content = '192.0.2.1'`); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = s.update(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "example.org." {
+		t.Errorf("Expected example.org. to have changed, got %v", changed)
+	}
+	z := s.Zones().Z["example.org."]
+	if x := z.SOASerialIfDefined(); x <= serial {
+		t.Errorf("Expected serial to be larger than %d, got %d", serial, x)
+	}
+	e, _ := z.Search("www.example.org.")
+	found := false
+	for _, rr := range e.Type(dns.TypeA) {
+		found = found || rr.(*dns.A).A.String() == "192.0.2.10"
+	}
+	if !found {
+		t.Errorf("Expected the new address for www.example.org., got %v", e.Type(dns.TypeA))
+	}
+
+	// A SOA record in the database is used as is.
+	if _, err := s.db.Exec(`INSERT INTO records VALUES ('example.com', 60, 'SOA', 'ns.example.com. admin.example.com. 42 3600 600 86400 60')`); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = s.update(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "example.com." {
+		t.Errorf("Expected example.com. to have changed, got %v", changed)
+	}
+	if x := s.Zones().Z["example.com."].SOASerialIfDefined(); x != 42 {
+		t.Errorf("Expected serial 42, got %d", x)
+	}
+
+	// Errors leave the zones as they are.
+	if _, err := s.db.Exec(`DROP TABLE records`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.update(context.TODO()); err == nil {
+		t.Errorf("Expected error, got none")
+	}
+	if z := s.Zones().Z["example.org."]; z.SOASerialIfDefined() < 0 {
+		t.Errorf("Expected example.org. to still be served")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	s, rm := newSQL(t, []string{"example.org."}, records...)
+	defer rm()
+
+	ch, err := s.Transfer("example.org.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	// SOA, NS, 6 records and the SOA at the end.
+	if len(rrs) != 9 {
+		t.Errorf("Expected 9 records, got %d: %v", len(rrs), rrs)
+	}
+	if rrs[0].Header().Rrtype != dns.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("Expected the transfer to start and end with the SOA record")
+	}
+
+	if _, err := s.Transfer("example.net.", 0); err == nil {
+		t.Errorf("Expected error for a zone we're not authoritative for")
+	}
+}
+
+func TestColumns(t *testing.T) {
+	s, rm := newSQL(t, []string{"example.org."},
+		`CREATE TABLE ipam (host TEXT, lifetime INTEGER, kind TEXT, address TEXT)`,
+		`INSERT INTO ipam VALUES ('db.example.org', 30, 'A', '192.0.2.4')`,
+	)
+	defer rm()
+	s.schema = schema{table: "ipam", name: "host", ttl: "lifetime", rrtype: "kind", content: "address"}
+	if _, err := s.update(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := s.Zones().Z["example.org."].Search("db.example.org.")
+	if !ok || len(e.Type(dns.TypeA)) != 1 {
+		t.Fatalf("Expected the record from the ipam table")
+	}
+	if ttl := e.Type(dns.TypeA)[0].Header().Ttl; ttl != 30 {
+		t.Errorf("Expected TTL 30, got %d", ttl)
+	}
+}
+
+func TestHostileRows(t *testing.T) {
+	s, rm := newSQL(t, []string{"example.org."},
+		`INSERT INTO records VALUES ('www.example.org', 300, 'A', '192.0.2.1')`,
+		// Owner outside the zone, hidden by a comment.
+		`INSERT INTO records VALUES ('evil.example.net. 300 IN A 192.0.2.66 ; www.example.org', 300, 'A', '192.0.2.1')`,
+		// Another owner in the zone.
+		`INSERT INTO records VALUES ('x.example.org. 300 IN A 192.0.2.66 ; www.example.org', 300, 'A', '192.0.2.1')`,
+		// A type that isn't the one in the type column.
+		`INSERT INTO records VALUES ('www.example.org', 300, 'MX 10 evil.example.net. ;', 'A')`,
+		`INSERT INTO records VALUES ('www.example.org', 300, 'TXT', '"x"
+evil.example.net. 300 IN A 192.0.2.66')`,
+	)
+	defer rm()
+
+	ch, err := s.Transfer("example.org.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Name != "example.org." && h.Name != "www.example.org." {
+			t.Errorf("Expected no record for %s, got %s", h.Name, rr)
+		}
+		if h.Rrtype == dns.TypeMX {
+			t.Errorf("Expected no MX record, got %s", rr)
+		}
+	}
+	// SOA, A, TXT and the SOA at the end.
+	if len(rrs) != 4 {
+		t.Errorf("Expected 4 records, got %d: %v", len(rrs), rrs)
+	}
+}
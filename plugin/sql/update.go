@@ -0,0 +1,203 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+
+	"github.com/lib/pq"
+	"github.com/miekg/dns"
+)
+
+// schema is the table the records are read from. Each row is a record, with its owner name, TTL, type and
+// content (the rdata in zone file format) in the columns of the same name.
+type schema struct {
+	table   string
+	name    string
+	ttl     string
+	rrtype  string
+	content string
+}
+
+func (sc schema) query() string {
+	return fmt.Sprintf("SELECT %s, %s, %s, %s FROM %s", sc.name, sc.ttl, sc.rrtype, sc.content, sc.table)
+}
+
+// load reads all records from the database, and returns them per zone. Records that aren't in any of the
+// zones, or that can't be parsed, are skipped.
+func (s *SQL) load(ctx context.Context) (map[string][]dns.RR, error) {
+	rows, err := s.db.QueryContext(ctx, s.schema.query())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rrs := make(map[string][]dns.RR)
+	for rows.Next() {
+		var (
+			name, rrtype, content string
+			ttl                   sql.NullInt64
+		)
+		if err := rows.Scan(&name, &ttl, &rrtype, &content); err != nil {
+			return nil, err
+		}
+		if !ttl.Valid {
+			ttl.Int64 = defaultTTL
+		}
+		name = dns.Fqdn(strings.ToLower(name))
+
+		origin := plugin.Zones(s.origins).Matches(name)
+		if origin == "" {
+			log.Debugf("Skipping record for %s, it's not in any of the zones", name)
+			continue
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl.Int64, rrtype, content))
+		if err != nil {
+			log.Warningf("Failed to parse record for %s: %s", name, err)
+			continue
+		}
+		// The columns are pasted together, make sure the record is the one the row describes and not
+		// another one, e.g. because of whitespace or a comment in a column.
+		h := rr.Header()
+		if strings.ToLower(h.Name) != name || dns.Type(h.Rrtype).String() != strings.ToUpper(rrtype) || !dns.IsSubDomain(origin, h.Name) {
+			log.Warningf("Skipping record for %s, it doesn't match its row: %s", name, rr)
+			continue
+		}
+		rrs[origin] = append(rrs[origin], rr)
+	}
+	return rrs, rows.Err()
+}
+
+// update reads the records from the database and replaces the zones that have changed. It returns the
+// names of these zones.
+func (s *SQL) update(ctx context.Context) ([]string, error) {
+	rrs, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, origin := range s.origins {
+		records := rrs[origin]
+		sort.Slice(records, func(i, j int) bool { return records[i].String() < records[j].String() })
+		h := sha256.New()
+		for _, rr := range records {
+			fmt.Fprintln(h, rr.String())
+		}
+		digest := h.Sum(nil)
+
+		s.mu.RLock()
+		old := s.zones.Z[origin]
+		same := old != nil && bytes.Equal(s.digests[origin], digest)
+		s.mu.RUnlock()
+		if same {
+			continue
+		}
+
+		z := file.NewZone(origin, "")
+		z.Upstream = s.upstream
+		for _, rr := range records {
+			if err := z.Insert(rr); err != nil {
+				log.Warningf("Failed to insert %s: %s", rr, err)
+			}
+		}
+		// Zones that don't have a SOA record in the database get one, with the time of the change as serial.
+		if z.Apex.SOA == nil {
+			serial := uint32(time.Now().Unix())
+			if old != nil {
+				if prev := old.SOASerialIfDefined(); prev >= int64(serial) {
+					serial = uint32(prev) + 1
+				}
+			}
+			z.Insert(soa(origin, serial))
+		}
+
+		s.mu.Lock()
+		zones := make(map[string]*file.Zone, len(s.origins))
+		for n, oz := range s.zones.Z {
+			zones[n] = oz
+		}
+		zones[origin] = z
+		s.zones = file.Zones{Z: zones, Names: s.origins}
+		s.digests[origin] = digest
+		s.mu.Unlock()
+
+		changed = append(changed, origin)
+	}
+	return changed, nil
+}
+
+// Run reads the records whenever the refresh interval passes, or a notification is received on l, until
+// stop is closed. Changed zones are announced to the secondaries with a NOTIFY.
+func (s *SQL) Run(stop chan bool, l *pq.Listener) {
+	var tick <-chan time.Time
+	if s.refresh > 0 {
+		ticker := time.NewTicker(s.refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var notifications <-chan *pq.Notification
+	if l != nil {
+		notifications = l.NotificationChannel()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+		case <-notifications:
+			// A single update handles all notifications that are pending. A nil notification is sent when
+			// the connection was re-established; notifications may have been lost, so update as well.
+		drain:
+			for {
+				select {
+				case <-notifications:
+				default:
+					break drain
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		changed, err := s.update(ctx)
+		cancel()
+		if err != nil {
+			log.Errorf("Failed to read records from table %s: %s", s.schema.table, err)
+			continue
+		}
+		for _, origin := range changed {
+			log.Infof("Zone %s has changed", origin)
+			if s.transfer != nil {
+				s.transfer.Notify(origin)
+			}
+		}
+	}
+}
+
+// soa returns the SOA record for zones that don't have one in the database.
+func soa(origin string, serial uint32) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:      "ns.dns." + origin,
+		Mbox:    "hostmaster." + origin,
+		Serial:  serial,
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  defaultTTL,
+	}
+}
+
+const (
+	defaultTTL   = 3600             // TTL for records without a TTL in the database
+	queryTimeout = 30 * time.Second // how long reading the records may take
+)
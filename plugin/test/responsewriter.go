@@ -12,6 +12,7 @@ import (
 type ResponseWriter struct {
 	TCP      bool // if TCP is true we return an TCP connection instead of an UDP one.
 	RemoteIP string
+	TsigErr  error // returned by TsigStatus, as the result of verifying the TSIG of the request.
 }
 
 // LocalAddr returns the local address, 127.0.0.1:53 (UDP, TCP if t.TCP is true).
@@ -48,7 +49,7 @@ func (t *ResponseWriter) Write(buf []byte) (int, error) { return len(buf), nil }
 func (t *ResponseWriter) Close() error { return nil }
 
 // TsigStatus implements dns.ResponseWriter interface.
-func (t *ResponseWriter) TsigStatus() error { return nil }
+func (t *ResponseWriter) TsigStatus() error { return t.TsigErr }
 
 // TsigTimersOnly implements dns.ResponseWriter interface.
 func (t *ResponseWriter) TsigTimersOnly(bool) {}
@@ -15,15 +15,16 @@ func (t *Transfer) Notify(zone string) error {
 		return nil
 	}
 
-	m := new(dns.Msg)
-	m.SetNotify(zone)
-	c := new(dns.Client)
-
 	x := longestMatch(t.xfrs, zone)
 	if x == nil {
 		return fmt.Errorf("no such zone registred in the transfer plugin: %s", zone)
 	}
 
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	c := new(dns.Client)
+	c.TsigSecret = x.sign(m)
+
 	var err1 error
 	for _, t := range x.to {
 		if t == "*" {
@@ -42,7 +43,7 @@ func sendNotify(c *dns.Client, m *dns.Msg, s string) error {
 
 	code := dns.RcodeServerFailure
 	for i := 0; i < 3; i++ {
-		ret, _, err := c.Exchange(m, s)
+		ret, _, err := c.Exchange(m.Copy(), s) // signing removes the TSIG record, so send a copy
 		if err != nil {
 			continue
 		}
@@ -87,6 +87,13 @@ func parseTransfer(c *caddy.Controller) (*Transfer, error) {
 					}
 					x.to = append(x.to, normalized)
 				}
+			case "tsig":
+				name, alg, secret, err := parse.TSIGKey(c)
+				if err != nil {
+					return nil, err
+				}
+				x.tsigKey, x.tsigAlgorithm, x.tsigSecret = name, alg, secret
+				dnsserver.GetConfig(c).AddTsigSecret(name, secret)
 			default:
 				return nil, plugin.Error("transfer", c.Errf("unknown property %q", c.Val()))
 			}
@@ -31,6 +31,20 @@ func TestParse(t *testing.T) {
 				}},
 			},
 		},
+		{`transfer example.org {
+			to 1.2.3.4
+			tsig xfr.example.org. hmac-sha256 c2VjcmV0
+		 }`,
+			nil,
+			false,
+			&Transfer{
+				xfrs: []*xfr{{
+					Zones:   []string{"example.org."},
+					to:      []string{"1.2.3.4:53"},
+					tsigKey: "xfr.example.org.",
+				}},
+			},
+		},
 		// errors
 		{`transfer example.net example.org {
 		 }`,
@@ -45,6 +59,14 @@ func TestParse(t *testing.T) {
 			true,
 			nil,
 		},
+		{`transfer example.org {
+			to 1.2.3.4
+			tsig xfr.example.org. hmac-sha256
+		 }`,
+			nil,
+			true,
+			nil,
+		},
 		{
 			`
          transfer example.com example.edu {
@@ -101,6 +123,9 @@ func TestParse(t *testing.T) {
 
 				}
 			}
+			if tc.exp.xfrs[j].tsigKey != x.tsigKey {
+				t.Errorf("Test %d expected TSIG key %q, got %q", i, tc.exp.xfrs[j].tsigKey, x.tsigKey)
+			}
 			// Check to
 			if len(tc.exp.xfrs[j].to) != len(x.to) {
 				t.Fatalf("Test %d expected %d 'to' values, got %d", i, len(tc.exp.xfrs[i].to), len(x.to))
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
@@ -25,6 +27,11 @@ type Transfer struct {
 type xfr struct {
 	Zones []string
 	to    []string
+
+	// TSIG key that signs the transfer requests and the NOTIFYs.
+	tsigKey       string
+	tsigAlgorithm string
+	tsigSecret    string
 }
 
 // Transferer may be implemented by plugins to enable zone transfers
@@ -42,8 +49,10 @@ type Transferer interface {
 	//
 	// If serial is not 0, it will be handled as an IXFR request. If the serial is equal to or greater (newer) than
 	// the current serial for the zone, send a single SOA record to the channel and then close it.
-	// If the serial is less (older) than the current serial for the zone, perform an AXFR fallback
-	// by proceeding as if an AXFR was requested (as above).
+	// If the serial is less (older) than the current serial for the zone, the changes since that serial may be sent
+	// as an incremental transfer (RFC 1995): the current SOA, then for each change the old SOA, the deleted
+	// records, the new SOA and the added records, and finally the current SOA again. If the changes are not known,
+	// perform an AXFR fallback by proceeding as if an AXFR was requested (as above).
 	Transfer(zone string, serial uint32) (<-chan []dns.RR, error)
 }
 
@@ -71,6 +80,13 @@ func (t *Transfer) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		w.WriteMsg(m)
 		return 0, nil
 	}
+	if !x.signed(state) {
+		log.Warningf("Refusing transfer of zone %q to %s: not signed with TSIG key %q", state.QName(), state.IP(), x.tsigKey)
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return 0, nil
+	}
 
 	// Get serial from request if this is an IXFR.
 	var serial uint32
@@ -139,6 +155,9 @@ func (t *Transfer) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		m := new(dns.Msg)
 		m.SetReply(r)
 		m.Answer = []dns.RR{soa}
+		if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+			m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+		}
 		w.WriteMsg(m)
 
 		log.Infof("Outgoing noop, incremental transfer for up to date zone %q to %s for %d SOA serial", state.QName(), state.IP(), soa.Serial)
@@ -178,6 +197,24 @@ func (x xfr) allowed(state request.Request) bool {
 	return false
 }
 
+// signed returns true if x has no TSIG key, or if the request is signed with it.
+func (x xfr) signed(state request.Request) bool {
+	if x.tsigKey == "" {
+		return true
+	}
+	t := state.Req.IsTsig()
+	return t != nil && strings.EqualFold(t.Hdr.Name, x.tsigKey) && state.W.TsigStatus() == nil
+}
+
+// sign adds a TSIG record to m when x has a TSIG key, and returns the secrets to sign m and verify the reply with.
+func (x xfr) sign(m *dns.Msg) map[string]string {
+	if x.tsigKey == "" {
+		return nil
+	}
+	m.SetTsig(x.tsigKey, x.tsigAlgorithm, 300, time.Now().Unix())
+	return map[string]string{x.tsigKey: x.tsigSecret}
+}
+
 // Find the first transfer instance for which the queried zone is the longest match. When nothing
 // is found nil is returned.
 func longestMatch(xfrs []*xfr, name string) *xfr {
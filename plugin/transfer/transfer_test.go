@@ -276,3 +276,33 @@ func TestTransferNotAllowed(t *testing.T) {
 		t.Errorf("Expected REFUSED response code, got %s", dns.RcodeToString[w.Msg.Rcode])
 	}
 }
+
+func TestTransferTSIG(t *testing.T) {
+	transfer := newTestTransfer()
+	transfer.xfrs[0].tsigKey = "xfr.example.org."
+
+	tests := []struct {
+		key     string
+		tsigErr error
+		rcode   int
+	}{
+		{"", nil, dns.RcodeRefused},
+		{"other.example.org.", nil, dns.RcodeRefused},
+		{"xfr.example.org.", dns.ErrSig, dns.RcodeRefused},
+		{"xfr.example.org.", nil, dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		m := &dns.Msg{}
+		m.SetAxfr("example.org.")
+		if tc.key != "" {
+			m.SetTsig(tc.key, dns.HmacSHA256, 300, 0)
+		}
+		w := dnstest.NewRecorder(&test.ResponseWriter{TsigErr: tc.tsigErr})
+		if _, err := transfer.ServeDNS(context.TODO(), w, m); err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		if w.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected %s response code, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[w.Msg.Rcode])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tsig
+
+import (
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+
+	"github.com/miekg/dns"
+)
+
+func init() { plugin.Register("tsig", setup) }
+
+func setup(c *caddy.Controller) error {
+	t, err := tsigParse(c)
+	if err != nil {
+		return plugin.Error("tsig", err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		t.Next = next
+		return t
+	})
+
+	return nil
+}
+
+func tsigParse(c *caddy.Controller) (TSIG, error) {
+	t := TSIG{keys: make(map[string]string)}
+	config := dnsserver.GetConfig(c)
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return t, plugin.ErrOnce
+		}
+		i++
+
+		t.Zones = c.RemainingArgs()
+		if len(t.Zones) == 0 {
+			t.Zones = make([]string, len(c.ServerBlockKeys))
+			copy(t.Zones, c.ServerBlockKeys)
+		}
+		for j := range t.Zones {
+			t.Zones[j] = plugin.Host(t.Zones[j]).Normalize()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "key":
+				name, alg, secret, err := parse.TSIGKey(c)
+				if err != nil {
+					return t, err
+				}
+				t.keys[name] = alg
+				config.AddTsigSecret(name, secret)
+			case "require":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					args = []string{"all"}
+				}
+				t.all = false
+				t.types = make(map[uint16]struct{})
+				t.opcodes = make(map[int]struct{})
+				for _, a := range args {
+					a = strings.ToUpper(a)
+					if a == "ALL" {
+						t.all = true
+						continue
+					}
+					if a == "NONE" {
+						continue
+					}
+					if op, ok := dns.StringToOpcode[a]; ok {
+						t.opcodes[op] = struct{}{}
+						continue
+					}
+					if qtype, ok := dns.StringToType[a]; ok {
+						t.types[qtype] = struct{}{}
+						continue
+					}
+					return t, c.Errf("unknown query type or opcode '%s'", a)
+				}
+			default:
+				return t, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+	return t, nil
+}
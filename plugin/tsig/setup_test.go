@@ -0,0 +1,75 @@
+package tsig
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		zones     []string
+		keys      int
+		all       bool
+		types     int
+		opcodes   int
+	}{
+		{`tsig`, false, []string{"example.org."}, 0, false, 0, 0},
+		{`tsig example.net {
+			key xfr.example.org hmac-sha256 c2VjcmV0
+			key ddns.example.org hmac-sha512 c2VjcmV0
+		}`, false, []string{"example.net."}, 2, false, 0, 0},
+		{`tsig {
+			require
+		}`, false, []string{"example.org."}, 0, true, 0, 0},
+		{`tsig {
+			require AXFR ixfr NOTIFY update
+		}`, false, []string{"example.org."}, 0, false, 2, 2},
+		{`tsig {
+			require none
+		}`, false, []string{"example.org."}, 0, false, 0, 0},
+		// fails
+		{`tsig {
+			key xfr.example.org hmac-sha256
+		}`, true, nil, 0, false, 0, 0},
+		{`tsig {
+			require FOO
+		}`, true, nil, 0, false, 0, 0},
+		{`tsig {
+			foo
+		}`, true, nil, 0, false, 0, 0},
+		{`tsig
+		tsig`, true, nil, 0, false, 0, 0},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		c.ServerBlockKeys = []string{"example.org"}
+		ts, err := tsigParse(c)
+		if err == nil && tc.shouldErr {
+			t.Errorf("Test %d expected errors, but got no error", i)
+			continue
+		} else if err != nil && !tc.shouldErr {
+			t.Errorf("Test %d expected no errors, but got '%v'", i, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(ts.Zones) != len(tc.zones) || ts.Zones[0] != tc.zones[0] {
+			t.Errorf("Test %d expected zones %v, got %v", i, tc.zones, ts.Zones)
+		}
+		if len(ts.keys) != tc.keys {
+			t.Errorf("Test %d expected %d keys, got %d", i, tc.keys, len(ts.keys))
+		}
+		if ts.all != tc.all || len(ts.types) != tc.types || len(ts.opcodes) != tc.opcodes {
+			t.Errorf("Test %d expected require %t %d %d, got %t %d %d", i, tc.all, tc.types, tc.opcodes, ts.all, len(ts.types), len(ts.opcodes))
+		}
+		if secrets := dnsserver.GetConfig(c).TsigSecret; len(secrets) != tc.keys {
+			t.Errorf("Test %d expected %d secrets in the config, got %d", i, tc.keys, len(secrets))
+		}
+	}
+}
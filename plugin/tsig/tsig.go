@@ -0,0 +1,147 @@
+// Package tsig implements TSIG (RFC 8945) verification and signing of requests and responses.
+package tsig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("tsig")
+
+// TSIG rejects requests of which the TSIG doesn't verify, requires TSIG for selected requests, and signs the
+// responses to signed requests. The verification itself is done by the server, for the keys added to its
+// config.
+type TSIG struct {
+	Next  plugin.Handler
+	Zones []string
+
+	keys    map[string]string // key name to algorithm
+	all     bool
+	types   map[uint16]struct{}
+	opcodes map[int]struct{}
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (t TSIG) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones(t.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+	}
+
+	rr := r.IsTsig()
+	if rr == nil {
+		if !t.required(r.Opcode, state.QType()) {
+			return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+		}
+		log.Debugf("Refusing %s %s request for %s from %s without TSIG", dns.OpcodeToString[r.Opcode], state.Type(), state.Name(), state.IP())
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+
+	status := w.TsigStatus()
+	if alg, ok := t.keys[strings.ToLower(rr.Hdr.Name)]; ok && status == nil && !strings.EqualFold(alg, rr.Algorithm) {
+		status = dns.ErrKeyAlg
+	}
+	if status != nil {
+		log.Debugf("Request for %s from %s with TSIG key %s failed verification: %s", state.Name(), state.IP(), rr.Hdr.Name, status)
+		notAuth(state, rr, status)
+		return dns.RcodeSuccess, nil
+	}
+
+	sw := &signWriter{ResponseWriter: w, req: r, tsig: rr}
+	rcode, err := plugin.NextOrFailure(t.Name(), t.Next, ctx, sw, r)
+	if !plugin.ClientWrite(rcode) {
+		// The server would write this response without going through sw, leaving it unsigned.
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		sw.WriteMsg(m)
+		return dns.RcodeSuccess, err
+	}
+	return rcode, err
+}
+
+// required returns true if a request with opcode and qtype must be signed. The types only apply to queries.
+func (t TSIG) required(opcode int, qtype uint16) bool {
+	if t.all {
+		return true
+	}
+	if _, ok := t.opcodes[opcode]; ok {
+		return true
+	}
+	if opcode != dns.OpcodeQuery {
+		return false
+	}
+	_, ok := t.types[qtype]
+	return ok
+}
+
+// notAuth writes the NOTAUTH response to a request of which the TSIG failed verification with status. The
+// response is only signed for a BADTIME error, see RFC 8945, section 5.3.2.
+func notAuth(state request.Request, rr *dns.TSIG, status error) {
+	m := new(dns.Msg)
+	m.SetRcode(state.Req, dns.RcodeNotAuth)
+	state.SizeAndDo(m)
+
+	t := &dns.TSIG{
+		Hdr:        dns.RR_Header{Name: rr.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+		Algorithm:  rr.Algorithm,
+		TimeSigned: rr.TimeSigned,
+		Fudge:      rr.Fudge,
+		OrigId:     state.Req.Id,
+	}
+	switch status {
+	case dns.ErrSecret, dns.ErrKeyAlg:
+		t.Error = dns.RcodeBadKey
+	case dns.ErrTime:
+		// The client's time is echoed, the server's time is sent as the other data.
+		t.Error = dns.RcodeBadTime
+		t.OtherLen = 6
+		t.OtherData = fmt.Sprintf("%012x", time.Now().Unix())
+		m.Extra = append(m.Extra, t)
+		state.W.WriteMsg(m)
+		return
+	default:
+		t.Error = dns.RcodeBadSig
+	}
+	m.Extra = append(m.Extra, t)
+
+	// Write the packed message, as WriteMsg would sign it.
+	buf, err := m.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack response: %s", err)
+		return
+	}
+	state.W.Write(buf)
+}
+
+// signWriter makes sure the responses to a signed request are signed, by adding a TSIG record that the server
+// signs when writing the response.
+type signWriter struct {
+	dns.ResponseWriter
+	req  *dns.Msg
+	tsig *dns.TSIG
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (s *signWriter) WriteMsg(m *dns.Msg) error {
+	if m.IsTsig() == nil {
+		// Add the OPT record now, as the scrub writer would add it after the TSIG record.
+		state := request.Request{W: s.ResponseWriter, Req: s.req}
+		state.SizeAndDo(m)
+		m.SetTsig(s.tsig.Hdr.Name, s.tsig.Algorithm, s.tsig.Fudge, time.Now().Unix())
+	}
+	return s.ResponseWriter.WriteMsg(m)
+}
+
+// Name implements the plugin.Handler interface.
+func (t TSIG) Name() string { return "tsig" }
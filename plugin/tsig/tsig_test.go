@@ -0,0 +1,158 @@
+package tsig
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// writer records the message written, also when it's written packed.
+type writer struct {
+	test.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *writer) WriteMsg(m *dns.Msg) error { w.msg = m; return nil }
+
+func (w *writer) Write(buf []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	return len(buf), w.msg.Unpack(buf)
+}
+
+func TestTSIG(t *testing.T) {
+	answer := plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	tests := []struct {
+		name    string
+		opcode  int
+		qtype   uint16
+		key     string
+		alg     string
+		tsigErr error
+		next    plugin.Handler
+
+		rcode     int
+		tsigError int // -1 for no TSIG in the response
+	}{
+		// Not required.
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "", "", nil, answer, dns.RcodeSuccess, -1},
+		{"example.net.", dns.OpcodeQuery, dns.TypeAXFR, "", "", nil, answer, dns.RcodeSuccess, -1},
+		// Required.
+		{"example.org.", dns.OpcodeQuery, dns.TypeAXFR, "", "", nil, answer, dns.RcodeRefused, -1},
+		{"example.org.", dns.OpcodeNotify, dns.TypeSOA, "", "", nil, answer, dns.RcodeRefused, -1},
+		// Signed.
+		{"example.org.", dns.OpcodeQuery, dns.TypeAXFR, "key.", dns.HmacSHA256, nil, answer, dns.RcodeSuccess, dns.RcodeSuccess},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA256, nil, answer, dns.RcodeSuccess, dns.RcodeSuccess},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA256, nil, test.ErrorHandler(), dns.RcodeServerFailure, dns.RcodeSuccess},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA256, nil, test.NextHandler(dns.RcodeServerFailure, nil), dns.RcodeServerFailure, dns.RcodeSuccess},
+		// Failed verification.
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA256, dns.ErrSig, answer, dns.RcodeNotAuth, dns.RcodeBadSig},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "other.", dns.HmacSHA256, dns.ErrSecret, answer, dns.RcodeNotAuth, dns.RcodeBadKey},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA256, dns.ErrTime, answer, dns.RcodeNotAuth, dns.RcodeBadTime},
+		{"example.org.", dns.OpcodeQuery, dns.TypeA, "key.", dns.HmacSHA1, nil, answer, dns.RcodeNotAuth, dns.RcodeBadKey},
+	}
+
+	ts := TSIG{
+		Zones:   []string{"example.org."},
+		keys:    map[string]string{"key.": dns.HmacSHA256},
+		types:   map[uint16]struct{}{dns.TypeAXFR: {}},
+		opcodes: map[int]struct{}{dns.OpcodeNotify: {}},
+	}
+	for i, tc := range tests {
+		ts.Next = tc.next
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		m.Opcode = tc.opcode
+		if tc.key != "" {
+			m.SetTsig(tc.key, tc.alg, 300, 0)
+		}
+		w := &writer{ResponseWriter: test.ResponseWriter{TsigErr: tc.tsigErr}}
+		rcode, _ := ts.ServeDNS(context.TODO(), w, m)
+		if !plugin.ClientWrite(rcode) {
+			// The server writes the response.
+			w.msg = new(dns.Msg)
+			w.msg.SetRcode(m, rcode)
+		}
+
+		if w.msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected %s response code, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[w.msg.Rcode])
+		}
+		rr := w.msg.IsTsig()
+		if tc.tsigError < 0 {
+			if rr != nil {
+				t.Errorf("Test %d: expected no TSIG record, got %s", i, rr)
+			}
+			continue
+		}
+		if rr == nil {
+			t.Errorf("Test %d: expected TSIG record, got none", i)
+			continue
+		}
+		if rr.Hdr.Name != tc.key || int(rr.Error) != tc.tsigError {
+			t.Errorf("Test %d: expected TSIG record for %s with error %s, got %s with %s", i, tc.key, dns.RcodeToString[tc.tsigError], rr.Hdr.Name, dns.RcodeToString[int(rr.Error)])
+		}
+	}
+}
+
+func TestTSIGServer(t *testing.T) {
+	const secret = "c2VjcmV0"
+	ts := TSIG{
+		Zones: []string{"example.org."},
+		all:   true,
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1")}
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{PacketConn: pc, TsigSecret: map[string]string{"key.": secret}, NotifyStartedFunc: func() { close(started) }, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		ts.ServeDNS(context.TODO(), w, r)
+	})}
+	go s.ActivateAndServe()
+	<-started
+	defer s.Shutdown()
+
+	c := new(dns.Client)
+	c.TsigSecret = map[string]string{"key.": secret}
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	// The client verifies the TSIG of the response.
+	m.SetTsig("key.", dns.HmacSHA256, 300, time.Now().Unix())
+	resp, _, err := c.Exchange(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || resp.IsTsig() == nil {
+		t.Errorf("Expected signed response with %s, got %s", dns.RcodeToString[dns.RcodeSuccess], dns.RcodeToString[resp.Rcode])
+	}
+
+	m = new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	resp, _, err = c.Exchange(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected %s, got %s", dns.RcodeToString[dns.RcodeRefused], dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -0,0 +1,506 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/core/dnsserver"
+
+	"github.com/miekg/dns"
+)
+
+// keyState is the state of a managed trust anchor, see RFC 5011, section 4.
+type keyState int
+
+const (
+	// stateAddPend is a new key that becomes a trust anchor when it's seen for the add hold-down time.
+	stateAddPend keyState = iota
+	// stateValid is a trust anchor.
+	stateValid
+	// stateMissing is a trust anchor that is no longer in the DNSKEY RRset, it's still used.
+	stateMissing
+	// stateRevoked is a key that revoked itself, it's forgotten after the remove hold-down time.
+	stateRevoked
+)
+
+var stateToString = map[keyState]string{
+	stateAddPend: "ADDPEND",
+	stateValid:   "VALID",
+	stateMissing: "MISSING",
+	stateRevoked: "REVOKED",
+}
+
+// managedKey is a key of a trust point, with its RFC 5011 state.
+type managedKey struct {
+	key     *dns.DNSKEY
+	state   keyState
+	changed time.Time // time of the last state change.
+}
+
+// trustPoint is a zone whose trust anchors are kept up to date.
+type trustPoint struct {
+	zone    string
+	initial []dns.RR // DS or DNSKEY records we start out with, until we have seen a DNSKEY RRset signed by them.
+	keys    []*managedKey
+	next    time.Time // when to query the DNSKEY RRset again.
+}
+
+// managed holds the trust points whose trust anchors are kept up to date with RFC 5011 "Automated Updates of DNS
+// Security (DNSSEC) Trust Anchors". Their state is saved in file.
+type managed struct {
+	file string
+
+	mu         sync.Mutex
+	points     []*trustPoint
+	refreshing bool
+
+	now func() time.Time
+}
+
+// readManaged reads the managed trust anchors from file. If file doesn't exist, the trust points are initialized
+// with anchors.
+func readManaged(file string, anchors []dns.RR) (*managed, error) {
+	m := &managed{file: file, now: time.Now}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		for _, a := range anchors {
+			p := m.point(a.Header().Name)
+			p.initial = append(p.initial, a)
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := m.parse(f); err != nil {
+		return nil, err
+	}
+	if len(m.points) == 0 {
+		return nil, fmt.Errorf("no trust anchors found in %q", file)
+	}
+	return m, nil
+}
+
+// parse parses the trust anchors in r. DNSKEY records carry their state in a comment, those without one, are
+// trust anchors. DS records are trust anchors we start out with.
+func (m *managed) parse(r io.Reader) error {
+	zp := dns.NewZoneParser(r, ".", m.file)
+	now := m.now()
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch x := rr.(type) {
+		case *dns.DS:
+			p := m.point(x.Hdr.Name)
+			p.initial = append(p.initial, x)
+		case *dns.DNSKEY:
+			p := m.point(x.Hdr.Name)
+			mk := &managedKey{key: x, state: stateValid, changed: now}
+			if c := zp.Comment(); c != "" {
+				if err := mk.parseComment(c); err != nil {
+					return fmt.Errorf("%s: %s", m.file, err)
+				}
+			}
+			p.keys = append(p.keys, mk)
+		}
+	}
+	return zp.Err()
+}
+
+// parseComment parses the state and the time of the last state change from the comment c. The comment looks like
+// "; state=VALID changed=2020-01-01T00:00:00Z".
+func (mk *managedKey) parseComment(c string) error {
+	for _, f := range strings.Fields(strings.TrimLeft(c, "; ")) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "state":
+			found := false
+			for s, str := range stateToString {
+				if str == kv[1] {
+					mk.state = s
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown state %q", kv[1])
+			}
+		case "changed":
+			t, err := time.Parse(time.RFC3339, kv[1])
+			if err != nil {
+				return err
+			}
+			mk.changed = t
+		}
+	}
+	return nil
+}
+
+// write writes the state of the trust points to m.file. The file is written under a temporary name first and then
+// renamed, so a crash doesn't leave a partial file.
+func (m *managed) write() error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "; Trust anchors managed by CoreDNS (RFC 5011), this file is rewritten on every change.\n")
+	for _, p := range m.points {
+		for _, rr := range p.initial {
+			fmt.Fprintf(buf, "%s\n", rr)
+		}
+		for _, mk := range p.keys {
+			fmt.Fprintf(buf, "%s ; state=%s changed=%s\n", mk.key, stateToString[mk.state], mk.changed.UTC().Format(time.RFC3339))
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.file), filepath.Base(m.file))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.file)
+}
+
+// point returns the trust point for zone, it is created if it doesn't exist yet.
+func (m *managed) point(zone string) *trustPoint {
+	zone = strings.ToLower(dns.Fqdn(zone))
+	for _, p := range m.points {
+		if p.zone == zone {
+			return p
+		}
+	}
+	p := &trustPoint{zone: zone}
+	m.points = append(m.points, p)
+	sort.Slice(m.points, func(i, j int) bool { return m.points[i].zone < m.points[j].zone })
+	return p
+}
+
+// anchors returns the current trust anchors of all trust points.
+func (m *managed) anchors() []dns.RR {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var anchors []dns.RR
+	for _, p := range m.points {
+		anchors = append(anchors, p.anchors()...)
+	}
+	return anchors
+}
+
+// due returns true if one of the trust points needs to be refreshed, and no refresh is running.
+func (m *managed) due() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refreshing {
+		return false
+	}
+	now := m.now()
+	for _, p := range m.points {
+		if !now.Before(p.next) {
+			m.refreshing = true
+			return true
+		}
+	}
+	return false
+}
+
+// refresh queries the DNSKEY RRset of the trust points that are due and updates their trust anchors. This is
+// started from ServeDNS, as we need a (full) server to send our queries to. The validator is given the new
+// trust anchors when anything changed.
+func (v *Validate) refresh(ctx context.Context, w dns.ResponseWriter) {
+	m := v.managed
+	defer func() {
+		m.mu.Lock()
+		m.refreshing = false
+		m.mu.Unlock()
+	}()
+
+	// The request this was started from might be done (and its context canceled) before we are.
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), dnsserver.Key{}, ctx.Value(dnsserver.Key{})), refreshTimeout)
+	defer cancel()
+	lookup := v.lookup(w)
+
+	m.mu.Lock()
+	points := append([]*trustPoint(nil), m.points...)
+	m.mu.Unlock()
+
+	changed := false
+	for _, p := range points {
+		now := m.now()
+		m.mu.Lock()
+		due := !now.Before(p.next)
+		m.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		ret, err := lookup(ctx, p.zone, dns.TypeDNSKEY)
+		m.mu.Lock()
+		if err == nil {
+			var c bool
+			c, err = p.update(ret.Answer, now)
+			changed = changed || c
+		}
+		if err != nil {
+			p.next = now.Add(retryInterval(ret, now))
+			log.Warningf("Failed to refresh the trust anchors of %q: %s", p.zone, err)
+		} else {
+			p.next = now.Add(queryInterval(ret, now))
+		}
+		m.mu.Unlock()
+	}
+	if !changed {
+		return
+	}
+
+	v.validator.SetAnchors(m.anchors())
+	m.mu.Lock()
+	err := m.write()
+	m.mu.Unlock()
+	if err != nil {
+		log.Errorf("Failed to write the trust anchors to %q: %s", m.file, err)
+	}
+}
+
+// anchors returns the trust anchors of p: its valid and missing keys or, when there aren't any, the trust anchors
+// we started out with.
+func (p *trustPoint) anchors() []dns.RR {
+	var anchors []dns.RR
+	for _, mk := range p.keys {
+		if mk.state == stateValid || mk.state == stateMissing {
+			anchors = append(anchors, mk.key)
+		}
+	}
+	if len(anchors) == 0 {
+		return p.initial
+	}
+	return anchors
+}
+
+// update updates the keys of p with the DNSKEY RRset (and its signatures) in rrs, as described in RFC 5011, section
+// 4. It returns true when anything changed. The DNSKEY RRset must be signed by one of the current trust anchors.
+func (p *trustPoint) update(rrs []dns.RR, now time.Time) (bool, error) {
+	var (
+		keys []*dns.DNSKEY
+		sigs []*dns.RRSIG
+	)
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, p.zone) {
+			continue
+		}
+		switch x := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, x)
+		case *dns.RRSIG:
+			if x.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, x)
+			}
+		}
+	}
+	set := make([]dns.RR, len(keys))
+	for i := range keys {
+		set[i] = keys[i]
+	}
+
+	// RFC 5011, section 2.1: a key that signs the DNSKEY RRset with the REVOKE bit set is revoked, even when
+	// none of the other trust anchors sign the RRset.
+	changed := false
+	for _, k := range keys {
+		if k.Flags&dns.REVOKE == 0 {
+			continue
+		}
+		mk := p.find(k)
+		if mk == nil || mk.state == stateRevoked || !selfSigned(k, set, sigs, now) {
+			continue
+		}
+		mk.key, mk.state, mk.changed = k, stateRevoked, now
+		changed = true
+	}
+
+	// RFC 5011, section 2.2: only a DNSKEY RRset signed by a trust anchor is used.
+	signed := false
+	for _, a := range p.anchors() {
+		for _, k := range keys {
+			if trusts(a, k) && selfSigned(k, set, sigs, now) {
+				signed = true
+			}
+		}
+	}
+	if !signed {
+		return changed, fmt.Errorf("DNSKEY RRset is not signed by a trust anchor")
+	}
+
+	bootstrap := bootstrapping(p)
+	seen := make(map[*managedKey]bool)
+	for _, k := range keys {
+		if k.Flags&dns.SEP == 0 || k.Flags&dns.ZONE == 0 || k.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		mk := p.find(k)
+
+		switch {
+		case mk == nil:
+			mk = &managedKey{key: k, state: stateAddPend, changed: now}
+			if bootstrap && trustedBy(k, p.initial) {
+				mk.state = stateValid
+			}
+			p.keys = append(p.keys, mk)
+			changed = true
+		case mk.state == stateAddPend && now.Sub(mk.changed) >= addHoldDown, mk.state == stateMissing:
+			mk.state, mk.changed = stateValid, now
+			changed = true
+		}
+		seen[mk] = true
+	}
+
+	j := 0
+	for _, mk := range p.keys {
+		if !seen[mk] {
+			switch mk.state {
+			case stateAddPend:
+				changed = true
+				continue
+			case stateValid:
+				mk.state, mk.changed = stateMissing, now
+				changed = true
+			}
+		}
+		if mk.state == stateRevoked && now.Sub(mk.changed) >= removeHoldDown {
+			changed = true
+			continue
+		}
+		p.keys[j] = mk
+		j++
+	}
+	p.keys = p.keys[:j]
+
+	// Once there are trust anchors of our own, the initial ones are no longer needed.
+	if len(p.initial) > 0 && !bootstrapping(p) {
+		p.initial = nil
+		changed = true
+	}
+	return changed, nil
+}
+
+// bootstrapping returns true if p has no valid or missing keys.
+func bootstrapping(p *trustPoint) bool {
+	for _, mk := range p.keys {
+		if mk.state == stateValid || mk.state == stateMissing {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the managed key that is k, ignoring the REVOKE flag.
+func (p *trustPoint) find(k *dns.DNSKEY) *managedKey {
+	for _, mk := range p.keys {
+		if sameKey(mk.key, k) {
+			return mk
+		}
+	}
+	return nil
+}
+
+// sameKey returns true if a and b are the same key, ignoring the REVOKE flag.
+func sameKey(a, b *dns.DNSKEY) bool {
+	return a.Algorithm == b.Algorithm && a.Protocol == b.Protocol && a.PublicKey == b.PublicKey
+}
+
+// trusts returns true if the trust anchor a, a DS or DNSKEY, is the key k.
+func trusts(a dns.RR, k *dns.DNSKEY) bool {
+	if k.Flags&dns.REVOKE != 0 {
+		return false
+	}
+	switch a := a.(type) {
+	case *dns.DS:
+		if a.KeyTag != k.KeyTag() || a.Algorithm != k.Algorithm {
+			return false
+		}
+		ds := k.ToDS(a.DigestType)
+		return ds != nil && strings.EqualFold(ds.Digest, a.Digest)
+	case *dns.DNSKEY:
+		return sameKey(a, k)
+	}
+	return false
+}
+
+// trustedBy returns true if one of the trust anchors is the key k.
+func trustedBy(k *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, a := range anchors {
+		if trusts(a, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// selfSigned returns true if one of sigs is a valid signature by k over set.
+func selfSigned(k *dns.DNSKEY, set []dns.RR, sigs []*dns.RRSIG, now time.Time) bool {
+	tag := k.KeyTag()
+	for _, sig := range sigs {
+		if sig.KeyTag != tag || sig.Algorithm != k.Algorithm || !sig.ValidityPeriod(now) {
+			continue
+		}
+		if sig.Verify(k, set) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// queryInterval returns when to query a DNSKEY RRset again, after a successful refresh (RFC 5011, section 2.3).
+func queryInterval(m *dns.Msg, now time.Time) time.Duration {
+	return interval(m, now, 2, time.Hour, 15*24*time.Hour)
+}
+
+// retryInterval returns when to query a DNSKEY RRset again, after a failed refresh (RFC 5011, section 2.3).
+func retryInterval(m *dns.Msg, now time.Time) time.Duration {
+	return interval(m, now, 10, time.Hour, 24*time.Hour)
+}
+
+// interval returns the minimum of max, the TTL of the DNSKEY RRset in m divided by div and the time until its
+// signatures expire divided by div, but it's never less than min.
+func interval(m *dns.Msg, now time.Time, div int64, min, max time.Duration) time.Duration {
+	d := max
+	if m != nil {
+		for _, rr := range m.Answer {
+			var x time.Duration
+			switch rr := rr.(type) {
+			case *dns.DNSKEY:
+				x = time.Duration(rr.Hdr.Ttl) * time.Second
+			case *dns.RRSIG:
+				x = time.Unix(int64(rr.Expiration), 0).Sub(now)
+			default:
+				continue
+			}
+			if x/time.Duration(div) < d {
+				d = x / time.Duration(div)
+			}
+		}
+	}
+	if d < min {
+		return min
+	}
+	return d
+}
+
+const (
+	addHoldDown    = 30 * 24 * time.Hour // RFC 5011, section 2.4.1.
+	removeHoldDown = 30 * 24 * time.Hour // RFC 5011, section 2.4.2.
+	refreshTimeout = 10 * time.Second
+)
@@ -0,0 +1,256 @@
+package validate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// dnskeys returns the DNSKEY RRset of the zones' keys, signed by the keys of signers.
+func dnskeys(t *testing.T, now time.Time, zones []*zone, signers ...*zone) []dns.RR {
+	var set []dns.RR
+	for _, z := range zones {
+		set = append(set, z.key)
+	}
+	var rrs []dns.RR
+	for _, s := range signers {
+		signed := s.sign(t, now.Add(-time.Hour), now.Add(time.Hour), set...)
+		rrs = append(rrs, signed[len(signed)-1])
+	}
+	return append(set, rrs...)
+}
+
+// revoked returns z with the REVOKE flag set on its key.
+func revoked(z *zone) *zone {
+	k := *z.key
+	k.Flags |= dns.REVOKE
+	return &zone{name: z.name, key: &k, priv: z.priv}
+}
+
+func TestTrustPointUpdate(t *testing.T) {
+	k1 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	k2 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	zsk := newZone(t, "example.org.", dns.ZONE)
+	p := &trustPoint{zone: "example.org.", initial: []dns.RR{k1.key.ToDS(dns.SHA256)}}
+	now := time.Now()
+
+	states := func() map[*zone]keyState {
+		m := make(map[*zone]keyState)
+		for _, z := range []*zone{k1, k2} {
+			if mk := p.find(z.key); mk != nil {
+				m[z] = mk.state
+			}
+		}
+		return m
+	}
+	update := func(step string, rrs []dns.RR, expectErr bool, expected map[*zone]keyState) {
+		_, err := p.update(rrs, now)
+		if (err != nil) != expectErr {
+			t.Fatalf("%s: expected error %t, got %v", step, expectErr, err)
+		}
+		got := states()
+		if len(got) != len(expected) {
+			t.Fatalf("%s: expected %d keys, got %d", step, len(expected), len(got))
+		}
+		for z, s := range expected {
+			if got[z] != s {
+				t.Fatalf("%s: expected state %s for key %d, got %s", step, stateToString[s], z.key.KeyTag(), stateToString[got[z]])
+			}
+		}
+	}
+
+	update("unsigned", dnskeys(t, now, []*zone{k1, zsk}), true, nil)
+	update("signed by an unknown key", dnskeys(t, now, []*zone{k1, k2}, k2), true, nil)
+
+	// The key that matches the initial DS is trusted right away, the zone signing key isn't managed.
+	update("bootstrap", dnskeys(t, now, []*zone{k1, zsk}, k1), false, map[*zone]keyState{k1: stateValid})
+	if len(p.initial) != 0 {
+		t.Errorf("Expected the initial trust anchors to be dropped")
+	}
+
+	update("new key", dnskeys(t, now, []*zone{k1, k2, zsk}, k1), false, map[*zone]keyState{k1: stateValid, k2: stateAddPend})
+	now = now.Add(addHoldDown - time.Hour)
+	update("new key before hold-down", dnskeys(t, now, []*zone{k1, k2, zsk}, k1), false, map[*zone]keyState{k1: stateValid, k2: stateAddPend})
+	if len(p.anchors()) != 1 {
+		t.Errorf("Expected 1 trust anchor, got %d", len(p.anchors()))
+	}
+	now = now.Add(2 * time.Hour)
+	update("new key after hold-down", dnskeys(t, now, []*zone{k1, k2, zsk}, k1), false, map[*zone]keyState{k1: stateValid, k2: stateValid})
+	if len(p.anchors()) != 2 {
+		t.Errorf("Expected 2 trust anchors, got %d", len(p.anchors()))
+	}
+
+	update("missing", dnskeys(t, now, []*zone{k2, zsk}, k2), false, map[*zone]keyState{k1: stateMissing, k2: stateValid})
+	if len(p.anchors()) != 2 {
+		t.Errorf("Expected 2 trust anchors, got %d", len(p.anchors()))
+	}
+	update("back", dnskeys(t, now, []*zone{k1, k2, zsk}, k2), false, map[*zone]keyState{k1: stateValid, k2: stateValid})
+
+	r1 := revoked(k1)
+	update("revoked", dnskeys(t, now, []*zone{r1, k2, zsk}, r1, k2), false, map[*zone]keyState{k1: stateRevoked, k2: stateValid})
+	if a := p.anchors(); len(a) != 1 || !sameKey(a[0].(*dns.DNSKEY), k2.key) {
+		t.Errorf("Expected the revoked key not to be a trust anchor")
+	}
+	now = now.Add(removeHoldDown + time.Hour)
+	update("removed", dnskeys(t, now, []*zone{k2, zsk}, k2), false, map[*zone]keyState{k2: stateValid})
+}
+
+func TestTrustPointAddPendRemoved(t *testing.T) {
+	k1 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	k2 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	p := &trustPoint{zone: "example.org.", keys: []*managedKey{{key: k1.key, state: stateValid}}}
+	now := time.Now()
+
+	if _, err := p.update(dnskeys(t, now, []*zone{k1, k2}, k1), now); err != nil {
+		t.Fatal(err)
+	}
+	if mk := p.find(k2.key); mk == nil || mk.state != stateAddPend {
+		t.Fatalf("Expected the new key to be pending")
+	}
+	// A pending key that disappears is forgotten.
+	if _, err := p.update(dnskeys(t, now, []*zone{k1}, k1), now); err != nil {
+		t.Fatal(err)
+	}
+	if p.find(k2.key) != nil {
+		t.Errorf("Expected the pending key to be removed")
+	}
+}
+
+func TestManagedReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredns-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "managed.keys")
+
+	k1 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	k2 := newZone(t, "example.org.", dns.ZONE|dns.SEP)
+	changed := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The file doesn't exist, so we start with the anchors given.
+	m, err := readManaged(file, []dns.RR{k1.key.ToDS(dns.SHA256)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m.anchors(); len(a) != 1 || a[0].Header().Rrtype != dns.TypeDS {
+		t.Fatalf("Expected the DS as trust anchor, got %v", a)
+	}
+
+	p := m.point("example.org.")
+	p.initial = nil
+	p.keys = []*managedKey{{key: k1.key, state: stateValid, changed: changed}, {key: k2.key, state: stateAddPend, changed: changed}}
+	if err := m.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = readManaged(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = m.point("example.org.")
+	if len(p.keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(p.keys))
+	}
+	for i, s := range []keyState{stateValid, stateAddPend} {
+		if p.keys[i].state != s {
+			t.Errorf("Expected key %d to be %s, got %s", i, stateToString[s], stateToString[p.keys[i].state])
+		}
+		if !p.keys[i].changed.Equal(changed) {
+			t.Errorf("Expected key %d to be changed at %s, got %s", i, changed, p.keys[i].changed)
+		}
+	}
+	if a := m.anchors(); len(a) != 1 || !sameKey(a[0].(*dns.DNSKEY), k1.key) {
+		t.Errorf("Expected 1 trust anchor, got %v", a)
+	}
+}
+
+func TestManagedReadError(t *testing.T) {
+	tests := []string{
+		"example.org. IN A 127.0.0.1\n",
+		"example.org. IN DNSKEY 257 3 13 AAAA ; state=NOSUCHSTATE\n",
+		"example.org. IN DNSKEY 257 3 13 AAAA ; changed=yesterday\n",
+	}
+	for i, tc := range tests {
+		f, rm, err := test.TempFile(".", tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readManaged(f, nil); err == nil {
+			t.Errorf("Test %d: expected error, got none", i)
+		}
+		rm()
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredns-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := newHierarchy(t)
+	m, err := readManaged(filepath.Join(dir, "managed.keys"), []dns.RR{h.root.key.ToDS(dns.SHA256)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newValidate(h, m.anchors())
+	v.managed = m
+
+	if !m.due() {
+		t.Fatalf("Expected a refresh to be due")
+	}
+	v.refresh(context.TODO(), &test.ResponseWriter{})
+	if m.due() {
+		t.Errorf("Expected no refresh to be due")
+	}
+
+	// The state is saved, the root key is now a trust anchor of its own.
+	m1, err := readManaged(m.file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m1.anchors(); len(a) != 1 || !sameKey(a[0].(*dns.DNSKEY), h.root.key) {
+		t.Errorf("Expected the root key as trust anchor, got %v", a)
+	}
+
+	// And the validator uses it.
+	h.answers[key("www.example.org.", dns.TypeA)] = h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.1"))
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	r.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	v.ServeDNS(context.TODO(), rec, r)
+	if !rec.Msg.AuthenticatedData {
+		t.Errorf("Expected AD to be set")
+	}
+}
+
+func TestInterval(t *testing.T) {
+	now := time.Now()
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{test.DNSKEY("example.org. 172800 IN DNSKEY 257 3 13 AAAA")}
+
+	if d := queryInterval(m, now); d != 24*time.Hour {
+		t.Errorf("Expected query interval of %s, got %s", 24*time.Hour, d)
+	}
+	if d := retryInterval(m, now); d != 4*time.Hour+48*time.Minute {
+		t.Errorf("Expected retry interval of %s, got %s", 4*time.Hour+48*time.Minute, d)
+	}
+	if d := retryInterval(nil, now); d != 24*time.Hour {
+		t.Errorf("Expected retry interval of %s, got %s", 24*time.Hour, d)
+	}
+	m.Answer[0].Header().Ttl = 60
+	if d := queryInterval(m, now); d != time.Hour {
+		t.Errorf("Expected query interval of %s, got %s", time.Hour, d)
+	}
+}
@@ -0,0 +1,18 @@
+package validate
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Variables declared for monitoring.
+var (
+	ValidationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "validate",
+		Name:      "validations_total",
+		Help:      "Counter of DNSSEC validation results.",
+	}, []string{"server", "result"})
+)
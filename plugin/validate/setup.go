@@ -0,0 +1,109 @@
+package validate
+
+import (
+	"path/filepath"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/pkg/validator"
+
+	"github.com/miekg/dns"
+)
+
+func init() { plugin.Register("validate", setup) }
+
+func setup(c *caddy.Controller) error {
+	v, err := validateParse(c)
+	if err != nil {
+		return plugin.Error("validate", err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		v.Next = next
+		return v
+	})
+
+	return nil
+}
+
+func validateParse(c *caddy.Controller) (*Validate, error) {
+	v := &Validate{}
+	config := dnsserver.GetConfig(c)
+
+	var (
+		anchors []dns.RR
+		file    string // managed trust anchors
+	)
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		// validate [zones...]
+		v.Zones = make([]string, len(c.ServerBlockKeys))
+		copy(v.Zones, c.ServerBlockKeys)
+		if args := c.RemainingArgs(); len(args) > 0 {
+			v.Zones = args
+		}
+		for i := range v.Zones {
+			v.Zones[i] = plugin.Host(v.Zones[i]).Normalize()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "trust_anchor":
+				files := c.RemainingArgs()
+				if len(files) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, f := range files {
+					if !filepath.IsAbs(f) && config.Root != "" {
+						f = filepath.Join(config.Root, f)
+					}
+					a, err := validator.ReadAnchorFile(f)
+					if err != nil {
+						return nil, err
+					}
+					anchors = append(anchors, a...)
+				}
+			case "managed":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				file = c.Val()
+				if !filepath.IsAbs(file) && config.Root != "" {
+					file = filepath.Join(config.Root, file)
+				}
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+			case "upstream":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				v.upstream = upstream.New()
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	if len(anchors) == 0 {
+		anchors = validator.RootAnchors()
+	}
+	if file != "" {
+		m, err := readManaged(file, anchors)
+		if err != nil {
+			return nil, err
+		}
+		v.managed = m
+		anchors = m.anchors()
+	}
+	v.validator = validator.New(anchors)
+	return v, nil
+}
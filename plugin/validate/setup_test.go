@@ -0,0 +1,96 @@
+package validate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+)
+
+func TestSetup(t *testing.T) {
+	anchors, rm, err := test.TempFile(".", "example.org. IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm()
+	dir, err := ioutil.TempDir("", "coredns-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	managed := filepath.Join(dir, "managed.keys")
+
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedZones    []string
+		expectedUpstream bool
+		expectedManaged  bool
+	}{
+		{`validate`, false, []string{"."}, false, false},
+		{`validate example.org`, false, []string{"example.org."}, false, false},
+		{`validate {
+			upstream
+		}`, false, []string{"."}, true, false},
+		{`validate {
+			trust_anchor ` + anchors + `
+		}`, false, []string{"."}, false, false},
+		{`validate {
+			managed ` + managed + `
+		}`, false, []string{"."}, false, true},
+		{`validate {
+			trust_anchor ` + anchors + `
+			managed ` + managed + `
+		}`, false, []string{"."}, false, true},
+		// fails
+		{`validate {
+			trust_anchor
+		}`, true, nil, false, false},
+		{`validate {
+			trust_anchor /does/not/exist
+		}`, true, nil, false, false},
+		{`validate {
+			managed
+		}`, true, nil, false, false},
+		{`validate {
+			managed a b
+		}`, true, nil, false, false},
+		{`validate {
+			upstream example.org
+		}`, true, nil, false, false},
+		{`validate {
+			blah
+		}`, true, nil, false, false},
+		{`validate
+		validate`, true, nil, false, false},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		c.ServerBlockKeys = []string{"."}
+		v, err := validateParse(c)
+
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, tc.input, err)
+			continue
+		}
+		if len(v.Zones) != len(tc.expectedZones) || v.Zones[0] != tc.expectedZones[0] {
+			t.Errorf("Test %d: expected zones %v, got %v", i, tc.expectedZones, v.Zones)
+		}
+		if (v.upstream != nil) != tc.expectedUpstream {
+			t.Errorf("Test %d: expected upstream %t, got %t", i, tc.expectedUpstream, v.upstream != nil)
+		}
+		if (v.managed != nil) != tc.expectedManaged {
+			t.Errorf("Test %d: expected managed %t, got %t", i, tc.expectedManaged, v.managed != nil)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package validate implements a plugin that validates the DNSSEC signatures of the responses of the plugins after it.
+package validate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/pkg/validator"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("validate")
+
+// Validate validates the responses for the queries in Zones.
+type Validate struct {
+	Next  plugin.Handler
+	Zones []string
+
+	validator *validator.Validator
+	managed   *managed           // trust anchors kept up to date with RFC 5011, nil when not configured.
+	upstream  *upstream.Upstream // when set, the DNSKEYs and DSs are looked up via CoreDNS itself.
+}
+
+// Name implements plugin.Handler.
+func (v *Validate) Name() string { return "validate" }
+
+// ServeDNS implements plugin.Handler.
+func (v *Validate) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+
+	// Queries with the CD bit are validated by the client. Our own lookups are validated as part of the chain
+	// of trust, so these are passed on as well.
+	zone := plugin.Zones(v.Zones).Matches(state.Name())
+	if zone == "" || r.CheckingDisabled || ctx.Value(lookupKey{}) != nil {
+		return plugin.NextOrFailure(v.Name(), v.Next, ctx, w, r)
+	}
+
+	if v.managed != nil && v.managed.due() {
+		go v.refresh(ctx, w)
+	}
+
+	do := state.Do()
+	nw := nonwriter.New(w)
+	rcode, err := plugin.NextOrFailure(v.Name(), v.Next, ctx, nw, validating(r))
+	if nw.Msg == nil {
+		return rcode, err
+	}
+	ret := nw.Msg
+
+	// A truncated response is incomplete, the client will retry over TCP.
+	result, verr := validator.Insecure, error(nil)
+	if !ret.Truncated {
+		result, verr = v.validator.Validate(ctx, ret, v.lookup(w))
+		ValidationCount.WithLabelValues(metrics.WithServer(ctx), result.String()).Add(1)
+	}
+
+	if result == validator.Bogus {
+		log.Warningf("Validation of %s %s failed: %s", state.QName(), state.Type(), verr)
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if state.Req.IsEdns0() != nil {
+			code := edns.ExtendedErrorDNSSECBogus
+			if e, ok := verr.(*validator.Error); ok {
+				code = e.Reason.ExtendedError()
+			}
+			edns.SetExtendedError(m, code, verr.Error())
+			state.SizeAndDo(m)
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+
+	// RFC 6840, section 5.7: only set AD when the client indicated it understands it.
+	ret.AuthenticatedData = result == validator.Secure && (do || r.AuthenticatedData)
+	ret.CheckingDisabled = false
+	if !do {
+		ret.Answer = validator.FilterDNSSEC(ret.Answer, state.QType())
+		ret.Ns = validator.FilterDNSSEC(ret.Ns, state.QType())
+		ret.Extra = validator.FilterDNSSEC(ret.Extra, state.QType())
+	}
+	if o := ret.IsEdns0(); o != nil {
+		if state.Req.IsEdns0() == nil {
+			ret.Extra = removeOPT(ret.Extra)
+		} else {
+			o.SetDo(do)
+		}
+	}
+
+	w.WriteMsg(ret)
+	return rcode, err
+}
+
+// lookup returns a validator.Lookup that retrieves the DNSKEY and DS records the validator needs, from the next
+// plugin or, when upstream is configured, from CoreDNS itself.
+func (v *Validate) lookup(w dns.ResponseWriter) validator.Lookup {
+	return func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+		ctx = context.WithValue(ctx, lookupKey{}, true)
+
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		m.SetEdns0(defaultBufSize, true)
+		m.CheckingDisabled = true
+
+		var ret *dns.Msg
+		if v.upstream != nil {
+			var err error
+			if ret, err = v.upstream.Lookup(ctx, request.Request{W: w, Req: m}, name, qtype); err != nil {
+				return nil, err
+			}
+		} else {
+			nw := nonwriter.New(w)
+			if _, err := plugin.NextOrFailure(v.Name(), v.Next, ctx, nw, m); err != nil {
+				return nil, err
+			}
+			ret = nw.Msg
+		}
+		if ret == nil {
+			return nil, fmt.Errorf("no reply for %s %s", name, dns.Type(qtype))
+		}
+		return ret, nil
+	}
+}
+
+// validating returns a copy of r that asks for the signatures and denial of existence records we need to
+// validate the response.
+func validating(r *dns.Msg) *dns.Msg {
+	m := r.Copy()
+	if o := m.IsEdns0(); o != nil {
+		o.SetDo()
+		if o.UDPSize() < defaultBufSize {
+			o.SetUDPSize(defaultBufSize)
+		}
+	} else {
+		m.SetEdns0(defaultBufSize, true)
+	}
+	m.CheckingDisabled = true
+	return m
+}
+
+// removeOPT returns rrs without the OPT record.
+func removeOPT(rrs []dns.RR) []dns.RR {
+	j := 0
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		rrs[j] = rr
+		j++
+	}
+	return rrs[:j]
+}
+
+// lookupKey marks the context of the queries we send to build the chain of trust.
+type lookupKey struct{}
+
+const defaultBufSize = 1232 // EDNS0 buffer size used for our queries.
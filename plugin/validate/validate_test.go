@@ -0,0 +1,229 @@
+package validate
+
+import (
+	"context"
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/pkg/validator"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// zone is a signed zone in the test hierarchy.
+type zone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newZone(t *testing.T, name string, flags uint16) *zone {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &zone{name: name, key: k, priv: priv.(crypto.Signer)}
+}
+
+// sign returns rrs and a signature over them, valid from inception until expiration.
+func (z *zone) sign(t *testing.T, inception, expiration time.Time, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func (z *zone) signNow(t *testing.T, rrs ...dns.RR) []dns.RR {
+	now := time.Now()
+	return z.sign(t, now.Add(-time.Hour), now.Add(time.Hour), rrs...)
+}
+
+// hierarchy is a signed ".", "org." and "example.org.", that answers queries as the next plugin.
+// DS queries for other names get a signed NODATA response, "net." is an insecure delegation.
+type hierarchy struct {
+	t                  *testing.T
+	root, org, example *zone
+	answers            map[string][]dns.RR
+	queries            int
+}
+
+func newHierarchy(t *testing.T) *hierarchy {
+	h := &hierarchy{
+		t:       t,
+		root:    newZone(t, ".", dns.ZONE|dns.SEP),
+		org:     newZone(t, "org.", dns.ZONE|dns.SEP),
+		example: newZone(t, "example.org.", dns.ZONE|dns.SEP),
+		answers: make(map[string][]dns.RR),
+	}
+	for _, z := range []*zone{h.root, h.org, h.example} {
+		h.answers[key(z.name, dns.TypeDNSKEY)] = z.signNow(t, z.key)
+	}
+	h.answers[key("org.", dns.TypeDS)] = h.root.signNow(t, h.org.key.ToDS(dns.SHA256))
+	h.answers[key("example.org.", dns.TypeDS)] = h.org.signNow(t, h.example.key.ToDS(dns.SHA256))
+	return h
+}
+
+func (h *hierarchy) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	h.queries++
+	q := r.Question[0]
+	m := new(dns.Msg)
+	m.SetReply(r)
+	answer, ok := h.answers[key(q.Name, q.Qtype)]
+	switch {
+	case ok:
+		m.Answer = answer
+	case q.Qtype == dns.TypeDS:
+		m.Ns = h.noDS(q.Name)
+	default:
+		return dns.RcodeServerFailure, nil
+	}
+	if o := r.IsEdns0(); o != nil {
+		m.SetEdns0(o.UDPSize(), o.Do())
+	}
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+// noDS returns the signed NSEC record that proves there is no DS for name.
+func (h *hierarchy) noDS(name string) []dns.RR {
+	z := h.root
+	for _, p := range []*zone{h.example, h.org} {
+		if name != p.name && dns.IsSubDomain(p.name, name) {
+			z = p
+			break
+		}
+	}
+	types := "A RRSIG NSEC"
+	if name == "net." {
+		types = "NS RRSIG NSEC"
+	}
+	return z.signNow(h.t, test.NSEC(name+" 3600 IN NSEC \\000."+name+" "+types))
+}
+
+func (h *hierarchy) Name() string { return "hierarchy" }
+
+func key(name string, qtype uint16) string { return name + "/" + dns.Type(qtype).String() }
+
+func newValidate(next plugin.Handler, anchors []dns.RR) *Validate {
+	return &Validate{Next: next, Zones: []string{"."}, validator: validator.New(anchors)}
+}
+
+func TestValidate(t *testing.T) {
+	h := newHierarchy(t)
+	v := newValidate(h, []dns.RR{h.root.key.ToDS(dns.SHA256)})
+
+	a := test.A("www.example.org. 3600 IN A 127.0.0.1")
+	h.answers[key("www.example.org.", dns.TypeA)] = h.example.signNow(t, a)
+	tampered := h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.2"))
+	tampered[0].(*dns.A).A[3] = 3
+	h.answers[key("bogus.example.org.", dns.TypeA)] = tampered
+	now := time.Now()
+	h.answers[key("expired.example.org.", dns.TypeA)] = h.example.sign(t, now.Add(-2*time.Hour), now.Add(-time.Hour), test.A("expired.example.org. 3600 IN A 127.0.0.1"))
+	h.answers[key("www.insecure.net.", dns.TypeA)] = []dns.RR{test.A("www.insecure.net. 3600 IN A 127.0.0.1")}
+
+	tests := []struct {
+		qname       string
+		edns, do    bool
+		cd          bool
+		rcode       int
+		ad          bool
+		answer      int // number of RRs in the answer section.
+		opt         bool
+		ede         uint16
+		expectedEDE bool
+	}{
+		{qname: "www.example.org.", edns: true, do: true, ad: true, answer: 2, opt: true},
+		{qname: "www.example.org.", edns: true, answer: 1, opt: true},
+		{qname: "www.example.org.", answer: 1},
+		{qname: "www.example.org.", edns: true, do: true, cd: true, answer: 2, opt: true},
+		{qname: "bogus.example.org.", edns: true, do: true, rcode: dns.RcodeServerFailure, opt: true, expectedEDE: true, ede: edns.ExtendedErrorDNSSECBogus},
+		{qname: "bogus.example.org.", rcode: dns.RcodeServerFailure},
+		{qname: "bogus.example.org.", edns: true, do: true, cd: true, answer: 2, opt: true},
+		{qname: "expired.example.org.", edns: true, rcode: dns.RcodeServerFailure, opt: true, expectedEDE: true, ede: edns.ExtendedErrorSignatureExpired},
+		{qname: "www.insecure.net.", edns: true, do: true, answer: 1, opt: true},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, tc.do)
+		}
+		m.CheckingDisabled = tc.cd
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := v.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		r := rec.Msg
+		if r.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[r.Rcode])
+		}
+		if r.AuthenticatedData != tc.ad {
+			t.Errorf("Test %d: expected AD %t, got %t", i, tc.ad, r.AuthenticatedData)
+		}
+		if r.CheckingDisabled != tc.cd {
+			t.Errorf("Test %d: expected CD %t, got %t", i, tc.cd, r.CheckingDisabled)
+		}
+		if len(r.Answer) != tc.answer {
+			t.Errorf("Test %d: expected %d RRs in the answer section, got %d", i, tc.answer, len(r.Answer))
+		}
+		o := r.IsEdns0()
+		if (o != nil) != tc.opt {
+			t.Errorf("Test %d: expected OPT %t, got %t", i, tc.opt, o != nil)
+		}
+		if o != nil && o.Do() != tc.do {
+			t.Errorf("Test %d: expected DO %t, got %t", i, tc.do, o.Do())
+		}
+		code, _, ok := edns.ExtendedError(r)
+		if ok != tc.expectedEDE {
+			t.Errorf("Test %d: expected extended error %t, got %t", i, tc.expectedEDE, ok)
+		}
+		if ok && code != tc.ede {
+			t.Errorf("Test %d: expected extended error %s, got %s", i, edns.ExtendedErrorToString[tc.ede], edns.ExtendedErrorToString[code])
+		}
+	}
+}
+
+func TestValidateZones(t *testing.T) {
+	h := newHierarchy(t)
+	v := newValidate(h, []dns.RR{h.root.key.ToDS(dns.SHA256)})
+	v.Zones = []string{"example.net."}
+
+	tampered := h.example.signNow(t, test.A("www.example.org. 3600 IN A 127.0.0.2"))
+	tampered[0].(*dns.A).A[3] = 3
+	h.answers[key("www.example.org.", dns.TypeA)] = tampered
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	v.ServeDNS(context.TODO(), rec, m)
+
+	if rec.Msg.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected the response for a name outside the zones to be passed on")
+	}
+	if h.queries != 1 {
+		t.Errorf("Expected 1 query to the next plugin, got %d", h.queries)
+	}
+}